@@ -0,0 +1,96 @@
+// Galaxy Node Pool - API Key Commands
+// AI-ID: CP-GAL-NODEPOOL-001
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"galaxy-node-pool/internal/auth"
+)
+
+// apikeyCmd creates a command for managing pool API keys
+func apikeyCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "apikey",
+		Short: "Manage API keys for the built-in auth plugin",
+	}
+
+	// Add subcommands
+	cmd.AddCommand(apikeyGenerateCmd())
+	cmd.AddCommand(apikeyHashCmd())
+
+	return cmd
+}
+
+// apikeyGenerateCmd creates a command that generates a new API key and its config entry
+func apikeyGenerateCmd() *cobra.Command {
+	var id string
+	var scopes []string
+	var org string
+	var validFor time.Duration
+
+	cmd := &cobra.Command{
+		Use:   "generate",
+		Short: "Generate a new API key",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			for _, scope := range scopes {
+				switch scope {
+				case auth.ActionRegister, auth.ActionList, auth.ActionAdmin:
+				default:
+					return fmt.Errorf("unknown scope %s, use %s, %s or %s", scope, auth.ActionRegister, auth.ActionList, auth.ActionAdmin)
+				}
+			}
+
+			key, err := auth.GenerateAPIKey()
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("API key (shown once, give it to the client): %s\n\n", key)
+			fmt.Println("Add this entry to the auth-plugin api_keys or keys_file:")
+			fmt.Printf("  - id: %q\n", id)
+			fmt.Printf("    hash: \"sha256:%s\"\n", auth.HashAPIKey(key))
+			fmt.Printf("    scopes: [%s]\n", quoteList(scopes))
+			if org != "" {
+				fmt.Printf("    org: %q\n", org)
+			}
+			if validFor > 0 {
+				fmt.Printf("    expires_at: %q\n", time.Now().Add(validFor).UTC().Format(time.RFC3339))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&id, "id", "", "Key ID shown in logs")
+	cmd.Flags().StringSliceVar(&scopes, "scopes", []string{auth.ActionList}, "Scopes granted to the key (register, list, admin)")
+	cmd.Flags().StringVar(&org, "org", "", "Bind the key to an organization")
+	cmd.Flags().DurationVar(&validFor, "valid-for", 0, "Expire the key after this duration, e.g. 720h")
+	cmd.MarkFlagRequired("id")
+
+	return cmd
+}
+
+// apikeyHashCmd creates a command that hashes an existing API key
+func apikeyHashCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "hash [key]",
+		Short: "Print the hash of an existing API key",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			fmt.Printf("sha256:%s\n", auth.HashAPIKey(args[0]))
+		},
+	}
+}
+
+// quoteList renders values as a quoted, comma separated YAML flow list body
+func quoteList(values []string) string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = fmt.Sprintf("%q", value)
+	}
+	return strings.Join(quoted, ", ")
+}
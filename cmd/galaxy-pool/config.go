@@ -0,0 +1,55 @@
+// Galaxy Node Pool - Config Commands
+// AI-ID: CP-GAL-NODEPOOL-001
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"galaxy-node-pool/internal/config"
+)
+
+// configCmd creates a command for working with pool-server configuration files
+func configCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Work with pool-server configuration files",
+	}
+
+	// Add subcommands
+	cmd.AddCommand(configValidateCmd())
+
+	return cmd
+}
+
+// configValidateCmd creates a command that checks configuration files against the schema
+func configValidateCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "validate [file...]",
+		Short: "Check configuration files for unknown keys and mistyped values",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			failed := 0
+			for _, path := range args {
+				if !config.IsYAMLFile(path) {
+					fmt.Printf("%s: not a YAML or JSON file, schema validation skipped\n", path)
+					continue
+				}
+				if err := config.ValidateFile(path); err != nil {
+					// Errors name the file and line themselves
+					fmt.Println(err)
+					failed++
+					continue
+				}
+				fmt.Printf("%s: valid (config version %d)\n", path, config.Version)
+			}
+			if failed > 0 {
+				return fmt.Errorf("%d of %d configuration files are invalid", failed, len(args))
+			}
+			return nil
+		},
+	}
+
+	return cmd
+}
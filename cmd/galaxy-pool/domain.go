@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 	
@@ -128,6 +129,10 @@ func domainSslCmd() *cobra.Command {
 	var testMode bool
 	var configPath string
 	var nginxConfig string
+	var keyType string
+	var validity time.Duration
+	var sans []string
+	var directoryURL string
 	
 	generateCmd := &cobra.Command{
 		Use:   "generate [domain]",
@@ -147,7 +152,11 @@ func domainSslCmd() *cobra.Command {
 			// For testnet, we can use self-signed certificates if in test mode
 			if testMode {
 				fmt.Println("Using test mode with self-signed certificates")
-				return manager.GenerateSelfSigned(domain)
+				return manager.GenerateSelfSignedWithOptions(domain, cert.SelfSignedOptions{
+					KeyType:  keyType,
+					Hosts:    sans,
+					Validity: validity,
+				})
 			}
 			
 			// Load certificate configuration
@@ -163,50 +172,149 @@ func domainSslCmd() *cobra.Command {
 			if dnsProvider != "" {
 				config.DNSProvider = dnsProvider
 			}
+			if directoryURL != "" {
+				config.DirectoryURL = directoryURL
+			}
 			
 			// For wildcard domains, we need to use DNS challenge
 			if isWildcard {
 				if config.DNSProvider == "" {
 					return fmt.Errorf("DNS provider is required for wildcard certificates")
 				}
-				return manager.GenerateWithLetsEncrypt(domain, config.Email, true, config.DNSProvider)
+				return manager.GenerateWithLetsEncrypt(domain, config, true)
 			}
 			
 			// For regular domains, we can use HTTP challenge
-			return manager.GenerateWithLetsEncrypt(domain, config.Email, false, "")
+			return manager.GenerateWithLetsEncrypt(domain, config, false)
 		},
 	}
 	
 	// Add flags
-	generateCmd.Flags().StringVar(&dnsProvider, "dns-provider", "", "DNS provider for wildcard certificates (e.g., cloudflare, route53)")
+	generateCmd.Flags().StringVar(&dnsProvider, "dns-provider", "", "DNS provider for wildcard certificates (e.g., cloudflare, route53, exec)")
+	generateCmd.Flags().StringVar(&directoryURL, "directory-url", "", "ACME directory URL (default: Let's Encrypt production)")
 	generateCmd.Flags().StringVar(&email, "email", "", "Email address for Let's Encrypt notifications")
 	generateCmd.Flags().BoolVar(&testMode, "test", false, "Use test mode with self-signed certificates for testnet")
 	generateCmd.Flags().StringVar(&configPath, "config", "", "Path to certificate configuration file")
 	generateCmd.Flags().StringVar(&nginxConfig, "nginx-config", "/etc/nginx/sites-available/galaxy-pool.conf", "Path to Nginx configuration file")
+	generateCmd.Flags().StringVar(&keyType, "key-type", cert.KeyTypeRSA, "Key type for self-signed certificates (rsa or ecdsa)")
+	generateCmd.Flags().DurationVar(&validity, "validity", cert.DefaultSelfSignedValidity, "Validity period for self-signed certificates")
+	generateCmd.Flags().StringSliceVar(&sans, "san", nil, "Subject alternative names for self-signed certificates (default: the domain)")
 	
 	cmd.AddCommand(generateCmd)
 	
-	cmd.AddCommand(&cobra.Command{
+	cmd.AddCommand(domainSslRenewCmd())
+	cmd.AddCommand(domainSslStatusCmd())
+	
+	return cmd
+}
+
+func domainSslRenewCmd() *cobra.Command {
+	var configPath string
+	var threshold time.Duration
+	var force bool
+
+	cmd := &cobra.Command{
 		Use:   "renew [domain]",
-		Short: "Renew SSL certificate for a domain",
-		Args:  cobra.ExactArgs(1),
-		Run: func(cmd *cobra.Command, args []string) {
+		Short: "Renew SSL certificates that are close to expiry",
+		Long:  `Renew the certificate for a domain, or every managed certificate due for renewal when no domain is given.`,
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			manager := cert.NewManager(filepath.Join(os.Getenv("HOME"), ".galaxy", "certs"), "")
+			config, err := cert.LoadConfig(configPath)
+			if err != nil {
+				return fmt.Errorf("failed to load certificate configuration: %v", err)
+			}
+
+			if len(args) == 0 {
+				renewed, err := manager.RenewDue(config, threshold)
+				for _, domain := range renewed {
+					fmt.Printf("Renewed SSL certificate for domain: %s\n", domain)
+				}
+				if len(renewed) == 0 && err == nil {
+					fmt.Println("No certificates are due for renewal")
+				}
+				return err
+			}
+
 			domain := args[0]
+			status, err := manager.Status(domain)
+			if err != nil {
+				return err
+			}
+			if !force && !status.RenewalDue(time.Now(), threshold) {
+				fmt.Printf("Certificate for %s is valid until %s, not due for renewal (use --force to renew anyway)\n",
+					domain, status.NotAfter.Format(time.RFC3339))
+				return nil
+			}
+
 			fmt.Printf("Renewing SSL certificate for domain: %s\n", domain)
-			// Implementation will go here
+			return manager.Renew(domain, config)
 		},
-	})
-	
-	cmd.AddCommand(&cobra.Command{
+	}
+
+	cmd.Flags().StringVar(&configPath, "config", "", "Path to certificate configuration file")
+	cmd.Flags().DurationVar(&threshold, "threshold", cert.DefaultRenewalThreshold, "Renew certificates expiring within this duration")
+	cmd.Flags().BoolVar(&force, "force", false, "Renew even if the certificate is not due")
+
+	return cmd
+}
+
+func domainSslStatusCmd() *cobra.Command {
+	var threshold time.Duration
+
+	cmd := &cobra.Command{
 		Use:   "status [domain]",
 		Short: "Check SSL certificate status for a domain",
-		Args:  cobra.ExactArgs(1),
-		Run: func(cmd *cobra.Command, args []string) {
-			domain := args[0]
-			fmt.Printf("SSL certificate status for domain: %s\n", domain)
-			// Implementation will go here
+		Long:  `Show the expiry of the certificate for a domain, or of every managed certificate when no domain is given.`,
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			manager := cert.NewManager(filepath.Join(os.Getenv("HOME"), ".galaxy", "certs"), "")
+
+			var statuses []*cert.CertStatus
+			if len(args) == 1 {
+				status, err := manager.Status(args[0])
+				if err != nil {
+					return err
+				}
+				statuses = append(statuses, status)
+			} else {
+				var err error
+				if statuses, err = manager.List(); err != nil {
+					return err
+				}
+				if len(statuses) == 0 {
+					fmt.Printf("No certificates found in %s\n", manager.CertDir)
+					return nil
+				}
+			}
+
+			now := time.Now()
+			for _, status := range statuses {
+				issuer := status.Issuer
+				if status.SelfSigned {
+					issuer = "self-signed"
+				}
+
+				state := "valid"
+				if status.Remaining(now) <= 0 {
+					state = "EXPIRED"
+				} else if status.RenewalDue(now, threshold) {
+					state = "renewal due"
+				}
+
+				fmt.Printf("SSL certificate status for domain: %s\n", status.Domain)
+				fmt.Printf("  Certificate: %s\n", status.CertPath)
+				fmt.Printf("  Names:       %s\n", strings.Join(status.Hosts, ", "))
+				fmt.Printf("  Issuer:      %s\n", issuer)
+				fmt.Printf("  Key type:    %s\n", status.KeyType)
+				fmt.Printf("  Expires:     %s (%d days)\n", status.NotAfter.Format(time.RFC3339), int(status.Remaining(now).Hours()/24))
+				fmt.Printf("  Status:      %s\n", state)
+			}
+			return nil
 		},
-	})
-	
+	}
+
+	cmd.Flags().DurationVar(&threshold, "threshold", cert.DefaultRenewalThreshold, "Report certificates expiring within this duration as due for renewal")
+
 	return cmd
 }
@@ -56,6 +56,9 @@ This tool allows you to manage nodes, pools, and participate in the Galaxy Netwo
 	// Add testnet and domain commands
 	rootCmd.AddCommand(testnetCmd())
 	rootCmd.AddCommand(domainCmd())
+	rootCmd.AddCommand(apikeyCmd())
+	rootCmd.AddCommand(configCmd())
+	rootCmd.AddCommand(pluginCmd())
 
 	// Load plugins (enterprise features can be added here)
 	loadPlugins(rootCmd)
@@ -0,0 +1,263 @@
+// Galaxy Node Pool - Plugin Signing Commands
+// AI-ID: CP-GAL-NODEPOOL-001
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"galaxy-node-pool/internal/config"
+	"galaxy-node-pool/internal/plugin"
+)
+
+// pluginCmd creates a command for signing and verifying pool-server plugins
+func pluginCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "plugin",
+		Short: "Sign and verify pool-server plugins",
+	}
+
+	// Add subcommands
+	cmd.AddCommand(pluginKeygenCmd())
+	cmd.AddCommand(pluginSignCmd())
+	cmd.AddCommand(pluginVerifyCmd())
+
+	return cmd
+}
+
+// pluginKeygenCmd creates a command that generates a plugin signing key
+func pluginKeygenCmd() *cobra.Command {
+	var out string
+	var id string
+
+	cmd := &cobra.Command{
+		Use:   "keygen",
+		Short: "Generate an ed25519 key for signing plugin manifests",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if _, err := os.Stat(out); err == nil {
+				return fmt.Errorf("%s already exists, refusing to overwrite it", out)
+			}
+
+			publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+			if err != nil {
+				return fmt.Errorf("failed to generate key: %v", err)
+			}
+			der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+			if err != nil {
+				return fmt.Errorf("failed to encode key: %v", err)
+			}
+			if err := os.MkdirAll(filepath.Dir(out), 0700); err != nil {
+				return fmt.Errorf("failed to create key directory: %v", err)
+			}
+			if err := os.WriteFile(out, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+				return fmt.Errorf("failed to write key: %v", err)
+			}
+
+			fmt.Printf("Signing key written to %s, keep it private\n\n", out)
+			fmt.Println("Trust plugins signed with it in the pool-server configuration:")
+			fmt.Println("  plugin_trust:")
+			fmt.Println("    signatures: required")
+			fmt.Println("    trusted_keys:")
+			fmt.Printf("      - id: %q\n", id)
+			fmt.Printf("        public_key: %q\n", plugin.EncodePublicKey(publicKey))
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&out, "out", "plugin-signing.pem", "File to write the private key to")
+	cmd.Flags().StringVar(&id, "id", "plugin-signing", "Key ID shown in the configuration snippet")
+
+	return cmd
+}
+
+// pluginSignCmd creates a command that completes and signs the manifest of a plugin file
+func pluginSignCmd() *cobra.Command {
+	var keyPath string
+
+	cmd := &cobra.Command{
+		Use:   "sign <plugin-file>",
+		Short: "Record the plugin file's hash in its manifest and sign the manifest",
+		Long: `Sign reads <name>.manifest.yaml next to the plugin file, records the SHA-256
+of the plugin file in it and writes the detached signature to
+<name>.manifest.yaml.sig. Sign again whenever the plugin file or manifest changes.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			pluginPath := args[0]
+			key, err := loadSigningKey(keyPath)
+			if err != nil {
+				return err
+			}
+
+			manifestPath := plugin.ManifestPath(pluginPath)
+			data, err := os.ReadFile(manifestPath)
+			if err != nil {
+				return fmt.Errorf("failed to read manifest: %v", err)
+			}
+			manifest, err := plugin.ParseManifest(data)
+			if err != nil {
+				return fmt.Errorf("%s: %v", manifestPath, err)
+			}
+			name := strings.TrimSuffix(filepath.Base(pluginPath), filepath.Ext(pluginPath))
+			if manifest.Name != name {
+				return fmt.Errorf("%s: manifest is for plugin %s, the file is %s", manifestPath, manifest.Name, name)
+			}
+			if err := manifest.CheckCompatible(); err != nil {
+				return fmt.Errorf("%s: %v", manifestPath, err)
+			}
+
+			sum, err := plugin.FileSHA256(pluginPath)
+			if err != nil {
+				return err
+			}
+			data, err = setManifestHash(data, sum)
+			if err != nil {
+				return fmt.Errorf("%s: %v", manifestPath, err)
+			}
+			if err := os.WriteFile(manifestPath, data, 0644); err != nil {
+				return fmt.Errorf("failed to write manifest: %v", err)
+			}
+
+			signaturePath := manifestPath + plugin.SignatureSuffix
+			if err := os.WriteFile(signaturePath, []byte(plugin.SignManifest(key, data)+"\n"), 0644); err != nil {
+				return fmt.Errorf("failed to write signature: %v", err)
+			}
+
+			fmt.Printf("Signed %s %s (plugin API %d, %s)\n", manifest.Name, manifest.Version, manifest.APIVersion, strings.Join(manifest.Interfaces, ", "))
+			fmt.Printf("  manifest:  %s\n", manifestPath)
+			fmt.Printf("  signature: %s\n", signaturePath)
+			fmt.Printf("  sha256:    %s\n", sum)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&keyPath, "key", "plugin-signing.pem", "Private key created by plugin keygen")
+
+	return cmd
+}
+
+// pluginVerifyCmd creates a command that checks plugin files against a trust policy
+func pluginVerifyCmd() *cobra.Command {
+	var configPath string
+
+	cmd := &cobra.Command{
+		Use:   "verify <plugin-file>...",
+		Short: "Check plugin files against the trust policy of a pool-server configuration",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			policy := plugin.DefaultTrustPolicy()
+			if configPath != "" {
+				cfg, err := config.LoadConfig(configPath)
+				if err != nil {
+					return err
+				}
+				keys, err := config.GetTrustedPluginKeys(cfg)
+				if err != nil {
+					return err
+				}
+				if policy, err = plugin.NewTrustPolicy(cfg.PluginTrust.Signatures, keys); err != nil {
+					return err
+				}
+			}
+			fmt.Printf("Trust policy: signatures %s, %d trusted keys\n\n", policy.Signatures, len(policy.TrustedKeys))
+
+			failed := 0
+			for _, path := range args {
+				name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+				result, err := policy.Verify(path, name)
+				if err != nil {
+					fmt.Printf("%s: refused: %v\n", path, err)
+					failed++
+					continue
+				}
+
+				status := "accepted"
+				if result.SignedBy != "" {
+					status = "accepted, signed by " + result.SignedBy
+				}
+				if m := result.Manifest; m != nil {
+					fmt.Printf("%s: %s (%s %s, plugin API %d, %s)\n", path, status, m.Name, m.Version, m.APIVersion, strings.Join(m.Interfaces, ", "))
+				} else {
+					fmt.Printf("%s: %s\n", path, status)
+				}
+				for _, warning := range result.Warnings {
+					fmt.Printf("  warning: %s\n", warning)
+				}
+			}
+			if failed > 0 {
+				return fmt.Errorf("%d of %d plugins would be refused", failed, len(args))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&configPath, "config", "", "pool-server configuration with the plugin_trust policy (default: signatures optional, no trusted keys)")
+
+	return cmd
+}
+
+// loadSigningKey reads an ed25519 private key written by plugin keygen
+func loadSigningKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key: %v", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("%s is not a PEM encoded private key", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing key: %v", err)
+	}
+	signingKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an ed25519 key", path)
+	}
+	return signingKey, nil
+}
+
+// setManifestHash sets the sha256 field of a manifest, keeping its comments
+func setManifestHash(data []byte, sum string) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %v", err)
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("invalid manifest: expected a mapping")
+	}
+
+	root := doc.Content[0]
+	found := false
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == "sha256" {
+			root.Content[i+1].SetString(sum)
+			found = true
+		}
+	}
+	if !found {
+		key := &yaml.Node{}
+		key.SetString("sha256")
+		value := &yaml.Node{}
+		value.SetString(sum)
+		root.Content = append(root.Content, key, value)
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to encode manifest: %v", err)
+	}
+	encoder.Close()
+	return buf.Bytes(), nil
+}
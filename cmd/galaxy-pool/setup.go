@@ -6,9 +6,12 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-	"text/template"
 
 	"github.com/spf13/cobra"
+
+	"galaxy-node-pool/internal/config"
+	"galaxy-node-pool/internal/nginx"
+	"galaxy-node-pool/internal/testnet"
 )
 
 // setupCmd creates a new setup command
@@ -75,18 +78,47 @@ func setupNginxCmd() *cobra.Command {
 	var serverName string
 	var apiPort string
 	var webPort string
+	var grpcPort string
+	var grpcTLS bool
+	var certPath string
+	var keyPath string
+	var dryRun bool
+	var force bool
 
 	cmd := &cobra.Command{
 		Use:   "nginx",
 		Short: "Generate Nginx configuration",
+		Long: `Generate or update the Nginx configuration of a pool.
+
+The configuration is written between galaxy-pool managed markers. Running
+the command again updates the managed section, leaving anything outside the
+markers untouched.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			fmt.Println("Generating Nginx configuration...")
 
 			// Create Nginx configuration
-			if err := createNginxConfig(outputPath, serverName, apiPort, webPort); err != nil {
+			result, err := createNginxConfig(outputPath, nginx.PoolOptions{
+				ServerName:        serverName,
+				SSLCertificate:    certPath,
+				SSLCertificateKey: keyPath,
+				APIAddr:           "127.0.0.1:" + apiPort,
+				WebAddr:           "127.0.0.1:" + webPort,
+				GRPCAddr:          "127.0.0.1:" + grpcPort,
+				GRPCTLS:           grpcTLS,
+			}, nginx.WriteOptions{DryRun: dryRun, Force: force})
+			if err != nil {
 				return fmt.Errorf("failed to create Nginx config: %v", err)
 			}
 
+			if !result.Changed {
+				fmt.Printf("Nginx configuration at %s is up to date\n", outputPath)
+				return nil
+			}
+			if dryRun {
+				fmt.Print(result.Diff)
+				return nil
+			}
+
 			fmt.Printf("Nginx configuration created at: %s\n", outputPath)
 			fmt.Println("To enable this configuration, run:")
 			fmt.Println("  sudo ln -sf " + outputPath + " /etc/nginx/sites-enabled/")
@@ -102,6 +134,12 @@ func setupNginxCmd() *cobra.Command {
 	cmd.Flags().StringVar(&serverName, "server-name", "pool.example.com", "Server name")
 	cmd.Flags().StringVar(&apiPort, "api-port", "3000", "API port")
 	cmd.Flags().StringVar(&webPort, "web-port", "8080", "Web interface port")
+	cmd.Flags().StringVar(&grpcPort, "grpc-port", "50051", "Pool server gRPC port")
+	cmd.Flags().BoolVar(&grpcTLS, "grpc-tls", false, "Connect to the pool server over TLS")
+	cmd.Flags().StringVar(&certPath, "cert", "", "Certificate path (default: snakeoil certificate)")
+	cmd.Flags().StringVar(&keyPath, "key", "", "Certificate key path")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show the changes as a diff without writing them")
+	cmd.Flags().BoolVar(&force, "force", false, "Replace a configuration file that has no managed section")
 
 	return cmd
 }
@@ -146,159 +184,18 @@ func setupFirewallCmd() *cobra.Command {
 	return cmd
 }
 
-// createTestnetConfig creates a testnet configuration file
+// createTestnetConfig creates a testnet configuration file in the pool-server schema
 func createTestnetConfig(path, poolName, listenAddr, grpcAddr, webAddr string) error {
-	config := `# Galaxy Node Pool Testnet Configuration
-version: 1.0
-environment: testnet
-
-# Pool configuration
-pool:
-  name: {{.PoolName}}
-  description: "Galaxy Node Pool Testnet"
-  listen_address: "{{.ListenAddr}}"
-  grpc_address: "{{.GrpcAddr}}"
-  web_address: "{{.WebAddr}}"
-
-# Node configuration
-node:
-  default_specialization: "developer"
-  health_check_interval: 30
-  timeout: 10
-
-# Security configuration
-security:
-  require_authentication: true
-  allow_anonymous_queries: true
-`
-
-	// Create template
-	tmpl, err := template.New("config").Parse(config)
-	if err != nil {
-		return err
-	}
-
-	// Create file
-	f, err := os.Create(path)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	// Execute template
-	data := struct {
-		PoolName   string
-		ListenAddr string
-		GrpcAddr   string
-		WebAddr    string
-	}{
-		PoolName:   poolName,
-		ListenAddr: listenAddr,
-		GrpcAddr:   grpcAddr,
-		WebAddr:    webAddr,
-	}
-
-	return tmpl.Execute(f, data)
-}
-
-// createNginxConfig creates an Nginx configuration file
-func createNginxConfig(path, serverName, apiPort, webPort string) error {
-	config := `# Galaxy Node Pool Nginx Configuration
-server {
-    listen 80;
-    server_name {{.ServerName}};
-    
-    location / {
-        return 301 https://$host$request_uri;
-    }
-    
-    # Let's Encrypt verification
-    location /.well-known/acme-challenge/ {
-        root /var/www/html;
-    }
-}
-
-server {
-    listen 443 ssl http2;
-    server_name {{.ServerName}};
-    
-    # SSL Configuration (placeholder - will be updated by certbot)
-    ssl_certificate /etc/ssl/certs/ssl-cert-snakeoil.pem;
-    ssl_certificate_key /etc/ssl/private/ssl-cert-snakeoil.key;
-    
-    # SSL Settings
-    ssl_protocols TLSv1.2 TLSv1.3;
-    ssl_prefer_server_ciphers on;
-    ssl_ciphers ECDHE-ECDSA-AES128-GCM-SHA256:ECDHE-RSA-AES128-GCM-SHA256:ECDHE-ECDSA-AES256-GCM-SHA384:ECDHE-RSA-AES256-GCM-SHA384:ECDHE-ECDSA-CHACHA20-POLY1305:ECDHE-RSA-CHACHA20-POLY1305:DHE-RSA-AES128-GCM-SHA256:DHE-RSA-AES256-GCM-SHA384;
-    ssl_session_cache shared:SSL:10m;
-    ssl_session_timeout 1d;
-    ssl_session_tickets off;
-    
-    # Security Headers
-    add_header X-Frame-Options "SAMEORIGIN" always;
-    add_header X-Content-Type-Options "nosniff" always;
-    add_header X-XSS-Protection "1; mode=block" always;
-    add_header Referrer-Policy "strict-origin-when-cross-origin" always;
-    
-    # Logs
-    access_log /var/log/nginx/{{.ServerName}}.access.log;
-    error_log /var/log/nginx/{{.ServerName}}.error.log;
-    
-    # API Service
-    location /api/ {
-        proxy_pass http://localhost:{{.ApiPort}};
-        proxy_set_header Host $host;
-        proxy_set_header X-Real-IP $remote_addr;
-        proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;
-        proxy_set_header X-Forwarded-Proto $scheme;
-        
-        # Timeouts
-        proxy_connect_timeout 60s;
-        proxy_send_timeout 60s;
-        proxy_read_timeout 60s;
-    }
-    
-    # Web Interface
-    location / {
-        proxy_pass http://localhost:{{.WebPort}};
-        proxy_set_header Host $host;
-        proxy_set_header X-Real-IP $remote_addr;
-        proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;
-        proxy_set_header X-Forwarded-Proto $scheme;
-        
-        # Timeouts
-        proxy_connect_timeout 60s;
-        proxy_send_timeout 60s;
-        proxy_read_timeout 60s;
-    }
+	cfg := testnet.NewConfig(poolName, "", listenAddr, grpcAddr, webAddr)
+	return config.WriteFile(path, cfg, "Galaxy Node Pool Testnet Configuration")
 }
-`
 
-	// Create template
-	tmpl, err := template.New("nginx").Parse(config)
-	if err != nil {
-		return err
+// createNginxConfig creates or updates the managed section of an Nginx configuration file
+func createNginxConfig(path string, opts nginx.PoolOptions, writeOpts nginx.WriteOptions) (*nginx.Result, error) {
+	if (opts.SSLCertificate == "") != (opts.SSLCertificateKey == "") {
+		return nil, fmt.Errorf("--cert and --key must be given together")
 	}
-
-	// Create file
-	f, err := os.Create(path)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	// Execute template
-	data := struct {
-		ServerName string
-		ApiPort    string
-		WebPort    string
-	}{
-		ServerName: serverName,
-		ApiPort:    apiPort,
-		WebPort:    webPort,
-	}
-
-	return tmpl.Execute(f, data)
+	return nginx.WriteConfig(path, nginx.NewPoolConfig(opts), writeOpts)
 }
 
 // generateFirewallRules generates firewall rules for the Galaxy Node Pool
@@ -3,13 +3,16 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
 	"path/filepath"
-	"strings"
+	"syscall"
 
 	"github.com/spf13/cobra"
 	
+	"galaxy-node-pool/internal/nginx"
 	"galaxy-node-pool/internal/testnet"
 )
 
@@ -25,7 +28,10 @@ func testnetCmd() *cobra.Command {
 	cmd.AddCommand(testnetStartCmd())
 	cmd.AddCommand(testnetStopCmd())
 	cmd.AddCommand(testnetStatusCmd())
+	cmd.AddCommand(testnetSuperviseCmd())
 	cmd.AddCommand(testnetConfigCmd())
+	cmd.AddCommand(testnetNginxCmd())
+	cmd.AddCommand(testnetClusterCmd())
 	cmd.AddCommand(testnetSslCmd())
 
 	return cmd
@@ -67,7 +73,7 @@ func testnetInitCmd() *cobra.Command {
 
 			// Generate Nginx configuration if requested
 			if generateNginx {
-				if err := manager.GenerateNginxConfig(poolName, orgID); err != nil {
+				if err := manager.GenerateNginxConfig(poolName, orgID, nginx.WriteOptions{}); err != nil {
 					fmt.Printf("Error generating Nginx configuration: %v\n", err)
 				}
 			}
@@ -89,60 +95,113 @@ func testnetInitCmd() *cobra.Command {
 	return cmd
 }
 
-// generateNginxConfig generates a Nginx configuration for the testnet using a template
-func generateNginxConfig(nginxPath, poolName, orgID, apiPort, wsPort, webPort string) error {
-	// Get the template path
-	homeDir := os.Getenv("HOME")
-	if homeDir == "" {
-		homeDir = "/root"
-	}
-	templateFile := filepath.Join(homeDir, "galaxy-node-pool", "templates", "nginx-testnet.conf.template")
-	
-	// Check if template exists
-	if _, err := os.Stat(templateFile); os.IsNotExist(err) {
-		return fmt.Errorf("template file not found: %s", templateFile)
-	}
-	
-	// Read template file
-	templateData, err := os.ReadFile(templateFile)
-	if err != nil {
-		return fmt.Errorf("failed to read template file: %v", err)
+// testnetNginxCmd creates a command to generate or update the Nginx configuration of a testnet pool
+func testnetNginxCmd() *cobra.Command {
+	var orgID string
+	var dryRun bool
+	var force bool
+
+	cmd := &cobra.Command{
+		Use:   "nginx [pool-name]",
+		Short: "Generate or update the Nginx configuration of a testnet pool",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			poolName := "test"
+			if len(args) > 0 {
+				poolName = args[0]
+			}
+
+			// Create testnet manager
+			manager := testnet.NewManager("")
+
+			return manager.GenerateNginxConfig(poolName, orgID, nginx.WriteOptions{
+				DryRun: dryRun,
+				Force:  force,
+			})
+		},
 	}
-	
-	// Get certificate paths
-	certDir := filepath.Join(homeDir, ".galaxy", "certs")
-	domain := fmt.Sprintf("*.%s.pool.galaxy.net.%s.asia.hybridconnect.cloud", poolName, orgID)
-	certPath := filepath.Join(certDir, domain+".crt")
-	keyPath := filepath.Join(certDir, domain+".key")
-	
-	// Check if certificates exist, otherwise use default snakeoil certs
-	if _, err := os.Stat(certPath); os.IsNotExist(err) {
-		certPath = "/etc/ssl/certs/ssl-cert-snakeoil.pem"
+
+	cmd.Flags().StringVar(&orgID, "org-id", "", "Organization ID for the testnet domain (default: testorg)")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show the changes as a diff without writing them")
+	cmd.Flags().BoolVar(&force, "force", false, "Replace a configuration file that has no managed section")
+
+	return cmd
+}
+
+// testnetClusterCmd creates a command to run local simulation clusters
+func testnetClusterCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cluster",
+		Short: "Run a local simulation cluster of registries and nodes",
 	}
-	if _, err := os.Stat(keyPath); os.IsNotExist(err) {
-		keyPath = "/etc/ssl/private/ssl-cert-snakeoil.key"
+
+	cmd.AddCommand(testnetClusterUpCmd())
+
+	return cmd
+}
+
+// testnetClusterUpCmd creates a command that runs a simulation cluster in the foreground
+func testnetClusterUpCmd() *cobra.Command {
+	opts := testnet.DefaultClusterOptions()
+	var logFile string
+
+	cmd := &cobra.Command{
+		Use:   "up",
+		Short: "Run registries and simulated nodes on loopback ports",
+		Long: `Run several registries and simulated nodes in this process, on loopback ports.
+
+Nodes register, heartbeat with a synthetic load, fail at random and rejoin a
+random pool after some downtime. When the run ends, after --duration or on
+Ctrl-C, a report shows how long the registries took to converge, how soon
+failed nodes were evicted and whether any running node was lost.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			manager := testnet.NewManager("")
+			opts.LogFile = logFile
+			if opts.LogFile == "" {
+				opts.LogFile = filepath.Join(manager.ConfigDir, "cluster.log")
+			}
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			fmt.Printf("Registry logs: %s\n", opts.LogFile)
+			_, err := manager.RunCluster(ctx, opts)
+			return err
+		},
 	}
-	
-	// Replace variables in template
-	nginxConfig := string(templateData)
-	nginxConfig = strings.ReplaceAll(nginxConfig, "${pool_env}", poolName)
-	nginxConfig = strings.ReplaceAll(nginxConfig, "${org_id}", orgID)
-	nginxConfig = strings.ReplaceAll(nginxConfig, "${api_port}", apiPort)
-	nginxConfig = strings.ReplaceAll(nginxConfig, "${ws_port}", wsPort)
-	nginxConfig = strings.ReplaceAll(nginxConfig, "${web_port}", webPort)
-	nginxConfig = strings.ReplaceAll(nginxConfig, "${ssl_cert}", certPath)
-	nginxConfig = strings.ReplaceAll(nginxConfig, "${ssl_key}", keyPath)
-	
-	// Write the configuration file
-	return os.WriteFile(nginxPath, []byte(nginxConfig), 0644)
+
+	cmd.Flags().IntVar(&opts.Pools, "pools", opts.Pools, "Number of registries")
+	cmd.Flags().IntVar(&opts.Nodes, "nodes", opts.Nodes, "Number of simulated nodes")
+	cmd.Flags().IntVar(&opts.BasePort, "base-port", 0, "Port of the first registry, the others use the following ports (default: free ports)")
+	cmd.Flags().DurationVar(&opts.Duration, "duration", opts.Duration, "How long to run, 0 runs until interrupted")
+	cmd.Flags().DurationVar(&opts.HeartbeatInterval, "heartbeat-interval", opts.HeartbeatInterval, "Interval between node heartbeats")
+	cmd.Flags().DurationVar(&opts.HealthCheckInterval, "health-check-interval", opts.HealthCheckInterval, "Registry health check interval")
+	cmd.Flags().DurationVar(&opts.LeaseTTL, "lease-ttl", opts.LeaseTTL, "Registry lease TTL")
+	cmd.Flags().DurationVar(&opts.SuspectAfter, "suspect-after", opts.SuspectAfter, "Time without heartbeats before a node is suspect")
+	cmd.Flags().Float64Var(&opts.FailureRate, "failure-rate", opts.FailureRate, "Chance of a node failing at each heartbeat")
+	cmd.Flags().DurationVar(&opts.MinDowntime, "min-downtime", opts.MinDowntime, "Shortest time a failed node stays down")
+	cmd.Flags().DurationVar(&opts.MaxDowntime, "max-downtime", opts.MaxDowntime, "Longest time a failed node stays down")
+	cmd.Flags().DurationVar(&opts.ReportInterval, "report-interval", opts.ReportInterval, "Interval between progress lines, 0 disables them")
+	cmd.Flags().Int64Var(&opts.Seed, "seed", opts.Seed, "Random seed for node behaviour (default: random)")
+	cmd.Flags().StringVar(&logFile, "log", "", "Registry log file (default: ~/.galaxy/testnet/cluster.log)")
+
+	return cmd
 }
 
 // testnetStartCmd creates a command to start a testnet pool
 func testnetStartCmd() *cobra.Command {
+	var serverBinary string
+
 	cmd := &cobra.Command{
 		Use:   "start [pool-name]",
 		Short: "Start a testnet pool",
-		Args:  cobra.MaximumNArgs(1),
+		Long: `Start the pool-server of a testnet pool in the background.
+
+The server runs under a supervisor that restarts it when it crashes and
+stops it gracefully on "testnet stop". Its PID is written to pool.pid and
+its output to the log file of the pool config.`,
+		Args: cobra.MaximumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			poolName := "test"
 			if len(args) > 0 {
@@ -153,12 +212,43 @@ func testnetStartCmd() *cobra.Command {
 
 			// Create testnet manager
 			manager := testnet.NewManager("")
+			manager.ServerBinary = serverBinary
 
 			// Start the testnet pool
 			return manager.Start(poolName)
 		},
 	}
 
+	cmd.Flags().StringVar(&serverBinary, "server-binary", "", "Path to the pool-server binary (default: next to galaxy-pool, then PATH)")
+
+	return cmd
+}
+
+// testnetSuperviseCmd creates the command that runs and supervises a testnet
+// pool-server in the foreground, started in the background by testnet start
+func testnetSuperviseCmd() *cobra.Command {
+	var serverBinary string
+
+	cmd := &cobra.Command{
+		Use:    "supervise [pool-name]",
+		Short:  "Run and supervise a testnet pool-server in the foreground",
+		Hidden: true,
+		Args:   cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			poolName := "test"
+			if len(args) > 0 {
+				poolName = args[0]
+			}
+
+			manager := testnet.NewManager("")
+			manager.ServerBinary = serverBinary
+
+			return manager.Supervise(poolName)
+		},
+	}
+
+	cmd.Flags().StringVar(&serverBinary, "server-binary", "", "Path to the pool-server binary")
+
 	return cmd
 }
 
@@ -199,8 +289,6 @@ func testnetStatusCmd() *cobra.Command {
 				poolName = args[0]
 			}
 
-			fmt.Printf("Checking status of testnet pool: %s\n", poolName)
-
 			// Create testnet manager
 			manager := testnet.NewManager("")
 
@@ -59,9 +59,12 @@ func testnetSslGenerateCmd() *cobra.Command {
 			
 			certDir := filepath.Join(homeDir, ".galaxy", "certs")
 			
-			// Use the specified Nginx config or the testnet one
+			// Use the specified Nginx config or the one generated for the testnet pool
 			if nginxConfigPath == "" {
-				nginxConfigPath = filepath.Join(homeDir, "galaxy-node-pool", "testnet-nginx.conf")
+				nginxConfigPath = filepath.Join(homeDir, ".galaxy", "testnet", poolName, "nginx.conf")
+				if _, err := os.Stat(nginxConfigPath); err != nil {
+					nginxConfigPath = ""
+				}
 			}
 			
 			manager := cert.NewManager(certDir, nginxConfigPath)
@@ -82,7 +85,7 @@ func testnetSslGenerateCmd() *cobra.Command {
 				}
 				
 				// Generate certificate using Let's Encrypt
-				return manager.GenerateWithLetsEncrypt(domain, config.Email, true, config.DNSProvider)
+				return manager.GenerateWithLetsEncrypt(domain, config, true)
 			}
 			
 			// Generate self-signed certificate for testnet
@@ -94,7 +97,7 @@ func testnetSslGenerateCmd() *cobra.Command {
 	cmd.Flags().StringVar(&poolName, "pool-name", "", "Pool name for the testnet domain (default: test)")
 	cmd.Flags().StringVar(&orgID, "org-id", "", "Organization ID for the testnet domain (default: testorg)")
 	cmd.Flags().BoolVar(&useProduction, "production", false, "Use production-grade certificates from Let's Encrypt")
-	cmd.Flags().StringVar(&nginxConfigPath, "nginx-config", "", "Path to Nginx configuration file")
+	cmd.Flags().StringVar(&nginxConfigPath, "nginx-config", "", "Path to Nginx configuration file (default: the testnet pool's generated configuration)")
 	
 	return cmd
 }
@@ -2,21 +2,27 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"flag"
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 	"time"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 
+	"galaxy-node-pool/internal/auth"
+	"galaxy-node-pool/internal/cert"
 	"galaxy-node-pool/internal/config"
+	"galaxy-node-pool/internal/container"
 	"galaxy-node-pool/internal/federation"
 	"galaxy-node-pool/internal/plugin"
 	"galaxy-node-pool/internal/registry"
 	"galaxy-node-pool/internal/stellar"
+	"galaxy-node-pool/internal/storage"
 	pb "galaxy-node-pool/proto/pool"
 )
 
@@ -39,16 +45,46 @@ func main() {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
-	// Create plugin manager
+	// Create plugin manager, plugins are also published in the service
+	// container for modules
 	log.Printf("Initializing plugin manager with directory: %s", *pluginDir)
 	pluginManager := plugin.NewPluginManager()
+	services := container.NewServiceContainer()
+	if err := pluginManager.SetContainer(services); err != nil {
+		log.Printf("Warning: Failed to register plugin manager with container: %v", err)
+	}
+
+	// Plugin files are verified against the trust policy before loading
+	trustedKeys, err := config.GetTrustedPluginKeys(cfg)
+	if err != nil {
+		log.Fatalf("Invalid plugin trust policy: %v", err)
+	}
+	trustPolicy, err := plugin.NewTrustPolicy(cfg.PluginTrust.Signatures, trustedKeys)
+	if err != nil {
+		log.Fatalf("Invalid plugin trust policy: %v", err)
+	}
+	pluginManager.SetTrustPolicy(trustPolicy)
 
 	// Extract plugin configs
 	pluginConfigs := config.GetPluginConfigs(cfg)
 
-	// Initialize plugin manager
-	if err := pluginManager.Initialize(*pluginDir, pluginConfigs); err != nil {
+	// Load the plugins of the plugin directory that have a configuration
+	report, err := pluginManager.Initialize(*pluginDir, pluginConfigs)
+	if err != nil {
 		log.Printf("Warning: Failed to initialize plugin manager: %v", err)
+	} else if len(report.Failed) > 0 {
+		log.Printf("Warning: %d plugins failed to load, plugins they provide are unavailable", len(report.Failed))
+	}
+
+	// Register built-in plugins
+	builtins := []plugin.Plugin{
+		storage.NewFileStoragePlugin(),
+		auth.NewAPIKeyPlugin(),
+	}
+	for _, builtin := range builtins {
+		if err := pluginManager.Register(builtin.Name(), builtin); err != nil {
+			log.Printf("Warning: Failed to register built-in plugin %s: %v", builtin.Name(), err)
+		}
 	}
 
 	// Create context for graceful shutdown
@@ -66,13 +102,99 @@ func main() {
 
 	// Prepare gRPC server options
 	var opts []grpc.ServerOption
+	var nodeCA *cert.CA
 	if cfg.Server.TLS.Enabled {
-		log.Printf("Setting up TLS with cert: %s, key: %s", cfg.Server.TLS.CertFile, cfg.Server.TLS.KeyFile)
-		creds, err := credentials.NewServerTLSFromFile(cfg.Server.TLS.CertFile, cfg.Server.TLS.KeyFile)
+		certFile, keyFile := cfg.Server.TLS.CertFile, cfg.Server.TLS.KeyFile
+		renewal := cfg.Server.TLS.Renewal
+
+		var certManager *cert.Manager
+		if renewal.Enabled {
+			certManager = cert.NewManager(renewal.CertDir, "")
+			if certFile == "" && renewal.Domain != "" {
+				certFile = filepath.Join(certManager.CertDir, renewal.Domain+".crt")
+				keyFile = filepath.Join(certManager.CertDir, renewal.Domain+".key")
+			}
+		}
+
+		// Serve the certificate through GetCertificate so renewed
+		// certificates are picked up by new handshakes without a restart
+		log.Printf("Setting up TLS with cert: %s, key: %s", certFile, keyFile)
+		reloader, err := cert.NewKeyPairReloader(certFile, keyFile)
 		if err != nil {
 			log.Fatalf("Failed to setup TLS: %v", err)
 		}
-		opts = append(opts, grpc.Creds(creds))
+		go reloader.Watch(ctx, durationSetting("server.tls.reload_interval", cfg.Server.TLS.ReloadInterval, time.Minute))
+
+		if certManager != nil {
+			renewer := cert.NewRenewalService(certManager, renewal.CertConfig,
+				durationSetting("server.tls.renewal.threshold", renewal.Threshold, cert.DefaultRenewalThreshold),
+				durationSetting("server.tls.renewal.check_interval", renewal.CheckInterval, cert.DefaultRenewalInterval))
+			renewer.OnRenew(func(domain string) {
+				if _, err := reloader.Reload(); err != nil {
+					log.Printf("Warning: Failed to reload TLS certificate after renewing %s: %v", domain, err)
+				}
+			})
+			renewer.Start(ctx)
+			log.Printf("Renewing certificates in %s %s before expiry", certManager.CertDir, renewal.Threshold)
+		}
+
+		tlsConfig := &tls.Config{
+			GetCertificate: reloader.GetCertificate,
+			MinVersion:     tls.VersionTLS12,
+		}
+
+		clientAuth := cfg.Server.TLS.ClientAuth
+		if clientAuth != "" && clientAuth != auth.ClientAuthNone {
+			if clientAuth != auth.ClientAuthNode && clientAuth != auth.ClientAuthRequire {
+				log.Fatalf("Invalid client_auth %q, use %s, %s or %s", clientAuth, auth.ClientAuthNone, auth.ClientAuthNode, auth.ClientAuthRequire)
+			}
+
+			// Nodes enroll with the internal CA and present its certificates
+			nodeCA, err = cert.LoadOrCreateCA(cfg.Server.TLS.CADir, "Galaxy Node Pool CA")
+			if err != nil {
+				log.Fatalf("Failed to load node CA: %v", err)
+			}
+			log.Printf("Requiring client certificates (%s) from CA: %s", clientAuth, cfg.Server.TLS.CADir)
+			tlsConfig = nodeCA.ServerTLSConfig(reloader.GetCertificate)
+
+			// Map client certificates to nodes before the auth plugin sees the call
+			certInterceptor := auth.NewCertInterceptor(nodeCA, clientAuth)
+			opts = append(opts,
+				grpc.ChainUnaryInterceptor(certInterceptor.Unary()),
+				grpc.ChainStreamInterceptor(certInterceptor.Stream()),
+			)
+		}
+
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+
+	// Authenticate and authorize calls through the configured auth plugin
+	if cfg.Server.Auth.Plugin != "" {
+		plg, err := pluginManager.Get(cfg.Server.Auth.Plugin)
+		if err != nil {
+			log.Fatalf("Failed to find auth plugin: %v", err)
+		}
+		authPlugin, ok := plg.(plugin.AuthPlugin)
+		if !ok {
+			log.Fatalf("Plugin %s is not an auth plugin", cfg.Server.Auth.Plugin)
+		}
+
+		authConfig := pluginConfigs[cfg.Server.Auth.Plugin]
+		if authConfig == nil {
+			authConfig = map[string]interface{}{}
+		}
+		if err := pluginManager.InitializePlugin(cfg.Server.Auth.Plugin, authConfig); err != nil {
+			log.Fatalf("Failed to initialize auth plugin %s: %v", cfg.Server.Auth.Plugin, err)
+		}
+
+		interceptor := auth.NewInterceptor(authPlugin)
+		opts = append(opts,
+			grpc.ChainUnaryInterceptor(interceptor.Unary()),
+			grpc.ChainStreamInterceptor(interceptor.Stream()),
+		)
+		log.Printf("Authenticating registry calls with plugin: %s", cfg.Server.Auth.Plugin)
+	} else {
+		log.Printf("Warning: No auth plugin configured, registry calls are not authenticated")
 	}
 
 	// Create and configure the registry
@@ -87,6 +209,10 @@ func main() {
 	// Create gRPC server and register services
 	grpcServer := grpc.NewServer(opts...)
 	pb.RegisterRegistryServer(grpcServer, reg)
+	if nodeCA != nil {
+		certTTL := durationSetting("server.tls.client_cert_ttl", cfg.Server.TLS.ClientCertTTL, cert.DefaultClientCertTTL)
+		pb.RegisterNodeCAServer(grpcServer, cert.NewCAService(nodeCA, certTTL))
+	}
 
 	// Start listening
 	log.Printf("Starting Galaxy Node Pool server on %s (TLS: %v)", cfg.Server.Address, cfg.Server.TLS.Enabled)
@@ -108,5 +234,24 @@ func main() {
 	
 	// Graceful shutdown
 	grpcServer.GracefulStop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	if err := pluginManager.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Warning: %v", err)
+	}
 	log.Println("Server shutdown complete")
 }
+
+// durationSetting parses a duration setting, falling back to a default
+func durationSetting(name, value string, fallback time.Duration) time.Duration {
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		log.Printf("Warning: Invalid %s %q, using %s", name, value, fallback)
+		return fallback
+	}
+	return d
+}
@@ -0,0 +1,423 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"galaxy-node-pool/internal/plugin"
+)
+
+const (
+	// APIKeyPluginName is the name the built-in API key plugin registers under
+	APIKeyPluginName = "auth-plugin"
+
+	// DefaultKeysReloadInterval is how often the keys file is checked for changes
+	DefaultKeysReloadInterval = 30 * time.Second
+
+	// hashPrefix marks a hashed key in configuration
+	hashPrefix = "sha256:"
+)
+
+// APIKey is a configured API key. Only the hash of the key is kept.
+type APIKey struct {
+	// ID identifies the key in logs and is the authenticated user ID
+	ID string
+
+	// Hash is the hex encoded SHA-256 of the key
+	Hash string
+
+	// Scopes are the actions the key may perform; admin allows every action
+	Scopes []string
+
+	// Org binds the key to a single organization when set
+	Org string
+
+	// ExpiresAt is when the key stops working, zero for keys that don't expire
+	ExpiresAt time.Time
+}
+
+// allows reports whether the key's scopes permit an action
+func (k *APIKey) allows(action string) bool {
+	for _, scope := range k.Scopes {
+		if scope == action || scope == ActionAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKeyPlugin implements the AuthPlugin interface with hashed API keys
+// taken from the plugin config and an optional keys file that is reloaded
+// whenever it changes
+type APIKeyPlugin struct {
+	byHash         map[string]*APIKey
+	byID           map[string]*APIKey
+	inlineKeys     []*APIKey
+	keysFile       string
+	keysFileMod    time.Time
+	reloadInterval time.Duration
+	stop           chan struct{}
+	initialized    bool
+	mu             sync.RWMutex
+}
+
+// NewAPIKeyPlugin creates a new API key auth plugin instance
+func NewAPIKeyPlugin() *APIKeyPlugin {
+	return &APIKeyPlugin{
+		byHash:      make(map[string]*APIKey),
+		byID:        make(map[string]*APIKey),
+		initialized: false,
+	}
+}
+
+// Name returns the plugin name
+func (p *APIKeyPlugin) Name() string {
+	return APIKeyPluginName
+}
+
+// Initialize sets up the plugin with its configuration:
+//
+//	api_keys:        list of keys with id, hash, scopes, org and expires_at
+//	keys_file:       YAML or JSON file with an api_keys list, reloaded on change
+//	reload_interval: how often keys_file is checked, e.g. 30s
+func (p *APIKeyPlugin) Initialize(config map[string]interface{}) error {
+	inlineKeys, err := parseAPIKeys(config["api_keys"])
+	if err != nil {
+		return fmt.Errorf("invalid api_keys: %v", err)
+	}
+
+	reloadInterval := DefaultKeysReloadInterval
+	if value, ok := config["reload_interval"].(string); ok && value != "" {
+		reloadInterval, err = time.ParseDuration(value)
+		if err != nil || reloadInterval <= 0 {
+			return fmt.Errorf("invalid reload_interval %q", value)
+		}
+	}
+
+	p.mu.Lock()
+	p.inlineKeys = inlineKeys
+	p.keysFile, _ = config["keys_file"].(string)
+	p.reloadInterval = reloadInterval
+	p.mu.Unlock()
+
+	if err := p.Reload(); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.keysFile != "" && p.stop == nil {
+		p.stop = make(chan struct{})
+		go p.watchKeysFile(p.stop)
+	}
+	p.initialized = true
+
+	log.Printf("API key auth plugin initialized with %d keys", len(p.byHash))
+	return nil
+}
+
+// Shutdown gracefully stops the plugin
+func (p *APIKeyPlugin) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stop != nil {
+		close(p.stop)
+		p.stop = nil
+	}
+	p.initialized = false
+	return nil
+}
+
+// Reload re-reads the keys file and replaces the active keys. On error the
+// previously loaded keys stay active.
+func (p *APIKeyPlugin) Reload() error {
+	p.mu.RLock()
+	keysFile := p.keysFile
+	keys := append([]*APIKey(nil), p.inlineKeys...)
+	p.mu.RUnlock()
+
+	var modTime time.Time
+	if keysFile != "" {
+		info, err := os.Stat(keysFile)
+		if err != nil {
+			return fmt.Errorf("failed to read keys file: %v", err)
+		}
+		modTime = info.ModTime()
+
+		fileKeys, err := loadKeysFile(keysFile)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, fileKeys...)
+	}
+
+	byHash := make(map[string]*APIKey, len(keys))
+	byID := make(map[string]*APIKey, len(keys))
+	for _, key := range keys {
+		if _, exists := byID[key.ID]; exists {
+			return fmt.Errorf("duplicate API key id %s", key.ID)
+		}
+		if other, exists := byHash[key.Hash]; exists {
+			return fmt.Errorf("duplicate API key hash for ids %s and %s", other.ID, key.ID)
+		}
+		byHash[key.Hash] = key
+		byID[key.ID] = key
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.byHash = byHash
+	p.byID = byID
+	p.keysFileMod = modTime
+	return nil
+}
+
+// watchKeysFile reloads the keys file whenever its modification time changes
+func (p *APIKeyPlugin) watchKeysFile(stop chan struct{}) {
+	ticker := time.NewTicker(p.reloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			info, err := os.Stat(p.keysFile)
+			if err != nil {
+				log.Printf("Warning: Failed to check API keys file: %v", err)
+				continue
+			}
+
+			p.mu.RLock()
+			changed := !info.ModTime().Equal(p.keysFileMod)
+			p.mu.RUnlock()
+			if !changed {
+				continue
+			}
+
+			if err := p.Reload(); err != nil {
+				log.Printf("Warning: Failed to reload API keys, keeping previous keys: %v", err)
+				continue
+			}
+			log.Printf("Reloaded API keys from %s", p.keysFile)
+		}
+	}
+}
+
+// Authenticate verifies the API key passed as a bearer token or x-api-key
+// header and returns the key ID
+func (p *APIKeyPlugin) Authenticate(credentials map[string]string) (string, error) {
+	userID, _, err := p.AuthenticateOrg(credentials)
+	return userID, err
+}
+
+// AuthenticateOrg verifies the API key like Authenticate and also returns
+// the organization the key is bound to, from the same key set
+func (p *APIKeyPlugin) AuthenticateOrg(credentials map[string]string) (string, string, error) {
+	secret := credentials["token"]
+	if secret == "" {
+		secret = credentials["x-api-key"]
+	}
+	if secret == "" {
+		return "", "", fmt.Errorf("no API key provided")
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if !p.initialized {
+		return "", "", fmt.Errorf("API key auth plugin not initialized")
+	}
+
+	key, ok := p.byHash[HashAPIKey(secret)]
+	if !ok {
+		return "", "", fmt.Errorf("invalid API key")
+	}
+	if !key.ExpiresAt.IsZero() && !time.Now().Before(key.ExpiresAt) {
+		return "", "", fmt.Errorf("API key %s expired at %s", key.ID, key.ExpiresAt.Format(time.RFC3339))
+	}
+
+	return key.ID, key.Org, nil
+}
+
+// Authorize checks if a key's scopes permit an action
+func (p *APIKeyPlugin) Authorize(userID string, resource string, action string) (bool, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	// Keys removed by a reload lose access immediately
+	key, ok := p.byID[userID]
+	if !ok {
+		return false, nil
+	}
+	return key.allows(action), nil
+}
+
+// UserOrg returns the organization a key is bound to
+func (p *APIKeyPlugin) UserOrg(userID string) string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if key, ok := p.byID[userID]; ok {
+		return key.Org
+	}
+	return ""
+}
+
+// Middleware returns an HTTP middleware that requires a valid API key
+func (p *APIKeyPlugin) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			credentials := map[string]string{
+				"x-api-key": r.Header.Get("X-API-Key"),
+			}
+			if authz := r.Header.Get("Authorization"); len(authz) > 7 && strings.EqualFold(authz[:7], "bearer ") {
+				credentials["token"] = strings.TrimSpace(authz[7:])
+			}
+
+			if _, err := p.Authenticate(credentials); err != nil {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// HashAPIKey returns the hex encoded SHA-256 hash under which a key is stored
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// GenerateAPIKey returns a new random API key
+func GenerateAPIKey() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate API key: %v", err)
+	}
+	return "gnp_" + hex.EncodeToString(buf), nil
+}
+
+// loadKeysFile reads the api_keys list from a YAML or JSON file
+func loadKeysFile(path string) ([]*APIKey, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("failed to read keys file %s: %v", path, err)
+	}
+
+	keys, err := parseAPIKeys(v.Get("api_keys"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid keys file %s: %v", path, err)
+	}
+	return keys, nil
+}
+
+// parseAPIKeys converts a configured api_keys list into keys. Entries are
+// maps, or plain key strings which are hashed on load and granted every scope.
+func parseAPIKeys(value interface{}) ([]*APIKey, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	entries, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected a list, got %T", value)
+	}
+
+	keys := make([]*APIKey, 0, len(entries))
+	for i, entry := range entries {
+		switch e := entry.(type) {
+		case string:
+			hash := HashAPIKey(e)
+			log.Printf("Warning: API key %d is configured in plain text, store its hash instead", i)
+			keys = append(keys, &APIKey{
+				ID:     "key-" + hash[:8],
+				Hash:   hash,
+				Scopes: []string{ActionAdmin},
+			})
+		case map[string]interface{}:
+			key, err := parseAPIKey(e)
+			if err != nil {
+				return nil, fmt.Errorf("key %d: %v", i, err)
+			}
+			keys = append(keys, key)
+		default:
+			return nil, fmt.Errorf("key %d: unexpected type %T", i, entry)
+		}
+	}
+
+	return keys, nil
+}
+
+// parseAPIKey converts a single configured key
+func parseAPIKey(entry map[string]interface{}) (*APIKey, error) {
+	key := &APIKey{}
+	key.ID, _ = entry["id"].(string)
+	key.Org, _ = entry["org"].(string)
+
+	hash, _ := entry["hash"].(string)
+	hash = strings.ToLower(strings.TrimPrefix(hash, hashPrefix))
+	if plain, ok := entry["key"].(string); ok && plain != "" {
+		log.Printf("Warning: API key %s is configured in plain text, store its hash instead", key.ID)
+		hash = HashAPIKey(plain)
+	}
+	if decoded, err := hex.DecodeString(hash); err != nil || len(decoded) != sha256.Size {
+		return nil, fmt.Errorf("hash must be a hex encoded SHA-256, optionally prefixed with %s", hashPrefix)
+	}
+	key.Hash = hash
+
+	if key.ID == "" {
+		key.ID = "key-" + hash[:8]
+	}
+
+	scopes, _ := entry["scopes"].([]interface{})
+	for _, scope := range scopes {
+		name, _ := scope.(string)
+		switch name {
+		case ActionRegister, ActionList, ActionAdmin:
+			key.Scopes = append(key.Scopes, name)
+		default:
+			return nil, fmt.Errorf("unknown scope %v", scope)
+		}
+	}
+	if len(key.Scopes) == 0 {
+		return nil, fmt.Errorf("at least one scope is required")
+	}
+
+	switch expires := entry["expires_at"].(type) {
+	case nil:
+	case time.Time:
+		key.ExpiresAt = expires
+	case string:
+		expiresAt, err := time.Parse(time.RFC3339, expires)
+		if err != nil {
+			return nil, fmt.Errorf("expires_at must be an RFC 3339 timestamp: %v", err)
+		}
+		key.ExpiresAt = expiresAt
+	default:
+		return nil, fmt.Errorf("unexpected expires_at type %T", expires)
+	}
+
+	return key, nil
+}
+
+// Ensure APIKeyPlugin implements the AuthPlugin interface
+var _ plugin.AuthPlugin = (*APIKeyPlugin)(nil)
+var _ plugin.OrgAuthenticator = (*APIKeyPlugin)(nil)
+var _ plugin.OrgResolver = (*APIKeyPlugin)(nil)
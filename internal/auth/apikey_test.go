@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAPIKeyPluginDuplicateKeys(t *testing.T) {
+	hash := HashAPIKey("gnp_secret")
+	scopes := []interface{}{ActionList}
+	tests := []struct {
+		name    string
+		inline  []interface{}
+		file    string
+		wantErr string
+	}{
+		{
+			name: "distinct keys",
+			inline: []interface{}{
+				map[string]interface{}{"id": "ci", "hash": hash, "scopes": scopes},
+				map[string]interface{}{"id": "ops", "key": "gnp_other", "scopes": scopes},
+			},
+		},
+		{
+			name: "duplicate id",
+			inline: []interface{}{
+				map[string]interface{}{"id": "ci", "hash": hash, "scopes": scopes},
+				map[string]interface{}{"id": "ci", "key": "gnp_other", "scopes": scopes},
+			},
+			wantErr: "duplicate API key id ci",
+		},
+		{
+			name: "same hash under another id",
+			inline: []interface{}{
+				map[string]interface{}{"id": "ci", "hash": hash, "scopes": scopes},
+				map[string]interface{}{"id": "ops", "hash": hashPrefix + strings.ToUpper(hash), "scopes": scopes},
+			},
+			wantErr: "duplicate API key hash for ids ci and ops",
+		},
+		{
+			name: "same key in plain text",
+			inline: []interface{}{
+				map[string]interface{}{"id": "ci", "hash": hash, "scopes": scopes},
+				map[string]interface{}{"id": "ops", "key": "gnp_secret", "scopes": scopes},
+			},
+			wantErr: "duplicate API key hash for ids ci and ops",
+		},
+		{
+			name:    "same hash in the keys file",
+			inline:  []interface{}{map[string]interface{}{"id": "ci", "hash": hash, "scopes": scopes}},
+			file:    "api_keys:\n  - id: ops\n    hash: " + hash + "\n    scopes: [list]\n",
+			wantErr: "duplicate API key hash for ids ci and ops",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := map[string]interface{}{"api_keys": tt.inline}
+			if tt.file != "" {
+				path := filepath.Join(t.TempDir(), "keys.yaml")
+				if err := os.WriteFile(path, []byte(tt.file), 0600); err != nil {
+					t.Fatal(err)
+				}
+				config["keys_file"] = path
+			}
+
+			p := NewAPIKeyPlugin()
+			err := p.Initialize(config)
+			defer p.Shutdown(context.Background())
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Initialize failed: %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Fatalf("Initialize error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAPIKeyPluginAuthenticateOrg(t *testing.T) {
+	p := NewAPIKeyPlugin()
+	err := p.Initialize(map[string]interface{}{"api_keys": []interface{}{
+		map[string]interface{}{"id": "ci", "key": "gnp_ci", "org": "org1", "scopes": []interface{}{ActionList}},
+		map[string]interface{}{"id": "ops", "key": "gnp_ops", "scopes": []interface{}{ActionAdmin}},
+	}})
+	if err != nil {
+		t.Fatalf("Initialize failed: %v", err)
+	}
+	defer p.Shutdown(context.Background())
+
+	tests := []struct {
+		name        string
+		credentials map[string]string
+		wantUser    string
+		wantOrg     string
+		wantErr     bool
+	}{
+		{name: "bearer token", credentials: map[string]string{"token": "gnp_ci"}, wantUser: "ci", wantOrg: "org1"},
+		{name: "x-api-key header", credentials: map[string]string{"x-api-key": "gnp_ci"}, wantUser: "ci", wantOrg: "org1"},
+		{name: "key without org", credentials: map[string]string{"token": "gnp_ops"}, wantUser: "ops"},
+		{name: "unknown key", credentials: map[string]string{"token": "gnp_other"}, wantErr: true},
+		{name: "no key", credentials: map[string]string{}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userID, org, err := p.AuthenticateOrg(tt.credentials)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("AuthenticateOrg succeeded for %s, want an error", userID)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("AuthenticateOrg failed: %v", err)
+			}
+			if userID != tt.wantUser || org != tt.wantOrg {
+				t.Errorf("AuthenticateOrg = %s, %q, want %s, %q", userID, org, tt.wantUser, tt.wantOrg)
+			}
+		})
+	}
+}
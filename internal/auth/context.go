@@ -0,0 +1,50 @@
+package auth
+
+import "context"
+
+// Identity is the authenticated caller of an RPC
+type Identity struct {
+	// UserID is the ID returned by the auth plugin's Authenticate
+	UserID string
+
+	// Method is the full gRPC method name, e.g. /pool.Registry/RegisterNode
+	Method string
+
+	// Action is the permission the method requires: register, list or admin
+	Action string
+
+	// Org is the organization the caller is bound to, empty when unrestricted
+	Org string
+}
+
+// identityKey is the context key under which the caller identity is stored
+type identityKey struct{}
+
+// WithIdentity returns a context carrying the caller identity
+func WithIdentity(ctx context.Context, identity *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// IdentityFromContext returns the caller identity, if the request was authenticated
+func IdentityFromContext(ctx context.Context) (*Identity, bool) {
+	identity, ok := ctx.Value(identityKey{}).(*Identity)
+	return identity, ok && identity != nil
+}
+
+// OrgFromContext returns the organization the caller is bound to, or an
+// empty string when the caller may act on any organization
+func OrgFromContext(ctx context.Context) string {
+	if identity, ok := IdentityFromContext(ctx); ok {
+		return identity.Org
+	}
+	return ""
+}
+
+// UserIDFromContext returns the authenticated user ID, or an empty string
+// when the request was not authenticated
+func UserIDFromContext(ctx context.Context) string {
+	if identity, ok := IdentityFromContext(ctx); ok {
+		return identity.UserID
+	}
+	return ""
+}
@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"galaxy-node-pool/internal/plugin"
+	pb "galaxy-node-pool/proto/pool"
+)
+
+// Actions passed to AuthPlugin.Authorize
+const (
+	// ActionRegister covers the calls a node makes about itself
+	ActionRegister = "register"
+
+	// ActionList covers read-only discovery calls
+	ActionList = "list"
+
+	// ActionAdmin covers operator calls, and any method without a known action
+	ActionAdmin = "admin"
+)
+
+// methodActions maps Registry and NodeCA RPCs to the action they require
+var methodActions = map[string]string{
+	pb.Registry_RegisterNode_FullMethodName:     ActionRegister,
+	pb.Registry_Heartbeat_FullMethodName:        ActionRegister,
+	pb.Registry_DeregisterNode_FullMethodName:   ActionRegister,
+	pb.Registry_RequestChallenge_FullMethodName: ActionRegister,
+	pb.Registry_ListNodes_FullMethodName:        ActionList,
+	pb.Registry_WatchNodes_FullMethodName:       ActionList,
+	pb.Registry_GetNode_FullMethodName:          ActionList,
+	pb.Registry_SelectNodes_FullMethodName:      ActionList,
+	pb.Registry_SetNodeStatus_FullMethodName:    ActionAdmin,
+	pb.NodeCA_IssueCertificate_FullMethodName:   ActionRegister,
+	pb.NodeCA_GetRevocationList_FullMethodName:  ActionList,
+	pb.NodeCA_RevokeCertificate_FullMethodName:  ActionAdmin,
+}
+
+// MethodAction returns the action a gRPC method requires
+func MethodAction(method string) string {
+	if action, ok := methodActions[method]; ok {
+		return action
+	}
+	return ActionAdmin
+}
+
+// Interceptor authenticates and authorizes gRPC calls through an AuthPlugin
+type Interceptor struct {
+	plugin plugin.AuthPlugin
+}
+
+// NewInterceptor creates a new interceptor backed by the given auth plugin
+func NewInterceptor(authPlugin plugin.AuthPlugin) *Interceptor {
+	return &Interceptor{
+		plugin: authPlugin,
+	}
+}
+
+// Unary returns the interceptor for unary RPCs
+func (i *Interceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := i.authorize(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// Stream returns the interceptor for streaming RPCs
+func (i *Interceptor) Stream() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := i.authorize(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &identityStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// authorize authenticates the caller and checks it may call the method,
+// returning a context carrying the caller identity
+func (i *Interceptor) authorize(ctx context.Context, method string) (context.Context, error) {
+	action := MethodAction(method)
+
+	// A certificate issued by the pool CA is enough for a node to act as itself
+	if peerNode, ok := PeerNodeFromContext(ctx); ok && action == ActionRegister {
+		return WithIdentity(ctx, &Identity{
+			UserID: "node:" + peerNode.NodeID,
+			Method: method,
+			Action: action,
+			Org:    peerNode.Org,
+		}), nil
+	}
+
+	var userID, org string
+	var err error
+	if authenticator, ok := i.plugin.(plugin.OrgAuthenticator); ok {
+		userID, org, err = authenticator.AuthenticateOrg(Credentials(ctx))
+	} else {
+		userID, err = i.plugin.Authenticate(Credentials(ctx))
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, "authentication failed: %v", err)
+	}
+
+	allowed, err := i.plugin.Authorize(userID, method, action)
+	if err != nil {
+		log.Printf("Warning: Auth plugin %s failed to authorize %s for %s: %v", i.plugin.Name(), userID, method, err)
+		return nil, status.Errorf(codes.PermissionDenied, "authorization failed for %s", method)
+	}
+	if !allowed {
+		return nil, status.Errorf(codes.PermissionDenied, "%s is not allowed to %s (%s)", userID, action, method)
+	}
+
+	identity := &Identity{UserID: userID, Method: method, Action: action, Org: org}
+	if resolver, ok := i.plugin.(plugin.OrgResolver); ok && org == "" {
+		identity.Org = resolver.UserOrg(userID)
+	}
+
+	return WithIdentity(ctx, identity), nil
+}
+
+// Credentials extracts the credentials passed to AuthPlugin.Authenticate from
+// the request metadata. Every text metadata key is included with its first
+// value; a bearer authorization header is also exposed as "token" and the
+// caller's address as "peer_address".
+func Credentials(ctx context.Context) map[string]string {
+	credentials := make(map[string]string)
+
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for key, values := range md {
+			if len(values) == 0 || strings.HasSuffix(key, "-bin") {
+				continue
+			}
+			credentials[key] = values[0]
+		}
+	}
+
+	if authz := credentials["authorization"]; len(authz) > 7 && strings.EqualFold(authz[:7], "bearer ") {
+		credentials["token"] = strings.TrimSpace(authz[7:])
+	}
+
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		credentials["peer_address"] = p.Addr.String()
+	}
+
+	return credentials
+}
+
+// identityStream overrides the stream context so handlers see the caller identity
+type identityStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+// Context returns the context carrying the caller identity
+func (s *identityStream) Context() context.Context {
+	return s.ctx
+}
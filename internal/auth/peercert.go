@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"context"
+	"math/big"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	pb "galaxy-node-pool/proto/pool"
+)
+
+// Client certificate requirements for CertInterceptor
+const (
+	// ClientAuthNone disables client certificates
+	ClientAuthNone = "none"
+
+	// ClientAuthNode requires a certificate for the calls a node makes about itself
+	ClientAuthNode = "node"
+
+	// ClientAuthRequire requires a certificate for every call except enrollment
+	ClientAuthRequire = "require"
+)
+
+// nodeMethods are the calls bound to the certificate of the node making them
+var nodeMethods = map[string]bool{
+	pb.Registry_RegisterNode_FullMethodName:   true,
+	pb.Registry_Heartbeat_FullMethodName:      true,
+	pb.Registry_DeregisterNode_FullMethodName: true,
+}
+
+// PeerNode is the node identity taken from a verified client certificate
+type PeerNode struct {
+	// NodeID is the certificate's common name
+	NodeID string
+
+	// Org is the certificate's organization
+	Org string
+
+	// Serial is the certificate serial number in hex
+	Serial string
+}
+
+// peerNodeKey is the context key under which the certificate identity is stored
+type peerNodeKey struct{}
+
+// WithPeerNode returns a context carrying the certificate identity
+func WithPeerNode(ctx context.Context, node *PeerNode) context.Context {
+	return context.WithValue(ctx, peerNodeKey{}, node)
+}
+
+// PeerNodeFromContext returns the certificate identity, if the caller presented one
+func PeerNodeFromContext(ctx context.Context) (*PeerNode, bool) {
+	node, ok := ctx.Value(peerNodeKey{}).(*PeerNode)
+	return node, ok && node != nil
+}
+
+// RevocationChecker reports whether a certificate has been revoked
+type RevocationChecker interface {
+	IsRevoked(serial *big.Int) bool
+}
+
+// CertInterceptor maps verified client certificates to node identities and
+// rejects revoked certificates on every call
+type CertInterceptor struct {
+	checker RevocationChecker
+	mode    string
+}
+
+// NewCertInterceptor creates a new interceptor for the given client auth mode
+func NewCertInterceptor(checker RevocationChecker, mode string) *CertInterceptor {
+	return &CertInterceptor{
+		checker: checker,
+		mode:    mode,
+	}
+}
+
+// Unary returns the interceptor for unary RPCs
+func (i *CertInterceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := i.verify(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// Stream returns the interceptor for streaming RPCs
+func (i *CertInterceptor) Stream() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := i.verify(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &identityStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// verify attaches the caller's certificate identity to the context and
+// enforces the certificate requirement for the method
+func (i *CertInterceptor) verify(ctx context.Context, method string) (context.Context, error) {
+	var node *PeerNode
+	if p, ok := peer.FromContext(ctx); ok {
+		if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(tlsInfo.State.VerifiedChains) > 0 {
+			leaf := tlsInfo.State.VerifiedChains[0][0]
+			if i.checker.IsRevoked(leaf.SerialNumber) {
+				return nil, status.Errorf(codes.Unauthenticated, "client certificate %s has been revoked", leaf.SerialNumber.Text(16))
+			}
+
+			node = &PeerNode{NodeID: leaf.Subject.CommonName, Serial: leaf.SerialNumber.Text(16)}
+			if len(leaf.Subject.Organization) > 0 {
+				node.Org = leaf.Subject.Organization[0]
+			}
+		}
+	}
+
+	if node != nil {
+		return WithPeerNode(ctx, node), nil
+	}
+
+	// Enrollment is how nodes get their first certificate
+	required := false
+	switch i.mode {
+	case ClientAuthRequire:
+		required = method != pb.NodeCA_IssueCertificate_FullMethodName
+	case ClientAuthNode:
+		required = nodeMethods[method]
+	}
+	if required {
+		return nil, status.Errorf(codes.Unauthenticated, "%s requires a client certificate issued by the pool CA", method)
+	}
+
+	return ctx, nil
+}
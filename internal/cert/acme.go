@@ -0,0 +1,530 @@
+// Galaxy Node Pool - ACME Client
+// AI-ID: CP-GAL-NODEPOOL-001
+package cert
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// LetsEncryptDirectory is the production Let's Encrypt ACME directory
+	LetsEncryptDirectory = "https://acme-v02.api.letsencrypt.org/directory"
+
+	// LetsEncryptStagingDirectory is the staging Let's Encrypt ACME directory
+	LetsEncryptStagingDirectory = "https://acme-staging-v02.api.letsencrypt.org/directory"
+
+	// acmePollInterval is the wait between polls of pending authorizations and orders
+	acmePollInterval = 2 * time.Second
+
+	// maxACMEResponse bounds the size of ACME responses read into memory
+	maxACMEResponse = 1 << 20
+)
+
+// ACME challenge types
+const (
+	// ChallengeHTTP01 proves control of a domain through a file served over HTTP
+	ChallengeHTTP01 = "http-01"
+
+	// ChallengeDNS01 proves control of a domain through a TXT record
+	ChallengeDNS01 = "dns-01"
+)
+
+// ChallengeSolver fulfils ACME challenges of one type
+type ChallengeSolver interface {
+	// Type returns the challenge type the solver handles
+	Type() string
+
+	// Present makes the key authorization available for the domain
+	Present(ctx context.Context, domain, token, keyAuth string) error
+
+	// CleanUp removes what Present set up
+	CleanUp(ctx context.Context, domain, token, keyAuth string) error
+}
+
+// ACMEError is a problem document returned by an ACME server
+type ACMEError struct {
+	Status int    `json:"status"`
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+}
+
+// Error implements the error interface
+func (e *ACMEError) Error() string {
+	return fmt.Sprintf("acme: %s (%d): %s", e.Type, e.Status, e.Detail)
+}
+
+// acmeDirectory lists the endpoints of an ACME server
+type acmeDirectory struct {
+	NewNonce   string `json:"newNonce"`
+	NewAccount string `json:"newAccount"`
+	NewOrder   string `json:"newOrder"`
+}
+
+// acmeIdentifier is an identifier a certificate is requested for
+type acmeIdentifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// acmeOrder is an ACME order object
+type acmeOrder struct {
+	Status         string     `json:"status"`
+	Authorizations []string   `json:"authorizations"`
+	Finalize       string     `json:"finalize"`
+	Certificate    string     `json:"certificate"`
+	Error          *ACMEError `json:"error"`
+}
+
+// acmeChallenge is an ACME challenge object
+type acmeChallenge struct {
+	Type   string     `json:"type"`
+	URL    string     `json:"url"`
+	Token  string     `json:"token"`
+	Status string     `json:"status"`
+	Error  *ACMEError `json:"error"`
+}
+
+// acmeAuthorization is an ACME authorization object
+type acmeAuthorization struct {
+	Status     string          `json:"status"`
+	Identifier acmeIdentifier  `json:"identifier"`
+	Challenges []acmeChallenge `json:"challenges"`
+	Wildcard   bool            `json:"wildcard"`
+}
+
+// ACMEClient obtains certificates from an ACME (RFC 8555) server such as
+// Let's Encrypt. Only ECDSA P-256 account keys are supported.
+type ACMEClient struct {
+	directoryURL string
+	accountKey   *ecdsa.PrivateKey
+	httpClient   *http.Client
+
+	dir        *acmeDirectory
+	accountURL string
+	nonces     []string
+	mu         sync.Mutex
+}
+
+// NewACMEClient creates a new ACME client for a directory URL
+func NewACMEClient(directoryURL string, accountKey *ecdsa.PrivateKey, httpClient *http.Client) *ACMEClient {
+	if directoryURL == "" {
+		directoryURL = LetsEncryptDirectory
+	}
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &ACMEClient{
+		directoryURL: directoryURL,
+		accountKey:   accountKey,
+		httpClient:   httpClient,
+	}
+}
+
+// Register creates the ACME account for the client's key, or looks up the
+// existing one, agreeing to the server's terms of service
+func (c *ACMEClient) Register(ctx context.Context, email string) error {
+	if err := c.discover(ctx); err != nil {
+		return err
+	}
+
+	account := map[string]interface{}{"termsOfServiceAgreed": true}
+	if email != "" {
+		account["contact"] = []string{"mailto:" + email}
+	}
+
+	header, _, err := c.post(ctx, c.dir.NewAccount, account, nil)
+	if err != nil {
+		return fmt.Errorf("failed to register ACME account: %v", err)
+	}
+
+	c.accountURL = header.Get("Location")
+	if c.accountURL == "" {
+		return fmt.Errorf("ACME server returned no account URL")
+	}
+	return nil
+}
+
+// ObtainCertificate orders a certificate for the domains, solving each
+// authorization with the first solver offered a matching challenge, and
+// returns the PEM encoded certificate chain
+func (c *ACMEClient) ObtainCertificate(ctx context.Context, domains []string, key crypto.Signer, solvers ...ChallengeSolver) ([]byte, error) {
+	if c.accountURL == "" {
+		return nil, fmt.Errorf("ACME account is not registered")
+	}
+	if len(domains) == 0 {
+		return nil, fmt.Errorf("at least one domain is required")
+	}
+
+	identifiers := make([]acmeIdentifier, len(domains))
+	for i, domain := range domains {
+		identifiers[i] = acmeIdentifier{Type: "dns", Value: domain}
+	}
+
+	var order acmeOrder
+	header, _, err := c.post(ctx, c.dir.NewOrder, map[string]interface{}{"identifiers": identifiers}, &order)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create order: %v", err)
+	}
+	orderURL := header.Get("Location")
+
+	for _, authzURL := range order.Authorizations {
+		if err := c.authorize(ctx, authzURL, solvers); err != nil {
+			return nil, err
+		}
+	}
+
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: domains[0]},
+		DNSNames: domains,
+	}, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate request: %v", err)
+	}
+
+	if _, _, err := c.post(ctx, order.Finalize, map[string]string{"csr": base64.RawURLEncoding.EncodeToString(csr)}, &order); err != nil {
+		return nil, fmt.Errorf("failed to finalize order: %v", err)
+	}
+
+	// Issuance may take a while after finalizing
+	for order.Status != "valid" {
+		switch order.Status {
+		case "invalid":
+			if order.Error != nil {
+				return nil, fmt.Errorf("order failed: %v", order.Error)
+			}
+			return nil, fmt.Errorf("order failed")
+		case "pending", "ready", "processing":
+		default:
+			return nil, fmt.Errorf("unexpected order status %q", order.Status)
+		}
+
+		if err := sleepContext(ctx, acmePollInterval); err != nil {
+			return nil, err
+		}
+		if _, _, err := c.post(ctx, orderURL, nil, &order); err != nil {
+			return nil, fmt.Errorf("failed to poll order: %v", err)
+		}
+	}
+
+	_, chain, err := c.post(ctx, order.Certificate, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download certificate: %v", err)
+	}
+	if block, _ := pem.Decode(chain); block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("ACME server returned an invalid certificate chain")
+	}
+
+	return chain, nil
+}
+
+// authorize completes one authorization of an order
+func (c *ACMEClient) authorize(ctx context.Context, authzURL string, solvers []ChallengeSolver) error {
+	var authz acmeAuthorization
+	if _, _, err := c.post(ctx, authzURL, nil, &authz); err != nil {
+		return fmt.Errorf("failed to fetch authorization: %v", err)
+	}
+	if authz.Status == "valid" {
+		return nil
+	}
+
+	domain := authz.Identifier.Value
+	if authz.Wildcard {
+		domain = "*." + domain
+	}
+
+	var solver ChallengeSolver
+	var chal acmeChallenge
+	for _, s := range solvers {
+		for _, offered := range authz.Challenges {
+			if offered.Type == s.Type() {
+				solver, chal = s, offered
+				break
+			}
+		}
+		if solver != nil {
+			break
+		}
+	}
+	if solver == nil {
+		return fmt.Errorf("no solver for the challenges offered for %s", domain)
+	}
+
+	keyAuth, err := c.keyAuthorization(chal.Token)
+	if err != nil {
+		return err
+	}
+
+	if err := solver.Present(ctx, domain, chal.Token, keyAuth); err != nil {
+		return fmt.Errorf("failed to present %s challenge for %s: %v", chal.Type, domain, err)
+	}
+	defer func() {
+		if err := solver.CleanUp(ctx, domain, chal.Token, keyAuth); err != nil {
+			fmt.Printf("Warning: failed to clean up %s challenge for %s: %v\n", chal.Type, domain, err)
+		}
+	}()
+
+	// Tell the server the challenge is ready, then wait for its verdict
+	if _, _, err := c.post(ctx, chal.URL, struct{}{}, nil); err != nil {
+		return fmt.Errorf("failed to accept %s challenge for %s: %v", chal.Type, domain, err)
+	}
+
+	for {
+		if err := sleepContext(ctx, acmePollInterval); err != nil {
+			return err
+		}
+		if _, _, err := c.post(ctx, authzURL, nil, &authz); err != nil {
+			return fmt.Errorf("failed to poll authorization: %v", err)
+		}
+
+		switch authz.Status {
+		case "valid":
+			return nil
+		case "pending", "processing":
+			continue
+		default:
+			for _, offered := range authz.Challenges {
+				if offered.Type == chal.Type && offered.Error != nil {
+					return fmt.Errorf("%s challenge for %s failed: %v", chal.Type, domain, offered.Error)
+				}
+			}
+			return fmt.Errorf("authorization for %s is %s", domain, authz.Status)
+		}
+	}
+}
+
+// discover fetches the ACME directory
+func (c *ACMEClient) discover(ctx context.Context) error {
+	if c.dir != nil {
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.directoryURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch ACME directory: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch ACME directory: %s", resp.Status)
+	}
+
+	var dir acmeDirectory
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxACMEResponse)).Decode(&dir); err != nil {
+		return fmt.Errorf("failed to parse ACME directory: %v", err)
+	}
+	if dir.NewNonce == "" || dir.NewAccount == "" || dir.NewOrder == "" {
+		return fmt.Errorf("ACME directory is missing endpoints")
+	}
+
+	c.dir = &dir
+	return nil
+}
+
+// post sends a JWS signed request, a nil payload sends a POST-as-GET. The
+// response body is returned and decoded into out when given. Requests
+// rejected for a bad nonce are retried.
+func (c *ACMEClient) post(ctx context.Context, url string, payload interface{}, out interface{}) (http.Header, []byte, error) {
+	var body []byte
+	if payload != nil {
+		var err error
+		if body, err = json.Marshal(payload); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		nonce, err := c.nonce(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		jws, err := c.sign(url, nonce, body)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(jws))
+		if err != nil {
+			return nil, nil, err
+		}
+		req.Header.Set("Content-Type", "application/jose+json")
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return nil, nil, err
+		}
+		data, err := io.ReadAll(io.LimitReader(resp.Body, maxACMEResponse))
+		resp.Body.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read ACME response: %v", err)
+		}
+		c.saveNonce(resp)
+
+		if resp.StatusCode >= 400 {
+			problem := &ACMEError{Status: resp.StatusCode}
+			json.Unmarshal(data, problem)
+			if problem.Type == "urn:ietf:params:acme:error:badNonce" && attempt < 3 {
+				continue
+			}
+			return nil, nil, problem
+		}
+
+		if out != nil {
+			if err := json.Unmarshal(data, out); err != nil {
+				return nil, nil, fmt.Errorf("failed to parse ACME response: %v", err)
+			}
+		}
+		return resp.Header, data, nil
+	}
+}
+
+// nonce returns a fresh anti-replay nonce
+func (c *ACMEClient) nonce(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	if n := len(c.nonces); n > 0 {
+		nonce := c.nonces[n-1]
+		c.nonces = c.nonces[:n-1]
+		c.mu.Unlock()
+		return nonce, nil
+	}
+	c.mu.Unlock()
+
+	if err := c.discover(ctx); err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, c.dir.NewNonce, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get nonce: %v", err)
+	}
+	resp.Body.Close()
+
+	nonce := resp.Header.Get("Replay-Nonce")
+	if nonce == "" {
+		return "", fmt.Errorf("ACME server returned no nonce")
+	}
+	return nonce, nil
+}
+
+// saveNonce keeps the nonce returned with a response for the next request
+func (c *ACMEClient) saveNonce(resp *http.Response) {
+	if nonce := resp.Header.Get("Replay-Nonce"); nonce != "" {
+		c.mu.Lock()
+		c.nonces = append(c.nonces, nonce)
+		c.mu.Unlock()
+	}
+}
+
+// sign wraps a payload in a flattened JWS signed with the account key.
+// Requests before registration embed the key, later ones reference the account.
+func (c *ACMEClient) sign(url, nonce string, payload []byte) ([]byte, error) {
+	protected := map[string]interface{}{
+		"alg":   "ES256",
+		"nonce": nonce,
+		"url":   url,
+	}
+	if c.accountURL != "" {
+		protected["kid"] = c.accountURL
+	} else {
+		protected["jwk"] = c.jwk()
+	}
+
+	header, err := json.Marshal(protected)
+	if err != nil {
+		return nil, err
+	}
+
+	encodedHeader := base64.RawURLEncoding.EncodeToString(header)
+	encodedPayload := ""
+	if payload != nil {
+		encodedPayload = base64.RawURLEncoding.EncodeToString(payload)
+	}
+
+	digest := sha256.Sum256([]byte(encodedHeader + "." + encodedPayload))
+	r, s, err := ecdsa.Sign(rand.Reader, c.accountKey, digest[:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign ACME request: %v", err)
+	}
+
+	// ES256 signatures are the fixed size concatenation of r and s
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+
+	return json.Marshal(map[string]string{
+		"protected": encodedHeader,
+		"payload":   encodedPayload,
+		"signature": base64.RawURLEncoding.EncodeToString(signature),
+	})
+}
+
+// jwk returns the account key as a JSON web key, with members in the
+// lexicographic order required for thumbprints
+func (c *ACMEClient) jwk() map[string]string {
+	return map[string]string{
+		"crv": "P-256",
+		"kty": "EC",
+		"x":   base64.RawURLEncoding.EncodeToString(paddedBytes(c.accountKey.X)),
+		"y":   base64.RawURLEncoding.EncodeToString(paddedBytes(c.accountKey.Y)),
+	}
+}
+
+// keyAuthorization returns the key authorization for a challenge token
+func (c *ACMEClient) keyAuthorization(token string) (string, error) {
+	// encoding/json sorts map keys, giving the canonical form
+	jwk, err := json.Marshal(c.jwk())
+	if err != nil {
+		return "", err
+	}
+	thumbprint := sha256.Sum256(jwk)
+	return token + "." + base64.RawURLEncoding.EncodeToString(thumbprint[:]), nil
+}
+
+// paddedBytes returns a P-256 coordinate as 32 bytes
+func paddedBytes(n *big.Int) []byte {
+	return n.FillBytes(make([]byte, 32))
+}
+
+// DNS01Record returns the TXT record name and value for a dns-01 challenge
+func DNS01Record(domain, keyAuth string) (string, string) {
+	digest := sha256.Sum256([]byte(keyAuth))
+	return "_acme-challenge." + strings.TrimPrefix(domain, "*.") + ".", base64.RawURLEncoding.EncodeToString(digest[:])
+}
+
+// sleepContext waits for a duration or until the context is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
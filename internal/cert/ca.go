@@ -0,0 +1,462 @@
+// Galaxy Node Pool - Internal Certificate Authority
+// AI-ID: CP-GAL-NODEPOOL-001
+package cert
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultClientCertTTL is the validity of node client certificates
+	DefaultClientCertTTL = 24 * time.Hour
+
+	// caValidity is the validity of a newly created CA certificate
+	caValidity = 10 * 365 * 24 * time.Hour
+
+	// crlValidity is how long a generated revocation list is valid for
+	crlValidity = 24 * time.Hour
+
+	// ReasonSuperseded is the revocation reason of certificates replaced by rotation
+	ReasonSuperseded = "superseded"
+
+	caCertFile  = "ca.crt"
+	caKeyFile   = "ca.key"
+	issuedFile  = "issued.json"
+	revokedFile = "revoked.json"
+)
+
+// IssuedCert records a client certificate issued by the CA
+type IssuedCert struct {
+	Serial    string    `json:"serial"`
+	NodeID    string    `json:"node_id"`
+	Org       string    `json:"org"`
+	NotAfter  time.Time `json:"not_after"`
+	RevokedAt time.Time `json:"revoked_at,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+}
+
+// CA is the pool's internal certificate authority. It issues short-lived
+// client certificates to nodes, with the node ID as common name and the
+// organization as organization, and keeps a revocation list.
+type CA struct {
+	dir    string
+	cert   *x509.Certificate
+	key    crypto.Signer
+	issued map[string]*IssuedCert
+	mu     sync.RWMutex
+}
+
+// LoadOrCreateCA loads the CA from a directory, creating a new CA key and
+// certificate there if none exists yet
+func LoadOrCreateCA(dir, name string) (*CA, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create CA directory: %v", err)
+	}
+
+	ca := &CA{
+		dir:    dir,
+		issued: make(map[string]*IssuedCert),
+	}
+
+	certPath := filepath.Join(dir, caCertFile)
+	if _, err := os.Stat(certPath); os.IsNotExist(err) {
+		if err := ca.create(name); err != nil {
+			return nil, err
+		}
+	} else if err := ca.load(); err != nil {
+		return nil, err
+	}
+
+	if err := ca.loadIssued(); err != nil {
+		return nil, err
+	}
+
+	return ca, nil
+}
+
+// create generates a new CA key and self-signed certificate
+func (ca *CA) create(name string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate CA key: %v", err)
+	}
+
+	serial, err := randomSerial()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return fmt.Errorf("failed to create CA certificate: %v", err)
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("failed to encode CA key: %v", err)
+	}
+
+	if err := writePEM(filepath.Join(ca.dir, caKeyFile), "PRIVATE KEY", keyDER, 0600); err != nil {
+		return err
+	}
+	if err := writePEM(filepath.Join(ca.dir, caCertFile), "CERTIFICATE", der, 0644); err != nil {
+		return err
+	}
+
+	ca.cert, _ = x509.ParseCertificate(der)
+	ca.key = key
+	return nil
+}
+
+// load reads the CA key and certificate from disk
+func (ca *CA) load() error {
+	certPEM, err := os.ReadFile(filepath.Join(ca.dir, caCertFile))
+	if err != nil {
+		return fmt.Errorf("failed to read CA certificate: %v", err)
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return fmt.Errorf("invalid CA certificate PEM")
+	}
+	caCert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("failed to parse CA certificate: %v", err)
+	}
+
+	keyPEM, err := os.ReadFile(filepath.Join(ca.dir, caKeyFile))
+	if err != nil {
+		return fmt.Errorf("failed to read CA key: %v", err)
+	}
+	block, _ = pem.Decode(keyPEM)
+	if block == nil {
+		return fmt.Errorf("invalid CA key PEM")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("failed to parse CA key: %v", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return fmt.Errorf("unsupported CA key type %T", key)
+	}
+
+	ca.cert = caCert
+	ca.key = signer
+	return nil
+}
+
+// loadIssued reads the issued and revoked certificate records
+func (ca *CA) loadIssued() error {
+	for _, name := range []string{issuedFile, revokedFile} {
+		data, err := os.ReadFile(filepath.Join(ca.dir, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %v", name, err)
+		}
+
+		var records []*IssuedCert
+		if err := json.Unmarshal(data, &records); err != nil {
+			return fmt.Errorf("failed to parse %s: %v", name, err)
+		}
+		for _, record := range records {
+			ca.issued[record.Serial] = record
+		}
+	}
+	return nil
+}
+
+// saveIssuedLocked writes the issued and revoked records, dropping expired
+// certificates that no longer need tracking (must be called with lock held)
+func (ca *CA) saveIssuedLocked() error {
+	now := time.Now()
+	var issued, revoked []*IssuedCert
+	for serial, record := range ca.issued {
+		if now.After(record.NotAfter) {
+			delete(ca.issued, serial)
+			continue
+		}
+		if record.RevokedAt.IsZero() {
+			issued = append(issued, record)
+		} else {
+			revoked = append(revoked, record)
+		}
+	}
+
+	for name, records := range map[string][]*IssuedCert{issuedFile: issued, revokedFile: revoked} {
+		data, err := json.MarshalIndent(records, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode %s: %v", name, err)
+		}
+		if err := os.WriteFile(filepath.Join(ca.dir, name), data, 0600); err != nil {
+			return fmt.Errorf("failed to write %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// Certificate returns the CA certificate
+func (ca *CA) Certificate() *x509.Certificate {
+	return ca.cert
+}
+
+// CertificatePEM returns the CA certificate in PEM form
+func (ca *CA) CertificatePEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})
+}
+
+// CertPool returns a pool containing the CA certificate, for verifying clients
+func (ca *CA) CertPool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return pool
+}
+
+// IssueClientCert signs a certificate request for a node. The request's
+// signature proves possession of the key; its subject is replaced with the
+// node ID and organization.
+func (ca *CA) IssueClientCert(csrDER []byte, nodeID, org string, ttl time.Duration) ([]byte, *IssuedCert, error) {
+	if nodeID == "" {
+		return nil, nil, fmt.Errorf("node ID is required")
+	}
+
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid certificate request: %v", err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, nil, fmt.Errorf("invalid certificate request signature: %v", err)
+	}
+
+	if ttl <= 0 {
+		ttl = DefaultClientCertTTL
+	}
+
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	notAfter := now.Add(ttl)
+	if notAfter.After(ca.cert.NotAfter) {
+		notAfter = ca.cert.NotAfter
+	}
+
+	subject := pkix.Name{CommonName: nodeID}
+	if org != "" {
+		subject.Organization = []string{org}
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      subject,
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, csr.PublicKey, ca.key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to sign certificate: %v", err)
+	}
+
+	record := &IssuedCert{
+		Serial:   serial.Text(16),
+		NodeID:   nodeID,
+		Org:      org,
+		NotAfter: notAfter,
+	}
+
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	ca.issued[record.Serial] = record
+	if err := ca.saveIssuedLocked(); err != nil {
+		return nil, nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), record, nil
+}
+
+// Revoke revokes a certificate by serial, returning false if it is unknown
+func (ca *CA) Revoke(serial, reason string) (bool, error) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	record, ok := ca.issued[serial]
+	if !ok {
+		return false, nil
+	}
+	if record.RevokedAt.IsZero() {
+		record.RevokedAt = time.Now()
+		record.Reason = reason
+	}
+	return true, ca.saveIssuedLocked()
+}
+
+// RevokeNode revokes every unexpired certificate issued to a node and returns their serials
+func (ca *CA) RevokeNode(nodeID, reason string) ([]string, error) {
+	return ca.revokeNode(nodeID, reason, "")
+}
+
+// RevokeSuperseded revokes the unexpired certificates of a node other than
+// its current one after a rotation and returns their serials
+func (ca *CA) RevokeSuperseded(nodeID, current string) ([]string, error) {
+	return ca.revokeNode(nodeID, ReasonSuperseded, current)
+}
+
+// revokeNode revokes the unexpired certificates of a node except the kept serial
+func (ca *CA) revokeNode(nodeID, reason, keep string) ([]string, error) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	now := time.Now()
+	var serials []string
+	for serial, record := range ca.issued {
+		if record.NodeID != nodeID || serial == keep || !record.RevokedAt.IsZero() || now.After(record.NotAfter) {
+			continue
+		}
+		record.RevokedAt = now
+		record.Reason = reason
+		serials = append(serials, serial)
+	}
+
+	if len(serials) == 0 {
+		return nil, nil
+	}
+	return serials, ca.saveIssuedLocked()
+}
+
+// IsRevoked reports whether a certificate serial has been revoked
+func (ca *CA) IsRevoked(serial *big.Int) bool {
+	ca.mu.RLock()
+	defer ca.mu.RUnlock()
+
+	record, ok := ca.issued[serial.Text(16)]
+	return ok && !record.RevokedAt.IsZero()
+}
+
+// Lookup returns a copy of the record of an issued certificate
+func (ca *CA) Lookup(serial string) (IssuedCert, bool) {
+	ca.mu.RLock()
+	defer ca.mu.RUnlock()
+
+	record, ok := ca.issued[serial]
+	if !ok {
+		return IssuedCert{}, false
+	}
+	return *record, true
+}
+
+// NodeCerts returns copies of the unexpired, unrevoked certificates issued to a node
+func (ca *CA) NodeCerts(nodeID string) []IssuedCert {
+	ca.mu.RLock()
+	defer ca.mu.RUnlock()
+
+	now := time.Now()
+	var records []IssuedCert
+	for _, record := range ca.issued {
+		if record.NodeID == nodeID && record.RevokedAt.IsZero() && now.Before(record.NotAfter) {
+			records = append(records, *record)
+		}
+	}
+	return records
+}
+
+// RevocationList returns a DER encoded CRL of the revoked, unexpired certificates
+func (ca *CA) RevocationList() ([]byte, error) {
+	ca.mu.RLock()
+	defer ca.mu.RUnlock()
+
+	now := time.Now()
+	var entries []x509.RevocationListEntry
+	for serial, record := range ca.issued {
+		if record.RevokedAt.IsZero() || now.After(record.NotAfter) {
+			continue
+		}
+		number, ok := new(big.Int).SetString(serial, 16)
+		if !ok {
+			continue
+		}
+		entries = append(entries, x509.RevocationListEntry{
+			SerialNumber:   number,
+			RevocationTime: record.RevokedAt,
+		})
+	}
+
+	// The CRL number must increase between lists, also across restarts
+	template := &x509.RevocationList{
+		RevokedCertificateEntries: entries,
+		Number:                    big.NewInt(now.UnixNano()),
+		ThisUpdate:                now,
+		NextUpdate:                now.Add(crlValidity),
+	}
+
+	crl, err := x509.CreateRevocationList(rand.Reader, template, ca.cert, ca.key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create revocation list: %v", err)
+	}
+	return crl, nil
+}
+
+// randomSerial returns a random 128-bit certificate serial number
+func randomSerial() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %v", err)
+	}
+	return serial, nil
+}
+
+// writePEM writes a single PEM block to a file with the given permissions
+func writePEM(path, blockType string, der []byte, perm os.FileMode) error {
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	return writeFileAtomic(path, data, perm)
+}
+
+// ServerTLSConfig returns a TLS configuration serving the certificate from
+// getCertificate that verifies client certificates against the CA when
+// presented, and rejects revoked ones during the handshake
+func (ca *CA) ServerTLSConfig(getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)) *tls.Config {
+	return &tls.Config{
+		GetCertificate: getCertificate,
+		ClientCAs:      ca.CertPool(),
+		ClientAuth:     tls.VerifyClientCertIfGiven,
+		MinVersion:     tls.VersionTLS12,
+		VerifyConnection: func(state tls.ConnectionState) error {
+			if len(state.PeerCertificates) > 0 && ca.IsRevoked(state.PeerCertificates[0].SerialNumber) {
+				return fmt.Errorf("client certificate %s has been revoked", state.PeerCertificates[0].SerialNumber.Text(16))
+			}
+			return nil
+		},
+	}
+}
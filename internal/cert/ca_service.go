@@ -0,0 +1,171 @@
+// Galaxy Node Pool - Node CA Service
+// AI-ID: CP-GAL-NODEPOOL-001
+package cert
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"galaxy-node-pool/internal/auth"
+	pb "galaxy-node-pool/proto/pool"
+)
+
+// CAService exposes the internal CA over gRPC
+type CAService struct {
+	pb.UnimplementedNodeCAServer
+	ca  *CA
+	ttl time.Duration
+}
+
+// NewCAService creates a new CA service issuing certificates valid for ttl
+func NewCAService(ca *CA, ttl time.Duration) *CAService {
+	if ttl <= 0 {
+		ttl = DefaultClientCertTTL
+	}
+	return &CAService{
+		ca:  ca,
+		ttl: ttl,
+	}
+}
+
+// IssueCertificate enrolls a node or rotates its certificate. A node that
+// already holds a valid certificate must present it to get a new one, and
+// its earlier certificates are revoked as superseded.
+func (s *CAService) IssueCertificate(ctx context.Context, req *pb.IssueCertificateRequest) (*pb.IssueCertificateResponse, error) {
+	if req.NodeId == "" || len(req.Csr) == 0 {
+		return &pb.IssueCertificateResponse{
+			Success: false,
+			Message: "node_id and csr are required",
+		}, nil
+	}
+
+	org := req.Org
+	peerNode, rotating := auth.PeerNodeFromContext(ctx)
+	if rotating {
+		// Rotation keeps the identity of the presented certificate
+		if peerNode.NodeID != req.NodeId || (req.Org != "" && req.Org != peerNode.Org) {
+			return nil, status.Errorf(codes.PermissionDenied, "certificate for %s can't request a certificate for %s", peerNode.NodeID, req.NodeId)
+		}
+		org = peerNode.Org
+	} else {
+		bound := auth.OrgFromContext(ctx)
+		if bound != "" && org != "" && org != bound {
+			return &pb.IssueCertificateResponse{
+				Success: false,
+				Message: fmt.Sprintf("Caller may not enroll nodes for organization %s", org),
+			}, nil
+		}
+		if org == "" {
+			org = bound
+		}
+		if len(s.ca.NodeCerts(req.NodeId)) > 0 {
+			return &pb.IssueCertificateResponse{
+				Success: false,
+				Message: fmt.Sprintf("Node %s already holds a valid certificate, present it to rotate or revoke it first", req.NodeId),
+			}, nil
+		}
+	}
+
+	certPEM, record, err := s.ca.IssueClientCert(req.Csr, req.NodeId, org, s.ttl)
+	if err != nil {
+		return &pb.IssueCertificateResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to issue certificate: %v", err),
+		}, nil
+	}
+
+	log.Printf("Issued client certificate %s for node %s (expires %s)", record.Serial, record.NodeID, record.NotAfter.Format(time.RFC3339))
+
+	// Rotation replaces the earlier certificates, a leaked one must not stay valid
+	if rotating {
+		serials, err := s.ca.RevokeSuperseded(req.NodeId, record.Serial)
+		if err != nil {
+			log.Printf("Warning: Failed to revoke superseded certificates of node %s: %v", req.NodeId, err)
+		} else if len(serials) > 0 {
+			log.Printf("Revoked %d superseded client certificate(s) of node %s", len(serials), req.NodeId)
+		}
+	}
+
+	return &pb.IssueCertificateResponse{
+		Success:       true,
+		Message:       "Certificate issued",
+		Certificate:   certPEM,
+		CaCertificate: s.ca.CertificatePEM(),
+		Serial:        record.Serial,
+		ExpiresAt:     record.NotAfter.Unix(),
+	}, nil
+}
+
+// RevokeCertificate revokes a certificate by serial, or all of a node's certificates
+func (s *CAService) RevokeCertificate(ctx context.Context, req *pb.RevokeCertificateRequest) (*pb.RevokeCertificateResponse, error) {
+	bound := auth.OrgFromContext(ctx)
+
+	if req.Serial != "" {
+		record, ok := s.ca.Lookup(req.Serial)
+		if !ok || (bound != "" && record.Org != bound) {
+			return &pb.RevokeCertificateResponse{
+				Success: false,
+				Message: fmt.Sprintf("Certificate %s not found", req.Serial),
+			}, nil
+		}
+		if _, err := s.ca.Revoke(req.Serial, req.Reason); err != nil {
+			return &pb.RevokeCertificateResponse{
+				Success: false,
+				Message: fmt.Sprintf("Failed to revoke certificate: %v", err),
+			}, nil
+		}
+		log.Printf("Revoked client certificate %s of node %s", record.Serial, record.NodeID)
+		return &pb.RevokeCertificateResponse{
+			Success:        true,
+			Message:        "Certificate revoked",
+			RevokedSerials: []string{req.Serial},
+		}, nil
+	}
+
+	if req.NodeId == "" {
+		return &pb.RevokeCertificateResponse{
+			Success: false,
+			Message: "serial or node_id is required",
+		}, nil
+	}
+
+	if bound != "" {
+		for _, record := range s.ca.NodeCerts(req.NodeId) {
+			if record.Org != bound {
+				return &pb.RevokeCertificateResponse{
+					Success: false,
+					Message: fmt.Sprintf("Caller may not revoke certificates of node %s", req.NodeId),
+				}, nil
+			}
+		}
+	}
+
+	serials, err := s.ca.RevokeNode(req.NodeId, req.Reason)
+	if err != nil {
+		return &pb.RevokeCertificateResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to revoke certificates: %v", err),
+		}, nil
+	}
+
+	log.Printf("Revoked %d client certificate(s) of node %s", len(serials), req.NodeId)
+	return &pb.RevokeCertificateResponse{
+		Success:        true,
+		Message:        fmt.Sprintf("Revoked %d certificate(s)", len(serials)),
+		RevokedSerials: serials,
+	}, nil
+}
+
+// GetRevocationList returns the current CRL
+func (s *CAService) GetRevocationList(ctx context.Context, req *pb.GetRevocationListRequest) (*pb.GetRevocationListResponse, error) {
+	crl, err := s.ca.RevocationList()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to generate revocation list: %v", err)
+	}
+	return &pb.GetRevocationListResponse{Crl: crl}, nil
+}
@@ -0,0 +1,167 @@
+package cert
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"sort"
+	"testing"
+
+	"galaxy-node-pool/internal/auth"
+	pb "galaxy-node-pool/proto/pool"
+)
+
+// newTestCSR returns a DER certificate request for a fresh node key
+func newTestCSR(t *testing.T, nodeID string) []byte {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: nodeID}}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return csr
+}
+
+// issueTestCert enrolls a node, or rotates the certificate with the given
+// serial, and returns the serial of the new certificate
+func issueTestCert(t *testing.T, s *CAService, nodeID, presented string) string {
+	t.Helper()
+
+	ctx := context.Background()
+	if presented != "" {
+		ctx = auth.WithPeerNode(ctx, &auth.PeerNode{NodeID: nodeID, Org: "org1", Serial: presented})
+	}
+	resp, err := s.IssueCertificate(ctx, &pb.IssueCertificateRequest{NodeId: nodeID, Org: "org1", Csr: newTestCSR(t, nodeID)})
+	if err != nil || !resp.Success {
+		t.Fatalf("IssueCertificate(%s) = %v, %v", nodeID, resp, err)
+	}
+	return resp.Serial
+}
+
+// revokedSerials parses a CRL signed by the CA and returns its serials
+func revokedSerials(t *testing.T, ca *CA) []string {
+	t.Helper()
+
+	der, err := ca.RevocationList()
+	if err != nil {
+		t.Fatalf("RevocationList failed: %v", err)
+	}
+	crl, err := x509.ParseRevocationList(der)
+	if err != nil {
+		t.Fatalf("failed to parse revocation list: %v", err)
+	}
+	if err := crl.CheckSignatureFrom(ca.Certificate()); err != nil {
+		t.Fatalf("revocation list is not signed by the CA: %v", err)
+	}
+
+	serials := []string{}
+	for _, entry := range crl.RevokedCertificateEntries {
+		serials = append(serials, entry.SerialNumber.Text(16))
+	}
+	sort.Strings(serials)
+	return serials
+}
+
+func TestRevocationListAfterRotation(t *testing.T) {
+	tests := []struct {
+		name       string
+		wantReason string
+		// run issues certificates and returns the serials that must be
+		// revoked and the ones that must stay valid
+		run func(t *testing.T, s *CAService) (revoked, valid []string)
+	}{
+		{
+			name: "enrollment",
+			run: func(t *testing.T, s *CAService) ([]string, []string) {
+				return nil, []string{issueTestCert(t, s, "node1", "")}
+			},
+		},
+		{
+			name:       "rotation revokes the previous certificate",
+			wantReason: ReasonSuperseded,
+			run: func(t *testing.T, s *CAService) ([]string, []string) {
+				first := issueTestCert(t, s, "node1", "")
+				second := issueTestCert(t, s, "node1", first)
+				return []string{first}, []string{second}
+			},
+		},
+		{
+			name:       "repeated rotations",
+			wantReason: ReasonSuperseded,
+			run: func(t *testing.T, s *CAService) ([]string, []string) {
+				first := issueTestCert(t, s, "node1", "")
+				second := issueTestCert(t, s, "node1", first)
+				third := issueTestCert(t, s, "node1", second)
+				return []string{first, second}, []string{third}
+			},
+		},
+		{
+			name:       "rotation leaves other nodes valid",
+			wantReason: ReasonSuperseded,
+			run: func(t *testing.T, s *CAService) ([]string, []string) {
+				first := issueTestCert(t, s, "node1", "")
+				other := issueTestCert(t, s, "node2", "")
+				second := issueTestCert(t, s, "node1", first)
+				return []string{first}, []string{second, other}
+			},
+		},
+		{
+			name:       "revoked node",
+			wantReason: "test",
+			run: func(t *testing.T, s *CAService) ([]string, []string) {
+				first := issueTestCert(t, s, "node1", "")
+				other := issueTestCert(t, s, "node2", "")
+				if _, err := s.ca.RevokeNode("node1", "test"); err != nil {
+					t.Fatalf("RevokeNode failed: %v", err)
+				}
+				return []string{first}, []string{other}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ca, err := LoadOrCreateCA(t.TempDir(), "test-pool")
+			if err != nil {
+				t.Fatalf("LoadOrCreateCA failed: %v", err)
+			}
+			revoked, valid := tt.run(t, NewCAService(ca, 0))
+
+			want := append([]string{}, revoked...)
+			sort.Strings(want)
+			got := revokedSerials(t, ca)
+			if len(got) != len(want) {
+				t.Fatalf("CRL lists %v, want %v", got, want)
+			}
+			for i := range got {
+				if got[i] != want[i] {
+					t.Fatalf("CRL lists %v, want %v", got, want)
+				}
+			}
+
+			for _, serial := range revoked {
+				number, _ := new(big.Int).SetString(serial, 16)
+				if !ca.IsRevoked(number) {
+					t.Errorf("certificate %s is not revoked", serial)
+				}
+				if record, _ := ca.Lookup(serial); record.Reason != tt.wantReason {
+					t.Errorf("certificate %s revoked as %q, want %q", serial, record.Reason, tt.wantReason)
+				}
+			}
+			for _, serial := range valid {
+				number, _ := new(big.Int).SetString(serial, 16)
+				if ca.IsRevoked(number) {
+					t.Errorf("certificate %s is revoked", serial)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+// Galaxy Node Pool - ACME Challenge Solvers
+// AI-ID: CP-GAL-NODEPOOL-001
+package cert
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// acmeChallengePath is the URL path HTTP-01 challenges are served under
+	acmeChallengePath = "/.well-known/acme-challenge/"
+
+	// DefaultPropagationTimeout bounds the wait for DNS-01 records to become visible
+	DefaultPropagationTimeout = 2 * time.Minute
+
+	// propagationCheckInterval is the wait between DNS-01 record lookups
+	propagationCheckInterval = 5 * time.Second
+)
+
+// HTTP01Solver answers HTTP-01 challenges, either by writing the tokens to
+// the webroot of a running web server or by serving them itself
+type HTTP01Solver struct {
+	// Webroot is the document root of a web server serving the domain on port 80
+	Webroot string
+
+	// Address is where to serve challenges when no webroot is set, default :80
+	Address string
+
+	tokens  map[string]string
+	server  *http.Server
+	serving int
+	mu      sync.Mutex
+}
+
+// NewHTTP01Solver creates a new HTTP-01 solver. With an empty webroot the
+// solver listens on address while challenges are pending.
+func NewHTTP01Solver(webroot, address string) *HTTP01Solver {
+	if address == "" {
+		address = ":80"
+	}
+	return &HTTP01Solver{
+		Webroot: webroot,
+		Address: address,
+		tokens:  make(map[string]string),
+	}
+}
+
+// Type returns the challenge type the solver handles
+func (s *HTTP01Solver) Type() string {
+	return ChallengeHTTP01
+}
+
+// Present publishes the key authorization for a token
+func (s *HTTP01Solver) Present(ctx context.Context, domain, token, keyAuth string) error {
+	if s.Webroot != "" {
+		dir := filepath.Join(s.Webroot, filepath.FromSlash(acmeChallengePath))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create challenge directory: %v", err)
+		}
+		return os.WriteFile(filepath.Join(dir, token), []byte(keyAuth), 0644)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens[token] = keyAuth
+	s.serving++
+	if s.server != nil {
+		return nil
+	}
+
+	listener, err := net.Listen("tcp", s.Address)
+	if err != nil {
+		delete(s.tokens, token)
+		s.serving--
+		return fmt.Errorf("failed to listen on %s: %v", s.Address, err)
+	}
+	s.server = &http.Server{Handler: s, ReadHeaderTimeout: 10 * time.Second}
+	go s.server.Serve(listener)
+	return nil
+}
+
+// CleanUp withdraws the key authorization for a token
+func (s *HTTP01Solver) CleanUp(ctx context.Context, domain, token, keyAuth string) error {
+	if s.Webroot != "" {
+		err := os.Remove(filepath.Join(s.Webroot, filepath.FromSlash(acmeChallengePath), token))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.tokens, token)
+	s.serving--
+	if s.serving > 0 || s.server == nil {
+		return nil
+	}
+
+	err := s.server.Close()
+	s.server = nil
+	return err
+}
+
+// ServeHTTP serves pending challenge tokens
+func (s *HTTP01Solver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.URL.Path, acmeChallengePath)
+
+	s.mu.Lock()
+	keyAuth, ok := s.tokens[token]
+	s.mu.Unlock()
+
+	if !ok || !strings.HasPrefix(r.URL.Path, acmeChallengePath) {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(keyAuth))
+}
+
+// DNS01Solver answers DNS-01 challenges through a DNS provider
+type DNS01Solver struct {
+	provider DNSProvider
+
+	// PropagationTimeout bounds the wait for the record to be visible in
+	// public DNS before the CA is asked to check it. Zero skips the check.
+	PropagationTimeout time.Duration
+
+	// Resolver looks up the published records, defaults to the system resolver
+	Resolver *net.Resolver
+}
+
+// NewDNS01Solver creates a new DNS-01 solver publishing records through a provider
+func NewDNS01Solver(provider DNSProvider, propagationTimeout time.Duration) *DNS01Solver {
+	return &DNS01Solver{
+		provider:           provider,
+		PropagationTimeout: propagationTimeout,
+		Resolver:           net.DefaultResolver,
+	}
+}
+
+// Type returns the challenge type the solver handles
+func (s *DNS01Solver) Type() string {
+	return ChallengeDNS01
+}
+
+// Present publishes the challenge TXT record and waits for it to propagate
+func (s *DNS01Solver) Present(ctx context.Context, domain, token, keyAuth string) error {
+	fqdn, value := DNS01Record(domain, keyAuth)
+	if err := s.provider.Present(ctx, fqdn, value); err != nil {
+		return err
+	}
+
+	if s.PropagationTimeout <= 0 {
+		return nil
+	}
+
+	deadline := time.Now().Add(s.PropagationTimeout)
+	for {
+		records, _ := s.Resolver.LookupTXT(ctx, fqdn)
+		for _, record := range records {
+			if record == value {
+				return nil
+			}
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("TXT record %s did not propagate within %s", fqdn, s.PropagationTimeout)
+		}
+		if err := sleepContext(ctx, propagationCheckInterval); err != nil {
+			return err
+		}
+	}
+}
+
+// CleanUp removes the challenge TXT record
+func (s *DNS01Solver) CleanUp(ctx context.Context, domain, token, keyAuth string) error {
+	fqdn, value := DNS01Record(domain, keyAuth)
+	return s.provider.CleanUp(ctx, fqdn, value)
+}
@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-	"strings"
 	
 	"gopkg.in/yaml.v3"
 )
@@ -16,6 +15,20 @@ type Config struct {
 	Email       string            `yaml:"email"`
 	DNSProvider string            `yaml:"dns_provider"`
 	Credentials map[string]string `yaml:"credentials"`
+
+	// ACME server settings, defaulting to production Let's Encrypt
+	DirectoryURL    string `yaml:"directory_url,omitempty"`
+	DirectoryCAFile string `yaml:"directory_ca_file,omitempty"`
+
+	// HTTP-01 challenges are written to Webroot, or served on HTTPAddress when set
+	Webroot     string `yaml:"webroot,omitempty"`
+	HTTPAddress string `yaml:"http_address,omitempty"`
+
+	// PropagationTimeout bounds the wait for DNS-01 records, "0" skips the check
+	PropagationTimeout string `yaml:"propagation_timeout,omitempty"`
+
+	// KeyType is the certificate key type, rsa or ecdsa
+	KeyType string `yaml:"key_type,omitempty"`
 }
 
 // LoadConfig loads certificate configuration from file
@@ -74,44 +87,3 @@ func SaveConfig(config *Config, configPath string) error {
 	
 	return nil
 }
-
-// CreateCredentialsFile creates a credentials file for the DNS provider
-func CreateCredentialsFile(config *Config, provider string) (string, error) {
-	if config.Credentials == nil || len(config.Credentials) == 0 {
-		return "", fmt.Errorf("no credentials found for DNS provider")
-	}
-	
-	// Create credentials directory
-	credsDir := filepath.Join(os.Getenv("HOME"), ".galaxy", "certs", "credentials")
-	if err := os.MkdirAll(credsDir, 0700); err != nil {
-		return "", fmt.Errorf("failed to create credentials directory: %v", err)
-	}
-	
-	// Create credentials file
-	credsPath := filepath.Join(credsDir, provider+".ini")
-	var content strings.Builder
-	
-	// Different providers have different credential formats
-	switch provider {
-	case "cloudflare":
-		content.WriteString("# Cloudflare API credentials used by Certbot\n")
-		content.WriteString("dns_cloudflare_email = " + config.Credentials["email"] + "\n")
-		content.WriteString("dns_cloudflare_api_key = " + config.Credentials["api_key"] + "\n")
-	case "route53":
-		content.WriteString("[default]\n")
-		content.WriteString("aws_access_key_id = " + config.Credentials["access_key"] + "\n")
-		content.WriteString("aws_secret_access_key = " + config.Credentials["secret_key"] + "\n")
-	default:
-		// Generic format
-		for key, value := range config.Credentials {
-			content.WriteString(key + " = " + value + "\n")
-		}
-	}
-	
-	// Write credentials file with restricted permissions
-	if err := os.WriteFile(credsPath, []byte(content.String()), 0600); err != nil {
-		return "", fmt.Errorf("failed to write credentials file: %v", err)
-	}
-	
-	return credsPath, nil
-}
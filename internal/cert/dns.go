@@ -0,0 +1,117 @@
+// Galaxy Node Pool - DNS Providers
+// AI-ID: CP-GAL-NODEPOOL-001
+package cert
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DNSProvider publishes the TXT records used by DNS-01 challenges
+type DNSProvider interface {
+	// Present creates a TXT record with the value at the fully qualified name
+	Present(ctx context.Context, fqdn, value string) error
+
+	// CleanUp removes the TXT record created by Present
+	CleanUp(ctx context.Context, fqdn, value string) error
+}
+
+// DNSProviderFactory creates a DNS provider from the credentials in cert.Config
+type DNSProviderFactory func(credentials map[string]string) (DNSProvider, error)
+
+var (
+	dnsProviders   = make(map[string]DNSProviderFactory)
+	dnsProvidersMu sync.RWMutex
+)
+
+func init() {
+	RegisterDNSProvider("cloudflare", newCloudflareProvider)
+	RegisterDNSProvider("route53", newRoute53Provider)
+	RegisterDNSProvider("exec", newExecProvider)
+}
+
+// RegisterDNSProvider makes a DNS provider available by name
+func RegisterDNSProvider(name string, factory DNSProviderFactory) {
+	dnsProvidersMu.Lock()
+	defer dnsProvidersMu.Unlock()
+
+	dnsProviders[name] = factory
+}
+
+// NewDNSProvider creates the named DNS provider with the given credentials
+func NewDNSProvider(name string, credentials map[string]string) (DNSProvider, error) {
+	dnsProvidersMu.RLock()
+	factory, ok := dnsProviders[name]
+	dnsProvidersMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown DNS provider %q (available: %s)", name, strings.Join(DNSProviders(), ", "))
+	}
+	if credentials == nil {
+		credentials = map[string]string{}
+	}
+	return factory(credentials)
+}
+
+// DNSProviders returns the names of the registered DNS providers
+func DNSProviders() []string {
+	dnsProvidersMu.RLock()
+	defer dnsProvidersMu.RUnlock()
+
+	names := make([]string, 0, len(dnsProviders))
+	for name := range dnsProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// execProvider manages records through an external script, called as
+// `<command> present|cleanup <fqdn> <value>` with the remaining credentials
+// in the environment as GALAXY_DNS_<KEY>
+type execProvider struct {
+	command string
+	env     []string
+}
+
+// newExecProvider creates an exec provider from the "command" credential
+func newExecProvider(credentials map[string]string) (DNSProvider, error) {
+	command := credentials["command"]
+	if command == "" {
+		return nil, fmt.Errorf("exec DNS provider requires a command credential")
+	}
+
+	env := os.Environ()
+	for key, value := range credentials {
+		if key != "command" {
+			env = append(env, "GALAXY_DNS_"+strings.ToUpper(key)+"="+value)
+		}
+	}
+
+	return &execProvider{command: command, env: env}, nil
+}
+
+// Present runs the command to create the record
+func (p *execProvider) Present(ctx context.Context, fqdn, value string) error {
+	return p.run(ctx, "present", fqdn, value)
+}
+
+// CleanUp runs the command to remove the record
+func (p *execProvider) CleanUp(ctx context.Context, fqdn, value string) error {
+	return p.run(ctx, "cleanup", fqdn, value)
+}
+
+// run invokes the command for an action
+func (p *execProvider) run(ctx context.Context, action, fqdn, value string) error {
+	cmd := exec.CommandContext(ctx, p.command, action, fqdn, value)
+	cmd.Env = p.env
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s %s failed: %v: %s", p.command, action, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
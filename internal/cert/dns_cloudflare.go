@@ -0,0 +1,160 @@
+// Galaxy Node Pool - Cloudflare DNS Provider
+// AI-ID: CP-GAL-NODEPOOL-001
+package cert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// cloudflareAPI is the Cloudflare v4 API endpoint
+const cloudflareAPI = "https://api.cloudflare.com/client/v4"
+
+// cloudflareProvider manages challenge records through the Cloudflare API.
+// It authenticates with an api_token, or with email and a global api_key.
+type cloudflareProvider struct {
+	apiURL     string
+	apiToken   string
+	email      string
+	apiKey     string
+	httpClient *http.Client
+}
+
+// cloudflareResponse is the envelope of Cloudflare API responses
+type cloudflareResponse struct {
+	Success bool `json:"success"`
+	Errors  []struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+	Result json.RawMessage `json:"result"`
+}
+
+// newCloudflareProvider creates a Cloudflare provider from its credentials
+func newCloudflareProvider(credentials map[string]string) (DNSProvider, error) {
+	p := &cloudflareProvider{
+		apiURL:     cloudflareAPI,
+		apiToken:   credentials["api_token"],
+		email:      credentials["email"],
+		apiKey:     credentials["api_key"],
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+	if p.apiToken == "" && (p.email == "" || p.apiKey == "") {
+		return nil, fmt.Errorf("cloudflare DNS provider requires api_token, or email and api_key credentials")
+	}
+	return p, nil
+}
+
+// Present creates the TXT record
+func (p *cloudflareProvider) Present(ctx context.Context, fqdn, value string) error {
+	zoneID, err := p.findZone(ctx, fqdn)
+	if err != nil {
+		return err
+	}
+
+	record := map[string]interface{}{
+		"type":    "TXT",
+		"name":    strings.TrimSuffix(fqdn, "."),
+		"content": value,
+		"ttl":     120,
+	}
+	return p.do(ctx, http.MethodPost, "/zones/"+zoneID+"/dns_records", record, nil)
+}
+
+// CleanUp removes the TXT record
+func (p *cloudflareProvider) CleanUp(ctx context.Context, fqdn, value string) error {
+	zoneID, err := p.findZone(ctx, fqdn)
+	if err != nil {
+		return err
+	}
+
+	query := url.Values{
+		"type":    {"TXT"},
+		"name":    {strings.TrimSuffix(fqdn, ".")},
+		"content": {value},
+	}
+	var records []struct {
+		ID string `json:"id"`
+	}
+	if err := p.do(ctx, http.MethodGet, "/zones/"+zoneID+"/dns_records?"+query.Encode(), nil, &records); err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		if err := p.do(ctx, http.MethodDelete, "/zones/"+zoneID+"/dns_records/"+record.ID, nil, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findZone returns the ID of the closest zone containing a name
+func (p *cloudflareProvider) findZone(ctx context.Context, fqdn string) (string, error) {
+	labels := strings.Split(strings.TrimSuffix(fqdn, "."), ".")
+	for i := 0; i < len(labels)-1; i++ {
+		var zones []struct {
+			ID string `json:"id"`
+		}
+		name := strings.Join(labels[i:], ".")
+		if err := p.do(ctx, http.MethodGet, "/zones?"+url.Values{"name": {name}}.Encode(), nil, &zones); err != nil {
+			return "", err
+		}
+		if len(zones) > 0 {
+			return zones[0].ID, nil
+		}
+	}
+	return "", fmt.Errorf("no Cloudflare zone found for %s", fqdn)
+}
+
+// do calls the Cloudflare API, decoding the result into out when given
+func (p *cloudflareProvider) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, p.apiURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.apiToken != "" {
+		req.Header.Set("Authorization", "Bearer "+p.apiToken)
+	} else {
+		req.Header.Set("X-Auth-Email", p.email)
+		req.Header.Set("X-Auth-Key", p.apiKey)
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("cloudflare request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var result cloudflareResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxACMEResponse)).Decode(&result); err != nil {
+		return fmt.Errorf("cloudflare returned %s", resp.Status)
+	}
+	if !result.Success {
+		if len(result.Errors) > 0 {
+			return fmt.Errorf("cloudflare error %d: %s", result.Errors[0].Code, result.Errors[0].Message)
+		}
+		return fmt.Errorf("cloudflare returned %s", resp.Status)
+	}
+
+	if out != nil {
+		return json.Unmarshal(result.Result, out)
+	}
+	return nil
+}
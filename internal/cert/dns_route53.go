@@ -0,0 +1,232 @@
+// Galaxy Node Pool - Route 53 DNS Provider
+// AI-ID: CP-GAL-NODEPOOL-001
+package cert
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// route53API is the global Route 53 API endpoint
+	route53API = "https://route53.amazonaws.com"
+
+	// route53Region is the signing region of the global Route 53 endpoint
+	route53Region = "us-east-1"
+
+	// route53RecordTTL is the TTL of challenge records, deletion must repeat it
+	route53RecordTTL = 60
+)
+
+// route53Provider manages challenge records through the Route 53 API using
+// access_key and secret_key credentials, with an optional session_token and
+// hosted_zone_id
+type route53Provider struct {
+	apiURL       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	hostedZoneID string
+	httpClient   *http.Client
+	now          func() time.Time
+}
+
+// route53Change is a ChangeResourceRecordSets request
+type route53Change struct {
+	XMLName xml.Name `xml:"https://route53.amazonaws.com/doc/2013-04-01/ ChangeResourceRecordSetsRequest"`
+	Action  string   `xml:"ChangeBatch>Changes>Change>Action"`
+	Name    string   `xml:"ChangeBatch>Changes>Change>ResourceRecordSet>Name"`
+	Type    string   `xml:"ChangeBatch>Changes>Change>ResourceRecordSet>Type"`
+	TTL     int      `xml:"ChangeBatch>Changes>Change>ResourceRecordSet>TTL"`
+	Value   string   `xml:"ChangeBatch>Changes>Change>ResourceRecordSet>ResourceRecords>ResourceRecord>Value"`
+}
+
+// route53Zones is a ListHostedZonesByName response
+type route53Zones struct {
+	HostedZones []struct {
+		ID          string `xml:"Id"`
+		Name        string `xml:"Name"`
+		PrivateZone bool   `xml:"Config>PrivateZone"`
+	} `xml:"HostedZones>HostedZone"`
+}
+
+// route53Error is an error response of the Route 53 API
+type route53Error struct {
+	Code    string `xml:"Error>Code"`
+	Message string `xml:"Error>Message"`
+}
+
+// newRoute53Provider creates a Route 53 provider from its credentials
+func newRoute53Provider(credentials map[string]string) (DNSProvider, error) {
+	p := &route53Provider{
+		apiURL:       route53API,
+		accessKey:    credentials["access_key"],
+		secretKey:    credentials["secret_key"],
+		sessionToken: credentials["session_token"],
+		hostedZoneID: credentials["hosted_zone_id"],
+		httpClient:   &http.Client{Timeout: 30 * time.Second},
+		now:          time.Now,
+	}
+	if p.accessKey == "" || p.secretKey == "" {
+		return nil, fmt.Errorf("route53 DNS provider requires access_key and secret_key credentials")
+	}
+	return p, nil
+}
+
+// Present creates the TXT record
+func (p *route53Provider) Present(ctx context.Context, fqdn, value string) error {
+	return p.change(ctx, "UPSERT", fqdn, value)
+}
+
+// CleanUp removes the TXT record
+func (p *route53Provider) CleanUp(ctx context.Context, fqdn, value string) error {
+	return p.change(ctx, "DELETE", fqdn, value)
+}
+
+// change applies a single TXT record change
+func (p *route53Provider) change(ctx context.Context, action, fqdn, value string) error {
+	zoneID, err := p.findZone(ctx, fqdn)
+	if err != nil {
+		return err
+	}
+
+	body, err := xml.Marshal(route53Change{
+		Action: action,
+		Name:   fqdn,
+		Type:   "TXT",
+		TTL:    route53RecordTTL,
+		Value:  `"` + value + `"`,
+	})
+	if err != nil {
+		return err
+	}
+
+	return p.do(ctx, http.MethodPost, "/2013-04-01/hostedzone/"+zoneID+"/rrset", "", body, nil)
+}
+
+// findZone returns the ID of the closest public hosted zone containing a name
+func (p *route53Provider) findZone(ctx context.Context, fqdn string) (string, error) {
+	if p.hostedZoneID != "" {
+		return p.hostedZoneID, nil
+	}
+
+	labels := strings.Split(strings.TrimSuffix(fqdn, "."), ".")
+	for i := 0; i < len(labels)-1; i++ {
+		name := strings.Join(labels[i:], ".") + "."
+
+		var zones route53Zones
+		query := url.Values{"dnsname": {name}, "maxitems": {"10"}}.Encode()
+		if err := p.do(ctx, http.MethodGet, "/2013-04-01/hostedzonesbyname", query, nil, &zones); err != nil {
+			return "", err
+		}
+		for _, zone := range zones.HostedZones {
+			if zone.Name == name && !zone.PrivateZone {
+				return strings.TrimPrefix(zone.ID, "/hostedzone/"), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no Route 53 hosted zone found for %s", fqdn)
+}
+
+// do calls the Route 53 API, decoding the response into out when given
+func (p *route53Provider) do(ctx context.Context, method, path, query string, body []byte, out interface{}) error {
+	target := p.apiURL + path
+	if query != "" {
+		target += "?" + query
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/xml")
+	}
+	p.sign(req, body)
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("route53 request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxACMEResponse))
+	if err != nil {
+		return fmt.Errorf("failed to read route53 response: %v", err)
+	}
+
+	if resp.StatusCode >= 300 {
+		var apiErr route53Error
+		if xml.Unmarshal(data, &apiErr) == nil && apiErr.Code != "" {
+			return fmt.Errorf("route53 error %s: %s", apiErr.Code, apiErr.Message)
+		}
+		return fmt.Errorf("route53 returned %s", resp.Status)
+	}
+
+	if out != nil {
+		return xml.Unmarshal(data, out)
+	}
+	return nil
+}
+
+// sign adds an AWS Signature Version 4 to a request
+func (p *route53Provider) sign(req *http.Request, body []byte) {
+	now := p.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := amzDate[:8]
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	headers := []string{"host", "x-amz-date"}
+	values := map[string]string{"host": req.URL.Host, "x-amz-date": amzDate}
+	if p.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", p.sessionToken)
+		headers = append(headers, "x-amz-security-token")
+		values["x-amz-security-token"] = p.sessionToken
+	}
+
+	var canonicalHeaders strings.Builder
+	for _, name := range headers {
+		canonicalHeaders.WriteString(name + ":" + values[name] + "\n")
+	}
+	signedHeaders := strings.Join(headers, ";")
+
+	payloadHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		strings.ReplaceAll(req.URL.RawQuery, "+", "%20"),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := date + "/" + route53Region + "/route53/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+p.secretKey), date)
+	key = hmacSHA256(key, route53Region)
+	key = hmacSHA256(key, "route53")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		p.accessKey, scope, signedHeaders, signature))
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data under key
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
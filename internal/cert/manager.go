@@ -3,11 +3,32 @@
 package cert
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"log"
+	"net/http"
+	"net/url"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
+
+	"galaxy-node-pool/internal/nginx"
+)
+
+const (
+	// acmeTimeout bounds a whole ACME certificate request
+	acmeTimeout = 15 * time.Minute
+
+	// defaultWebroot is where HTTP-01 challenges are written when no webroot is set
+	defaultWebroot = "/var/www/html"
 )
 
 // Manager handles certificate operations for Galaxy Node Pool
@@ -30,152 +51,221 @@ func NewManager(certDir, nginxConfig string) *Manager {
 
 // GenerateSelfSigned generates a self-signed certificate for testnet environments
 func (m *Manager) GenerateSelfSigned(domain string) error {
+	return m.GenerateSelfSignedWithOptions(domain, SelfSignedOptions{})
+}
+
+// GenerateSelfSignedWithOptions generates a self-signed certificate with the
+// given key type, SANs and validity, writing <domain>.crt and a 0600 <domain>.key
+func (m *Manager) GenerateSelfSignedWithOptions(domain string, opts SelfSignedOptions) error {
 	// Create cert directory if it doesn't exist
 	if err := os.MkdirAll(m.CertDir, 0755); err != nil {
 		return fmt.Errorf("failed to create cert directory: %v", err)
 	}
-	
-	// Generate a private key
-	keyPath := filepath.Join(m.CertDir, domain+".key")
-	cmd := exec.Command("openssl", "genrsa", "-out", keyPath, "2048")
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to generate private key: %v", err)
-	}
-	
-	// Generate a CSR configuration
-	csrConfigPath := filepath.Join(m.CertDir, domain+".cnf")
-	csrConfig := fmt.Sprintf(`[req]
-default_bits = 2048
-prompt = no
-default_md = sha256
-distinguished_name = dn
-req_extensions = req_ext
-
-[dn]
-CN = %s
-
-[req_ext]
-subjectAltName = @alt_names
-
-[alt_names]
-`, domain)
-	
-	// Handle wildcard domains
-	if strings.HasPrefix(domain, "*.") {
-		baseDomain := strings.TrimPrefix(domain, "*.")
-		csrConfig += fmt.Sprintf("DNS.1 = %s\nDNS.2 = *.%s\n", baseDomain, baseDomain)
-	} else {
-		csrConfig += fmt.Sprintf("DNS.1 = %s\n", domain)
-	}
-	
-	if err := os.WriteFile(csrConfigPath, []byte(csrConfig), 0644); err != nil {
-		return fmt.Errorf("failed to write CSR config: %v", err)
+
+	certPEM, keyPEM, err := NewSelfSigned(domain, opts)
+	if err != nil {
+		return fmt.Errorf("failed to generate self-signed certificate: %v", err)
 	}
-	
-	// Generate a CSR
-	csrPath := filepath.Join(m.CertDir, domain+".csr")
-	cmd = exec.Command("openssl", "req", "-new", "-key", keyPath, "-out", csrPath, "-config", csrConfigPath)
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to generate CSR: %v", err)
+
+	// Write the key first so the certificate never points at a missing key
+	keyPath := filepath.Join(m.CertDir, domain+".key")
+	if err := writeFileAtomic(keyPath, keyPEM, 0600); err != nil {
+		return err
 	}
-	
-	// Generate a self-signed certificate
 	certPath := filepath.Join(m.CertDir, domain+".crt")
-	cmd = exec.Command("openssl", "x509", "-req", "-days", "365", "-in", csrPath, "-signkey", keyPath, "-out", certPath, "-extensions", "req_ext", "-extfile", csrConfigPath)
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to generate self-signed certificate: %v", err)
+	if err := writeFileAtomic(certPath, certPEM, 0644); err != nil {
+		return err
 	}
-	
+
+	// Remove artifacts left behind by the openssl based generator
+	for _, ext := range []string{".cnf", ".csr"} {
+		os.Remove(filepath.Join(m.CertDir, domain+ext))
+	}
+
 	fmt.Printf("Self-signed certificate generated at: %s\n", certPath)
 	fmt.Printf("Private key generated at: %s\n", keyPath)
-	
+
 	// Update Nginx configuration if provided
 	if m.NginxConfig != "" {
 		if err := m.UpdateNginxConfig(domain, certPath, keyPath); err != nil {
 			return fmt.Errorf("failed to update Nginx config: %v", err)
 		}
 	}
-	
+
 	return nil
 }
 
-// GenerateWithLetsEncrypt generates a certificate using Let's Encrypt
-func (m *Manager) GenerateWithLetsEncrypt(domain, email string, wildcard bool, dnsProvider string) error {
-	// Check if certbot is installed
-	if _, err := exec.LookPath("certbot"); err != nil {
-		return fmt.Errorf("certbot is not installed: %v", err)
-	}
-	
-	var cmd *exec.Cmd
-	
-	if wildcard {
-		// For wildcard certificates, we need to use DNS challenge
-		if dnsProvider == "" {
-			return fmt.Errorf("DNS provider is required for wildcard certificates")
+// GenerateWithLetsEncrypt obtains a certificate from Let's Encrypt, or the
+// ACME server set in config. DNS-01 challenges are used for wildcard domains
+// or when useDNS is set, HTTP-01 challenges otherwise.
+func (m *Manager) GenerateWithLetsEncrypt(domain string, config *Config, useDNS bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), acmeTimeout)
+	defer cancel()
+
+	var solver ChallengeSolver
+	if useDNS || strings.HasPrefix(domain, "*.") {
+		if config.DNSProvider == "" {
+			return fmt.Errorf("DNS provider is required for DNS-01 challenges")
+		}
+		provider, err := NewDNSProvider(config.DNSProvider, config.Credentials)
+		if err != nil {
+			return err
 		}
-		
-		// Install the DNS plugin if needed
-		pluginPackage := fmt.Sprintf("python3-certbot-dns-%s", dnsProvider)
-		installCmd := exec.Command("apt", "list", "--installed", pluginPackage)
-		if err := installCmd.Run(); err != nil {
-			fmt.Printf("Warning: DNS plugin for %s might not be installed\n", dnsProvider)
+
+		timeout := DefaultPropagationTimeout
+		if config.PropagationTimeout != "" {
+			if timeout, err = time.ParseDuration(config.PropagationTimeout); err != nil {
+				return fmt.Errorf("invalid propagation_timeout: %v", err)
+			}
 		}
-		
-		// Generate certificate using DNS challenge
-		cmd = exec.Command("certbot", "certonly",
-			"--dns-"+dnsProvider,
-			"--agree-tos",
-			"--email", email,
-			"-d", domain)
+		solver = NewDNS01Solver(provider, timeout)
+	} else if config.HTTPAddress != "" {
+		solver = NewHTTP01Solver("", config.HTTPAddress)
 	} else {
-		// For regular certificates, we can use HTTP challenge
-		cmd = exec.Command("certbot", "certonly",
-			"--webroot",
-			"--webroot-path", "/var/www/html",
-			"--agree-tos",
-			"--email", email,
-			"-d", domain)
+		webroot := config.Webroot
+		if webroot == "" {
+			webroot = defaultWebroot
+		}
+		solver = NewHTTP01Solver(webroot, "")
 	}
-	
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to generate certificate: %v", err)
+
+	client, err := m.acmeClient(config)
+	if err != nil {
+		return err
 	}
-	
+	if err := client.Register(ctx, config.Email); err != nil {
+		return err
+	}
+
+	key, err := generateKey(config.KeyType, 0)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("failed to encode private key: %v", err)
+	}
+
+	fmt.Printf("Requesting certificate for %s using %s challenges\n", domain, solver.Type())
+	chain, err := client.ObtainCertificate(ctx, DefaultHosts(domain), key, solver)
+	if err != nil {
+		return fmt.Errorf("failed to obtain certificate: %v", err)
+	}
+
+	if err := os.MkdirAll(m.CertDir, 0755); err != nil {
+		return fmt.Errorf("failed to create cert directory: %v", err)
+	}
+	keyPath := filepath.Join(m.CertDir, domain+".key")
+	if err := writePEM(keyPath, "PRIVATE KEY", keyDER, 0600); err != nil {
+		return err
+	}
+	certPath := filepath.Join(m.CertDir, domain+".crt")
+	if err := writeFileAtomic(certPath, chain, 0644); err != nil {
+		return err
+	}
+
+	fmt.Printf("Certificate obtained at: %s\n", certPath)
+	fmt.Printf("Private key generated at: %s\n", keyPath)
+
 	// Update Nginx configuration if provided
 	if m.NginxConfig != "" {
-		certPath := fmt.Sprintf("/etc/letsencrypt/live/%s/fullchain.pem", domain)
-		keyPath := fmt.Sprintf("/etc/letsencrypt/live/%s/privkey.pem", domain)
 		if err := m.UpdateNginxConfig(domain, certPath, keyPath); err != nil {
 			return fmt.Errorf("failed to update Nginx config: %v", err)
 		}
 	}
-	
+
 	return nil
 }
 
-// UpdateNginxConfig updates the Nginx configuration with the certificate paths
+// acmeClient creates an ACME client for the configured directory, with an
+// account key kept per directory under the cert directory
+func (m *Manager) acmeClient(config *Config) (*ACMEClient, error) {
+	directoryURL := config.DirectoryURL
+	if directoryURL == "" {
+		directoryURL = LetsEncryptDirectory
+	}
+	parsed, err := url.Parse(directoryURL)
+	if err != nil || parsed.Host == "" {
+		return nil, fmt.Errorf("invalid ACME directory URL %q", directoryURL)
+	}
+
+	accountDir := filepath.Join(m.CertDir, "acme", strings.ReplaceAll(parsed.Host, ":", "_"))
+	if err := os.MkdirAll(accountDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create ACME account directory: %v", err)
+	}
+	accountKey, err := loadOrCreateECKey(filepath.Join(accountDir, "account.key"))
+	if err != nil {
+		return nil, err
+	}
+
+	httpClient := &http.Client{Timeout: 30 * time.Second}
+	if config.DirectoryCAFile != "" {
+		caPEM, err := os.ReadFile(config.DirectoryCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read directory CA file: %v", err)
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in %s", config.DirectoryCAFile)
+		}
+		httpClient.Transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}
+	}
+
+	return NewACMEClient(directoryURL, accountKey, httpClient), nil
+}
+
+// loadOrCreateECKey loads a PEM encoded ECDSA key, generating it if missing
+func loadOrCreateECKey(path string) (*ecdsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate account key: %v", err)
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode account key: %v", err)
+		}
+		return key, writePEM(path, "PRIVATE KEY", der, 0600)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read account key: %v", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("invalid account key PEM in %s", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse account key: %v", err)
+	}
+	ecKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok || ecKey.Curve != elliptic.P256() {
+		return nil, fmt.Errorf("account key in %s is not an ECDSA P-256 key", path)
+	}
+	return ecKey, nil
+}
+
+// UpdateNginxConfig points the managed Nginx server blocks for a domain at new
+// certificate paths. Configurations without a managed server for the domain
+// are left unchanged with a warning.
 func (m *Manager) UpdateNginxConfig(domain, certPath, keyPath string) error {
-	// Read the Nginx configuration
-	config, err := os.ReadFile(m.NginxConfig)
+	result, err := nginx.UpdateCertificate(m.NginxConfig, domain, certPath, keyPath, nginx.WriteOptions{})
+	if errors.Is(err, nginx.ErrNoManagedServer) {
+		log.Printf("Warning: Nginx configuration not updated: %v; set ssl_certificate %s and ssl_certificate_key %s yourself, or regenerate the configuration with galaxy-pool setup nginx", err, certPath, keyPath)
+		return nil
+	}
 	if err != nil {
-		return fmt.Errorf("failed to read Nginx config: %v", err)
+		return err
 	}
-	
-	// Replace the certificate paths
-	configStr := string(config)
-	configStr = strings.Replace(configStr, "ssl_certificate /etc/ssl/certs/ssl-cert-snakeoil.pem;", fmt.Sprintf("ssl_certificate %s;", certPath), -1)
-	configStr = strings.Replace(configStr, "ssl_certificate_key /etc/ssl/private/ssl-cert-snakeoil.key;", fmt.Sprintf("ssl_certificate_key %s;", keyPath), -1)
-	
-	// Write the updated configuration
-	if err := os.WriteFile(m.NginxConfig, []byte(configStr), 0644); err != nil {
-		return fmt.Errorf("failed to write Nginx config: %v", err)
+	if !result.Changed {
+		fmt.Printf("Nginx configuration already uses the certificate for %s\n", domain)
+		return nil
 	}
-	
-	fmt.Printf("Nginx configuration updated with certificate paths\n")
+
+	fmt.Printf("Nginx configuration updated with certificate paths:\n%s", result.Diff)
 	fmt.Printf("To apply the changes, run: sudo nginx -t && sudo systemctl reload nginx\n")
-	
+
 	return nil
 }
@@ -0,0 +1,83 @@
+package cert
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const managedNginxConfig = `# BEGIN galaxy-pool managed section
+# Changes between these markers are overwritten, edit outside them.
+
+server {
+    listen 443 ssl;
+    server_name %s;
+    ssl_certificate /etc/ssl/certs/ssl-cert-snakeoil.pem;
+    ssl_certificate_key /etc/ssl/private/ssl-cert-snakeoil.key;
+}
+# END galaxy-pool managed section
+`
+
+const unmanagedNginxConfig = `server {
+    listen 443 ssl;
+    server_name pool.example.com;
+    ssl_certificate /etc/ssl/certs/ssl-cert-snakeoil.pem;
+    ssl_certificate_key /etc/ssl/private/ssl-cert-snakeoil.key;
+}
+`
+
+func TestGenerateSelfSignedUpdatesNginxConfig(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      string
+		missing     bool
+		wantUpdated bool
+	}{
+		{name: "managed server", config: strings.Replace(managedNginxConfig, "%s", "pool.example.com", 1), wantUpdated: true},
+		{name: "managed section without the domain", config: strings.Replace(managedNginxConfig, "%s", "other.example.com", 1)},
+		{name: "configuration without markers", config: unmanagedNginxConfig},
+		{name: "missing configuration", missing: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			nginxConfig := filepath.Join(dir, "galaxy-pool.conf")
+			if !tt.missing {
+				if err := os.WriteFile(nginxConfig, []byte(tt.config), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// Issuance succeeds whether or not the configuration can be updated
+			certDir := filepath.Join(dir, "certs")
+			m := NewManager(certDir, nginxConfig)
+			if err := m.GenerateSelfSignedWithOptions("pool.example.com", SelfSignedOptions{KeyType: KeyTypeECDSA}); err != nil {
+				t.Fatalf("GenerateSelfSignedWithOptions failed: %v", err)
+			}
+
+			data, err := os.ReadFile(nginxConfig)
+			if tt.missing {
+				if !os.IsNotExist(err) {
+					t.Fatalf("missing configuration was created")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			certPath := filepath.Join(certDir, "pool.example.com.crt")
+			keyPath := filepath.Join(certDir, "pool.example.com.key")
+			updated := strings.Contains(string(data), "ssl_certificate "+certPath+";") &&
+				strings.Contains(string(data), "ssl_certificate_key "+keyPath+";")
+			if updated != tt.wantUpdated {
+				t.Errorf("configuration updated = %v, want %v:\n%s", updated, tt.wantUpdated, data)
+			}
+			if !tt.wantUpdated && string(data) != tt.config {
+				t.Errorf("configuration changed:\n%s", data)
+			}
+		})
+	}
+}
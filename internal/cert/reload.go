@@ -0,0 +1,125 @@
+// Galaxy Node Pool - Certificate Hot Reload
+// AI-ID: CP-GAL-NODEPOOL-001
+package cert
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// KeyPairReloader serves a certificate and key from disk through
+// tls.Config.GetCertificate, reloading them when the files change. New
+// handshakes get the new certificate, established connections are unaffected.
+type KeyPairReloader struct {
+	certFile string
+	keyFile  string
+
+	cert    *tls.Certificate
+	leaf    *x509.Certificate
+	version string
+	mu      sync.RWMutex
+}
+
+// NewKeyPairReloader creates a new reloader, loading the key pair once
+func NewKeyPairReloader(certFile, keyFile string) (*KeyPairReloader, error) {
+	r := &KeyPairReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+	if _, err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate returns the current certificate, for use as tls.Config.GetCertificate
+func (r *KeyPairReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// NotAfter returns the expiry of the current certificate
+func (r *KeyPairReloader) NotAfter() time.Time {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.leaf.NotAfter
+}
+
+// Reload loads the key pair if the files changed since the last load,
+// reporting whether a new certificate is being served. A pair that fails to
+// load leaves the current certificate in place.
+func (r *KeyPairReloader) Reload() (bool, error) {
+	version, err := r.fileVersion()
+	if err != nil {
+		return false, err
+	}
+
+	r.mu.RLock()
+	unchanged := version == r.version
+	r.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	pair, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("failed to load certificate: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return false, fmt.Errorf("failed to parse certificate: %v", err)
+	}
+	pair.Leaf = leaf
+
+	r.mu.Lock()
+	r.cert = &pair
+	r.leaf = leaf
+	r.version = version
+	r.mu.Unlock()
+
+	return true, nil
+}
+
+// Watch reloads the key pair whenever the files change until the context is done
+func (r *KeyPairReloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := r.Reload()
+			if err != nil {
+				// Renewals write the key before the certificate, a mismatched
+				// pair is retried on the next tick
+				log.Printf("Warning: Failed to reload TLS certificate %s: %v", r.certFile, err)
+				continue
+			}
+			if reloaded {
+				log.Printf("Reloaded TLS certificate %s (expires %s)", r.certFile, r.NotAfter().Format(time.RFC3339))
+			}
+		}
+	}
+}
+
+// fileVersion identifies the current contents of the key pair files
+func (r *KeyPairReloader) fileVersion() (string, error) {
+	version := ""
+	for _, path := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to stat %s: %v", path, err)
+		}
+		version += fmt.Sprintf("%d/%d;", info.ModTime().UnixNano(), info.Size())
+	}
+	return version, nil
+}
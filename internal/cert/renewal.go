@@ -0,0 +1,255 @@
+// Galaxy Node Pool - Certificate Renewal
+// AI-ID: CP-GAL-NODEPOOL-001
+package cert
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultRenewalThreshold is how long before expiry certificates are renewed
+	DefaultRenewalThreshold = 30 * 24 * time.Hour
+
+	// DefaultRenewalInterval is how often the renewal service checks certificates
+	DefaultRenewalInterval = 12 * time.Hour
+)
+
+// CertStatus describes a certificate managed in the cert directory
+type CertStatus struct {
+	Domain     string
+	CertPath   string
+	KeyPath    string
+	Hosts      []string
+	Issuer     string
+	SelfSigned bool
+	KeyType    string
+	NotBefore  time.Time
+	NotAfter   time.Time
+}
+
+// Remaining returns the time left until the certificate expires
+func (s *CertStatus) Remaining(now time.Time) time.Duration {
+	return s.NotAfter.Sub(now)
+}
+
+// RenewalDue reports whether the certificate should be renewed. Certificates
+// whose whole validity is shorter than the threshold are renewed once two
+// thirds of it has passed.
+func (s *CertStatus) RenewalDue(now time.Time, threshold time.Duration) bool {
+	if validity := s.NotAfter.Sub(s.NotBefore); threshold >= validity {
+		threshold = validity / 3
+	}
+	return s.Remaining(now) <= threshold
+}
+
+// Status returns the status of the certificate managed for a domain
+func (m *Manager) Status(domain string) (*CertStatus, error) {
+	certPath := filepath.Join(m.CertDir, domain+".crt")
+	data, err := os.ReadFile(certPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no certificate managed for %s in %s", domain, m.CertDir)
+		}
+		return nil, fmt.Errorf("failed to read certificate: %v", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("invalid certificate PEM in %s", certPath)
+	}
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate %s: %v", certPath, err)
+	}
+
+	status := &CertStatus{
+		Domain:     domain,
+		CertPath:   certPath,
+		KeyPath:    filepath.Join(m.CertDir, domain+".key"),
+		Hosts:      append([]string(nil), leaf.DNSNames...),
+		Issuer:     leaf.Issuer.String(),
+		SelfSigned: isSelfSigned(leaf),
+		NotBefore:  leaf.NotBefore,
+		NotAfter:   leaf.NotAfter,
+	}
+	for _, ip := range leaf.IPAddresses {
+		status.Hosts = append(status.Hosts, ip.String())
+	}
+
+	switch leaf.PublicKey.(type) {
+	case *rsa.PublicKey:
+		status.KeyType = KeyTypeRSA
+	case *ecdsa.PublicKey:
+		status.KeyType = KeyTypeECDSA
+	}
+
+	return status, nil
+}
+
+// isSelfSigned reports whether a certificate is signed by its own key
+func isSelfSigned(leaf *x509.Certificate) bool {
+	return bytes.Equal(leaf.RawIssuer, leaf.RawSubject) &&
+		leaf.CheckSignature(leaf.SignatureAlgorithm, leaf.RawTBSCertificate, leaf.Signature) == nil
+}
+
+// List returns the status of every certificate in the cert directory that has a key
+func (m *Manager) List() ([]*CertStatus, error) {
+	paths, err := filepath.Glob(filepath.Join(m.CertDir, "*.crt"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	var statuses []*CertStatus
+	for _, path := range paths {
+		domain := strings.TrimSuffix(filepath.Base(path), ".crt")
+		if _, err := os.Stat(filepath.Join(m.CertDir, domain+".key")); err != nil {
+			continue
+		}
+		status, err := m.Status(domain)
+		if err != nil {
+			log.Printf("Warning: Skipping certificate %s: %v", path, err)
+			continue
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// Renew replaces the certificate for a domain the way it was obtained:
+// self-signed certificates are regenerated with the same key type, names and
+// validity, others are requested again over ACME
+func (m *Manager) Renew(domain string, config *Config) error {
+	status, err := m.Status(domain)
+	if err != nil {
+		return err
+	}
+
+	if status.SelfSigned {
+		return m.GenerateSelfSignedWithOptions(domain, SelfSignedOptions{
+			KeyType: status.KeyType,
+			Hosts:   status.Hosts,
+			// Generated certificates are backdated by a minute
+			Validity: status.NotAfter.Sub(status.NotBefore) - time.Minute,
+		})
+	}
+
+	if config.KeyType == "" {
+		config.KeyType = status.KeyType
+	}
+	return m.GenerateWithLetsEncrypt(domain, config, strings.HasPrefix(domain, "*."))
+}
+
+// RenewDue renews every managed certificate due for renewal, returning the
+// renewed domains. Failures are reported but don't stop other renewals.
+func (m *Manager) RenewDue(config *Config, threshold time.Duration) ([]string, error) {
+	statuses, err := m.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var renewed, failed []string
+	now := time.Now()
+	for _, status := range statuses {
+		if !status.RenewalDue(now, threshold) {
+			continue
+		}
+
+		// Each renewal gets its own copy, Renew fills in defaults
+		domainConfig := *config
+		if err := m.Renew(status.Domain, &domainConfig); err != nil {
+			log.Printf("Warning: Failed to renew certificate for %s: %v", status.Domain, err)
+			failed = append(failed, status.Domain)
+			continue
+		}
+		renewed = append(renewed, status.Domain)
+	}
+
+	if len(failed) > 0 {
+		return renewed, fmt.Errorf("failed to renew certificates for %s", strings.Join(failed, ", "))
+	}
+	return renewed, nil
+}
+
+// RenewalService renews managed certificates in the background before they expire
+type RenewalService struct {
+	manager    *Manager
+	configPath string
+	threshold  time.Duration
+	interval   time.Duration
+	onRenew    func(domain string)
+}
+
+// NewRenewalService creates a new renewal service for the certificates of a
+// manager, loading ACME settings from the certificate configuration file on
+// every check
+func NewRenewalService(manager *Manager, configPath string, threshold, interval time.Duration) *RenewalService {
+	if threshold <= 0 {
+		threshold = DefaultRenewalThreshold
+	}
+	if interval <= 0 {
+		interval = DefaultRenewalInterval
+	}
+	return &RenewalService{
+		manager:    manager,
+		configPath: configPath,
+		threshold:  threshold,
+		interval:   interval,
+	}
+}
+
+// OnRenew sets a function called after each successful renewal
+func (s *RenewalService) OnRenew(fn func(domain string)) {
+	s.onRenew = fn
+}
+
+// Start checks certificates now and then every interval until the context is done
+func (s *RenewalService) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			s.Check()
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Check renews every certificate that is due
+func (s *RenewalService) Check() {
+	config, err := LoadConfig(s.configPath)
+	if err != nil {
+		log.Printf("Warning: Failed to load certificate configuration: %v", err)
+		return
+	}
+
+	renewed, err := s.manager.RenewDue(config, s.threshold)
+	if err != nil {
+		log.Printf("Warning: Certificate renewal incomplete: %v", err)
+	}
+
+	for _, domain := range renewed {
+		log.Printf("Renewed certificate for %s", domain)
+		if s.onRenew != nil {
+			s.onRenew(domain)
+		}
+	}
+}
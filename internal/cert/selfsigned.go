@@ -0,0 +1,206 @@
+// Galaxy Node Pool - Self-Signed Certificates
+// AI-ID: CP-GAL-NODEPOOL-001
+package cert
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Key types for generated certificates
+const (
+	// KeyTypeRSA generates RSA keys
+	KeyTypeRSA = "rsa"
+
+	// KeyTypeECDSA generates ECDSA P-256 keys
+	KeyTypeECDSA = "ecdsa"
+)
+
+const (
+	// DefaultSelfSignedValidity is the validity of self-signed certificates
+	DefaultSelfSignedValidity = 365 * 24 * time.Hour
+
+	// defaultRSABits is the RSA key size used when none is given
+	defaultRSABits = 2048
+
+	// minRSABits is the smallest RSA key size accepted
+	minRSABits = 2048
+)
+
+// SelfSignedOptions controls how a self-signed certificate is generated
+type SelfSignedOptions struct {
+	// KeyType is rsa or ecdsa, defaulting to rsa
+	KeyType string
+
+	// RSABits is the RSA key size, defaulting to 2048
+	RSABits int
+
+	// Hosts are the DNS names and IP addresses the certificate is valid for.
+	// Names may be wildcards such as *.example.com. Defaults to the domain,
+	// plus its base domain for wildcards.
+	Hosts []string
+
+	// Validity is how long the certificate is valid for, defaulting to a year
+	Validity time.Duration
+}
+
+// DefaultHosts returns the subject alternative names for a domain. A
+// wildcard domain also covers its base domain.
+func DefaultHosts(domain string) []string {
+	if strings.HasPrefix(domain, "*.") {
+		return []string{strings.TrimPrefix(domain, "*."), domain}
+	}
+	return []string{domain}
+}
+
+// NewSelfSigned generates a key and a self-signed certificate for a domain,
+// returning both PEM encoded
+func NewSelfSigned(domain string, opts SelfSignedOptions) (certPEM, keyPEM []byte, err error) {
+	if domain == "" {
+		return nil, nil, fmt.Errorf("domain is required")
+	}
+
+	hosts := opts.Hosts
+	if len(hosts) == 0 {
+		hosts = DefaultHosts(domain)
+	}
+
+	validity := opts.Validity
+	if validity == 0 {
+		validity = DefaultSelfSignedValidity
+	}
+	if validity < 0 {
+		return nil, nil, fmt.Errorf("validity must be positive")
+	}
+
+	key, err := generateKey(opts.KeyType, opts.RSABits)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: domain},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+
+	// RSA key exchange needs the key to encipher
+	if _, ok := key.(*rsa.PrivateKey); ok {
+		template.KeyUsage |= x509.KeyUsageKeyEncipherment
+	}
+
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+			continue
+		}
+		if err := validateDNSName(host); err != nil {
+			return nil, nil, err
+		}
+		template.DNSNames = append(template.DNSNames, host)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create certificate: %v", err)
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode private key: %v", err)
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
+
+// generateKey creates a private key of the given type
+func generateKey(keyType string, rsaBits int) (crypto.Signer, error) {
+	switch strings.ToLower(keyType) {
+	case "", KeyTypeRSA:
+		if rsaBits == 0 {
+			rsaBits = defaultRSABits
+		}
+		if rsaBits < minRSABits {
+			return nil, fmt.Errorf("RSA keys must be at least %d bits", minRSABits)
+		}
+		key, err := rsa.GenerateKey(rand.Reader, rsaBits)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate RSA key: %v", err)
+		}
+		return key, nil
+	case KeyTypeECDSA:
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate ECDSA key: %v", err)
+		}
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q, use %s or %s", keyType, KeyTypeRSA, KeyTypeECDSA)
+	}
+}
+
+// validateDNSName checks a subject alternative name. Wildcards are only
+// allowed as the whole leftmost label.
+func validateDNSName(name string) error {
+	labels := strings.Split(name, ".")
+	for i, label := range labels {
+		if label == "" {
+			return fmt.Errorf("invalid DNS name %q", name)
+		}
+		if strings.Contains(label, "*") && (i != 0 || label != "*" || len(labels) < 3) {
+			return fmt.Errorf("invalid wildcard DNS name %q", name)
+		}
+	}
+	return nil
+}
+
+// writeFileAtomic writes a file through a temporary file in the same
+// directory, so the file never exists with partial content or looser
+// permissions than perm
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %v", path, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to set permissions on %s: %v", path, err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	return nil
+}
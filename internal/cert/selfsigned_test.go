@@ -0,0 +1,223 @@
+package cert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readCertificate parses the PEM certificate a manager wrote for a domain
+func readCertificate(t *testing.T, dir, domain string) *x509.Certificate {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join(dir, domain+".crt"))
+	if err != nil {
+		t.Fatalf("failed to read certificate: %v", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		t.Fatalf("certificate file is not a PEM certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+	return cert
+}
+
+func TestGenerateSelfSignedWithOptions(t *testing.T) {
+	tests := []struct {
+		name      string
+		domain    string
+		opts      SelfSignedOptions
+		wantKey   string
+		wantBits  int
+		wantDNS   []string
+		wantIPs   []string
+		wantValid time.Duration
+	}{
+		{
+			name:      "defaults",
+			domain:    "pool.example.com",
+			wantKey:   KeyTypeRSA,
+			wantBits:  2048,
+			wantDNS:   []string{"pool.example.com"},
+			wantValid: DefaultSelfSignedValidity,
+		},
+		{
+			name:      "rsa 3072",
+			domain:    "pool.example.com",
+			opts:      SelfSignedOptions{KeyType: KeyTypeRSA, RSABits: 3072, Validity: 48 * time.Hour},
+			wantKey:   KeyTypeRSA,
+			wantBits:  3072,
+			wantDNS:   []string{"pool.example.com"},
+			wantValid: 48 * time.Hour,
+		},
+		{
+			name:      "ecdsa",
+			domain:    "pool.example.com",
+			opts:      SelfSignedOptions{KeyType: KeyTypeECDSA, Validity: 30 * 24 * time.Hour},
+			wantKey:   KeyTypeECDSA,
+			wantDNS:   []string{"pool.example.com"},
+			wantValid: 30 * 24 * time.Hour,
+		},
+		{
+			name:      "wildcard covers base domain",
+			domain:    "*.example.com",
+			opts:      SelfSignedOptions{KeyType: KeyTypeECDSA},
+			wantKey:   KeyTypeECDSA,
+			wantDNS:   []string{"example.com", "*.example.com"},
+			wantValid: DefaultSelfSignedValidity,
+		},
+		{
+			name:      "explicit hosts with IPs",
+			domain:    "pool.example.com",
+			opts:      SelfSignedOptions{Hosts: []string{"*.pool.example.com", "127.0.0.1", "::1"}},
+			wantKey:   KeyTypeRSA,
+			wantBits:  2048,
+			wantDNS:   []string{"*.pool.example.com"},
+			wantIPs:   []string{"127.0.0.1", "::1"},
+			wantValid: DefaultSelfSignedValidity,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			m := NewManager(dir, "")
+			start := time.Now()
+			if err := m.GenerateSelfSignedWithOptions(tt.domain, tt.opts); err != nil {
+				t.Fatalf("GenerateSelfSignedWithOptions failed: %v", err)
+			}
+
+			cert := readCertificate(t, dir, tt.domain)
+			if cert.Subject.CommonName != tt.domain {
+				t.Errorf("common name = %q, want %q", cert.Subject.CommonName, tt.domain)
+			}
+			if !reflect.DeepEqual(cert.DNSNames, tt.wantDNS) {
+				t.Errorf("DNS names = %v, want %v", cert.DNSNames, tt.wantDNS)
+			}
+			var ips []string
+			for _, ip := range cert.IPAddresses {
+				ips = append(ips, ip.String())
+			}
+			if !reflect.DeepEqual(ips, tt.wantIPs) {
+				t.Errorf("IP addresses = %v, want %v", ips, tt.wantIPs)
+			}
+
+			// The validity starts just before generation
+			if cert.NotBefore.After(start) {
+				t.Errorf("certificate not valid before %s, generated at %s", cert.NotBefore, start)
+			}
+			if got := cert.NotAfter.Sub(start); got < tt.wantValid-time.Minute || got > tt.wantValid+time.Minute {
+				t.Errorf("certificate valid for %s, want %s", got, tt.wantValid)
+			}
+			if err := cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature); err != nil {
+				t.Errorf("certificate is not self-signed: %v", err)
+			}
+			for _, name := range tt.wantDNS {
+				// Wildcards cover any single label
+				host := strings.Replace(name, "*", "node1", 1)
+				if err := cert.VerifyHostname(host); err != nil {
+					t.Errorf("certificate is not valid for %s: %v", host, err)
+				}
+			}
+
+			switch key := cert.PublicKey.(type) {
+			case *rsa.PublicKey:
+				if tt.wantKey != KeyTypeRSA {
+					t.Errorf("key type = rsa, want %s", tt.wantKey)
+				} else if key.N.BitLen() != tt.wantBits {
+					t.Errorf("RSA key has %d bits, want %d", key.N.BitLen(), tt.wantBits)
+				}
+			case *ecdsa.PublicKey:
+				if tt.wantKey != KeyTypeECDSA {
+					t.Errorf("key type = ecdsa, want %s", tt.wantKey)
+				} else if key.Curve != elliptic.P256() {
+					t.Errorf("ECDSA key uses %s, want P-256", key.Curve.Params().Name)
+				}
+			default:
+				t.Errorf("unexpected public key type %T", key)
+			}
+
+			// The key file is private and matches the certificate
+			keyPath := filepath.Join(dir, tt.domain+".key")
+			info, err := os.Stat(keyPath)
+			if err != nil {
+				t.Fatalf("failed to stat key file: %v", err)
+			}
+			if mode := info.Mode().Perm(); mode != 0600 {
+				t.Errorf("key file mode = %o, want 600", mode)
+			}
+			if _, err := tls.LoadX509KeyPair(filepath.Join(dir, tt.domain+".crt"), keyPath); err != nil {
+				t.Errorf("certificate and key do not match: %v", err)
+			}
+		})
+	}
+}
+
+func TestGenerateSelfSignedWithOptionsInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		domain string
+		opts   SelfSignedOptions
+	}{
+		{name: "no domain", domain: ""},
+		{name: "unknown key type", domain: "pool.example.com", opts: SelfSignedOptions{KeyType: "dsa"}},
+		{name: "short RSA key", domain: "pool.example.com", opts: SelfSignedOptions{RSABits: 1024}},
+		{name: "negative validity", domain: "pool.example.com", opts: SelfSignedOptions{Validity: -time.Hour}},
+		{name: "wildcard not leftmost", domain: "pool.*.example.com"},
+		{name: "partial wildcard", domain: "pool*.example.com"},
+		{name: "wildcard of a TLD", domain: "*.com"},
+		{name: "empty label", domain: "pool..example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := NewManager(dir, "").GenerateSelfSignedWithOptions(tt.domain, tt.opts); err == nil {
+				t.Fatalf("GenerateSelfSignedWithOptions(%q) succeeded, want an error", tt.domain)
+			}
+			if _, err := os.Stat(filepath.Join(dir, tt.domain+".key")); !os.IsNotExist(err) {
+				t.Errorf("key file written for a failed generation")
+			}
+		})
+	}
+}
+
+func TestGenerateSelfSignedWithOptionsReplacesKey(t *testing.T) {
+	dir := t.TempDir()
+	keyPath := filepath.Join(dir, "pool.example.com.key")
+
+	// An existing key file with loose permissions is replaced, not rewritten in place
+	if err := os.WriteFile(keyPath, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewManager(dir, "").GenerateSelfSignedWithOptions("pool.example.com", SelfSignedOptions{KeyType: KeyTypeECDSA, Hosts: []string{"pool.example.com", "10.0.0.1"}}); err != nil {
+		t.Fatalf("GenerateSelfSignedWithOptions failed: %v", err)
+	}
+
+	info, err := os.Stat(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Errorf("key file mode = %o, want 600", mode)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("cert directory holds %d files, want the certificate and key only", len(entries))
+	}
+}
@@ -2,7 +2,6 @@ package config
 
 import (
 	"fmt"
-	"os"
 	"strings"
 
 	"github.com/spf13/viper"
@@ -10,13 +9,40 @@ import (
 
 // Config represents the application configuration
 type Config struct {
+	// Version is the schema version of the configuration file
+	Version int `mapstructure:"version"`
+
+	// Pool identity and the services exposed next to the registry
+	Pool struct {
+		Name string `mapstructure:"name"`
+		// OrgID is the organization in the pool's domain
+		OrgID string `mapstructure:"org_id"`
+		// Network is mainnet or testnet
+		Network    string `mapstructure:"network"`
+		APIAddress string `mapstructure:"api_address"`
+		WebAddress string `mapstructure:"web_address"`
+	} `mapstructure:"pool"`
+
 	// Server configuration
 	Server struct {
 		Address string `mapstructure:"address"`
 		TLS     struct {
-			Enabled  bool   `mapstructure:"enabled"`
-			CertFile string `mapstructure:"cert_file"`
-			KeyFile  string `mapstructure:"key_file"`
+			Enabled       bool   `mapstructure:"enabled"`
+			CertFile      string `mapstructure:"cert_file"`
+			KeyFile       string `mapstructure:"key_file"`
+			ClientAuth    string `mapstructure:"client_auth"`
+			CADir         string `mapstructure:"ca_dir"`
+			ClientCertTTL string `mapstructure:"client_cert_ttl"`
+			// ReloadInterval is how often cert_file and key_file are checked for changes
+			ReloadInterval string `mapstructure:"reload_interval"`
+			Renewal        struct {
+				Enabled       bool   `mapstructure:"enabled"`
+				Domain        string `mapstructure:"domain"`
+				CertDir       string `mapstructure:"cert_dir"`
+				CertConfig    string `mapstructure:"cert_config"`
+				Threshold     string `mapstructure:"threshold"`
+				CheckInterval string `mapstructure:"check_interval"`
+			} `mapstructure:"renewal"`
 		} `mapstructure:"tls"`
 		MaxConnections int `mapstructure:"max_connections"`
 		Resources      struct {
@@ -28,6 +54,9 @@ type Config struct {
 			Datacenter  string `mapstructure:"datacenter"`
 			Coordinates string `mapstructure:"coordinates"`
 		} `mapstructure:"location"`
+		Auth struct {
+			Plugin string `mapstructure:"plugin"`
+		} `mapstructure:"auth"`
 	} `mapstructure:"server"`
 
 	// Main net configuration
@@ -53,10 +82,32 @@ type Config struct {
 		HealthCheckInterval     string   `mapstructure:"health_check_interval"`
 		MaxNodes                int      `mapstructure:"max_nodes"`
 		AutoDeregisterAfter     int      `mapstructure:"auto_deregister_after"`
-		Plugins                 []struct {
+		LeaseTTL                string   `mapstructure:"lease_ttl"`
+		SuspectAfter            string   `mapstructure:"suspect_after"`
+		Storage                 struct {
+			Plugin string                 `mapstructure:"plugin"`
+			Config map[string]interface{} `mapstructure:"config"`
+		} `mapstructure:"storage"`
+		Selection struct {
+			DefaultStrategy string `mapstructure:"default_strategy"`
+		} `mapstructure:"selection"`
+		Identity struct {
+			Mode         string `mapstructure:"mode"`
+			ChallengeTTL string `mapstructure:"challenge_ttl"`
+			SessionTTL   string `mapstructure:"session_ttl"`
+			ClockSkew    string `mapstructure:"clock_skew"`
+		} `mapstructure:"identity"`
+		Plugins []struct {
 			Name    string                 `mapstructure:"name"`
 			Enabled bool                   `mapstructure:"enabled"`
 			Config  map[string]interface{} `mapstructure:"config"`
+			// Plugins with a higher priority run first, ties keep config order
+			Priority int `mapstructure:"priority"`
+			// Timeout bounds each hook call, default 1s
+			Timeout string `mapstructure:"timeout"`
+			// FailurePolicy is closed (failures reject the operation, the
+			// default) or open (failures are logged and the plugin skipped)
+			FailurePolicy string `mapstructure:"failure_policy"`
 		} `mapstructure:"plugins"`
 	} `mapstructure:"registry"`
 
@@ -74,6 +125,16 @@ type Config struct {
 		Config  map[string]interface{} `mapstructure:"config"`
 	} `mapstructure:"plugins"`
 
+	// PluginTrust is the policy plugin files are verified against
+	PluginTrust struct {
+		// Signatures is required, optional or disabled
+		Signatures  string `mapstructure:"signatures"`
+		TrustedKeys []struct {
+			ID        string `mapstructure:"id"`
+			PublicKey string `mapstructure:"public_key"`
+		} `mapstructure:"trusted_keys"`
+	} `mapstructure:"plugin_trust"`
+
 	// Docker runtime settings
 	Docker struct {
 		RestartPolicy string   `mapstructure:"restart_policy"`
@@ -89,8 +150,11 @@ func LoadConfig(configPath string) (*Config, error) {
 	// Set defaults
 	setDefaults(v)
 
-	// If a config file is provided, read it
+	// If a config file is provided, check it against the schema and read it
 	if configPath != "" {
+		if err := ValidateFile(configPath); err != nil {
+			return nil, err
+		}
 		v.SetConfigFile(configPath)
 		if err := v.ReadInConfig(); err != nil {
 			return nil, fmt.Errorf("failed to read config file: %v", err)
@@ -113,9 +177,19 @@ func LoadConfig(configPath string) (*Config, error) {
 
 // setDefaults sets default values for the configuration
 func setDefaults(v *viper.Viper) {
+	v.SetDefault("version", Version)
+	v.SetDefault("pool.network", "mainnet")
+
 	// Server defaults
 	v.SetDefault("server.address", "0.0.0.0:50051")
 	v.SetDefault("server.tls.enabled", false)
+	v.SetDefault("server.tls.client_auth", "none")
+	v.SetDefault("server.tls.ca_dir", "./data/ca")
+	v.SetDefault("server.tls.client_cert_ttl", "24h")
+	v.SetDefault("server.tls.reload_interval", "1m")
+	v.SetDefault("server.tls.renewal.enabled", false)
+	v.SetDefault("server.tls.renewal.threshold", "720h")
+	v.SetDefault("server.tls.renewal.check_interval", "12h")
 	v.SetDefault("server.max_connections", 500)
 	v.SetDefault("server.resources.cpu_limit", "2")
 	v.SetDefault("server.resources.memory_limit", "2Gi")
@@ -125,11 +199,20 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("registry.health_check_interval", "30s")
 	v.SetDefault("registry.max_nodes", 100)
 	v.SetDefault("registry.auto_deregister_after", 3)
+	v.SetDefault("registry.storage.plugin", "file-storage")
+	v.SetDefault("registry.selection.default_strategy", "least-loaded")
+	v.SetDefault("registry.identity.mode", "required")
+	v.SetDefault("registry.identity.challenge_ttl", "60s")
+	v.SetDefault("registry.identity.session_ttl", "15m")
+	v.SetDefault("registry.identity.clock_skew", "30s")
 
 	// Logging defaults
 	v.SetDefault("logging.level", "info")
 	v.SetDefault("logging.format", "json")
 
+	// Plugin trust defaults
+	v.SetDefault("plugin_trust.signatures", "optional")
+
 	// Docker defaults
 	v.SetDefault("docker.restart_policy", "always")
 	v.SetDefault("docker.network_mode", "bridge")
@@ -153,5 +236,31 @@ func GetPluginConfigs(config *Config) map[string]map[string]interface{} {
 		}
 	}
 
+	// Add the storage plugin, configured in its own section
+	if name := config.Registry.Storage.Plugin; name != "" {
+		if _, exists := result[name]; !exists {
+			storageConfig := config.Registry.Storage.Config
+			if storageConfig == nil {
+				storageConfig = map[string]interface{}{}
+			}
+			result[name] = storageConfig
+		}
+	}
+
 	return result
 }
+
+// GetTrustedPluginKeys returns the public keys of plugin_trust.trusted_keys by ID
+func GetTrustedPluginKeys(config *Config) (map[string]string, error) {
+	keys := make(map[string]string, len(config.PluginTrust.TrustedKeys))
+	for i, key := range config.PluginTrust.TrustedKeys {
+		if key.ID == "" {
+			return nil, fmt.Errorf("plugin_trust.trusted_keys[%d] has no id", i)
+		}
+		if _, exists := keys[key.ID]; exists {
+			return nil, fmt.Errorf("plugin_trust.trusted_keys lists %s twice", key.ID)
+		}
+		keys[key.ID] = key.PublicKey
+	}
+	return keys, nil
+}
@@ -0,0 +1,121 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// Default returns the configuration used when no file or environment sets a value
+func Default() *Config {
+	v := viper.New()
+	setDefaults(v)
+
+	var config Config
+	if err := v.Unmarshal(&config); err != nil {
+		// The defaults are fixed, failing to decode them is a programming error
+		panic(fmt.Sprintf("invalid configuration defaults: %v", err))
+	}
+	return &config
+}
+
+// Marshal encodes a configuration as YAML in the current schema version.
+// Only settings that differ from the defaults are written, so the file reads
+// back into the same configuration.
+func Marshal(config *Config) ([]byte, error) {
+	root, err := encodeDiff(reflect.ValueOf(*config), reflect.ValueOf(*Default()))
+	if err != nil {
+		return nil, err
+	}
+	if root == nil {
+		root = &yaml.Node{Kind: yaml.MappingNode}
+	}
+
+	// The version always leads the file
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == "version" {
+			root.Content = append(root.Content[:i], root.Content[i+2:]...)
+			break
+		}
+	}
+	root.Content = append([]*yaml.Node{
+		{Kind: yaml.ScalarNode, Value: "version"},
+		{Kind: yaml.ScalarNode, Tag: "!!int", Value: fmt.Sprint(Version)},
+	}, root.Content...)
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(root); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// WriteFile writes a configuration file, starting with the lines of header as comments
+func WriteFile(path string, config *Config, header string) error {
+	data, err := Marshal(config)
+	if err != nil {
+		return fmt.Errorf("failed to encode config: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if header != "" {
+		for _, line := range strings.Split(strings.TrimRight(header, "\n"), "\n") {
+			buf.WriteString(strings.TrimRight("# "+line, " ") + "\n")
+		}
+		buf.WriteString("\n")
+	}
+	buf.Write(data)
+
+	// Catch schema drift before anything reads the file
+	if err := Validate(path, buf.Bytes()); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create config directory: %v", err)
+	}
+	return os.WriteFile(path, buf.Bytes(), 0644)
+}
+
+// encodeDiff encodes the parts of a value that differ from a default value,
+// returning nil when they are equal
+func encodeDiff(value, def reflect.Value) (*yaml.Node, error) {
+	if value.Kind() != reflect.Struct {
+		if reflect.DeepEqual(value.Interface(), def.Interface()) {
+			return nil, nil
+		}
+		node := &yaml.Node{}
+		if err := node.Encode(value.Interface()); err != nil {
+			return nil, err
+		}
+		return node, nil
+	}
+
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	for i := 0; i < value.NumField(); i++ {
+		child, err := encodeDiff(value.Field(i), def.Field(i))
+		if err != nil {
+			return nil, err
+		}
+		if child == nil {
+			continue
+		}
+		key := &yaml.Node{Kind: yaml.ScalarNode, Value: fieldKey(value.Type().Field(i))}
+		node.Content = append(node.Content, key, child)
+	}
+	if len(node.Content) == 0 {
+		return nil, nil
+	}
+	return node, nil
+}
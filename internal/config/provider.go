@@ -37,6 +37,9 @@ func (p *Provider) Load() error {
 	defer p.mu.Unlock()
 
 	// Set up viper
+	if err := ValidateFile(p.configPath); err != nil {
+		return err
+	}
 	p.viper.SetConfigFile(p.configPath)
 	
 	// Read the main config file
@@ -51,6 +54,9 @@ func (p *Provider) Load() error {
 		envConfigPath := fmt.Sprintf("%s.%s%s", baseConfigPath, p.env, ext)
 		
 		if _, err := os.Stat(envConfigPath); err == nil {
+			if err := ValidateFile(envConfigPath); err != nil {
+				return err
+			}
 			p.viper.SetConfigFile(envConfigPath)
 			if err := p.viper.MergeInConfig(); err != nil {
 				return fmt.Errorf("failed to merge environment config: %v", err)
@@ -0,0 +1,235 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Version is the current schema version of configuration files. Files
+// without a version are read as the current version.
+const Version = 1
+
+// ValidateFile checks a configuration file against the Config schema,
+// reporting unknown keys and mistyped values with their file and line.
+// Only YAML and JSON files are checked, other formats viper reads are
+// loaded as is.
+func ValidateFile(path string) error {
+	if !IsYAMLFile(path) {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+	return Validate(path, data)
+}
+
+// IsYAMLFile reports whether a configuration file can be read as YAML by its
+// extension, the same way viper picks the format. JSON is a subset of YAML.
+func IsYAMLFile(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml", ".json":
+		return true
+	default:
+		return false
+	}
+}
+
+// Validate checks YAML configuration data against the Config schema. The
+// name is used as the file name in error messages.
+func Validate(name string, data []byte) error {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	if len(doc.Content) == 0 {
+		return nil
+	}
+
+	v := &validator{name: name}
+	root := doc.Content[0]
+	v.checkVersion(root)
+	v.check(root, reflect.TypeOf(Config{}), "")
+
+	if len(v.errors) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid configuration:\n  %s", strings.Join(v.errors, "\n  "))
+}
+
+// validator collects schema errors of a configuration file
+type validator struct {
+	name   string
+	errors []string
+}
+
+// errorf records an error at the position of a node
+func (v *validator) errorf(node *yaml.Node, format string, args ...interface{}) {
+	v.errors = append(v.errors, fmt.Sprintf("%s:%d:%d: %s", v.name, node.Line, node.Column, fmt.Sprintf(format, args...)))
+}
+
+// checkVersion rejects versions this build does not understand
+func (v *validator) checkVersion(root *yaml.Node) {
+	if root.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if !strings.EqualFold(root.Content[i].Value, "version") {
+			continue
+		}
+		value := root.Content[i+1]
+		var version int
+		if value.Decode(&version) != nil {
+			// Reported as a type error
+			return
+		}
+		if version < 1 || version > Version {
+			v.errorf(value, "unsupported config version %d, this build reads version %d", version, Version)
+		}
+	}
+}
+
+// check validates a node against the type it is decoded into
+func (v *validator) check(node *yaml.Node, t reflect.Type, path string) {
+	for node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if node.Tag == "!!null" {
+		return
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			v.errorf(node, "%s must be a mapping", describe(path))
+			return
+		}
+		fields := structFields(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if key.Value == "<<" {
+				v.check(value, t, path)
+				continue
+			}
+			field, ok := fields[strings.ToLower(key.Value)]
+			if !ok {
+				v.errorf(key, "unknown key %q%s", join(path, key.Value), suggest(key.Value, fields))
+				continue
+			}
+			v.check(value, field.Type, join(path, key.Value))
+		}
+
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			v.errorf(node, "%s must be a mapping", describe(path))
+			return
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			v.check(node.Content[i+1], t.Elem(), join(path, node.Content[i].Value))
+		}
+
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			v.errorf(node, "%s must be a list", describe(path))
+			return
+		}
+		for i, item := range node.Content {
+			v.check(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i))
+		}
+
+	case reflect.Bool:
+		// Quoted values are converted the way viper decodes them
+		if _, err := strconv.ParseBool(node.Value); node.Kind != yaml.ScalarNode || (node.Tag != "!!bool" && err != nil) {
+			v.errorf(node, "%s must be true or false", describe(path))
+		}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if _, err := strconv.Atoi(node.Value); node.Kind != yaml.ScalarNode || (node.Tag != "!!int" && err != nil) {
+			v.errorf(node, "%s must be an integer", describe(path))
+		}
+
+	case reflect.String:
+		if node.Kind != yaml.ScalarNode {
+			v.errorf(node, "%s must be a single value", describe(path))
+		}
+	}
+}
+
+// structFields maps the lower-cased mapstructure keys of a struct to its fields
+func structFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		fields[strings.ToLower(fieldKey(field))] = field
+	}
+	return fields
+}
+
+// fieldKey returns the configuration key of a struct field
+func fieldKey(field reflect.StructField) string {
+	key := strings.Split(field.Tag.Get("mapstructure"), ",")[0]
+	if key == "" {
+		key = field.Name
+	}
+	return key
+}
+
+// suggest proposes a known key close to an unknown one
+func suggest(key string, fields map[string]reflect.StructField) string {
+	best, bestDistance := "", 3
+	for known := range fields {
+		if d := editDistance(strings.ToLower(key), known); d < bestDistance || (d == bestDistance && known < best) {
+			best, bestDistance = known, d
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return fmt.Sprintf(", did you mean %q?", best)
+}
+
+// editDistance returns the Levenshtein distance between two strings
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// join appends a key to a dotted key path
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// describe names a key path in error messages
+func describe(path string) string {
+	if path == "" {
+		return "the configuration"
+	}
+	return fmt.Sprintf("%q", path)
+}
@@ -0,0 +1,202 @@
+// Galaxy Node Pool - Nginx Configuration Model
+// AI-ID: CP-GAL-NODEPOOL-001
+package nginx
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Config is the part of an Nginx configuration managed by galaxy-pool
+type Config struct {
+	Upstreams []*Upstream
+	Servers   []*Server
+}
+
+// Upstream is a named group of backend servers
+type Upstream struct {
+	Name      string
+	Servers   []string
+	Keepalive int
+}
+
+// Server is a server block
+type Server struct {
+	Listen            []string
+	ServerNames       []string
+	SSLCertificate    string
+	SSLCertificateKey string
+
+	// Directives are emitted after the listen, name and certificate directives
+	Directives []*Directive
+	Locations  []*Location
+}
+
+// Location is a location block. At most one of ProxyPass, GRPCPass, Root and
+// Return should be set.
+type Location struct {
+	Match string
+
+	// ProxyPass proxies HTTP requests, forwarding the client address and scheme
+	ProxyPass string
+
+	// WebSocket upgrades proxied connections to WebSocket
+	WebSocket bool
+
+	// GRPCPass proxies gRPC requests, grpc:// or grpcs:// followed by an
+	// address or upstream name
+	GRPCPass string
+
+	Root   string
+	Return string
+
+	// Directives are emitted after the generated directives
+	Directives []*Directive
+}
+
+// Directive is a single Nginx directive, with Block set for block directives
+type Directive struct {
+	Name  string
+	Args  []string
+	Block []*Directive
+}
+
+// NewDirective creates a simple directive
+func NewDirective(name string, args ...string) *Directive {
+	return &Directive{Name: name, Args: args}
+}
+
+// IsBlock reports whether the directive has a block
+func (d *Directive) IsBlock() bool {
+	return d.Block != nil
+}
+
+// Directives converts the configuration to directives
+func (c *Config) Directives() []*Directive {
+	var directives []*Directive
+	for _, upstream := range c.Upstreams {
+		directives = append(directives, upstream.Directive())
+	}
+	for _, server := range c.Servers {
+		directives = append(directives, server.Directive())
+	}
+	return directives
+}
+
+// Validate checks that the configuration can be rendered into a valid Nginx configuration
+func (c *Config) Validate() error {
+	upstreams := make(map[string]bool)
+	for _, upstream := range c.Upstreams {
+		if !isName(upstream.Name) {
+			return fmt.Errorf("invalid upstream name %q", upstream.Name)
+		}
+		if upstreams[upstream.Name] {
+			return fmt.Errorf("duplicate upstream %q", upstream.Name)
+		}
+		if len(upstream.Servers) == 0 {
+			return fmt.Errorf("upstream %q has no servers", upstream.Name)
+		}
+		upstreams[upstream.Name] = true
+	}
+
+	for _, server := range c.Servers {
+		if len(server.Listen) == 0 {
+			return fmt.Errorf("server %s has no listen address", strings.Join(server.ServerNames, " "))
+		}
+		if (server.SSLCertificate == "") != (server.SSLCertificateKey == "") {
+			return fmt.Errorf("server %s needs both a certificate and a key", strings.Join(server.ServerNames, " "))
+		}
+		for _, location := range server.Locations {
+			targets := 0
+			for _, target := range []string{location.ProxyPass, location.GRPCPass, location.Root, location.Return} {
+				if target != "" {
+					targets++
+				}
+			}
+			if targets > 1 {
+				return fmt.Errorf("location %s has more than one of proxy_pass, grpc_pass, root and return", location.Match)
+			}
+			if location.GRPCPass != "" && !strings.HasPrefix(location.GRPCPass, "grpc://") && !strings.HasPrefix(location.GRPCPass, "grpcs://") {
+				return fmt.Errorf("location %s: grpc_pass must start with grpc:// or grpcs://", location.Match)
+			}
+		}
+	}
+	return nil
+}
+
+// Directive converts the upstream to an upstream block
+func (u *Upstream) Directive() *Directive {
+	block := []*Directive{}
+	for _, server := range u.Servers {
+		block = append(block, NewDirective("server", server))
+	}
+	if u.Keepalive > 0 {
+		block = append(block, NewDirective("keepalive", fmt.Sprint(u.Keepalive)))
+	}
+	return &Directive{Name: "upstream", Args: []string{u.Name}, Block: block}
+}
+
+// Directive converts the server to a server block
+func (s *Server) Directive() *Directive {
+	block := []*Directive{}
+	for _, listen := range s.Listen {
+		block = append(block, NewDirective("listen", strings.Fields(listen)...))
+	}
+	if len(s.ServerNames) > 0 {
+		block = append(block, NewDirective("server_name", s.ServerNames...))
+	}
+	if s.SSLCertificate != "" {
+		block = append(block,
+			NewDirective("ssl_certificate", s.SSLCertificate),
+			NewDirective("ssl_certificate_key", s.SSLCertificateKey))
+	}
+	block = append(block, s.Directives...)
+	for _, location := range s.Locations {
+		block = append(block, location.Directive())
+	}
+	return &Directive{Name: "server", Block: block}
+}
+
+// Directive converts the location to a location block
+func (l *Location) Directive() *Directive {
+	block := []*Directive{}
+	switch {
+	case l.ProxyPass != "":
+		block = append(block, NewDirective("proxy_pass", l.ProxyPass))
+		if l.WebSocket {
+			block = append(block,
+				NewDirective("proxy_http_version", "1.1"),
+				NewDirective("proxy_set_header", "Upgrade", "$http_upgrade"),
+				NewDirective("proxy_set_header", "Connection", "upgrade"))
+		}
+		block = append(block,
+			NewDirective("proxy_set_header", "Host", "$host"),
+			NewDirective("proxy_set_header", "X-Real-IP", "$remote_addr"),
+			NewDirective("proxy_set_header", "X-Forwarded-For", "$proxy_add_x_forwarded_for"),
+			NewDirective("proxy_set_header", "X-Forwarded-Proto", "$scheme"))
+	case l.GRPCPass != "":
+		block = append(block,
+			NewDirective("grpc_pass", l.GRPCPass),
+			NewDirective("grpc_set_header", "X-Real-IP", "$remote_addr"),
+			NewDirective("grpc_set_header", "X-Forwarded-For", "$proxy_add_x_forwarded_for"))
+	case l.Root != "":
+		block = append(block, NewDirective("root", l.Root))
+	case l.Return != "":
+		block = append(block, NewDirective("return", strings.Fields(l.Return)...))
+	}
+	block = append(block, l.Directives...)
+	return &Directive{Name: "location", Args: strings.Fields(l.Match), Block: block}
+}
+
+// isName reports whether s can be used as an upstream name
+func isName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if !(r == '_' || r == '-' || r == '.' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,141 @@
+// Galaxy Node Pool - Configuration Diffs
+// AI-ID: CP-GAL-NODEPOOL-001
+package nginx
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around changes
+const diffContext = 3
+
+// diffLine is a line of an edit script
+type diffLine struct {
+	op   byte
+	text string
+}
+
+// Diff returns a unified diff turning one text into another, or an empty
+// string when they are equal
+func Diff(fromName, toName, from, to string) string {
+	if from == to {
+		return ""
+	}
+	a, b := splitLines(from), splitLines(to)
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var script []diffLine
+	for i, j := 0, 0; i < len(a) || j < len(b); {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			script = append(script, diffLine{' ', a[i]})
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			script = append(script, diffLine{'-', a[i]})
+			i++
+		default:
+			script = append(script, diffLine{'+', b[j]})
+			j++
+		}
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+
+	// aLine and bLine are the line numbers before script[start]
+	aLine, bLine := 0, 0
+	for start := 0; start < len(script); {
+		// Find the next change and the unchanged lines before it
+		change := start
+		for change < len(script) && script[change].op == ' ' {
+			change++
+		}
+		if change == len(script) {
+			break
+		}
+		hunkStart := change - diffContext
+		if hunkStart < start {
+			hunkStart = start
+		}
+		for _, line := range script[start:hunkStart] {
+			if line.op != '+' {
+				aLine++
+			}
+			if line.op != '-' {
+				bLine++
+			}
+		}
+
+		// Extend the hunk while changes are close enough to share context
+		hunkEnd, unchanged := change, 0
+		for hunkEnd < len(script) && unchanged <= 2*diffContext {
+			if script[hunkEnd].op == ' ' {
+				unchanged++
+			} else {
+				unchanged = 0
+			}
+			hunkEnd++
+		}
+		if unchanged > diffContext {
+			hunkEnd -= unchanged - diffContext
+		}
+
+		aCount, bCount := 0, 0
+		for _, line := range script[hunkStart:hunkEnd] {
+			if line.op != '+' {
+				aCount++
+			}
+			if line.op != '-' {
+				bCount++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(aLine, aCount), hunkRange(bLine, bCount))
+		for _, line := range script[hunkStart:hunkEnd] {
+			out.WriteByte(line.op)
+			out.WriteString(line.text)
+			out.WriteByte('\n')
+		}
+
+		aLine += aCount
+		bLine += bCount
+		start = hunkEnd
+	}
+	return out.String()
+}
+
+// hunkRange formats the range of a hunk, counting lines from one
+func hunkRange(line, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", line)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", line+1)
+	}
+	return fmt.Sprintf("%d,%d", line+1, count)
+}
+
+// splitLines splits text into lines without their line endings
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
@@ -0,0 +1,238 @@
+// Galaxy Node Pool - Managed Nginx Configuration Files
+// AI-ID: CP-GAL-NODEPOOL-001
+package nginx
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// beginMarker starts the managed section of a configuration file
+	beginMarker = "# BEGIN galaxy-pool managed section"
+
+	// endMarker ends the managed section of a configuration file
+	endMarker = "# END galaxy-pool managed section"
+
+	// markerNote follows the begin marker to warn operators
+	markerNote = "# Changes between these markers are overwritten, edit outside them."
+)
+
+// ErrNoManagedServer is returned by UpdateCertificate when a file has no
+// managed section with a TLS server for the domain. Such files are left for
+// the operator to update.
+var ErrNoManagedServer = errors.New("no managed TLS server")
+
+// errNotManaged is returned by update for files without a managed section
+var errNotManaged = errors.New("no galaxy-pool managed section")
+
+// WriteOptions controls how a configuration file is updated
+type WriteOptions struct {
+	// DryRun computes the change without writing the file
+	DryRun bool
+
+	// Force replaces a file that has no managed section, keeping a .bak copy
+	Force bool
+}
+
+// Result describes the change to a configuration file
+type Result struct {
+	Path    string
+	Changed bool
+	Diff    string
+}
+
+// WriteConfig renders a configuration into the managed section of a file.
+// Text outside the section is left as it is, and a file without a managed
+// section is only replaced when forced.
+func WriteConfig(path string, config *Config, opts WriteOptions) (*Result, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return update(path, opts, func([]*Directive) ([]*Directive, error) {
+		return config.Directives(), nil
+	})
+}
+
+// UpdateCertificate points the TLS servers of the managed section whose
+// server_name is covered by a domain at a new certificate and key. Files
+// without a managed section are never replaced, and ErrNoManagedServer is
+// returned when there is no such server.
+func UpdateCertificate(path, domain, certPath, keyPath string, opts WriteOptions) (*Result, error) {
+	opts.Force = false
+	result, err := update(path, opts, func(directives []*Directive) ([]*Directive, error) {
+		updated := 0
+		for _, d := range directives {
+			if d.Name != "server" || !serverMatches(d, domain) {
+				continue
+			}
+			for _, child := range d.Block {
+				switch child.Name {
+				case "ssl_certificate":
+					child.Args = []string{certPath}
+					updated++
+				case "ssl_certificate_key":
+					child.Args = []string{keyPath}
+				}
+			}
+		}
+		if updated == 0 {
+			return nil, fmt.Errorf("%w for %s in %s", ErrNoManagedServer, domain, path)
+		}
+		return directives, nil
+	})
+	if errors.Is(err, errNotManaged) {
+		return nil, fmt.Errorf("%w for %s, %s has no galaxy-pool managed section", ErrNoManagedServer, domain, path)
+	}
+	return result, err
+}
+
+// update applies a change to the directives of the managed section of a file
+func update(path string, opts WriteOptions, change func([]*Directive) ([]*Directive, error)) (*Result, error) {
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	old := string(data)
+
+	before, managed, after, found, err := splitManaged(old)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	replace := !found && strings.TrimSpace(old) != ""
+	if replace {
+		if !opts.Force {
+			return nil, fmt.Errorf("%s has %w, it is only replaced when forced", path, errNotManaged)
+		}
+		before, after = "", ""
+	}
+
+	directives, err := Parse(managed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse managed section of %s: %v", path, err)
+	}
+	if directives, err = change(directives); err != nil {
+		return nil, err
+	}
+
+	if before != "" && !strings.HasSuffix(before, "\n") {
+		before += "\n"
+	}
+	text := before + beginMarker + "\n" + markerNote + "\n\n" + Render(directives) + endMarker + "\n" + after
+
+	result := &Result{
+		Path:    path,
+		Changed: text != old,
+		Diff:    Diff("a/"+filepath.Base(path), "b/"+filepath.Base(path), old, text),
+	}
+	if !result.Changed || opts.DryRun {
+		return result, nil
+	}
+
+	if replace {
+		if err := writeFileAtomic(path+".bak", data); err != nil {
+			return nil, fmt.Errorf("failed to back up %s: %v", path, err)
+		}
+	}
+	if err := writeFileAtomic(path, []byte(text)); err != nil {
+		return nil, fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	return result, nil
+}
+
+// splitManaged splits file text around its managed section
+func splitManaged(text string) (before, managed, after string, found bool, err error) {
+	begin, end := -1, -1
+	offset := 0
+	for _, line := range strings.SplitAfter(text, "\n") {
+		switch strings.TrimSpace(line) {
+		case beginMarker:
+			if begin >= 0 {
+				return "", "", "", false, fmt.Errorf("more than one galaxy-pool managed section")
+			}
+			begin = offset
+		case endMarker:
+			if begin < 0 || end >= 0 {
+				return "", "", "", false, fmt.Errorf("unexpected end of galaxy-pool managed section")
+			}
+			end = offset
+		}
+		offset += len(line)
+	}
+
+	if begin < 0 {
+		return text, "", "", false, nil
+	}
+	if end < 0 {
+		return "", "", "", false, fmt.Errorf("galaxy-pool managed section is not closed")
+	}
+
+	managedStart := begin + strings.Index(text[begin:], "\n") + 1
+	afterStart := len(text)
+	if i := strings.Index(text[end:], "\n"); i >= 0 {
+		afterStart = end + i + 1
+	}
+	return text[:begin], text[managedStart:end], text[afterStart:], true, nil
+}
+
+// serverMatches reports whether a server block serves a domain
+func serverMatches(server *Directive, domain string) bool {
+	for _, child := range server.Block {
+		if child.Name != "server_name" {
+			continue
+		}
+		for _, name := range child.Args {
+			if nameCovered(name, domain) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// nameCovered reports whether a certificate for domain covers a server name
+func nameCovered(name, domain string) bool {
+	if name == domain {
+		return true
+	}
+	if !strings.HasPrefix(domain, "*.") {
+		return false
+	}
+	base := strings.TrimPrefix(domain, "*.")
+	label := strings.TrimSuffix(name, "."+base)
+	return name == base || (label != name && label != "" && !strings.Contains(label, "."))
+}
+
+// writeFileAtomic replaces a file through a rename, keeping its permissions
+func writeFileAtomic(path string, data []byte) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
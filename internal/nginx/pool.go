@@ -0,0 +1,182 @@
+// Galaxy Node Pool - Nginx Pool Layout
+// AI-ID: CP-GAL-NODEPOOL-001
+package nginx
+
+import (
+	"strings"
+)
+
+const (
+	// SnakeoilCertificate is the placeholder certificate used until one is issued
+	SnakeoilCertificate = "/etc/ssl/certs/ssl-cert-snakeoil.pem"
+
+	// SnakeoilCertificateKey is the key of the placeholder certificate
+	SnakeoilCertificateKey = "/etc/ssl/private/ssl-cert-snakeoil.key"
+
+	// DefaultWebroot is where HTTP-01 challenge files are served from
+	DefaultWebroot = "/var/www/html"
+)
+
+// PoolOptions describes how a pool is exposed through Nginx. Backend addresses
+// are host:port, backends left empty get no location.
+type PoolOptions struct {
+	ServerName        string
+	SSLCertificate    string
+	SSLCertificateKey string
+	Webroot           string
+	IPv6              bool
+	HSTS              bool
+
+	APIAddr       string
+	WebSocketAddr string
+	WebAddr       string
+	GRPCAddr      string
+
+	// GRPCTLS proxies gRPC over TLS, for pool servers with TLS enabled
+	GRPCTLS bool
+
+	// GRPCServices are the fully qualified gRPC services proxied to GRPCAddr
+	GRPCServices []string
+}
+
+// DefaultGRPCServices are the gRPC services of the pool server
+var DefaultGRPCServices = []string{"pool.Registry", "pool.NodeCA"}
+
+// NewPoolConfig creates the configuration of a pool: a plain HTTP server that
+// redirects to HTTPS and answers ACME challenges, and an HTTPS server that
+// proxies the API, WebSocket, gRPC and web backends through upstreams
+func NewPoolConfig(opts PoolOptions) *Config {
+	if opts.SSLCertificate == "" {
+		opts.SSLCertificate = SnakeoilCertificate
+		opts.SSLCertificateKey = SnakeoilCertificateKey
+	}
+	if opts.Webroot == "" {
+		opts.Webroot = DefaultWebroot
+	}
+	if len(opts.GRPCServices) == 0 {
+		opts.GRPCServices = DefaultGRPCServices
+	}
+
+	config := &Config{}
+	prefix := "galaxy_" + upstreamName(opts.ServerName)
+	upstream := func(kind, addr string, keepalive int) string {
+		name := prefix + "_" + kind
+		config.Upstreams = append(config.Upstreams, &Upstream{
+			Name:      name,
+			Servers:   []string{addr},
+			Keepalive: keepalive,
+		})
+		return name
+	}
+
+	http := &Server{
+		Listen:      []string{"80"},
+		ServerNames: []string{opts.ServerName},
+		Locations: []*Location{
+			{Match: "/.well-known/acme-challenge/", Root: opts.Webroot},
+			{Match: "/", Return: "301 https://$host$request_uri"},
+		},
+	}
+
+	https := &Server{
+		Listen:            []string{"443 ssl http2"},
+		ServerNames:       []string{opts.ServerName},
+		SSLCertificate:    opts.SSLCertificate,
+		SSLCertificateKey: opts.SSLCertificateKey,
+		Directives: []*Directive{
+			NewDirective("ssl_protocols", "TLSv1.2", "TLSv1.3"),
+			NewDirective("ssl_prefer_server_ciphers", "off"),
+			NewDirective("ssl_session_timeout", "1d"),
+			NewDirective("ssl_session_cache", "shared:SSL:10m"),
+			NewDirective("ssl_session_tickets", "off"),
+		},
+	}
+	if opts.IPv6 {
+		http.Listen = append(http.Listen, "[::]:80")
+		https.Listen = append(https.Listen, "[::]:443 ssl http2")
+	}
+	if opts.HSTS {
+		https.Directives = append(https.Directives, NewDirective("add_header", "Strict-Transport-Security", "max-age=63072000", "always"))
+	}
+	https.Directives = append(https.Directives,
+		NewDirective("add_header", "X-Frame-Options", "SAMEORIGIN", "always"),
+		NewDirective("add_header", "X-Content-Type-Options", "nosniff", "always"),
+		NewDirective("add_header", "Referrer-Policy", "strict-origin-when-cross-origin", "always"),
+		NewDirective("access_log", "/var/log/nginx/"+logName(opts.ServerName)+".access.log"),
+		NewDirective("error_log", "/var/log/nginx/"+logName(opts.ServerName)+".error.log"))
+
+	timeouts := []*Directive{
+		NewDirective("proxy_connect_timeout", "60s"),
+		NewDirective("proxy_send_timeout", "60s"),
+		NewDirective("proxy_read_timeout", "60s"),
+	}
+
+	if opts.GRPCAddr != "" {
+		scheme := "grpc://"
+		if opts.GRPCTLS {
+			scheme = "grpcs://"
+		}
+		target := scheme + upstream("grpc", opts.GRPCAddr, 16)
+		for _, service := range opts.GRPCServices {
+			https.Locations = append(https.Locations, &Location{
+				Match:    "/" + service + "/",
+				GRPCPass: target,
+				Directives: []*Directive{
+					// Watch streams stay open between events
+					NewDirective("grpc_read_timeout", "1h"),
+					NewDirective("grpc_send_timeout", "1h"),
+				},
+			})
+		}
+	}
+	if opts.APIAddr != "" {
+		https.Locations = append(https.Locations, &Location{
+			Match:      "/api/",
+			ProxyPass:  "http://" + upstream("api", opts.APIAddr, 0) + "/",
+			Directives: timeouts,
+		})
+	}
+	if opts.WebSocketAddr != "" {
+		https.Locations = append(https.Locations, &Location{
+			Match:     "/ws/",
+			ProxyPass: "http://" + upstream("ws", opts.WebSocketAddr, 0) + "/",
+			WebSocket: true,
+			Directives: []*Directive{
+				NewDirective("proxy_read_timeout", "1h"),
+			},
+		})
+	}
+	if opts.WebAddr != "" {
+		https.Locations = append(https.Locations, &Location{
+			Match:      "/",
+			ProxyPass:  "http://" + upstream("web", opts.WebAddr, 0),
+			Directives: timeouts,
+		})
+	}
+
+	config.Servers = []*Server{http, https}
+	return config
+}
+
+// upstreamName turns a server name into a string usable in upstream names
+func upstreamName(serverName string) string {
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, strings.TrimPrefix(serverName, "*."))
+	if name == "" {
+		return "default"
+	}
+	return name
+}
+
+// logName turns a server name into a log file name
+func logName(serverName string) string {
+	name := strings.TrimPrefix(serverName, "*.")
+	if name == "" || name == "_" {
+		return "galaxy-pool"
+	}
+	return name
+}
@@ -0,0 +1,193 @@
+// Galaxy Node Pool - Nginx Rendering and Parsing
+// AI-ID: CP-GAL-NODEPOOL-001
+package nginx
+
+import (
+	"fmt"
+	"strings"
+)
+
+// indent is the indentation of each block level
+const indent = "    "
+
+// Render renders directives as Nginx configuration text. The output only
+// depends on the directives, so rendering the same configuration twice gives
+// the same text.
+func Render(directives []*Directive) string {
+	var b strings.Builder
+	renderBlock(&b, directives, 0)
+	return b.String()
+}
+
+// renderBlock renders directives at a nesting depth, separating blocks from
+// their neighbours with a blank line
+func renderBlock(b *strings.Builder, directives []*Directive, depth int) {
+	prefix := strings.Repeat(indent, depth)
+	for i, d := range directives {
+		if i > 0 && (d.IsBlock() || directives[i-1].IsBlock()) {
+			b.WriteString("\n")
+		}
+
+		b.WriteString(prefix)
+		b.WriteString(d.Name)
+		for _, arg := range d.Args {
+			b.WriteString(" ")
+			b.WriteString(quote(arg))
+		}
+
+		if !d.IsBlock() {
+			b.WriteString(";\n")
+			continue
+		}
+		b.WriteString(" {\n")
+		renderBlock(b, d.Block, depth+1)
+		b.WriteString(prefix)
+		b.WriteString("}\n")
+	}
+}
+
+// quote quotes an argument when Nginx would not read it back as one word
+func quote(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\r\n;{}\"'#") {
+		return arg
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	return `"` + r.Replace(arg) + `"`
+}
+
+// Parse parses Nginx configuration text into directives. Comments are dropped.
+func Parse(text string) ([]*Directive, error) {
+	p := &parser{text: text, line: 1}
+	directives, err := p.parseBlock(false)
+	if err != nil {
+		return nil, err
+	}
+	return directives, nil
+}
+
+// parser reads directives from configuration text
+type parser struct {
+	text string
+	pos  int
+	line int
+}
+
+// token kinds returned by the parser
+const (
+	tokenEOF = iota
+	tokenWord
+	tokenSemicolon
+	tokenOpen
+	tokenClose
+)
+
+// parseBlock parses directives until the end of the text or, in a block, the closing brace
+func (p *parser) parseBlock(inBlock bool) ([]*Directive, error) {
+	directives := []*Directive{}
+	for {
+		kind, word, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+
+		switch kind {
+		case tokenEOF:
+			if inBlock {
+				return nil, fmt.Errorf("line %d: unexpected end of file, expecting \"}\"", p.line)
+			}
+			return directives, nil
+		case tokenClose:
+			if !inBlock {
+				return nil, fmt.Errorf("line %d: unexpected \"}\"", p.line)
+			}
+			return directives, nil
+		case tokenSemicolon, tokenOpen:
+			return nil, fmt.Errorf("line %d: expected directive name", p.line)
+		}
+
+		d := &Directive{Name: word}
+		for done := false; !done; {
+			kind, word, err := p.next()
+			if err != nil {
+				return nil, err
+			}
+			switch kind {
+			case tokenWord:
+				d.Args = append(d.Args, word)
+			case tokenSemicolon:
+				done = true
+			case tokenOpen:
+				if d.Block, err = p.parseBlock(true); err != nil {
+					return nil, err
+				}
+				done = true
+			default:
+				return nil, fmt.Errorf("line %d: directive %q is not terminated by \";\"", p.line, d.Name)
+			}
+		}
+		directives = append(directives, d)
+	}
+}
+
+// next returns the next token
+func (p *parser) next() (int, string, error) {
+	for p.pos < len(p.text) {
+		c := p.text[p.pos]
+		switch {
+		case c == '\n':
+			p.line++
+			p.pos++
+		case c == ' ' || c == '\t' || c == '\r':
+			p.pos++
+		case c == '#':
+			for p.pos < len(p.text) && p.text[p.pos] != '\n' {
+				p.pos++
+			}
+		default:
+			return p.word()
+		}
+	}
+	return tokenEOF, "", nil
+}
+
+// word reads a punctuation token, a quoted word or a bare word
+func (p *parser) word() (int, string, error) {
+	switch c := p.text[p.pos]; c {
+	case ';':
+		p.pos++
+		return tokenSemicolon, "", nil
+	case '{':
+		p.pos++
+		return tokenOpen, "", nil
+	case '}':
+		p.pos++
+		return tokenClose, "", nil
+	case '"', '\'':
+		start := p.line
+		p.pos++
+		var b strings.Builder
+		for p.pos < len(p.text) {
+			ch := p.text[p.pos]
+			p.pos++
+			switch {
+			case ch == c:
+				return tokenWord, b.String(), nil
+			case ch == '\\' && p.pos < len(p.text):
+				b.WriteByte(p.text[p.pos])
+				p.pos++
+			default:
+				if ch == '\n' {
+					p.line++
+				}
+				b.WriteByte(ch)
+			}
+		}
+		return 0, "", fmt.Errorf("line %d: unterminated quoted string", start)
+	}
+
+	start := p.pos
+	for p.pos < len(p.text) && !strings.ContainsRune(" \t\r\n;{}", rune(p.text[p.pos])) {
+		p.pos++
+	}
+	return tokenWord, p.text[start:p.pos], nil
+}
@@ -2,6 +2,7 @@ package plugin
 
 import (
 	"context"
+	"errors"
 	"net/http"
 )
 
@@ -31,6 +32,20 @@ type AuthPlugin interface {
 	Middleware() func(http.Handler) http.Handler
 }
 
+// OrgAuthenticator is implemented by auth plugins that report the caller's
+// organization when authenticating, so they need not remember it per user
+type OrgAuthenticator interface {
+	// AuthenticateOrg verifies credentials and returns a user ID and the
+	// organization it is bound to, or an empty string
+	AuthenticateOrg(credentials map[string]string) (string, string, error)
+}
+
+// OrgResolver is implemented by auth plugins that bind callers to an organization
+type OrgResolver interface {
+	// UserOrg returns the organization a user is bound to, or an empty string
+	UserOrg(userID string) string
+}
+
 // MetricsPlugin provides metrics collection and reporting
 type MetricsPlugin interface {
 	Plugin
@@ -62,21 +77,74 @@ type StoragePlugin interface {
 	List(prefix string) ([]string, error)
 }
 
-// RegistryPlugin hooks into the node registry operations
+// RegistryPlugin hooks into the node registry operations. The registry calls
+// the hooks of its plugins in priority order, each with a context carrying
+// the plugin's timeout. An error returned by a hook rejects the operation;
+// failures such as timeouts are handled by the plugin's failure policy.
 type RegistryPlugin interface {
 	Plugin
 	
-	// OnNodeRegister is called when a node registers
-	OnNodeRegister(nodeID string, metadata map[string]interface{}) error
+	// OnNodeRegister is called when a node registers. It may return changes
+	// to the node, which the following plugins see in their metadata.
+	OnNodeRegister(ctx context.Context, nodeID string, metadata map[string]interface{}) (*NodeChanges, error)
+	
+	// OnNodeHeartbeat is called when a node sends a heartbeat, before its
+	// lease is renewed
+	OnNodeHeartbeat(ctx context.Context, nodeID string) error
 	
-	// OnNodeHeartbeat is called when a node sends a heartbeat
-	OnNodeHeartbeat(nodeID string) error
+	// OnNodeList is called with the nodes a listing returns, which carry the
+	// same metadata keys as SelectionPlugin candidates. It returns the IDs of
+	// the nodes to return, best first, or nil to leave the listing unchanged.
+	OnNodeList(ctx context.Context, filter map[string]string, nodes []map[string]interface{}) ([]string, error)
 	
-	// OnNodeList is called when nodes are listed
-	OnNodeList(filter map[string]string) error
+	// OnNodeDeregister is called when a node is removed. Removal cannot be
+	// rejected, errors are only logged.
+	OnNodeDeregister(ctx context.Context, nodeID string) error
+}
+
+// NodeChanges are the changes a registry plugin makes to a registering node
+type NodeChanges struct {
+	// Labels replaces the labels of the node when not nil
+	Labels map[string]string
+	
+	// Metadata sets metadata keys of the node, limited to MutableNodeMetadata
+	Metadata map[string]interface{}
+}
+
+// MutableNodeMetadata are the metadata keys OnNodeRegister may change
+var MutableNodeMetadata = []string{"specialization", "endpoint", "private_node", "version", "region", "datacenter"}
+
+// FailureError reports that a plugin could not handle a call because it
+// timed out, crashed, was unavailable or exceeded a limit. Errors the plugin
+// returns itself are not failures.
+type FailureError struct {
+	Err error
+}
+
+// Error implements the error interface
+func (e *FailureError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *FailureError) Unwrap() error {
+	return e.Err
+}
+
+// IsFailure reports whether an error is or wraps a FailureError
+func IsFailure(err error) bool {
+	var failure *FailureError
+	return errors.As(err, &failure)
+}
+
+// SelectionPlugin provides a custom node selection strategy for SelectNodes.
+// The plugin name is the strategy name callers request.
+type SelectionPlugin interface {
+	Plugin
 	
-	// OnNodeDeregister is called when a node is removed
-	OnNodeDeregister(nodeID string) error
+	// SelectNodes returns up to count node IDs from the candidates, best first.
+	// Candidates carry the same metadata keys as OnNodeRegister plus status and load.
+	SelectNodes(candidates []map[string]interface{}, count int, key string) ([]string, error)
 }
 
 // FederationPlugin handles main net and cross-pool communication
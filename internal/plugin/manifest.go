@@ -0,0 +1,471 @@
+package plugin
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// APIVersion is the version of the plugin API: the interfaces plugins
+// implement, the out-of-process protocol and the WASM ABI. Plugins declare
+// the version they target in their manifest.
+const APIVersion = 1
+
+// supportedAPIVersions are the plugin API versions this build loads
+var supportedAPIVersions = []int{1}
+
+// Interface names used in manifests
+const (
+	InterfaceRegistry   = "registry"
+	InterfaceSelection  = "selection"
+	InterfaceAuth       = "auth"
+	InterfaceMetrics    = "metrics"
+	InterfaceStorage    = "storage"
+	InterfaceFederation = "federation"
+)
+
+// Signature policies of a TrustPolicy
+const (
+	// SignaturesRequired refuses plugins without a manifest signed by a trusted key
+	SignaturesRequired = "required"
+
+	// SignaturesOptional loads unsigned plugins with a warning
+	SignaturesOptional = "optional"
+
+	// SignaturesDisabled does not check signatures
+	SignaturesDisabled = "disabled"
+)
+
+// ManifestSuffix is appended to the plugin name to find its manifest,
+// SignatureSuffix to the manifest path to find its detached signature
+const (
+	ManifestSuffix  = ".manifest.yaml"
+	SignatureSuffix = ".sig"
+)
+
+// Manifest describes a plugin file. It is kept next to the plugin as
+// <name>.manifest.yaml and signed by <name>.manifest.yaml.sig.
+type Manifest struct {
+	Name       string `yaml:"name"`
+	Version    string `yaml:"version"`
+	APIVersion int    `yaml:"api_version"`
+
+	// Interfaces lists the plugin interfaces the plugin implements
+	Interfaces []string `yaml:"interfaces"`
+
+	// ConfigSchema describes the settings of the plugin's config entry,
+	// settings are not checked when it is empty
+	ConfigSchema map[string]ConfigField `yaml:"config_schema,omitempty"`
+
+	// SHA256 is the hex encoded hash of the plugin file, binding the
+	// signature of the manifest to it
+	SHA256 string `yaml:"sha256"`
+}
+
+// ConfigField describes a setting of a plugin configuration
+type ConfigField struct {
+	// Type is string, int, number, bool, duration, list, map or any
+	Type        string `yaml:"type"`
+	Required    bool   `yaml:"required,omitempty"`
+	Description string `yaml:"description,omitempty"`
+}
+
+// ManifestPath returns the manifest path of a plugin file
+func ManifestPath(pluginPath string) string {
+	base := strings.TrimSuffix(pluginPath, filepath.Ext(pluginPath))
+	return base + ManifestSuffix
+}
+
+// ParseManifest decodes a manifest, rejecting unknown fields
+func ParseManifest(data []byte) (*Manifest, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	manifest := &Manifest{}
+	if err := decoder.Decode(manifest); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("invalid manifest: %v", err)
+	}
+	if manifest.Name == "" {
+		return nil, fmt.Errorf("invalid manifest: name is required")
+	}
+	if manifest.APIVersion == 0 {
+		return nil, fmt.Errorf("invalid manifest: api_version is required")
+	}
+	if len(manifest.Interfaces) == 0 {
+		return nil, fmt.Errorf("invalid manifest: interfaces is required")
+	}
+	for _, name := range manifest.Interfaces {
+		if _, ok := interfaceTypes[name]; !ok {
+			return nil, fmt.Errorf("invalid manifest: unknown interface %s, use %s", name, strings.Join(interfaceNames(), ", "))
+		}
+	}
+	for key, field := range manifest.ConfigSchema {
+		if _, ok := configFieldTypes[field.Type]; !ok {
+			return nil, fmt.Errorf("invalid manifest: config_schema.%s has unknown type %q", key, field.Type)
+		}
+	}
+	return manifest, nil
+}
+
+// CheckCompatible returns an error when the manifest targets a plugin API
+// version this build does not support
+func (m *Manifest) CheckCompatible() error {
+	for _, version := range supportedAPIVersions {
+		if m.APIVersion == version {
+			return nil
+		}
+	}
+	return fmt.Errorf("plugin targets plugin API version %d, this build supports %v", m.APIVersion, supportedAPIVersions)
+}
+
+// CheckInterfaces returns an error when a plugin does not implement exactly
+// the interfaces its manifest declares
+func (m *Manifest) CheckInterfaces(instance interface{}) error {
+	declared := append([]string(nil), m.Interfaces...)
+	sort.Strings(declared)
+	implemented := ImplementedInterfaces(instance)
+	if !reflect.DeepEqual(declared, implemented) {
+		return fmt.Errorf("manifest declares interfaces %v, plugin implements %v", declared, implemented)
+	}
+	return nil
+}
+
+// CheckConfig validates a plugin configuration against the config schema.
+// Settings listed in hostSettings are consumed by the host and always allowed.
+func (m *Manifest) CheckConfig(config map[string]interface{}, hostSettings ...string) error {
+	if len(m.ConfigSchema) == 0 {
+		return nil
+	}
+
+	var problems []string
+	for key, value := range config {
+		field, ok := m.ConfigSchema[key]
+		if !ok {
+			if !contains(hostSettings, key) {
+				problems = append(problems, fmt.Sprintf("unknown setting %s", key))
+			}
+			continue
+		}
+		if !configFieldTypes[field.Type](value) {
+			problems = append(problems, fmt.Sprintf("%s must be of type %s, got %v", key, field.Type, value))
+		}
+	}
+	for key, field := range m.ConfigSchema {
+		if _, ok := config[key]; field.Required && !ok {
+			problems = append(problems, fmt.Sprintf("missing required setting %s", key))
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("invalid config: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// interfaceTypes maps manifest interface names to the plugin interfaces
+var interfaceTypes = map[string]reflect.Type{
+	InterfaceRegistry:   reflect.TypeOf((*RegistryPlugin)(nil)).Elem(),
+	InterfaceSelection:  reflect.TypeOf((*SelectionPlugin)(nil)).Elem(),
+	InterfaceAuth:       reflect.TypeOf((*AuthPlugin)(nil)).Elem(),
+	InterfaceMetrics:    reflect.TypeOf((*MetricsPlugin)(nil)).Elem(),
+	InterfaceStorage:    reflect.TypeOf((*StoragePlugin)(nil)).Elem(),
+	InterfaceFederation: reflect.TypeOf((*FederationPlugin)(nil)).Elem(),
+}
+
+// interfaceNames returns the manifest interface names, sorted
+func interfaceNames() []string {
+	names := make([]string, 0, len(interfaceTypes))
+	for name := range interfaceTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ImplementedInterfaces returns the manifest names of the plugin interfaces
+// an instance implements, sorted
+func ImplementedInterfaces(instance interface{}) []string {
+	implemented := []string{}
+	if instance == nil {
+		return implemented
+	}
+	t := reflect.TypeOf(instance)
+	for _, name := range interfaceNames() {
+		if t.Implements(interfaceTypes[name]) {
+			implemented = append(implemented, name)
+		}
+	}
+	return implemented
+}
+
+// configFieldTypes checks values of config_schema types as decoded from YAML
+var configFieldTypes = map[string]func(interface{}) bool{
+	"string": func(v interface{}) bool { _, ok := v.(string); return ok },
+	"int": func(v interface{}) bool {
+		switch n := v.(type) {
+		case int, int64:
+			return true
+		case float64:
+			return n == float64(int64(n))
+		}
+		return false
+	},
+	"number": func(v interface{}) bool {
+		switch v.(type) {
+		case int, int64, float64:
+			return true
+		}
+		return false
+	},
+	"bool": func(v interface{}) bool { _, ok := v.(bool); return ok },
+	"duration": func(v interface{}) bool {
+		s, ok := v.(string)
+		if !ok {
+			return false
+		}
+		_, err := time.ParseDuration(s)
+		return err == nil
+	},
+	"list": func(v interface{}) bool {
+		return v != nil && reflect.TypeOf(v).Kind() == reflect.Slice
+	},
+	"map": func(v interface{}) bool {
+		return v != nil && reflect.TypeOf(v).Kind() == reflect.Map
+	},
+	"any": func(v interface{}) bool { return true },
+}
+
+// TrustPolicy decides which plugin files may be loaded
+type TrustPolicy struct {
+	// Signatures is SignaturesRequired, SignaturesOptional or SignaturesDisabled
+	Signatures string
+
+	// TrustedKeys are the keys plugin manifests may be signed with, by ID
+	TrustedKeys map[string]ed25519.PublicKey
+}
+
+// NewTrustPolicy creates a trust policy from the signature mode and the
+// trusted public keys by ID, encoded as "ed25519:<base64>"
+func NewTrustPolicy(signatures string, keys map[string]string) (*TrustPolicy, error) {
+	switch signatures {
+	case "":
+		signatures = SignaturesOptional
+	case SignaturesRequired, SignaturesOptional, SignaturesDisabled:
+	default:
+		return nil, fmt.Errorf("unknown signature policy %q, use %s, %s or %s",
+			signatures, SignaturesRequired, SignaturesOptional, SignaturesDisabled)
+	}
+
+	policy := &TrustPolicy{Signatures: signatures, TrustedKeys: make(map[string]ed25519.PublicKey)}
+	for id, encoded := range keys {
+		key, err := ParsePublicKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("trusted key %s: %v", id, err)
+		}
+		policy.TrustedKeys[id] = key
+	}
+	if signatures == SignaturesRequired && len(policy.TrustedKeys) == 0 {
+		return nil, fmt.Errorf("signatures are required but no trusted keys are configured")
+	}
+	return policy, nil
+}
+
+// DefaultTrustPolicy loads unsigned plugins with a warning
+func DefaultTrustPolicy() *TrustPolicy {
+	return &TrustPolicy{Signatures: SignaturesOptional, TrustedKeys: map[string]ed25519.PublicKey{}}
+}
+
+// Verification is the outcome of verifying a plugin file
+type Verification struct {
+	// Manifest is nil for plugins without a manifest
+	Manifest *Manifest
+
+	// SignedBy is the ID of the trusted key that signed the manifest, or empty
+	SignedBy string
+
+	// Warnings are problems the policy lets pass
+	Warnings []string
+}
+
+// Verify checks a plugin file against the policy: its manifest must be
+// compatible with this build, describe the file and be signed as the policy
+// requires. Plugins without a manifest are only refused when signatures are
+// required.
+func (p *TrustPolicy) Verify(pluginPath, name string) (*Verification, error) {
+	result := &Verification{}
+	manifestPath := ManifestPath(pluginPath)
+	data, err := os.ReadFile(manifestPath)
+	if os.IsNotExist(err) {
+		if p.Signatures == SignaturesRequired {
+			return nil, fmt.Errorf("no manifest %s, signed manifests are required", manifestPath)
+		}
+		result.Warnings = append(result.Warnings, "no manifest, API version and origin are unknown")
+		return result, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %v", err)
+	}
+
+	manifest, err := ParseManifest(data)
+	if err != nil {
+		return nil, err
+	}
+	if manifest.Name != name {
+		return nil, fmt.Errorf("manifest is for plugin %s, expected %s", manifest.Name, name)
+	}
+	if err := manifest.CheckCompatible(); err != nil {
+		return nil, err
+	}
+
+	sum, err := FileSHA256(pluginPath)
+	if err != nil {
+		return nil, err
+	}
+	if manifest.SHA256 == "" {
+		return nil, fmt.Errorf("manifest does not list the sha256 of the plugin file")
+	}
+	if !strings.EqualFold(manifest.SHA256, sum) {
+		return nil, fmt.Errorf("plugin file does not match the sha256 of its manifest")
+	}
+	result.Manifest = manifest
+
+	if p.Signatures == SignaturesDisabled {
+		return result, nil
+	}
+	signedBy, err := p.verifySignature(manifestPath+SignatureSuffix, data)
+	if err != nil {
+		if p.Signatures == SignaturesRequired {
+			return nil, err
+		}
+		result.Warnings = append(result.Warnings, err.Error())
+		return result, nil
+	}
+	result.SignedBy = signedBy
+	return result, nil
+}
+
+// verifySignature checks the detached signature of a manifest against the
+// trusted keys, returning the ID of the key that made it
+func (p *TrustPolicy) verifySignature(signaturePath string, manifest []byte) (string, error) {
+	encoded, err := os.ReadFile(signaturePath)
+	if os.IsNotExist(err) {
+		return "", fmt.Errorf("manifest is not signed")
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read signature: %v", err)
+	}
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encoded)))
+	if err != nil || len(signature) != ed25519.SignatureSize {
+		return "", fmt.Errorf("invalid signature file %s", signaturePath)
+	}
+
+	ids := make([]string, 0, len(p.TrustedKeys))
+	for id := range p.TrustedKeys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		if ed25519.Verify(p.TrustedKeys[id], manifest, signature) {
+			return id, nil
+		}
+	}
+	return "", fmt.Errorf("manifest signature does not verify with any trusted key")
+}
+
+// SignManifest returns the detached signature of manifest data, base64 encoded
+func SignManifest(key ed25519.PrivateKey, manifest []byte) string {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(key, manifest))
+}
+
+// FileSHA256 returns the hex encoded SHA-256 hash of a file
+func FileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open plugin file: %v", err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("failed to hash plugin file: %v", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ParsePublicKey decodes a public key encoded as "ed25519:<base64>"
+func ParsePublicKey(encoded string) (ed25519.PublicKey, error) {
+	data, ok := strings.CutPrefix(strings.TrimSpace(encoded), "ed25519:")
+	if !ok {
+		return nil, fmt.Errorf("public key must start with ed25519:")
+	}
+	key, err := base64.StdEncoding.DecodeString(data)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid ed25519 public key")
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// EncodePublicKey encodes a public key as "ed25519:<base64>"
+func EncodePublicKey(key ed25519.PublicKey) string {
+	return "ed25519:" + base64.StdEncoding.EncodeToString(key)
+}
+
+// contains reports whether a list holds a value
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// checkVerifiedCode checks that code read after verifying a plugin file is
+// the file that was verified, plugins without a manifest are not checked
+func checkVerifiedCode(path string, code []byte, manifest *Manifest) error {
+	if manifest == nil {
+		return nil
+	}
+	sum := sha256.Sum256(code)
+	if !strings.EqualFold(hex.EncodeToString(sum[:]), manifest.SHA256) {
+		return fmt.Errorf("%s changed since it was verified", path)
+	}
+	return nil
+}
+
+// verifyFile applies the trust policy of the manager to a plugin file,
+// logging the warnings the policy lets pass
+func (pm *PluginManager) verifyFile(path, name string) (*Verification, error) {
+	pm.mu.RLock()
+	policy := pm.trust
+	pm.mu.RUnlock()
+
+	result, err := policy.Verify(path, name)
+	if err != nil {
+		return nil, err
+	}
+	for _, warning := range result.Warnings {
+		log.Printf("Warning: Plugin %s (%s): %s", name, path, warning)
+	}
+	if result.Manifest != nil {
+		signer := "unsigned"
+		if result.SignedBy != "" {
+			signer = "signed by " + result.SignedBy
+		}
+		log.Printf("Plugin %s %s verified (plugin API %d, %s)", name, result.Manifest.Version, result.Manifest.APIVersion, signer)
+	}
+	return result, nil
+}
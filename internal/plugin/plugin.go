@@ -1,45 +1,227 @@
 package plugin
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"os"
+	"path/filepath"
 	"plugin"
+	"sort"
+	"strings"
 	"sync"
+
+	"galaxy-node-pool/internal/container"
+)
+
+// ContainerName is the name the manager registers itself under in a service container
+const ContainerName = "plugin_manager"
+
+// Stages of loading a plugin file, reported in LoadError
+const (
+	StageVerify     = "verify"
+	StageOpen       = "open"
+	StageLookup     = "lookup"
+	StageHandshake  = "handshake"
+	StageConstruct  = "construct"
+	StageInitialize = "initialize"
+	StageRegister   = "register"
 )
 
+// Constructor is the New function plugin files export. The manager calls
+// Initialize on the plugin it returns with the plugin's configuration.
+type Constructor func() Plugin
+
+// LegacyConstructor is the older New function that receives the
+// configuration itself, plugins it returns are not initialized again
+type LegacyConstructor func(map[string]interface{}) (interface{}, error)
+
+// LoadError describes a plugin file that failed to load
+type LoadError struct {
+	Path   string
+	Plugin string
+	Stage  string
+	Err    error
+}
+
+// Error implements the error interface
+func (e *LoadError) Error() string {
+	return fmt.Sprintf("plugin %s (%s): %s failed: %v", e.Plugin, e.Path, e.Stage, e.Err)
+}
+
+// LoadReport describes the outcome of loading a plugin directory
+type LoadReport struct {
+	Dir string
+
+	// Loaded lists the plugins loaded, initialized and registered
+	Loaded []string
+
+	// Skipped lists plugin files without an enabled configuration entry
+	Skipped []string
+
+	// Failed lists the plugin files that could not be loaded
+	Failed []*LoadError
+}
+
+// Err returns an error summarizing the failed plugins, or nil
+func (r *LoadReport) Err() error {
+	if len(r.Failed) == 0 {
+		return nil
+	}
+	messages := make([]string, 0, len(r.Failed))
+	for _, failure := range r.Failed {
+		messages = append(messages, failure.Error())
+	}
+	return fmt.Errorf("%d plugins failed to load:\n  %s", len(r.Failed), strings.Join(messages, "\n  "))
+}
+
 // PluginManager handles the loading and management of plugins
 type PluginManager struct {
 	plugins     map[string]interface{}
 	mu          sync.RWMutex
 	initialized bool
+
+	// initializedPlugins records plugins whose Initialize has been called
+	initializedPlugins map[string]bool
+
+	// container, when set, receives every registered plugin
+	container *container.ServiceContainer
+
+	// trust decides which plugin files may be loaded
+	trust *TrustPolicy
 }
 
 // NewPluginManager creates a new plugin manager
 func NewPluginManager() *PluginManager {
 	return &PluginManager{
-		plugins:     make(map[string]interface{}),
-		initialized: false,
+		plugins:            make(map[string]interface{}),
+		initialized:        false,
+		initializedPlugins: make(map[string]bool),
+		trust:              DefaultTrustPolicy(),
 	}
 }
 
-// Initialize loads all plugins from the specified directory
-func (pm *PluginManager) Initialize(pluginDir string, configs map[string]map[string]interface{}) error {
+// SetTrustPolicy sets the policy plugin files are verified against before
+// they are loaded
+func (pm *PluginManager) SetTrustPolicy(policy *TrustPolicy) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.trust = policy
+}
+
+// SetContainer publishes the manager and its plugins in a service container.
+// Plugins registered later are added to the container as well.
+func (pm *PluginManager) SetContainer(c *container.ServiceContainer) error {
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
 
+	if err := c.Register(ContainerName, pm); err != nil {
+		return err
+	}
+	for name, instance := range pm.plugins {
+		if err := c.Register(name, instance); err != nil {
+			return fmt.Errorf("failed to register plugin %s with container: %v", name, err)
+		}
+	}
+	pm.container = c
+	return nil
+}
+
+// Initialize loads the plugin files of a directory: Go plugins (*.so),
+// WebAssembly registry plugins (*.wasm) and executables, which run as
+// out-of-process plugins. A file is loaded when its
+// name without extension has an entry in configs, the plugin is then
+// initialized with that configuration and registered. Failures of single
+// plugins are reported in the returned LoadReport rather than as an error.
+func (pm *PluginManager) Initialize(pluginDir string, configs map[string]map[string]interface{}) (*LoadReport, error) {
+	pm.mu.Lock()
 	if pm.initialized {
-		return fmt.Errorf("plugin manager already initialized")
+		pm.mu.Unlock()
+		return nil, fmt.Errorf("plugin manager already initialized")
 	}
+	pm.initialized = true
+	pm.mu.Unlock()
 
-	// Load plugins from directory
-	// For each plugin file in pluginDir:
-	//   1. Open the plugin
-	//   2. Look up the "New" symbol
-	//   3. Create a new instance with the plugin config
-	//   4. Register the plugin
+	report := &LoadReport{Dir: pluginDir}
+	entries, err := os.ReadDir(pluginDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			log.Printf("Plugin directory %s does not exist, no plugins loaded", pluginDir)
+			return report, nil
+		}
+		return nil, fmt.Errorf("failed to read plugin directory: %v", err)
+	}
 
-	pm.initialized = true
-	log.Printf("Plugin manager initialized with directory: %s", pluginDir)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		load := pm.LoadPlugin
+		switch filepath.Ext(entry.Name()) {
+		case ".so":
+		case ".wasm":
+			load = pm.LoadWASMPlugin
+		default:
+			// Manifests and signatures belong to the plugin next to them
+			if strings.HasSuffix(entry.Name(), ManifestSuffix) || strings.HasSuffix(entry.Name(), SignatureSuffix) {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil || !info.Mode().IsRegular() || info.Mode().Perm()&0111 == 0 {
+				continue
+			}
+			load = pm.LoadRemotePlugin
+		}
+
+		path := filepath.Join(pluginDir, entry.Name())
+		name := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+		config, ok := configs[name]
+		if !ok {
+			log.Printf("No enabled configuration for plugin %s, skipping %s", name, path)
+			report.Skipped = append(report.Skipped, name)
+			continue
+		}
+
+		if err := load(path, name, config); err != nil {
+			loadErr, ok := err.(*LoadError)
+			if !ok {
+				loadErr = &LoadError{Path: path, Plugin: name, Stage: StageRegister, Err: err}
+			}
+			log.Printf("Warning: %v", loadErr)
+			report.Failed = append(report.Failed, loadErr)
+			continue
+		}
+		report.Loaded = append(report.Loaded, name)
+	}
+
+	log.Printf("Plugin manager initialized with directory %s: %d loaded, %d skipped, %d failed",
+		pluginDir, len(report.Loaded), len(report.Skipped), len(report.Failed))
+	return report, nil
+}
+
+// Shutdown shuts down the initialized plugins, stopping the processes of
+// out-of-process plugins
+func (pm *PluginManager) Shutdown(ctx context.Context) error {
+	pm.mu.RLock()
+	plugins := make(map[string]Plugin)
+	for name, instance := range pm.plugins {
+		if p, ok := instance.(Plugin); ok && pm.initializedPlugins[name] {
+			plugins[name] = p
+		}
+	}
+	pm.mu.RUnlock()
+
+	var failed []string
+	for name, p := range plugins {
+		if err := p.Shutdown(ctx); err != nil {
+			log.Printf("Warning: Failed to shut down plugin %s: %v", name, err)
+			failed = append(failed, name)
+		}
+	}
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return fmt.Errorf("failed to shut down plugins: %s", strings.Join(failed, ", "))
+	}
 	return nil
 }
 
@@ -51,6 +233,11 @@ func (pm *PluginManager) Register(name string, instance interface{}) error {
 	if _, exists := pm.plugins[name]; exists {
 		return fmt.Errorf("plugin %s already registered", name)
 	}
+	if pm.container != nil {
+		if err := pm.container.Register(name, instance); err != nil {
+			return fmt.Errorf("failed to register plugin %s with container: %v", name, err)
+		}
+	}
 
 	pm.plugins[name] = instance
 	log.Printf("Plugin registered: %s", name)
@@ -70,40 +257,169 @@ func (pm *PluginManager) Get(name string) (interface{}, error) {
 	return plugin, nil
 }
 
-// LoadPlugin loads a single plugin from a file
-func (pm *PluginManager) LoadPlugin(path string, config map[string]interface{}) error {
-	p, err := plugin.Open(path)
-	if err != nil {
-		return fmt.Errorf("failed to open plugin %s: %v", path, err)
+// Plugins returns the registered plugins by name
+func (pm *PluginManager) Plugins() map[string]interface{} {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	plugins := make(map[string]interface{}, len(pm.plugins))
+	for name, instance := range pm.plugins {
+		plugins[name] = instance
 	}
+	return plugins
+}
 
-	newFunc, err := p.Lookup("New")
-	if err != nil {
-		return fmt.Errorf("plugin %s does not export 'New' function: %v", path, err)
+// Names returns the names of the registered plugins, sorted
+func (pm *PluginManager) Names() []string {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	names := make([]string, 0, len(pm.plugins))
+	for name := range pm.plugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// InitializePlugin initializes a registered plugin with its configuration.
+// Each plugin is initialized once, later calls return nil.
+func (pm *PluginManager) InitializePlugin(name string, config map[string]interface{}) error {
+	pm.mu.RLock()
+	instance, exists := pm.plugins[name]
+	done := pm.initializedPlugins[name]
+	pm.mu.RUnlock()
+
+	if !exists {
+		return fmt.Errorf("plugin %s not found", name)
+	}
+	if done {
+		return nil
 	}
 
-	// Call the New function to create a plugin instance
-	constructor, ok := newFunc.(func(map[string]interface{}) (interface{}, error))
+	p, ok := instance.(Plugin)
 	if !ok {
-		return fmt.Errorf("plugin %s has invalid 'New' function signature", path)
+		return fmt.Errorf("plugin %s does not implement the Plugin interface", name)
+	}
+	if config == nil {
+		config = map[string]interface{}{}
+	}
+	if err := p.Initialize(config); err != nil {
+		return err
+	}
+
+	pm.mu.Lock()
+	pm.initializedPlugins[name] = true
+	pm.mu.Unlock()
+	return nil
+}
+
+// IsInitialized reports whether a plugin has been initialized successfully
+func (pm *PluginManager) IsInitialized(name string) bool {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	return pm.initializedPlugins[name]
+}
+
+// LoadPlugin verifies, loads, initializes and registers a single plugin
+// file. The plugin is expected to be called name, the file name without
+// extension when empty. Failures are returned as *LoadError.
+func (pm *PluginManager) LoadPlugin(path, name string, config map[string]interface{}) error {
+	if name == "" {
+		name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	fail := func(stage string, err error) error {
+		return &LoadError{Path: path, Plugin: name, Stage: stage, Err: err}
 	}
 
-	instance, err := constructor(config)
+	verification, err := pm.verifyFile(path, name)
 	if err != nil {
-		return fmt.Errorf("failed to create plugin instance for %s: %v", path, err)
+		return fail(StageVerify, err)
+	}
+	manifest := verification.Manifest
+	if manifest != nil {
+		if err := manifest.CheckConfig(config); err != nil {
+			return fail(StageVerify, err)
+		}
 	}
 
-	// Extract plugin name from the instance
-	nameMethod, err := p.Lookup("Name")
+	// Open a private copy of the verified code, the file in the plugin
+	// directory could be replaced between verifying and opening it
+	code, err := os.ReadFile(path)
+	if err != nil {
+		return fail(StageOpen, err)
+	}
+	if err := checkVerifiedCode(path, code, manifest); err != nil {
+		return fail(StageVerify, err)
+	}
+	p, err := openPluginCopy(path, code)
 	if err != nil {
-		return fmt.Errorf("plugin %s does not export 'Name' function: %v", path, err)
+		return fail(StageOpen, err)
 	}
 
-	nameFunc, ok := nameMethod.(func() string)
-	if !ok {
-		return fmt.Errorf("plugin %s has invalid 'Name' function signature", path)
+	newFunc, err := p.Lookup("New")
+	if err != nil {
+		return fail(StageLookup, fmt.Errorf("plugin does not export a New function: %v", err))
+	}
+
+	var instance interface{}
+	initialized := false
+	switch constructor := newFunc.(type) {
+	case func() Plugin:
+		instance = constructor()
+	case func(map[string]interface{}) (interface{}, error):
+		instance, err = constructor(config)
+		if err != nil {
+			return fail(StageConstruct, err)
+		}
+		initialized = true
+	default:
+		return fail(StageLookup, fmt.Errorf("New has signature %T, expected func() plugin.Plugin", newFunc))
+	}
+	if instance == nil {
+		return fail(StageConstruct, fmt.Errorf("New returned nil"))
+	}
+
+	if named, ok := instance.(Plugin); ok && named.Name() != name {
+		return fail(StageConstruct, fmt.Errorf("file provides plugin %s, expected %s", named.Name(), name))
+	}
+	if manifest != nil {
+		if err := manifest.CheckInterfaces(instance); err != nil {
+			return fail(StageVerify, err)
+		}
 	}
 
-	name := nameFunc()
-	return pm.Register(name, instance)
+	if !initialized {
+		if config == nil {
+			config = map[string]interface{}{}
+		}
+		if err := instance.(Plugin).Initialize(config); err != nil {
+			return fail(StageInitialize, err)
+		}
+	}
+
+	if err := pm.Register(name, instance); err != nil {
+		return fail(StageRegister, err)
+	}
+
+	pm.mu.Lock()
+	pm.initializedPlugins[name] = true
+	pm.mu.Unlock()
+	return nil
+}
+
+// openPluginCopy writes the code of a plugin file to a private temporary
+// directory and opens it from there. The copy is removed once it is loaded.
+func openPluginCopy(path string, code []byte) (*plugin.Plugin, error) {
+	dir, err := os.MkdirTemp("", "galaxy-plugin-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create plugin copy: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	copyPath := filepath.Join(dir, filepath.Base(path))
+	if err := os.WriteFile(copyPath, code, 0700); err != nil {
+		return nil, fmt.Errorf("failed to write plugin copy: %v", err)
+	}
+	return plugin.Open(copyPath)
 }
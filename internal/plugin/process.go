@@ -0,0 +1,403 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	pluginpb "galaxy-node-pool/proto/plugin"
+)
+
+// ProtocolVersion is the newest version of the out-of-process plugin protocol
+// this build speaks
+const ProtocolVersion = 1
+
+// supportedProtocolVersions are the protocol versions offered in the handshake
+var supportedProtocolVersions = []uint32{1}
+
+const (
+	// CookieEnv and CookieValue mark a process as started by the plugin host,
+	// so plugin executables refuse to run on their own
+	CookieEnv   = "GALAXY_PLUGIN_COOKIE"
+	CookieValue = "galaxy-node-pool-plugin"
+
+	// SocketEnv names the unix socket a plugin serves on
+	SocketEnv = "GALAXY_PLUGIN_SOCKET"
+
+	// handshakeTimeout bounds how long a plugin may take to start serving
+	handshakeTimeout = 10 * time.Second
+
+	// callTimeout bounds each call to a plugin
+	callTimeout = 10 * time.Second
+
+	// stopTimeout bounds how long a plugin may take to exit after Shutdown
+	stopTimeout = 10 * time.Second
+
+	// minUptime is how long a plugin must run for an exit to not count as a crash loop
+	minUptime = 10 * time.Second
+
+	// maxCrashes is how many crashes in a row make the host give up on a plugin
+	maxCrashes = 5
+
+	// maxRestartDelay caps the delay between restarts
+	maxRestartDelay = 30 * time.Second
+)
+
+// remoteProcess runs and supervises the executable of an out-of-process
+// plugin, restarting it when it exits unexpectedly
+type remoteProcess struct {
+	path      string
+	name      string
+	socketDir string
+
+	// checksum, when set, is the SHA-256 the executable must have when started
+	checksum string
+
+	mu       sync.RWMutex
+	cmd      *exec.Cmd
+	conn     *grpc.ClientConn
+	info     *pluginpb.HandshakeResponse
+	config   map[string]interface{}
+	started  time.Time
+	stopping bool
+
+	// failed is set when the plugin kept crashing and was given up on
+	failed error
+
+	// exited is the exit status of the current process
+	exited *exitStatus
+}
+
+// exitStatus is closed when a plugin process exits
+type exitStatus struct {
+	done chan struct{}
+	err  error
+}
+
+// newRemoteProcess prepares a plugin executable to be started
+func newRemoteProcess(path, name string) (*remoteProcess, error) {
+	socketDir, err := os.MkdirTemp("", "galaxy-plugin-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create socket directory: %v", err)
+	}
+	return &remoteProcess{path: path, name: name, socketDir: socketDir}, nil
+}
+
+// start launches the plugin and performs the handshake
+func (p *remoteProcess) start() (*pluginpb.HandshakeResponse, error) {
+	if p.checksum != "" {
+		sum, err := FileSHA256(p.path)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.EqualFold(sum, p.checksum) {
+			return nil, fmt.Errorf("%s changed since it was verified", p.path)
+		}
+	}
+
+	socket := filepath.Join(p.socketDir, "plugin.sock")
+	os.Remove(socket)
+
+	cmd := exec.Command(p.path)
+	cmd.Env = append(os.Environ(), CookieEnv+"="+CookieValue, SocketEnv+"="+socket)
+	cmd.Stdout = &pluginLog{name: p.name}
+	cmd.Stderr = cmd.Stdout
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start %s: %v", p.path, err)
+	}
+	exited := &exitStatus{done: make(chan struct{})}
+	go func() {
+		exited.err = cmd.Wait()
+		close(exited.done)
+	}()
+
+	conn, err := grpc.NewClient("unix://"+socket, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		cmd.Process.Kill()
+		return nil, err
+	}
+
+	info, err := handshake(conn, exited)
+	if err == nil {
+		err = p.checkHandshake(info)
+	}
+	if err != nil {
+		conn.Close()
+		cmd.Process.Kill()
+		return nil, err
+	}
+
+	p.mu.Lock()
+	p.cmd = cmd
+	p.conn = conn
+	p.info = info
+	p.started = time.Now()
+	p.exited = exited
+	p.mu.Unlock()
+	return info, nil
+}
+
+// handshake waits for a starting plugin to answer the handshake
+func handshake(conn *grpc.ClientConn, exited *exitStatus) (*pluginpb.HandshakeResponse, error) {
+	client := pluginpb.NewPluginClient(conn)
+	deadline := time.Now().Add(handshakeTimeout)
+	for {
+		select {
+		case <-exited.done:
+			return nil, fmt.Errorf("plugin exited before the handshake: %v", exited.err)
+		default:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		info, err := client.Handshake(ctx, &pluginpb.HandshakeRequest{ProtocolVersions: supportedProtocolVersions})
+		cancel()
+		if err == nil {
+			return info, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("no handshake within %s: %v", handshakeTimeout, err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// checkHandshake validates the handshake answer of a plugin
+func (p *remoteProcess) checkHandshake(info *pluginpb.HandshakeResponse) error {
+	supported := false
+	for _, version := range supportedProtocolVersions {
+		if info.ProtocolVersion == version {
+			supported = true
+		}
+	}
+	if !supported {
+		return fmt.Errorf("plugin speaks protocol version %d, this build supports %v", info.ProtocolVersion, supportedProtocolVersions)
+	}
+	if info.Name != p.name {
+		return fmt.Errorf("executable provides plugin %s, expected %s", info.Name, p.name)
+	}
+	if info.Kind == pluginpb.Kind_KIND_UNSPECIFIED {
+		return fmt.Errorf("plugin did not declare its kind")
+	}
+
+	// A restarted plugin must still be the same kind of plugin
+	p.mu.RLock()
+	previous := p.info
+	p.mu.RUnlock()
+	if previous != nil && previous.Kind != info.Kind {
+		return fmt.Errorf("plugin changed its kind from %s to %s", previous.Kind, info.Kind)
+	}
+	return nil
+}
+
+// initialize passes the configuration to the plugin, it is kept to
+// initialize the plugin again after a restart
+func (p *remoteProcess) initialize(config map[string]interface{}) error {
+	p.mu.Lock()
+	p.config = config
+	p.mu.Unlock()
+	return p.sendConfig()
+}
+
+// sendConfig calls Initialize on the running plugin
+func (p *remoteProcess) sendConfig() error {
+	p.mu.RLock()
+	config := p.config
+	p.mu.RUnlock()
+
+	request := &pluginpb.InitializeRequest{}
+	if config != nil {
+		s, err := toStruct(config)
+		if err != nil {
+			return fmt.Errorf("failed to encode config: %v", err)
+		}
+		request.Config = s
+	}
+	return p.call(func(ctx context.Context, conn grpc.ClientConnInterface) (string, error) {
+		resp, err := pluginpb.NewPluginClient(conn).Initialize(ctx, request)
+		if err != nil {
+			return "", err
+		}
+		return resp.Error, nil
+	})
+}
+
+// call runs a call against the plugin, turning an error the plugin returned
+// into an error
+func (p *remoteProcess) call(fn func(ctx context.Context, conn grpc.ClientConnInterface) (string, error)) error {
+	return p.callContext(context.Background(), fn)
+}
+
+// callContext runs a call against the plugin within ctx. Calls that do not
+// reach the plugin fail with a FailureError.
+func (p *remoteProcess) callContext(ctx context.Context, fn func(ctx context.Context, conn grpc.ClientConnInterface) (string, error)) error {
+	p.mu.RLock()
+	conn, failed, stopping := p.conn, p.failed, p.stopping
+	p.mu.RUnlock()
+
+	switch {
+	case failed != nil:
+		return &FailureError{Err: fmt.Errorf("plugin %s is unavailable: %v", p.name, failed)}
+	case stopping:
+		return &FailureError{Err: fmt.Errorf("plugin %s is shutting down", p.name)}
+	case conn == nil:
+		return &FailureError{Err: fmt.Errorf("plugin %s is restarting", p.name)}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, callTimeout)
+	defer cancel()
+	pluginErr, err := fn(ctx, conn)
+	if err != nil {
+		return &FailureError{Err: fmt.Errorf("plugin %s call failed: %v", p.name, err)}
+	}
+	if pluginErr != "" {
+		return fmt.Errorf("%s", pluginErr)
+	}
+	return nil
+}
+
+// supervise restarts the plugin when it exits until it is stopped or keeps
+// crashing
+func (p *remoteProcess) supervise() {
+	crashes := 0
+	for {
+		p.mu.RLock()
+		exited, started := p.exited, p.started
+		p.mu.RUnlock()
+
+		<-exited.done
+		err := exited.err
+
+		p.mu.Lock()
+		if p.stopping {
+			p.mu.Unlock()
+			return
+		}
+		if p.conn != nil {
+			p.conn.Close()
+			p.conn = nil
+		}
+		p.mu.Unlock()
+
+		if time.Since(started) >= minUptime {
+			crashes = 0
+		}
+		for {
+			crashes++
+			if crashes >= maxCrashes {
+				log.Printf("Warning: Plugin %s exited (%v) %d times in a row, giving up", p.name, err, crashes)
+				p.mu.Lock()
+				p.failed = fmt.Errorf("exited %d times in a row: %v", crashes, err)
+				p.mu.Unlock()
+				return
+			}
+
+			delay := time.Second << uint(crashes-1)
+			if delay > maxRestartDelay {
+				delay = maxRestartDelay
+			}
+			log.Printf("Warning: Plugin %s exited (%v), restarting in %s", p.name, err, delay)
+			time.Sleep(delay)
+
+			p.mu.RLock()
+			stopping := p.stopping
+			p.mu.RUnlock()
+			if stopping {
+				return
+			}
+
+			if _, err = p.start(); err == nil {
+				if err = p.sendConfig(); err == nil {
+					log.Printf("Plugin %s restarted", p.name)
+					break
+				}
+				p.kill()
+			}
+		}
+	}
+}
+
+// stop shuts the plugin down gracefully, killing it if it does not exit in time
+func (p *remoteProcess) stop(ctx context.Context) error {
+	// Mark the plugin as stopping first so the supervisor does not restart it
+	p.mu.Lock()
+	p.stopping = true
+	cmd, conn, exited := p.cmd, p.conn, p.exited
+	p.conn = nil
+	p.mu.Unlock()
+
+	var shutdownErr error
+	if conn != nil {
+		callCtx, cancel := context.WithTimeout(ctx, callTimeout)
+		resp, err := pluginpb.NewPluginClient(conn).Shutdown(callCtx, &pluginpb.ShutdownRequest{})
+		cancel()
+		switch {
+		case err != nil:
+			shutdownErr = fmt.Errorf("plugin %s call failed: %v", p.name, err)
+		case resp.Error != "":
+			shutdownErr = fmt.Errorf("%s", resp.Error)
+		}
+		conn.Close()
+	}
+
+	if cmd != nil && exited != nil {
+		cmd.Process.Signal(syscall.SIGTERM)
+		select {
+		case <-exited.done:
+		case <-time.After(stopTimeout):
+			log.Printf("Warning: Plugin %s did not exit within %s, killing it", p.name, stopTimeout)
+			cmd.Process.Kill()
+		case <-ctx.Done():
+			cmd.Process.Kill()
+		}
+	}
+	os.RemoveAll(p.socketDir)
+	return shutdownErr
+}
+
+// kill stops the current process without a graceful shutdown
+func (p *remoteProcess) kill() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn != nil {
+		p.conn.Close()
+		p.conn = nil
+	}
+	if p.cmd != nil {
+		p.cmd.Process.Kill()
+	}
+}
+
+// pluginLog writes the output of a plugin to the log, line by line
+type pluginLog struct {
+	name string
+	mu   sync.Mutex
+	buf  []byte
+}
+
+// Write implements io.Writer
+func (l *pluginLog) Write(data []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.buf = append(l.buf, data...)
+	for {
+		i := bytes.IndexByte(l.buf, '\n')
+		if i < 0 {
+			break
+		}
+		log.Printf("plugin %s: %s", l.name, l.buf[:i])
+		l.buf = l.buf[i+1:]
+	}
+	return len(data), nil
+}
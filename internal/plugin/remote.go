@@ -0,0 +1,520 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	pluginpb "galaxy-node-pool/proto/plugin"
+)
+
+// LoadRemotePlugin verifies and starts an out-of-process plugin executable,
+// performs the handshake, initializes and registers it. The plugin is
+// restarted when it exits until the manager shuts it down. Failures are
+// returned as *LoadError.
+func (pm *PluginManager) LoadRemotePlugin(path, name string, config map[string]interface{}) error {
+	fail := func(stage string, err error) error {
+		return &LoadError{Path: path, Plugin: name, Stage: stage, Err: err}
+	}
+
+	verification, err := pm.verifyFile(path, name)
+	if err != nil {
+		return fail(StageVerify, err)
+	}
+	manifest := verification.Manifest
+	if manifest != nil {
+		if err := manifest.CheckConfig(config); err != nil {
+			return fail(StageVerify, err)
+		}
+	}
+
+	process, err := newRemoteProcess(path, name)
+	if err != nil {
+		return fail(StageOpen, err)
+	}
+	if manifest != nil {
+		// Restarts launch the file again, it must still be the verified one
+		process.checksum = manifest.SHA256
+	}
+	info, err := process.start()
+	if err != nil {
+		process.stop(context.Background())
+		return fail(StageHandshake, err)
+	}
+
+	instance := newRemotePlugin(process, info.Kind)
+	if manifest != nil {
+		if err := manifest.CheckInterfaces(instance); err != nil {
+			process.stop(context.Background())
+			return fail(StageVerify, err)
+		}
+	}
+	if config == nil {
+		config = map[string]interface{}{}
+	}
+	if err := instance.Initialize(config); err != nil {
+		process.stop(context.Background())
+		return fail(StageInitialize, err)
+	}
+
+	if err := pm.Register(name, instance); err != nil {
+		process.stop(context.Background())
+		return fail(StageRegister, err)
+	}
+
+	pm.mu.Lock()
+	pm.initializedPlugins[name] = true
+	pm.mu.Unlock()
+
+	go process.supervise()
+	return nil
+}
+
+// newRemotePlugin wraps a started plugin process in the plugin interface of its kind
+func newRemotePlugin(process *remoteProcess, kind pluginpb.Kind) Plugin {
+	base := &remotePlugin{process: process}
+	switch kind {
+	case pluginpb.Kind_KIND_REGISTRY:
+		return &remoteRegistryPlugin{base}
+	case pluginpb.Kind_KIND_AUTH:
+		return &remoteAuthPlugin{remotePlugin: base}
+	case pluginpb.Kind_KIND_METRICS:
+		return &remoteMetricsPlugin{base}
+	case pluginpb.Kind_KIND_STORAGE:
+		return &remoteStoragePlugin{base}
+	case pluginpb.Kind_KIND_FEDERATION:
+		return &remoteFederationPlugin{base}
+	}
+	return base
+}
+
+// remotePlugin implements Plugin for a plugin running in its own process
+type remotePlugin struct {
+	process *remoteProcess
+}
+
+// Name returns the plugin name
+func (p *remotePlugin) Name() string {
+	return p.process.name
+}
+
+// Initialize sends the configuration to the plugin
+func (p *remotePlugin) Initialize(config map[string]interface{}) error {
+	return p.process.initialize(config)
+}
+
+// Shutdown stops the plugin process
+func (p *remotePlugin) Shutdown(ctx context.Context) error {
+	return p.process.stop(ctx)
+}
+
+// remoteRegistryPlugin implements RegistryPlugin over gRPC
+type remoteRegistryPlugin struct {
+	*remotePlugin
+}
+
+// OnNodeRegister is called when a node registers
+func (p *remoteRegistryPlugin) OnNodeRegister(ctx context.Context, nodeID string, metadata map[string]interface{}) (*NodeChanges, error) {
+	s, err := toStruct(metadata)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode metadata: %v", err)
+	}
+	var changes *NodeChanges
+	err = p.process.callContext(ctx, func(ctx context.Context, conn grpc.ClientConnInterface) (string, error) {
+		resp, err := pluginpb.NewRegistryPluginClient(conn).OnNodeRegister(ctx, &pluginpb.NodeRegisterRequest{NodeId: nodeID, Metadata: s})
+		if err != nil {
+			return "", err
+		}
+		if resp.ReplaceLabels || len(resp.Metadata.GetFields()) > 0 {
+			changes = &NodeChanges{}
+			if resp.ReplaceLabels {
+				changes.Labels = make(map[string]string, len(resp.Labels))
+				for key, value := range resp.Labels {
+					changes.Labels[key] = value
+				}
+			}
+			if len(resp.Metadata.GetFields()) > 0 {
+				changes.Metadata = resp.Metadata.AsMap()
+			}
+		}
+		return resp.Error, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// OnNodeHeartbeat is called when a node sends a heartbeat
+func (p *remoteRegistryPlugin) OnNodeHeartbeat(ctx context.Context, nodeID string) error {
+	return p.process.callContext(ctx, func(ctx context.Context, conn grpc.ClientConnInterface) (string, error) {
+		resp, err := pluginpb.NewRegistryPluginClient(conn).OnNodeHeartbeat(ctx, &pluginpb.NodeRequest{NodeId: nodeID})
+		if err != nil {
+			return "", err
+		}
+		return resp.Error, nil
+	})
+}
+
+// OnNodeList is called with the nodes a listing returns
+func (p *remoteRegistryPlugin) OnNodeList(ctx context.Context, filter map[string]string, nodes []map[string]interface{}) ([]string, error) {
+	request := &pluginpb.NodeListRequest{Filter: filter}
+	for _, node := range nodes {
+		s, err := toStruct(node)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode node: %v", err)
+		}
+		request.Nodes = append(request.Nodes, s)
+	}
+	var ids []string
+	err := p.process.callContext(ctx, func(ctx context.Context, conn grpc.ClientConnInterface) (string, error) {
+		resp, err := pluginpb.NewRegistryPluginClient(conn).OnNodeList(ctx, request)
+		if err != nil {
+			return "", err
+		}
+		if resp.Reordered {
+			ids = append([]string{}, resp.NodeIds...)
+		}
+		return resp.Error, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// OnNodeDeregister is called when a node is removed
+func (p *remoteRegistryPlugin) OnNodeDeregister(ctx context.Context, nodeID string) error {
+	return p.process.callContext(ctx, func(ctx context.Context, conn grpc.ClientConnInterface) (string, error) {
+		resp, err := pluginpb.NewRegistryPluginClient(conn).OnNodeDeregister(ctx, &pluginpb.NodeRequest{NodeId: nodeID})
+		if err != nil {
+			return "", err
+		}
+		return resp.Error, nil
+	})
+}
+
+// remoteAuthPlugin implements AuthPlugin over gRPC. The HTTP middleware runs
+// in the host and authenticates each request through the plugin.
+type remoteAuthPlugin struct {
+	*remotePlugin
+}
+
+// Authenticate verifies credentials and returns a user ID
+func (p *remoteAuthPlugin) Authenticate(credentials map[string]string) (string, error) {
+	userID, _, err := p.AuthenticateOrg(credentials)
+	return userID, err
+}
+
+// AuthenticateOrg verifies credentials and returns a user ID with the
+// organization the plugin reported for it
+func (p *remoteAuthPlugin) AuthenticateOrg(credentials map[string]string) (string, string, error) {
+	var userID, org string
+	err := p.process.call(func(ctx context.Context, conn grpc.ClientConnInterface) (string, error) {
+		resp, err := pluginpb.NewAuthPluginClient(conn).Authenticate(ctx, &pluginpb.AuthenticateRequest{Credentials: credentials})
+		if err != nil {
+			return "", err
+		}
+		userID, org = resp.UserId, resp.Org
+		return resp.Error, nil
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return userID, org, nil
+}
+
+// Authorize checks if a user has permission for an action
+func (p *remoteAuthPlugin) Authorize(userID string, resource string, action string) (bool, error) {
+	var allowed bool
+	err := p.process.call(func(ctx context.Context, conn grpc.ClientConnInterface) (string, error) {
+		resp, err := pluginpb.NewAuthPluginClient(conn).Authorize(ctx, &pluginpb.AuthorizeRequest{UserId: userID, Resource: resource, Action: action})
+		if err != nil {
+			return "", err
+		}
+		allowed = resp.Allowed
+		return resp.Error, nil
+	})
+	return allowed, err
+}
+
+// Middleware returns an HTTP middleware that authenticates requests by their
+// X-API-Key or bearer token
+func (p *remoteAuthPlugin) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			credentials := map[string]string{
+				"x-api-key": r.Header.Get("X-API-Key"),
+			}
+			if authz := r.Header.Get("Authorization"); len(authz) > 7 && strings.EqualFold(authz[:7], "bearer ") {
+				credentials["token"] = strings.TrimSpace(authz[7:])
+			}
+
+			if _, err := p.Authenticate(credentials); err != nil {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// remoteMetricsPlugin implements MetricsPlugin over gRPC. The HTTP middleware
+// runs in the host and records request counts and durations.
+type remoteMetricsPlugin struct {
+	*remotePlugin
+}
+
+// RecordMetric records a named metric with value and labels
+func (p *remoteMetricsPlugin) RecordMetric(name string, value float64, labels map[string]string) error {
+	return p.process.call(func(ctx context.Context, conn grpc.ClientConnInterface) (string, error) {
+		resp, err := pluginpb.NewMetricsPluginClient(conn).RecordMetric(ctx, &pluginpb.RecordMetricRequest{Name: name, Value: value, Labels: labels})
+		if err != nil {
+			return "", err
+		}
+		return resp.Error, nil
+	})
+}
+
+// GetMetrics returns all current metrics
+func (p *remoteMetricsPlugin) GetMetrics() (map[string]interface{}, error) {
+	var metrics map[string]interface{}
+	err := p.process.call(func(ctx context.Context, conn grpc.ClientConnInterface) (string, error) {
+		resp, err := pluginpb.NewMetricsPluginClient(conn).GetMetrics(ctx, &pluginpb.GetMetricsRequest{})
+		if err != nil {
+			return "", err
+		}
+		metrics = resp.Metrics.AsMap()
+		return resp.Error, nil
+	})
+	return metrics, err
+}
+
+// Middleware returns an HTTP middleware recording http_requests_total and
+// http_request_duration_seconds
+func (p *remoteMetricsPlugin) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			next.ServeHTTP(w, r)
+
+			labels := map[string]string{"method": r.Method, "path": r.URL.Path}
+			p.RecordMetric("http_requests_total", 1, labels)
+			p.RecordMetric("http_request_duration_seconds", time.Since(start).Seconds(), labels)
+		})
+	}
+}
+
+// remoteStoragePlugin implements StoragePlugin over gRPC
+type remoteStoragePlugin struct {
+	*remotePlugin
+}
+
+// Store persists a key-value pair
+func (p *remoteStoragePlugin) Store(key string, value interface{}) error {
+	v, err := toStorageValue(value)
+	if err != nil {
+		return fmt.Errorf("failed to encode value of %s: %v", key, err)
+	}
+	return p.process.call(func(ctx context.Context, conn grpc.ClientConnInterface) (string, error) {
+		resp, err := pluginpb.NewStoragePluginClient(conn).Store(ctx, &pluginpb.StoreRequest{Key: key, Value: v})
+		if err != nil {
+			return "", err
+		}
+		return resp.Error, nil
+	})
+}
+
+// Retrieve gets a value by key
+func (p *remoteStoragePlugin) Retrieve(key string) (interface{}, error) {
+	var value interface{}
+	err := p.process.call(func(ctx context.Context, conn grpc.ClientConnInterface) (string, error) {
+		resp, err := pluginpb.NewStoragePluginClient(conn).Retrieve(ctx, &pluginpb.KeyRequest{Key: key})
+		if err != nil {
+			return "", err
+		}
+		value = fromStorageValue(resp.Value)
+		return resp.Error, nil
+	})
+	return value, err
+}
+
+// Delete removes a key-value pair
+func (p *remoteStoragePlugin) Delete(key string) error {
+	return p.process.call(func(ctx context.Context, conn grpc.ClientConnInterface) (string, error) {
+		resp, err := pluginpb.NewStoragePluginClient(conn).Delete(ctx, &pluginpb.KeyRequest{Key: key})
+		if err != nil {
+			return "", err
+		}
+		return resp.Error, nil
+	})
+}
+
+// List returns all keys with optional prefix
+func (p *remoteStoragePlugin) List(prefix string) ([]string, error) {
+	var keys []string
+	err := p.process.call(func(ctx context.Context, conn grpc.ClientConnInterface) (string, error) {
+		resp, err := pluginpb.NewStoragePluginClient(conn).List(ctx, &pluginpb.ListKeysRequest{Prefix: prefix})
+		if err != nil {
+			return "", err
+		}
+		keys = resp.Keys
+		return resp.Error, nil
+	})
+	return keys, err
+}
+
+// remoteFederationPlugin implements FederationPlugin over gRPC
+type remoteFederationPlugin struct {
+	*remotePlugin
+}
+
+// RegisterWithMainNet registers this pool with the main net
+func (p *remoteFederationPlugin) RegisterWithMainNet(mainNetURL string, poolMetadata map[string]interface{}) error {
+	s, err := toStruct(poolMetadata)
+	if err != nil {
+		return fmt.Errorf("failed to encode pool metadata: %v", err)
+	}
+	return p.process.call(func(ctx context.Context, conn grpc.ClientConnInterface) (string, error) {
+		resp, err := pluginpb.NewFederationPluginClient(conn).RegisterWithMainNet(ctx, &pluginpb.RegisterWithMainNetRequest{MainNetUrl: mainNetURL, PoolMetadata: s})
+		if err != nil {
+			return "", err
+		}
+		return resp.Error, nil
+	})
+}
+
+// DiscoverPools finds other pools on the main net
+func (p *remoteFederationPlugin) DiscoverPools(filter map[string]string) ([]map[string]interface{}, error) {
+	var pools []map[string]interface{}
+	err := p.process.call(func(ctx context.Context, conn grpc.ClientConnInterface) (string, error) {
+		resp, err := pluginpb.NewFederationPluginClient(conn).DiscoverPools(ctx, &pluginpb.DiscoverPoolsRequest{Filter: filter})
+		if err != nil {
+			return "", err
+		}
+		for _, pool := range resp.Pools {
+			pools = append(pools, pool.AsMap())
+		}
+		return resp.Error, nil
+	})
+	return pools, err
+}
+
+// SyncWithPeers synchronizes state with peer pools
+func (p *remoteFederationPlugin) SyncWithPeers() error {
+	return p.process.call(func(ctx context.Context, conn grpc.ClientConnInterface) (string, error) {
+		resp, err := pluginpb.NewFederationPluginClient(conn).SyncWithPeers(ctx, &pluginpb.SyncWithPeersRequest{})
+		if err != nil {
+			return "", err
+		}
+		return resp.Error, nil
+	})
+}
+
+// VerifyNodePayment verifies a node's payment for registration
+func (p *remoteFederationPlugin) VerifyNodePayment(nodeID string, nodeAccount string) (bool, error) {
+	var verified bool
+	err := p.process.call(func(ctx context.Context, conn grpc.ClientConnInterface) (string, error) {
+		resp, err := pluginpb.NewFederationPluginClient(conn).VerifyNodePayment(ctx, &pluginpb.VerifyNodePaymentRequest{NodeId: nodeID, NodeAccount: nodeAccount})
+		if err != nil {
+			return "", err
+		}
+		verified = resp.Verified
+		return resp.Error, nil
+	})
+	return verified, err
+}
+
+// DistributeRewards distributes rewards to stakers
+func (p *remoteFederationPlugin) DistributeRewards(totalFees string, stakerAccounts []string) error {
+	return p.process.call(func(ctx context.Context, conn grpc.ClientConnInterface) (string, error) {
+		resp, err := pluginpb.NewFederationPluginClient(conn).DistributeRewards(ctx, &pluginpb.DistributeRewardsRequest{TotalFees: totalFees, StakerAccounts: stakerAccounts})
+		if err != nil {
+			return "", err
+		}
+		return resp.Error, nil
+	})
+}
+
+// toStruct converts a map to a protobuf Struct
+func toStruct(m map[string]interface{}) (*structpb.Struct, error) {
+	v, err := toValue(m)
+	if err != nil {
+		return nil, err
+	}
+	return v.GetStructValue(), nil
+}
+
+// toValue converts a Go value to a protobuf Value. Besides the types
+// structpb accepts it handles typed maps and slices such as map[string]string.
+func toValue(value interface{}) (*structpb.Value, error) {
+	if v, err := structpb.NewValue(value); err == nil {
+		return v, nil
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("unsupported map key type %s", rv.Type().Key())
+		}
+		fields := make(map[string]*structpb.Value, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			v, err := toValue(iter.Value().Interface())
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", iter.Key().String(), err)
+			}
+			fields[iter.Key().String()] = v
+		}
+		return structpb.NewStructValue(&structpb.Struct{Fields: fields}), nil
+	case reflect.Slice, reflect.Array:
+		values := make([]*structpb.Value, rv.Len())
+		for i := range values {
+			v, err := toValue(rv.Index(i).Interface())
+			if err != nil {
+				return nil, fmt.Errorf("[%d]: %v", i, err)
+			}
+			values[i] = v
+		}
+		return structpb.NewListValue(&structpb.ListValue{Values: values}), nil
+	case reflect.Ptr, reflect.Interface:
+		if rv.IsNil() {
+			return structpb.NewNullValue(), nil
+		}
+		return toValue(rv.Elem().Interface())
+	}
+	return nil, fmt.Errorf("unsupported type %T", value)
+}
+
+// toStorageValue converts a stored value, byte slices are kept as bytes
+func toStorageValue(value interface{}) (*pluginpb.StorageValue, error) {
+	if b, ok := value.([]byte); ok {
+		return &pluginpb.StorageValue{Kind: &pluginpb.StorageValue_BytesValue{BytesValue: b}}, nil
+	}
+	v, err := toValue(value)
+	if err != nil {
+		return nil, err
+	}
+	return &pluginpb.StorageValue{Kind: &pluginpb.StorageValue_Value{Value: v}}, nil
+}
+
+// fromStorageValue converts a stored value back
+func fromStorageValue(value *pluginpb.StorageValue) interface{} {
+	switch kind := value.GetKind().(type) {
+	case *pluginpb.StorageValue_BytesValue:
+		return kind.BytesValue
+	case *pluginpb.StorageValue_Value:
+		return kind.Value.AsInterface()
+	}
+	return nil
+}
+
+// Ensure remoteAuthPlugin reports the organization with the user
+var _ OrgAuthenticator = (*remoteAuthPlugin)(nil)
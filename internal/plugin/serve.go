@@ -0,0 +1,349 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	pluginpb "galaxy-node-pool/proto/plugin"
+)
+
+// Serve runs a plugin as an out-of-process plugin. It is called from the main
+// function of a plugin executable and returns when the host shuts the plugin
+// down or exits. The plugin must implement exactly one of RegistryPlugin,
+// AuthPlugin, MetricsPlugin, StoragePlugin and FederationPlugin.
+func Serve(p Plugin, version string) error {
+	if os.Getenv(CookieEnv) != CookieValue {
+		return fmt.Errorf("%s is a Galaxy Node Pool plugin, place it in the plugin directory of pool-server instead of running it directly", p.Name())
+	}
+	socket := os.Getenv(SocketEnv)
+	if socket == "" {
+		return fmt.Errorf("%s is not set", SocketEnv)
+	}
+
+	var kinds []pluginpb.Kind
+	if _, ok := p.(RegistryPlugin); ok {
+		kinds = append(kinds, pluginpb.Kind_KIND_REGISTRY)
+	}
+	if _, ok := p.(AuthPlugin); ok {
+		kinds = append(kinds, pluginpb.Kind_KIND_AUTH)
+	}
+	if _, ok := p.(MetricsPlugin); ok {
+		kinds = append(kinds, pluginpb.Kind_KIND_METRICS)
+	}
+	if _, ok := p.(StoragePlugin); ok {
+		kinds = append(kinds, pluginpb.Kind_KIND_STORAGE)
+	}
+	if _, ok := p.(FederationPlugin); ok {
+		kinds = append(kinds, pluginpb.Kind_KIND_FEDERATION)
+	}
+	if len(kinds) != 1 {
+		return fmt.Errorf("plugin %s must implement exactly one plugin interface, it implements %d", p.Name(), len(kinds))
+	}
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", socket, err)
+	}
+
+	server := grpc.NewServer()
+	ps := &pluginServer{plugin: p, kind: kinds[0], version: version, server: server}
+	pluginpb.RegisterPluginServer(server, ps)
+	switch kinds[0] {
+	case pluginpb.Kind_KIND_REGISTRY:
+		pluginpb.RegisterRegistryPluginServer(server, &registryPluginServer{plugin: p.(RegistryPlugin)})
+	case pluginpb.Kind_KIND_AUTH:
+		pluginpb.RegisterAuthPluginServer(server, &authPluginServer{plugin: p.(AuthPlugin)})
+	case pluginpb.Kind_KIND_METRICS:
+		pluginpb.RegisterMetricsPluginServer(server, &metricsPluginServer{plugin: p.(MetricsPlugin)})
+	case pluginpb.Kind_KIND_STORAGE:
+		pluginpb.RegisterStoragePluginServer(server, &storagePluginServer{plugin: p.(StoragePlugin)})
+	case pluginpb.Kind_KIND_FEDERATION:
+		pluginpb.RegisterFederationPluginServer(server, &federationPluginServer{plugin: p.(FederationPlugin)})
+	}
+
+	// Stop when the host asks to or goes away without asking
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		parent := os.Getppid()
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-sigCh:
+			case <-ticker.C:
+				if os.Getppid() == parent {
+					continue
+				}
+				log.Printf("Host process exited, stopping plugin %s", p.Name())
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), stopTimeout)
+			ps.stop(ctx)
+			cancel()
+			return
+		}
+	}()
+
+	return server.Serve(listener)
+}
+
+// pluginServer serves the Plugin service
+type pluginServer struct {
+	pluginpb.UnimplementedPluginServer
+
+	plugin  Plugin
+	kind    pluginpb.Kind
+	version string
+	server  *grpc.Server
+
+	shutdown sync.Once
+}
+
+// Handshake answers with the highest protocol version both sides support
+func (s *pluginServer) Handshake(ctx context.Context, req *pluginpb.HandshakeRequest) (*pluginpb.HandshakeResponse, error) {
+	var version uint32
+	for _, offered := range req.ProtocolVersions {
+		for _, supported := range supportedProtocolVersions {
+			if offered == supported && offered > version {
+				version = offered
+			}
+		}
+	}
+	if version == 0 {
+		return nil, fmt.Errorf("no common protocol version, host offers %v, plugin supports %v", req.ProtocolVersions, supportedProtocolVersions)
+	}
+	return &pluginpb.HandshakeResponse{
+		ProtocolVersion: version,
+		Name:            s.plugin.Name(),
+		Kind:            s.kind,
+		Version:         s.version,
+	}, nil
+}
+
+// Initialize passes the configuration to the plugin
+func (s *pluginServer) Initialize(ctx context.Context, req *pluginpb.InitializeRequest) (*pluginpb.CallResponse, error) {
+	config := req.Config.AsMap()
+	if config == nil {
+		config = map[string]interface{}{}
+	}
+	return callResponse(s.plugin.Initialize(config)), nil
+}
+
+// Shutdown shuts the plugin down and stops serving once the response is sent
+func (s *pluginServer) Shutdown(ctx context.Context, req *pluginpb.ShutdownRequest) (*pluginpb.CallResponse, error) {
+	return callResponse(s.stop(ctx)), nil
+}
+
+// stop shuts the plugin down once and stops the server
+func (s *pluginServer) stop(ctx context.Context) error {
+	var err error
+	s.shutdown.Do(func() {
+		err = s.plugin.Shutdown(ctx)
+		go s.server.GracefulStop()
+	})
+	return err
+}
+
+// registryPluginServer serves the RegistryPlugin service
+type registryPluginServer struct {
+	pluginpb.UnimplementedRegistryPluginServer
+	plugin RegistryPlugin
+}
+
+func (s *registryPluginServer) OnNodeRegister(ctx context.Context, req *pluginpb.NodeRegisterRequest) (*pluginpb.NodeRegisterResponse, error) {
+	changes, err := s.plugin.OnNodeRegister(ctx, req.NodeId, req.Metadata.AsMap())
+	if err != nil {
+		return &pluginpb.NodeRegisterResponse{Error: err.Error()}, nil
+	}
+	resp := &pluginpb.NodeRegisterResponse{}
+	if changes != nil {
+		if changes.Labels != nil {
+			resp.ReplaceLabels = true
+			resp.Labels = changes.Labels
+		}
+		if len(changes.Metadata) > 0 {
+			metadata, err := toStruct(changes.Metadata)
+			if err != nil {
+				return &pluginpb.NodeRegisterResponse{Error: fmt.Sprintf("failed to encode metadata: %v", err)}, nil
+			}
+			resp.Metadata = metadata
+		}
+	}
+	return resp, nil
+}
+
+func (s *registryPluginServer) OnNodeHeartbeat(ctx context.Context, req *pluginpb.NodeRequest) (*pluginpb.CallResponse, error) {
+	return callResponse(s.plugin.OnNodeHeartbeat(ctx, req.NodeId)), nil
+}
+
+func (s *registryPluginServer) OnNodeList(ctx context.Context, req *pluginpb.NodeListRequest) (*pluginpb.NodeListResponse, error) {
+	nodes := make([]map[string]interface{}, 0, len(req.Nodes))
+	for _, node := range req.Nodes {
+		nodes = append(nodes, node.AsMap())
+	}
+	ids, err := s.plugin.OnNodeList(ctx, req.Filter, nodes)
+	if err != nil {
+		return &pluginpb.NodeListResponse{Error: err.Error()}, nil
+	}
+	return &pluginpb.NodeListResponse{Reordered: ids != nil, NodeIds: ids}, nil
+}
+
+func (s *registryPluginServer) OnNodeDeregister(ctx context.Context, req *pluginpb.NodeRequest) (*pluginpb.CallResponse, error) {
+	return callResponse(s.plugin.OnNodeDeregister(ctx, req.NodeId)), nil
+}
+
+// authPluginServer serves the AuthPlugin service
+type authPluginServer struct {
+	pluginpb.UnimplementedAuthPluginServer
+	plugin AuthPlugin
+}
+
+func (s *authPluginServer) Authenticate(ctx context.Context, req *pluginpb.AuthenticateRequest) (*pluginpb.AuthenticateResponse, error) {
+	// Plugins may report the organization with the user, or look it up
+	if authenticator, ok := s.plugin.(OrgAuthenticator); ok {
+		userID, org, err := authenticator.AuthenticateOrg(req.Credentials)
+		if err != nil {
+			return &pluginpb.AuthenticateResponse{Error: err.Error()}, nil
+		}
+		return &pluginpb.AuthenticateResponse{UserId: userID, Org: org}, nil
+	}
+
+	userID, err := s.plugin.Authenticate(req.Credentials)
+	if err != nil {
+		return &pluginpb.AuthenticateResponse{Error: err.Error()}, nil
+	}
+	resp := &pluginpb.AuthenticateResponse{UserId: userID}
+	if resolver, ok := s.plugin.(OrgResolver); ok {
+		resp.Org = resolver.UserOrg(userID)
+	}
+	return resp, nil
+}
+
+func (s *authPluginServer) Authorize(ctx context.Context, req *pluginpb.AuthorizeRequest) (*pluginpb.AuthorizeResponse, error) {
+	allowed, err := s.plugin.Authorize(req.UserId, req.Resource, req.Action)
+	if err != nil {
+		return &pluginpb.AuthorizeResponse{Error: err.Error()}, nil
+	}
+	return &pluginpb.AuthorizeResponse{Allowed: allowed}, nil
+}
+
+// metricsPluginServer serves the MetricsPlugin service
+type metricsPluginServer struct {
+	pluginpb.UnimplementedMetricsPluginServer
+	plugin MetricsPlugin
+}
+
+func (s *metricsPluginServer) RecordMetric(ctx context.Context, req *pluginpb.RecordMetricRequest) (*pluginpb.CallResponse, error) {
+	return callResponse(s.plugin.RecordMetric(req.Name, req.Value, req.Labels)), nil
+}
+
+func (s *metricsPluginServer) GetMetrics(ctx context.Context, req *pluginpb.GetMetricsRequest) (*pluginpb.GetMetricsResponse, error) {
+	metrics, err := s.plugin.GetMetrics()
+	if err != nil {
+		return &pluginpb.GetMetricsResponse{Error: err.Error()}, nil
+	}
+	m, err := toStruct(metrics)
+	if err != nil {
+		return &pluginpb.GetMetricsResponse{Error: fmt.Sprintf("failed to encode metrics: %v", err)}, nil
+	}
+	return &pluginpb.GetMetricsResponse{Metrics: m}, nil
+}
+
+// storagePluginServer serves the StoragePlugin service
+type storagePluginServer struct {
+	pluginpb.UnimplementedStoragePluginServer
+	plugin StoragePlugin
+}
+
+func (s *storagePluginServer) Store(ctx context.Context, req *pluginpb.StoreRequest) (*pluginpb.CallResponse, error) {
+	return callResponse(s.plugin.Store(req.Key, fromStorageValue(req.Value))), nil
+}
+
+func (s *storagePluginServer) Retrieve(ctx context.Context, req *pluginpb.KeyRequest) (*pluginpb.RetrieveResponse, error) {
+	value, err := s.plugin.Retrieve(req.Key)
+	if err != nil {
+		return &pluginpb.RetrieveResponse{Error: err.Error()}, nil
+	}
+	v, err := toStorageValue(value)
+	if err != nil {
+		return &pluginpb.RetrieveResponse{Error: fmt.Sprintf("failed to encode value of %s: %v", req.Key, err)}, nil
+	}
+	return &pluginpb.RetrieveResponse{Value: v}, nil
+}
+
+func (s *storagePluginServer) Delete(ctx context.Context, req *pluginpb.KeyRequest) (*pluginpb.CallResponse, error) {
+	return callResponse(s.plugin.Delete(req.Key)), nil
+}
+
+func (s *storagePluginServer) List(ctx context.Context, req *pluginpb.ListKeysRequest) (*pluginpb.ListKeysResponse, error) {
+	keys, err := s.plugin.List(req.Prefix)
+	if err != nil {
+		return &pluginpb.ListKeysResponse{Error: err.Error()}, nil
+	}
+	return &pluginpb.ListKeysResponse{Keys: keys}, nil
+}
+
+// federationPluginServer serves the FederationPlugin service
+type federationPluginServer struct {
+	pluginpb.UnimplementedFederationPluginServer
+	plugin FederationPlugin
+}
+
+func (s *federationPluginServer) RegisterWithMainNet(ctx context.Context, req *pluginpb.RegisterWithMainNetRequest) (*pluginpb.CallResponse, error) {
+	return callResponse(s.plugin.RegisterWithMainNet(req.MainNetUrl, req.PoolMetadata.AsMap())), nil
+}
+
+func (s *federationPluginServer) DiscoverPools(ctx context.Context, req *pluginpb.DiscoverPoolsRequest) (*pluginpb.DiscoverPoolsResponse, error) {
+	pools, err := s.plugin.DiscoverPools(req.Filter)
+	if err != nil {
+		return &pluginpb.DiscoverPoolsResponse{Error: err.Error()}, nil
+	}
+	resp := &pluginpb.DiscoverPoolsResponse{Pools: make([]*structpb.Struct, 0, len(pools))}
+	for _, pool := range pools {
+		p, err := toStruct(pool)
+		if err != nil {
+			return &pluginpb.DiscoverPoolsResponse{Error: fmt.Sprintf("failed to encode pool: %v", err)}, nil
+		}
+		resp.Pools = append(resp.Pools, p)
+	}
+	return resp, nil
+}
+
+func (s *federationPluginServer) SyncWithPeers(ctx context.Context, req *pluginpb.SyncWithPeersRequest) (*pluginpb.CallResponse, error) {
+	return callResponse(s.plugin.SyncWithPeers()), nil
+}
+
+func (s *federationPluginServer) VerifyNodePayment(ctx context.Context, req *pluginpb.VerifyNodePaymentRequest) (*pluginpb.VerifyNodePaymentResponse, error) {
+	verified, err := s.plugin.VerifyNodePayment(req.NodeId, req.NodeAccount)
+	if err != nil {
+		return &pluginpb.VerifyNodePaymentResponse{Error: err.Error()}, nil
+	}
+	return &pluginpb.VerifyNodePaymentResponse{Verified: verified}, nil
+}
+
+func (s *federationPluginServer) DistributeRewards(ctx context.Context, req *pluginpb.DistributeRewardsRequest) (*pluginpb.CallResponse, error) {
+	return callResponse(s.plugin.DistributeRewards(req.TotalFees, req.StakerAccounts)), nil
+}
+
+// callResponse carries an error of the plugin in a response
+func callResponse(err error) *pluginpb.CallResponse {
+	if err != nil {
+		return &pluginpb.CallResponse{Error: err.Error()}
+	}
+	return &pluginpb.CallResponse{}
+}
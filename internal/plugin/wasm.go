@@ -0,0 +1,476 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+// Default limits of a single WASM hook call
+const (
+	DefaultWASMMemoryLimitMB = 16
+	DefaultWASMFuel          = 10000000
+	DefaultWASMTimeout       = 100 * time.Millisecond
+)
+
+// WASM hook exports, each taking the pointer and length of a JSON request
+// and returning the pointer and length of a JSON response packed into an
+// i64 (pointer in the high 32 bits), or 0 for no response
+const (
+	wasmOnNodeRegister   = "on_node_register"
+	wasmOnNodeHeartbeat  = "on_node_heartbeat"
+	wasmOnNodeList       = "on_node_list"
+	wasmOnNodeDeregister = "on_node_deregister"
+
+	// wasmAlloc is exported by modules to allocate the request buffer
+	wasmAlloc = "alloc"
+
+	// wasmHostModule is the module of the host functions modules may import
+	wasmHostModule = "galaxy"
+)
+
+// wasmLimitSettings are the config settings of the limits, they are read by
+// the host and need not be listed in a manifest's config schema
+var wasmLimitSettings = []string{"memory_limit_mb", "fuel", "timeout"}
+
+// wasmPageSize is the size of a WASM memory page
+const wasmPageSize = 64 * 1024
+
+// WASMPlugin runs RegistryPlugin hooks in a WebAssembly module. Each call
+// runs in a fresh instance of the module limited in memory, fuel and time, so
+// modules cannot keep state between calls or reach the host beyond the
+// galaxy host module and WASI without file system access.
+type WASMPlugin struct {
+	name string
+	code []byte
+
+	mu       sync.RWMutex
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+	hooks    map[string]bool
+	config   map[string]interface{}
+	fuel     int64
+	timeout  time.Duration
+	output   *pluginLog
+}
+
+// NewWASMPlugin creates a WASM plugin from the code of a module. The module
+// is compiled when the plugin is initialized.
+func NewWASMPlugin(name string, code []byte) *WASMPlugin {
+	return &WASMPlugin{name: name, code: code, output: &pluginLog{name: name}}
+}
+
+// wasmRequest is the JSON request passed to hooks
+type wasmRequest struct {
+	NodeID   string                   `json:"node_id,omitempty"`
+	Metadata map[string]interface{}   `json:"metadata,omitempty"`
+	Filter   map[string]string        `json:"filter,omitempty"`
+	Nodes    []map[string]interface{} `json:"nodes,omitempty"`
+	Config   map[string]interface{}   `json:"config"`
+}
+
+// wasmResponse is the JSON response of hooks. A non-empty error rejects the
+// operation. Labels and metadata change a registering node, nodes are the
+// IDs a listing returns.
+type wasmResponse struct {
+	Error    string                 `json:"error,omitempty"`
+	Labels   map[string]string      `json:"labels,omitempty"`
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+	Nodes    []string               `json:"nodes,omitempty"`
+}
+
+// Name returns the plugin name
+func (p *WASMPlugin) Name() string {
+	return p.name
+}
+
+// Initialize compiles the module with the limits of its configuration. The
+// whole configuration is passed to the module with each call.
+//
+// Limits:
+//
+//	memory_limit_mb: memory a call may use, default 16
+//	fuel: instructions a call may execute, including the module's
+//	_initialize, default 10000000
+//	timeout: time a call may take, default 100ms
+func (p *WASMPlugin) Initialize(config map[string]interface{}) error {
+	memoryLimit, err := intSetting(config, "memory_limit_mb", DefaultWASMMemoryLimitMB)
+	if err != nil {
+		return err
+	}
+	fuel, err := intSetting(config, "fuel", DefaultWASMFuel)
+	if err != nil {
+		return err
+	}
+	timeout := DefaultWASMTimeout
+	if value, ok := config["timeout"].(string); ok && value != "" {
+		timeout, err = time.ParseDuration(value)
+		if err != nil || timeout <= 0 {
+			return fmt.Errorf("invalid timeout %q", value)
+		}
+	}
+
+	ctx := context.Background()
+	runtime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(uint32(memoryLimit*1024*1024/wasmPageSize)).
+		WithCloseOnContextDone(true))
+
+	compiled, hooks, err := p.compile(ctx, runtime, int64(fuel))
+	if err != nil {
+		runtime.Close(ctx)
+		return err
+	}
+
+	p.mu.Lock()
+	old := p.runtime
+	p.runtime = runtime
+	p.compiled = compiled
+	p.hooks = hooks
+	p.config = config
+	p.fuel = int64(fuel)
+	p.timeout = timeout
+	p.mu.Unlock()
+
+	if old != nil {
+		old.Close(ctx)
+	}
+
+	log.Printf("WASM plugin %s initialized with hooks %v (memory %d MB, fuel %d, timeout %s)",
+		p.name, hookNames(hooks), memoryLimit, fuel, timeout)
+	return nil
+}
+
+// compile instantiates the host modules and compiles the plugin module
+// metered with fuel, checking it exports what the host calls
+func (p *WASMPlugin) compile(ctx context.Context, runtime wazero.Runtime, fuel int64) (wazero.CompiledModule, map[string]bool, error) {
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, runtime); err != nil {
+		return nil, nil, fmt.Errorf("failed to instantiate WASI: %v", err)
+	}
+	_, err := runtime.NewHostModuleBuilder(wasmHostModule).
+		NewFunctionBuilder().WithFunc(p.hostLog).Export("log").
+		Instantiate(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to instantiate host module: %v", err)
+	}
+
+	code, err := meterFuel(p.code, fuel)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to meter module: %v", err)
+	}
+	compiled, err := runtime.CompileModule(ctx, code)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to compile module: %v", err)
+	}
+
+	for _, imported := range compiled.ImportedFunctions() {
+		module, name, _ := imported.Import()
+		if module != wasmHostModule && module != wasi_snapshot_preview1.ModuleName {
+			return nil, nil, fmt.Errorf("module imports %s.%s, only %s and %s functions are available",
+				module, name, wasmHostModule, wasi_snapshot_preview1.ModuleName)
+		}
+	}
+
+	exports := compiled.ExportedFunctions()
+	if _, ok := compiled.ExportedMemories()["memory"]; !ok {
+		return nil, nil, fmt.Errorf("module does not export its memory")
+	}
+	if !hasSignature(exports[wasmAlloc], []api.ValueType{api.ValueTypeI32}, []api.ValueType{api.ValueTypeI32}) {
+		return nil, nil, fmt.Errorf("module does not export %s(i32) i32", wasmAlloc)
+	}
+
+	hooks := make(map[string]bool)
+	for _, hook := range []string{wasmOnNodeRegister, wasmOnNodeHeartbeat, wasmOnNodeList, wasmOnNodeDeregister} {
+		def, ok := exports[hook]
+		if !ok {
+			continue
+		}
+		if !hasSignature(def, []api.ValueType{api.ValueTypeI32, api.ValueTypeI32}, []api.ValueType{api.ValueTypeI64}) {
+			return nil, nil, fmt.Errorf("hook %s must have signature (i32, i32) i64", hook)
+		}
+		hooks[hook] = true
+	}
+	if len(hooks) == 0 {
+		return nil, nil, fmt.Errorf("module exports none of the hooks %s, %s, %s, %s",
+			wasmOnNodeRegister, wasmOnNodeHeartbeat, wasmOnNodeList, wasmOnNodeDeregister)
+	}
+	return compiled, hooks, nil
+}
+
+// Shutdown releases the runtime of the plugin
+func (p *WASMPlugin) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	runtime := p.runtime
+	p.runtime = nil
+	p.compiled = nil
+	p.mu.Unlock()
+
+	if runtime == nil {
+		return nil
+	}
+	return runtime.Close(ctx)
+}
+
+// OnNodeRegister is called when a node registers. The module may reject the
+// node or change its labels and metadata.
+func (p *WASMPlugin) OnNodeRegister(ctx context.Context, nodeID string, metadata map[string]interface{}) (*NodeChanges, error) {
+	resp, err := p.call(ctx, wasmOnNodeRegister, &wasmRequest{NodeID: nodeID, Metadata: metadata})
+	if err != nil || resp == nil {
+		return nil, err
+	}
+	if resp.Labels == nil && resp.Metadata == nil {
+		return nil, nil
+	}
+	return &NodeChanges{Labels: resp.Labels, Metadata: resp.Metadata}, nil
+}
+
+// OnNodeHeartbeat is called when a node sends a heartbeat
+func (p *WASMPlugin) OnNodeHeartbeat(ctx context.Context, nodeID string) error {
+	_, err := p.call(ctx, wasmOnNodeHeartbeat, &wasmRequest{NodeID: nodeID})
+	return err
+}
+
+// OnNodeList is called with the nodes a listing returns. The module may
+// filter and reorder them.
+func (p *WASMPlugin) OnNodeList(ctx context.Context, filter map[string]string, nodes []map[string]interface{}) ([]string, error) {
+	resp, err := p.call(ctx, wasmOnNodeList, &wasmRequest{Filter: filter, Nodes: nodes})
+	if err != nil || resp == nil {
+		return nil, err
+	}
+	return resp.Nodes, nil
+}
+
+// OnNodeDeregister is called when a node is removed
+func (p *WASMPlugin) OnNodeDeregister(ctx context.Context, nodeID string) error {
+	_, err := p.call(ctx, wasmOnNodeDeregister, &wasmRequest{NodeID: nodeID})
+	return err
+}
+
+// call runs a hook in a fresh instance of the module within ctx. Hooks the
+// module does not export succeed without a response. Traps and exceeded
+// limits fail with a FailureError.
+func (p *WASMPlugin) call(parent context.Context, hook string, req *wasmRequest) (*wasmResponse, error) {
+	p.mu.RLock()
+	runtime, compiled, exported := p.runtime, p.compiled, p.hooks[hook]
+	req.Config = p.config
+	fuel, timeout := p.fuel, p.timeout
+	p.mu.RUnlock()
+
+	if runtime == nil {
+		return nil, &FailureError{Err: fmt.Errorf("plugin %s is not initialized", p.name)}
+	}
+	if !exported {
+		return nil, nil
+	}
+
+	input, err := json.Marshal(req)
+	if err != nil {
+		return nil, &FailureError{Err: fmt.Errorf("plugin %s: failed to encode request: %v", p.name, err)}
+	}
+
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+
+	var module api.Module
+	fail := func(stage string, err error) (*wasmResponse, error) {
+		switch {
+		case outOfFuel(module):
+			err = fmt.Errorf("plugin %s: %s ran out of fuel (%d instructions)", p.name, hook, fuel)
+		case parent.Err() != nil:
+			err = fmt.Errorf("plugin %s: %s was canceled: %v", p.name, hook, parent.Err())
+		case ctx.Err() == context.DeadlineExceeded:
+			err = fmt.Errorf("plugin %s: %s exceeded its time limit of %s", p.name, hook, timeout)
+		default:
+			// Traps carry a multi-line stack trace, the first line names the trap
+			message := err.Error()
+			if i := strings.IndexByte(message, '\n'); i >= 0 {
+				message = message[:i]
+			}
+			err = fmt.Errorf("plugin %s: %s %s failed: %s", p.name, hook, stage, message)
+		}
+		return nil, &FailureError{Err: err}
+	}
+
+	// The module is initialized separately so that running out of fuel
+	// there can be told apart from other failures
+	module, err = runtime.InstantiateModule(ctx, compiled, wazero.NewModuleConfig().
+		WithName("").
+		WithStartFunctions().
+		WithStdout(p.output).
+		WithStderr(p.output))
+	if err != nil {
+		return fail("instantiation", err)
+	}
+	defer module.Close(context.Background())
+	if initialize := module.ExportedFunction("_initialize"); initialize != nil {
+		if _, err := initialize.Call(ctx); err != nil {
+			return fail("instantiation", err)
+		}
+	}
+
+	results, err := module.ExportedFunction(wasmAlloc).Call(ctx, uint64(len(input)))
+	if err != nil {
+		return fail(wasmAlloc, err)
+	}
+	ptr := uint32(results[0])
+	if !module.Memory().Write(ptr, input) {
+		return fail(wasmAlloc, fmt.Errorf("returned buffer %d+%d is out of memory range", ptr, len(input)))
+	}
+
+	results, err = module.ExportedFunction(hook).Call(ctx, uint64(ptr), uint64(len(input)))
+	if err != nil {
+		return fail("call", err)
+	}
+	if results[0] == 0 {
+		return nil, nil
+	}
+
+	outPtr, outLen := uint32(results[0]>>32), uint32(results[0])
+	output, ok := module.Memory().Read(outPtr, outLen)
+	if !ok {
+		return fail("call", fmt.Errorf("response %d+%d is out of memory range", outPtr, outLen))
+	}
+	resp := &wasmResponse{}
+	if err := json.Unmarshal(output, resp); err != nil {
+		return fail("call", fmt.Errorf("invalid response: %v", err))
+	}
+	if resp.Error != "" {
+		return resp, fmt.Errorf("%s", resp.Error)
+	}
+	return resp, nil
+}
+
+// hostLog implements galaxy.log(ptr, len), writing a message to the pool log
+func (p *WASMPlugin) hostLog(ctx context.Context, module api.Module, ptr, length uint32) {
+	if length > 4096 {
+		length = 4096
+	}
+	if message, ok := module.Memory().Read(ptr, length); ok {
+		log.Printf("plugin %s: %s", p.name, message)
+	}
+}
+
+// outOfFuel reports whether an instance of a metered module used up its fuel
+func outOfFuel(module api.Module) bool {
+	if module == nil {
+		return false
+	}
+	fuel := module.ExportedGlobal(wasmFuelGlobal)
+	return fuel != nil && int64(fuel.Get()) < 0
+}
+
+// hasSignature reports whether an exported function has the given signature
+func hasSignature(def api.FunctionDefinition, params, results []api.ValueType) bool {
+	if def == nil || len(def.ParamTypes()) != len(params) || len(def.ResultTypes()) != len(results) {
+		return false
+	}
+	for i, param := range params {
+		if def.ParamTypes()[i] != param {
+			return false
+		}
+	}
+	for i, result := range results {
+		if def.ResultTypes()[i] != result {
+			return false
+		}
+	}
+	return true
+}
+
+// hookNames lists the hooks a module exports in call order
+func hookNames(hooks map[string]bool) []string {
+	var names []string
+	for _, hook := range []string{wasmOnNodeRegister, wasmOnNodeHeartbeat, wasmOnNodeList, wasmOnNodeDeregister} {
+		if hooks[hook] {
+			names = append(names, hook)
+		}
+	}
+	return names
+}
+
+// intSetting reads a positive integer setting of a plugin configuration
+func intSetting(config map[string]interface{}, key string, fallback int) (int, error) {
+	value, ok := config[key]
+	if !ok || value == nil {
+		return fallback, nil
+	}
+	var n int
+	switch v := value.(type) {
+	case int:
+		n = v
+	case int64:
+		n = int(v)
+	case float64:
+		n = int(v)
+		if float64(n) != v {
+			return 0, fmt.Errorf("invalid %s %v, expected an integer", key, value)
+		}
+	default:
+		return 0, fmt.Errorf("invalid %s %v, expected an integer", key, value)
+	}
+	if n <= 0 {
+		return 0, fmt.Errorf("invalid %s %d, must be positive", key, n)
+	}
+	return n, nil
+}
+
+// LoadWASMPlugin verifies and loads a WebAssembly module as a
+// RegistryPlugin, then initializes and registers it. Failures are returned
+// as *LoadError.
+func (pm *PluginManager) LoadWASMPlugin(path, name string, config map[string]interface{}) error {
+	fail := func(stage string, err error) error {
+		return &LoadError{Path: path, Plugin: name, Stage: stage, Err: err}
+	}
+
+	verification, err := pm.verifyFile(path, name)
+	if err != nil {
+		return fail(StageVerify, err)
+	}
+	manifest := verification.Manifest
+	if manifest != nil {
+		if err := manifest.CheckConfig(config, wasmLimitSettings...); err != nil {
+			return fail(StageVerify, err)
+		}
+	}
+
+	code, err := os.ReadFile(path)
+	if err != nil {
+		return fail(StageOpen, err)
+	}
+	if err := checkVerifiedCode(path, code, manifest); err != nil {
+		return fail(StageVerify, err)
+	}
+
+	instance := NewWASMPlugin(name, code)
+	if manifest != nil {
+		if err := manifest.CheckInterfaces(instance); err != nil {
+			return fail(StageVerify, err)
+		}
+	}
+	if config == nil {
+		config = map[string]interface{}{}
+	}
+	if err := instance.Initialize(config); err != nil {
+		return fail(StageInitialize, err)
+	}
+
+	if err := pm.Register(name, instance); err != nil {
+		instance.Shutdown(context.Background())
+		return fail(StageRegister, err)
+	}
+
+	pm.mu.Lock()
+	pm.initializedPlugins[name] = true
+	pm.mu.Unlock()
+	return nil
+}
+
+var _ RegistryPlugin = (*WASMPlugin)(nil)
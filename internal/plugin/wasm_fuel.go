@@ -0,0 +1,482 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// wasmFuelGlobal is the export name of the fuel counter injected into modules
+const wasmFuelGlobal = "galaxy_fuel"
+
+// WASM section IDs used by fuel metering
+const (
+	wasmSectionCustom = 0
+	wasmSectionImport = 2
+	wasmSectionGlobal = 6
+	wasmSectionExport = 7
+	wasmSectionCode   = 10
+)
+
+// wasmSectionOrder is the order non-custom sections must appear in
+var wasmSectionOrder = map[byte]int{1: 1, 2: 2, 3: 3, 4: 4, 5: 5, 13: 6, 6: 7, 7: 8, 8: 9, 9: 10, 12: 11, 10: 12, 11: 13}
+
+// meterFuel rewrites a module so that every instruction it executes burns
+// one unit of fuel from a mutable i64 global, exported as galaxy_fuel and
+// starting at fuel. Each straight-line run of instructions is charged when
+// it starts, at function entry and after block, loop, if, else, end and
+// br_if, so loops pay on every iteration. A module that runs out traps with
+// unreachable, leaving the counter negative.
+//
+// Debug sections are dropped, their code offsets no longer match.
+func meterFuel(code []byte, fuel int64) ([]byte, error) {
+	if len(code) < 8 || !bytes.Equal(code[:4], []byte("\x00asm")) {
+		return nil, fmt.Errorf("not a WebAssembly module")
+	}
+	if binary.LittleEndian.Uint32(code[4:8]) != 1 {
+		return nil, fmt.Errorf("unsupported WebAssembly version %d", binary.LittleEndian.Uint32(code[4:8]))
+	}
+
+	type section struct {
+		id      byte
+		content []byte
+	}
+	var sections []section
+	r := &wasmReader{b: code, pos: 8}
+	for !r.done() {
+		id := r.byte()
+		size := r.u32()
+		content := r.bytes(int(size))
+		if r.err != nil {
+			return nil, fmt.Errorf("malformed section %d: %v", id, r.err)
+		}
+		if id == wasmSectionCustom && isDebugSection(content) {
+			continue
+		}
+		sections = append(sections, section{id: id, content: content})
+	}
+
+	// The counter is appended to the module's globals, after the imported
+	// ones, so existing global indices don't change
+	var importedGlobals, definedGlobals uint32
+	for _, s := range sections {
+		switch s.id {
+		case wasmSectionImport:
+			n, err := countImportedGlobals(s.content)
+			if err != nil {
+				return nil, fmt.Errorf("malformed import section: %v", err)
+			}
+			importedGlobals = n
+		case wasmSectionGlobal:
+			r := &wasmReader{b: s.content}
+			definedGlobals = r.u32()
+			if r.err != nil {
+				return nil, fmt.Errorf("malformed global section: %v", r.err)
+			}
+		}
+	}
+	fuelGlobal := importedGlobals + definedGlobals
+
+	global := []byte{0x7E, 0x01, 0x42} // mut i64 = i64.const fuel
+	global = appendSLEB(global, fuel)
+	global = append(global, 0x0B)
+
+	export := appendULEB(nil, uint32(len(wasmFuelGlobal)))
+	export = append(export, wasmFuelGlobal...)
+	export = append(export, 0x03) // global
+	export = appendULEB(export, fuelGlobal)
+
+	var out []byte
+	out = append(out, code[:8]...)
+	writeSection := func(id byte, content []byte) {
+		out = append(out, id)
+		out = appendULEB(out, uint32(len(content)))
+		out = append(out, content...)
+	}
+
+	added := map[byte]bool{}
+	addMissing := func(before byte) {
+		for _, id := range []byte{wasmSectionGlobal, wasmSectionExport} {
+			if added[id] || wasmSectionOrder[id] >= wasmSectionOrder[before] {
+				continue
+			}
+			if id == wasmSectionGlobal {
+				writeSection(id, append([]byte{1}, global...))
+			} else {
+				writeSection(id, append([]byte{1}, export...))
+			}
+			added[id] = true
+		}
+	}
+
+	for _, s := range sections {
+		if s.id != wasmSectionCustom {
+			addMissing(s.id)
+		}
+		switch s.id {
+		case wasmSectionGlobal:
+			writeSection(s.id, appendToVector(s.content, global))
+			added[s.id] = true
+		case wasmSectionExport:
+			content, err := appendExport(s.content, export)
+			if err != nil {
+				return nil, fmt.Errorf("malformed export section: %v", err)
+			}
+			writeSection(s.id, content)
+			added[s.id] = true
+		case wasmSectionCode:
+			content, err := meterCode(s.content, fuelGlobal)
+			if err != nil {
+				return nil, err
+			}
+			writeSection(s.id, content)
+		default:
+			writeSection(s.id, s.content)
+		}
+	}
+	addMissing(0xFF)
+
+	return out, nil
+}
+
+// isDebugSection reports whether a custom section holds DWARF debug info
+func isDebugSection(content []byte) bool {
+	r := &wasmReader{b: content}
+	name := r.name()
+	return r.err == nil && strings.HasPrefix(name, ".debug_")
+}
+
+// countImportedGlobals returns the number of globals a module imports
+func countImportedGlobals(content []byte) (uint32, error) {
+	r := &wasmReader{b: content}
+	var globals uint32
+	for n := r.u32(); n > 0 && r.err == nil; n-- {
+		r.name()
+		r.name()
+		switch kind := r.byte(); kind {
+		case 0x00: // func
+			r.u32()
+		case 0x01: // table
+			r.byte()
+			r.limits()
+		case 0x02: // memory
+			r.limits()
+		case 0x03: // global
+			r.byte()
+			r.byte()
+			globals++
+		case 0x04: // tag
+			r.byte()
+			r.u32()
+		default:
+			return 0, fmt.Errorf("unknown import kind %#x", kind)
+		}
+	}
+	return globals, r.err
+}
+
+// appendExport adds an export, refusing modules that already use its name
+func appendExport(content, export []byte) ([]byte, error) {
+	r := &wasmReader{b: content}
+	for n := r.u32(); n > 0 && r.err == nil; n-- {
+		if r.name() == wasmFuelGlobal {
+			return nil, fmt.Errorf("module exports %s, which is reserved for the host", wasmFuelGlobal)
+		}
+		r.byte()
+		r.u32()
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return appendToVector(content, export), nil
+}
+
+// appendToVector appends an entry to the vector a section consists of
+func appendToVector(content, entry []byte) []byte {
+	r := &wasmReader{b: content}
+	n := r.u32()
+	out := appendULEB(nil, n+1)
+	out = append(out, content[r.pos:]...)
+	return append(out, entry...)
+}
+
+// meterCode instruments every function body of a code section
+func meterCode(content []byte, fuelGlobal uint32) ([]byte, error) {
+	r := &wasmReader{b: content}
+	n := r.u32()
+	out := appendULEB(nil, n)
+	for i := uint32(0); i < n && r.err == nil; i++ {
+		size := r.u32()
+		body := r.bytes(int(size))
+		if r.err != nil {
+			break
+		}
+		metered, err := meterBody(body, fuelGlobal)
+		if err != nil {
+			return nil, fmt.Errorf("function body %d: %v", i, err)
+		}
+		out = appendULEB(out, uint32(len(metered)))
+		out = append(out, metered...)
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("malformed code section: %v", r.err)
+	}
+	return out, nil
+}
+
+// meterBody charges each straight-line run of instructions of a function
+// body before it runs
+func meterBody(body []byte, fuelGlobal uint32) ([]byte, error) {
+	r := &wasmReader{b: body}
+	for n := r.u32(); n > 0 && r.err == nil; n-- {
+		r.u32()
+		r.byte()
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("malformed locals: %v", r.err)
+	}
+	locals := body[:r.pos]
+
+	// Split the instructions into runs, each ending with an instruction
+	// after which control may continue elsewhere than the next instruction
+	type run struct {
+		start, end int
+		count      int64
+	}
+	var runs []run
+	current := run{start: r.pos}
+	depth := 0
+	for {
+		if r.done() {
+			return nil, fmt.Errorf("missing end of function")
+		}
+		op, err := r.instruction()
+		if err != nil {
+			return nil, err
+		}
+		current.count++
+
+		switch op {
+		case 0x02, 0x03, 0x04: // block, loop, if
+			depth++
+		case 0x0B: // end
+			depth--
+		case 0x05, 0x0D: // else, br_if
+		default:
+			continue
+		}
+		current.end = r.pos
+		runs = append(runs, current)
+		current = run{start: r.pos}
+		if depth < 0 {
+			break
+		}
+	}
+	if !r.done() {
+		return nil, fmt.Errorf("instructions after end of function")
+	}
+
+	out := append([]byte(nil), locals...)
+	for _, run := range runs {
+		out = appendFuelCharge(out, fuelGlobal, run.count)
+		out = append(out, body[run.start:run.end]...)
+	}
+	return out, nil
+}
+
+// appendFuelCharge appends code subtracting cost from the fuel counter and
+// trapping when it goes negative
+func appendFuelCharge(out []byte, fuelGlobal uint32, cost int64) []byte {
+	out = append(out, 0x23) // global.get
+	out = appendULEB(out, fuelGlobal)
+	out = append(out, 0x42) // i64.const
+	out = appendSLEB(out, cost)
+	out = append(out, 0x7D, 0x24) // i64.sub, global.set
+	out = appendULEB(out, fuelGlobal)
+	out = append(out, 0x23) // global.get
+	out = appendULEB(out, fuelGlobal)
+	// i64.const 0, i64.lt_s, if, unreachable, end
+	return append(out, 0x42, 0x00, 0x53, 0x04, 0x40, 0x00, 0x0B)
+}
+
+// wasmReader decodes the WebAssembly binary format. The first error is kept
+// and further reads return zero values.
+type wasmReader struct {
+	b   []byte
+	pos int
+	err error
+}
+
+func (r *wasmReader) done() bool {
+	return r.err != nil || r.pos >= len(r.b)
+}
+
+func (r *wasmReader) fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+func (r *wasmReader) byte() byte {
+	if r.err != nil {
+		return 0
+	}
+	if r.pos >= len(r.b) {
+		r.fail(fmt.Errorf("unexpected end of data"))
+		return 0
+	}
+	b := r.b[r.pos]
+	r.pos++
+	return b
+}
+
+func (r *wasmReader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(r.b)-r.pos {
+		r.fail(fmt.Errorf("unexpected end of data"))
+		return nil
+	}
+	b := r.b[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+// leb reads a LEB128 integer of at most maxBytes bytes
+func (r *wasmReader) leb(maxBytes int) uint64 {
+	var value uint64
+	for i := 0; i < maxBytes; i++ {
+		b := r.byte()
+		if r.err != nil {
+			return 0
+		}
+		value |= uint64(b&0x7F) << (7 * i)
+		if b&0x80 == 0 {
+			return value
+		}
+	}
+	r.fail(fmt.Errorf("integer too long"))
+	return 0
+}
+
+func (r *wasmReader) u32() uint32 {
+	return uint32(r.leb(5))
+}
+
+func (r *wasmReader) name() string {
+	return string(r.bytes(int(r.u32())))
+}
+
+func (r *wasmReader) limits() {
+	flags := r.byte()
+	r.leb(10)
+	if flags&0x01 != 0 {
+		r.leb(10)
+	}
+}
+
+// memarg skips the alignment and offset of a memory instruction
+func (r *wasmReader) memarg() {
+	r.u32()
+	r.leb(10)
+}
+
+// instruction skips an instruction with its immediates and returns its
+// opcode. Prefixed instructions return the prefix.
+func (r *wasmReader) instruction() (byte, error) {
+	op := r.byte()
+	switch {
+	case op == 0x00, op == 0x01, op == 0x05, op == 0x0B, op == 0x0F,
+		op == 0x1A, op == 0x1B, op == 0xD1,
+		op >= 0x45 && op <= 0xC4:
+		// no immediates
+	case op >= 0x02 && op <= 0x04, op == 0x0C, op == 0x0D, op == 0x10, op == 0x12,
+		op >= 0x20 && op <= 0x26, op == 0x3F, op == 0x40, op == 0xD0, op == 0xD2:
+		// block types, labels, functions, locals, globals, tables, memories
+		// and heap types
+		r.leb(5)
+	case op == 0x0E: // br_table
+		for n := r.u32(); n > 0 && r.err == nil; n-- {
+			r.u32()
+		}
+		r.u32()
+	case op == 0x11, op == 0x13: // call_indirect, return_call_indirect
+		r.u32()
+		r.u32()
+	case op == 0x1C: // select t
+		for n := r.u32(); n > 0 && r.err == nil; n-- {
+			r.byte()
+		}
+	case op >= 0x28 && op <= 0x3E: // loads and stores
+		r.memarg()
+	case op == 0x41: // i32.const
+		r.leb(5)
+	case op == 0x42: // i64.const
+		r.leb(10)
+	case op == 0x43: // f32.const
+		r.bytes(4)
+	case op == 0x44: // f64.const
+		r.bytes(8)
+	case op == 0xFC:
+		switch sub := r.u32(); {
+		case sub <= 7: // saturating truncation
+		case sub == 8, sub == 10, sub == 12, sub == 14: // memory.init, memory.copy, table.init, table.copy
+			r.u32()
+			r.u32()
+		case sub <= 17: // data.drop, memory.fill, elem.drop, table.grow, table.size, table.fill
+			r.u32()
+		default:
+			return 0, fmt.Errorf("unsupported instruction 0xfc %d", sub)
+		}
+	case op == 0xFD:
+		switch sub := r.u32(); {
+		case sub <= 11, sub == 92, sub == 93: // loads and stores
+			r.memarg()
+		case sub == 12, sub == 13: // v128.const, i8x16.shuffle
+			r.bytes(16)
+		case sub >= 21 && sub <= 34: // extract and replace lane
+			r.byte()
+		case sub >= 84 && sub <= 91: // lane loads and stores
+			r.memarg()
+			r.byte()
+		}
+	case op == 0xFE:
+		if sub := r.u32(); sub == 3 { // atomic.fence
+			r.byte()
+		} else {
+			r.memarg()
+		}
+	default:
+		return 0, fmt.Errorf("unsupported instruction %#x", op)
+	}
+	if r.err != nil {
+		return 0, fmt.Errorf("malformed instruction %#x: %v", op, r.err)
+	}
+	return op, nil
+}
+
+// appendULEB appends an unsigned LEB128 integer
+func appendULEB(out []byte, v uint32) []byte {
+	for {
+		b := byte(v & 0x7F)
+		v >>= 7
+		if v == 0 {
+			return append(out, b)
+		}
+		out = append(out, b|0x80)
+	}
+}
+
+// appendSLEB appends a signed LEB128 integer
+func appendSLEB(out []byte, v int64) []byte {
+	for {
+		b := byte(v & 0x7F)
+		v >>= 7
+		if (v == 0 && b&0x40 == 0) || (v == -1 && b&0x40 != 0) {
+			return append(out, b)
+		}
+		out = append(out, b|0x80)
+	}
+}
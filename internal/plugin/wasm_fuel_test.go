@@ -0,0 +1,112 @@
+package plugin
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// wasmSection encodes a section of a WebAssembly module
+func wasmSection(id byte, entries ...[]byte) []byte {
+	content := appendULEB(nil, uint32(len(entries)))
+	for _, entry := range entries {
+		content = append(content, entry...)
+	}
+	out := appendULEB([]byte{id}, uint32(len(content)))
+	return append(out, content...)
+}
+
+// wasmFunc encodes a function body
+func wasmFunc(body ...byte) []byte {
+	return append(appendULEB(nil, uint32(len(body))), body...)
+}
+
+// wasmExport encodes an export
+func wasmExport(name string, kind byte, index uint32) []byte {
+	out := appendULEB(nil, uint32(len(name)))
+	out = append(out, name...)
+	return appendULEB(append(out, kind), index)
+}
+
+// newLoopModule assembles a module whose on_node_heartbeat runs a loop of
+// five instructions the given number of times, or forever when it is 0
+func newLoopModule(iterations int32, extraExports ...[]byte) []byte {
+	var loop []byte
+	if iterations == 0 {
+		// loop, br 0, end
+		loop = []byte{0x00, 0x03, 0x40, 0x0C, 0x00, 0x0B}
+	} else {
+		// one i32 local counting down to 0
+		loop = []byte{0x01, 0x01, 0x7F, 0x41}
+		loop = appendSLEB(loop, int64(iterations))
+		// local.set, loop, local.get, i32.const 1, i32.sub, local.tee, br_if 0, end
+		loop = append(loop, 0x21, 0x02, 0x03, 0x40, 0x20, 0x02, 0x41, 0x01, 0x6B, 0x22, 0x02, 0x0D, 0x00, 0x0B)
+	}
+	// i64.const 0, end
+	loop = append(loop, 0x42, 0x00, 0x0B)
+
+	exports := [][]byte{
+		wasmExport("memory", 0x02, 0),
+		wasmExport(wasmAlloc, 0x00, 0),
+		wasmExport(wasmOnNodeHeartbeat, 0x00, 1),
+	}
+	exports = append(exports, extraExports...)
+
+	module := []byte("\x00asm\x01\x00\x00\x00")
+	module = append(module, wasmSection(1,
+		[]byte{0x60, 0x01, 0x7F, 0x01, 0x7F},       // (i32) i32
+		[]byte{0x60, 0x02, 0x7F, 0x7F, 0x01, 0x7E}, // (i32, i32) i64
+	)...)
+	module = append(module, wasmSection(3, []byte{0x00}, []byte{0x01})...)
+	module = append(module, wasmSection(5, []byte{0x00, 0x01})...)
+	module = append(module, wasmSection(7, exports...)...)
+	return append(module, wasmSection(10,
+		wasmFunc(0x00, 0x41, 0x80, 0x08, 0x0B), // i32.const 1024
+		wasmFunc(loop...),
+	)...)
+}
+
+func TestWASMPluginFuel(t *testing.T) {
+	tests := []struct {
+		name       string
+		iterations int32
+		fuel       int
+		wantErr    string
+	}{
+		{name: "loop within fuel", iterations: 1000, fuel: 6000},
+		{name: "loop exceeding fuel", iterations: 1000, fuel: 4000, wantErr: "ran out of fuel (4000 instructions)"},
+		{name: "infinite loop", fuel: DefaultWASMFuel, wantErr: "ran out of fuel (10000000 instructions)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewWASMPlugin("loop", newLoopModule(tt.iterations))
+			// The time limit is far off, only fuel may stop the loop
+			if err := p.Initialize(map[string]interface{}{"fuel": tt.fuel, "timeout": "1m"}); err != nil {
+				t.Fatalf("Initialize failed: %v", err)
+			}
+			defer p.Shutdown(context.Background())
+
+			// Every call gets the whole fuel
+			for i := 0; i < 2; i++ {
+				err := p.OnNodeHeartbeat(context.Background(), "node1")
+				if tt.wantErr == "" {
+					if err != nil {
+						t.Fatalf("OnNodeHeartbeat failed: %v", err)
+					}
+					continue
+				}
+				if _, ok := err.(*FailureError); !ok || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("OnNodeHeartbeat error = %v, want a failure containing %q", err, tt.wantErr)
+				}
+			}
+		})
+	}
+}
+
+func TestMeterFuelReservedExport(t *testing.T) {
+	module := newLoopModule(1, wasmExport(wasmFuelGlobal, 0x00, 0))
+	if _, err := meterFuel(module, DefaultWASMFuel); err == nil || !strings.Contains(err.Error(), "reserved") {
+		t.Fatalf("meterFuel error = %v, want the export to be refused", err)
+	}
+}
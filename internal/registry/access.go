@@ -0,0 +1,38 @@
+package registry
+
+import (
+	"context"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"galaxy-node-pool/internal/auth"
+)
+
+// scopedOrg returns the organization a read request is limited to. Callers
+// bound to an organization only see that organization's nodes.
+func scopedOrg(ctx context.Context, org string) (string, error) {
+	bound := auth.OrgFromContext(ctx)
+	if bound == "" {
+		return org, nil
+	}
+	if org != "" && org != bound {
+		return "", status.Errorf(codes.PermissionDenied, "caller is bound to organization %s", bound)
+	}
+	return bound, nil
+}
+
+// mayActOnOrg reports whether the caller may register or manage nodes of an organization
+func mayActOnOrg(ctx context.Context, org string) bool {
+	bound := auth.OrgFromContext(ctx)
+	return bound == "" || bound == org
+}
+
+// mayActAsNode reports whether the caller may make calls on behalf of a node.
+// Callers presenting a node certificate may only act as that node.
+func mayActAsNode(ctx context.Context, nodeID, org string) bool {
+	if peerNode, ok := auth.PeerNodeFromContext(ctx); ok {
+		return peerNode.NodeID == nodeID && peerNode.Org == org
+	}
+	return true
+}
@@ -0,0 +1,173 @@
+package registry
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"galaxy-node-pool/internal/config"
+	pb "galaxy-node-pool/proto/pool"
+)
+
+// earthRadiusKm is the mean Earth radius used for haversine distances
+const earthRadiusKm = 6371.0
+
+// validateCoordinates checks that coordinates are within WGS84 bounds
+func validateCoordinates(coords *pb.Coordinates) error {
+	if coords == nil {
+		return nil
+	}
+	if coords.Latitude < -90 || coords.Latitude > 90 {
+		return fmt.Errorf("latitude must be between -90 and 90")
+	}
+	if coords.Longitude < -180 || coords.Longitude > 180 {
+		return fmt.Errorf("longitude must be between -180 and 180")
+	}
+	return nil
+}
+
+// validateGeoQuery checks that a geo query is well formed
+func validateGeoQuery(query *pb.GeoQuery) error {
+	if query == nil {
+		return nil
+	}
+	if err := validateCoordinates(query.Origin); err != nil {
+		return fmt.Errorf("invalid geo origin: %v", err)
+	}
+	if query.MaxDistanceKm < 0 {
+		return fmt.Errorf("max_distance_km must not be negative")
+	}
+	if query.Origin == nil && query.Region == "" && (query.MaxDistanceKm > 0 || query.SortByDistance) {
+		return fmt.Errorf("geo query requires an origin or a region")
+	}
+	return nil
+}
+
+// parseCoordinates parses a "lat,long" pair as used in the server location config
+func parseCoordinates(value string) (*pb.Coordinates, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("coordinates %q must be formatted as lat,long", value)
+	}
+
+	lat, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid latitude in %q: %v", value, err)
+	}
+	long, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid longitude in %q: %v", value, err)
+	}
+
+	coords := &pb.Coordinates{Latitude: lat, Longitude: long}
+	if err := validateCoordinates(coords); err != nil {
+		return nil, fmt.Errorf("invalid coordinates %q: %v", value, err)
+	}
+	return coords, nil
+}
+
+// haversineKm returns the great-circle distance between two points in kilometres
+func haversineKm(a, b *pb.Coordinates) float64 {
+	lat1 := a.Latitude * math.Pi / 180
+	lat2 := b.Latitude * math.Pi / 180
+	dLat := lat2 - lat1
+	dLong := (b.Longitude - a.Longitude) * math.Pi / 180
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLong/2)*math.Sin(dLong/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// geoOriginLocked resolves the point a geo query measures from. A region
+// resolves to the pool's own coordinates when the pool is located in it,
+// otherwise to the mean position of registered nodes in that region.
+// Returns nil when the query has no origin. (must be called with lock held)
+func (r *Registry) geoOriginLocked(query *pb.GeoQuery) (*pb.Coordinates, error) {
+	if query == nil {
+		return nil, nil
+	}
+	if query.Origin != nil {
+		return query.Origin, nil
+	}
+	if query.Region == "" {
+		return nil, nil
+	}
+
+	if r.location != nil && r.config.Server.Location.Region == query.Region {
+		return r.location, nil
+	}
+
+	var lat, long float64
+	var count int
+	for _, node := range r.nodes {
+		if node.Region != query.Region || node.Coordinates == nil {
+			continue
+		}
+		lat += node.Coordinates.Latitude
+		long += node.Coordinates.Longitude
+		count++
+	}
+	if count == 0 {
+		return nil, fmt.Errorf("no coordinates known for region %s", query.Region)
+	}
+
+	// Averaging longitudes is good enough for a region that doesn't straddle
+	// the antimeridian
+	return &pb.Coordinates{Latitude: lat / float64(count), Longitude: long / float64(count)}, nil
+}
+
+// applyGeoQuery sets distance_km on nodes, drops nodes outside the maximum
+// distance and orders them nearest first when requested. The nodes must be
+// copies, their distance field is overwritten.
+func applyGeoQuery(nodes []*pb.NodeInfo, query *pb.GeoQuery, origin *pb.Coordinates) []*pb.NodeInfo {
+	if query == nil || origin == nil {
+		return nodes
+	}
+
+	result := nodes[:0]
+	for _, node := range nodes {
+		node.DistanceKm = nil
+		if node.Coordinates != nil {
+			distance := haversineKm(origin, node.Coordinates)
+			node.DistanceKm = &distance
+		}
+
+		if query.MaxDistanceKm > 0 && (node.DistanceKm == nil || *node.DistanceKm > query.MaxDistanceKm) {
+			continue
+		}
+		result = append(result, node)
+	}
+
+	if query.SortByDistance {
+		sortByDistance(result)
+	}
+	return result
+}
+
+// sortByDistance orders nodes nearest first, nodes without a distance last
+func sortByDistance(nodes []*pb.NodeInfo) {
+	sort.SliceStable(nodes, func(i, j int) bool {
+		a, b := nodes[i].DistanceKm, nodes[j].DistanceKm
+		if a == nil || b == nil {
+			return a != nil && b == nil
+		}
+		return *a < *b
+	})
+}
+
+// poolLocation parses the pool's configured coordinates, returning nil when
+// they are unset or invalid
+func poolLocation(cfg *config.Config) *pb.Coordinates {
+	if cfg.Server.Location.Coordinates == "" {
+		return nil
+	}
+
+	coords, err := parseCoordinates(cfg.Server.Location.Coordinates)
+	if err != nil {
+		log.Printf("Warning: Ignoring pool location: %v", err)
+		return nil
+	}
+	return coords
+}
@@ -0,0 +1,329 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"galaxy-node-pool/internal/config"
+	"galaxy-node-pool/internal/plugin"
+	pb "galaxy-node-pool/proto/pool"
+)
+
+// Failure policies of registry plugins
+const (
+	// FailureClosed rejects the operation when a plugin fails
+	FailureClosed = "closed"
+
+	// FailureOpen logs the failure and carries on without the plugin
+	FailureOpen = "open"
+)
+
+const (
+	// defaultHookTimeout bounds the hooks of registry plugins without a timeout
+	defaultHookTimeout = time.Second
+
+	// maxRunningHookCalls bounds the calls of a plugin still running, including
+	// calls that timed out, further calls fail until some of them return
+	maxRunningHookCalls = 32
+)
+
+// pluginCall bounds the calls the registry makes to a plugin with the
+// plugin's timeout and failure policy
+type pluginCall struct {
+	name     string
+	timeout  time.Duration
+	failOpen bool
+
+	// running holds a token for each call of the plugin that has not returned
+	running chan struct{}
+}
+
+// newPluginCalls returns the call settings of the registry plugins by name
+func newPluginCalls(cfg *config.Config) map[string]pluginCall {
+	calls := make(map[string]pluginCall)
+	for _, pluginCfg := range cfg.Registry.Plugins {
+		call := newPluginCall(pluginCfg.Name)
+		if pluginCfg.Timeout != "" {
+			timeout, err := time.ParseDuration(pluginCfg.Timeout)
+			if err != nil || timeout <= 0 {
+				log.Printf("Invalid timeout %q of registry plugin %s, using default of %s", pluginCfg.Timeout, pluginCfg.Name, defaultHookTimeout)
+			} else {
+				call.timeout = timeout
+			}
+		}
+		switch pluginCfg.FailurePolicy {
+		case "", FailureClosed:
+		case FailureOpen:
+			call.failOpen = true
+		default:
+			log.Printf("Invalid failure policy %q of registry plugin %s, using %s", pluginCfg.FailurePolicy, pluginCfg.Name, FailureClosed)
+		}
+		calls[pluginCfg.Name] = call
+	}
+	return calls
+}
+
+// newPluginCall returns the default call settings of a plugin
+func newPluginCall(name string) pluginCall {
+	return pluginCall{
+		name:    name,
+		timeout: defaultHookTimeout,
+		running: make(chan struct{}, maxRunningHookCalls),
+	}
+}
+
+// pluginCallFor returns the call settings of a plugin, the defaults for
+// plugins without a registry.plugins entry
+func (r *Registry) pluginCallFor(name string) pluginCall {
+	if call, ok := r.pluginCalls[name]; ok {
+		return call
+	}
+	return newPluginCall(name)
+}
+
+// registryHook is an enabled registry plugin with its call settings
+type registryHook struct {
+	pluginCall
+	plugin   plugin.RegistryPlugin
+	priority int
+}
+
+// hookError rejects an operation on behalf of a registry plugin
+type hookError struct {
+	plugin string
+	failed bool
+	err    error
+}
+
+// Error implements the error interface. Rejections carry the plugin's own message.
+func (e *hookError) Error() string {
+	if e.failed {
+		return fmt.Sprintf("registry plugin %s failed: %v", e.plugin, e.err)
+	}
+	return e.err.Error()
+}
+
+// loadHooks returns the enabled registry plugins in the order their hooks
+// run: by descending priority, then in configuration order. Plugins that did
+// not initialize are skipped when their failure policy is open; with the
+// closed policy the registry refuses to start without them.
+func (r *Registry) loadHooks() ([]registryHook, error) {
+	var hooks []registryHook
+	for _, pluginCfg := range r.config.Registry.Plugins {
+		if !pluginCfg.Enabled {
+			continue
+		}
+
+		plg, err := r.pluginManager.Get(pluginCfg.Name)
+		if err != nil {
+			continue
+		}
+		regPlugin, ok := plg.(plugin.RegistryPlugin)
+		if !ok {
+			continue
+		}
+
+		hook := registryHook{
+			pluginCall: r.pluginCallFor(pluginCfg.Name),
+			plugin:     regPlugin,
+			priority:   pluginCfg.Priority,
+		}
+
+		if !r.pluginManager.IsInitialized(pluginCfg.Name) {
+			if !hook.failOpen {
+				return nil, fmt.Errorf("registry plugin %s is not initialized and its failure policy is %s", pluginCfg.Name, FailureClosed)
+			}
+			log.Printf("Warning: Registry plugin %s is not initialized, skipping its hooks", pluginCfg.Name)
+			continue
+		}
+		hooks = append(hooks, hook)
+	}
+
+	sort.SliceStable(hooks, func(i, j int) bool {
+		return hooks[i].priority > hooks[j].priority
+	})
+	return hooks, nil
+}
+
+// describeHooks lists the hooks in call order for the startup log
+func describeHooks(hooks []registryHook) string {
+	descriptions := make([]string, 0, len(hooks))
+	for _, hook := range hooks {
+		policy := FailureClosed
+		if hook.failOpen {
+			policy = FailureOpen
+		}
+		descriptions = append(descriptions, fmt.Sprintf("%s (priority %d, timeout %s, fail-%s)", hook.name, hook.priority, hook.timeout, policy))
+	}
+	return strings.Join(descriptions, ", ")
+}
+
+// run calls a hook with the plugin's timeout. It reports whether the hook
+// succeeded, so its results can be used, and returns an error when the
+// operation must be rejected: the plugin rejected it, or failed under the
+// closed failure policy. Failures are logged. Hooks are called without the
+// registry lock held.
+func (h pluginCall) run(ctx context.Context, op string, fn func(ctx context.Context) error) (bool, *hookError) {
+	err := h.call(ctx, op, fn)
+	switch {
+	case err == nil:
+		return true, nil
+	case !plugin.IsFailure(err):
+		return false, &hookError{plugin: h.name, err: err}
+	case h.failOpen:
+		log.Printf("Warning: Registry plugin %s failed on %s, skipping it: %v", h.name, op, err)
+		return false, nil
+	default:
+		log.Printf("Warning: Registry plugin %s failed on %s: %v", h.name, op, err)
+		return false, &hookError{plugin: h.name, failed: true, err: err}
+	}
+}
+
+// call runs fn with the plugin's timeout, turning timeouts, panics and too
+// many running calls into failures
+func (h pluginCall) call(ctx context.Context, op string, fn func(ctx context.Context) error) error {
+	// In-process plugins cannot be stopped, a hook that times out keeps
+	// running in the background and its results are discarded. Bound how
+	// many of those a plugin may pile up.
+	select {
+	case h.running <- struct{}{}:
+	default:
+		return &plugin.FailureError{Err: fmt.Errorf("%d calls are still running", cap(h.running))}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		defer func() { <-h.running }()
+		defer func() {
+			if p := recover(); p != nil {
+				done <- &plugin.FailureError{Err: fmt.Errorf("panic: %v", p)}
+			}
+		}()
+		done <- fn(ctx)
+	}()
+
+	interrupted := func() error {
+		if ctx.Err() == context.DeadlineExceeded {
+			return &plugin.FailureError{Err: fmt.Errorf("%s hook timed out after %s", op, h.timeout)}
+		}
+		return &plugin.FailureError{Err: fmt.Errorf("%s hook canceled: %v", op, ctx.Err())}
+	}
+
+	select {
+	case err := <-done:
+		// A hook giving up because its context ended failed, it did not reject
+		if err != nil && !plugin.IsFailure(err) && ctx.Err() != nil {
+			return interrupted()
+		}
+		return err
+	case <-ctx.Done():
+		return interrupted()
+	}
+}
+
+// notifyDeregistered calls the deregister hooks for removed nodes. Removal
+// cannot be rejected, errors are only logged. Called without the registry
+// lock held.
+func notifyDeregistered(hooks []registryHook, nodeIDs []string) {
+	for _, nodeID := range nodeIDs {
+		for _, hook := range hooks {
+			_, err := hook.run(context.Background(), "deregister", func(ctx context.Context) error {
+				return hook.plugin.OnNodeDeregister(ctx, nodeID)
+			})
+			if err != nil && !err.failed {
+				log.Printf("Warning: Registry plugin %s returned an error for deregistered node %s: %v", hook.name, nodeID, err)
+			}
+		}
+	}
+}
+
+// checkNodeChanges validates the changes a plugin made to a registering node
+func checkNodeChanges(changes *plugin.NodeChanges) error {
+	if changes == nil {
+		return nil
+	}
+	for key, value := range changes.Metadata {
+		switch key {
+		case "private_node":
+			if _, ok := value.(bool); !ok {
+				return fmt.Errorf("metadata %s must be a bool, got %v", key, value)
+			}
+		default:
+			if !isMutableMetadata(key) {
+				return fmt.Errorf("metadata %s cannot be changed, plugins may change %s", key, strings.Join(plugin.MutableNodeMetadata, ", "))
+			}
+			if _, ok := value.(string); !ok {
+				return fmt.Errorf("metadata %s must be a string, got %v", key, value)
+			}
+		}
+	}
+	return nil
+}
+
+// isMutableMetadata reports whether plugins may change a metadata key
+func isMutableMetadata(key string) bool {
+	for _, mutable := range plugin.MutableNodeMetadata {
+		if key == mutable {
+			return true
+		}
+	}
+	return false
+}
+
+// applyNodeChanges applies validated plugin changes to registration metadata
+func applyNodeChanges(metadata map[string]interface{}, changes *plugin.NodeChanges) {
+	if changes == nil {
+		return
+	}
+	if changes.Labels != nil {
+		metadata["labels"] = copyLabels(changes.Labels)
+	}
+	for key, value := range changes.Metadata {
+		metadata[key] = value
+	}
+}
+
+// copyMetadata returns a copy of plugin metadata, so a hook that keeps
+// running after its timeout cannot change what the next hook sees
+func copyMetadata(metadata map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(metadata))
+	for key, value := range metadata {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			result[key] = copyMetadata(v)
+		case map[string]string:
+			result[key] = copyLabels(v)
+		default:
+			result[key] = value
+		}
+	}
+	return result
+}
+
+// orderNodes returns the nodes a list hook kept, in the order it returned
+// them. IDs the plugin made up or repeated are dropped.
+func orderNodes(nodes []*pb.NodeInfo, ids []string, pluginName string) []*pb.NodeInfo {
+	byID := make(map[string]*pb.NodeInfo, len(nodes))
+	for _, node := range nodes {
+		byID[node.NodeId] = node
+	}
+
+	result := make([]*pb.NodeInfo, 0, len(ids))
+	for _, id := range ids {
+		node, ok := byID[id]
+		if !ok {
+			log.Printf("Warning: Registry plugin %s returned unknown node %s", pluginName, id)
+			continue
+		}
+		delete(byID, id)
+		result = append(result, node)
+	}
+	return result
+}
@@ -0,0 +1,291 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"galaxy-node-pool/internal/config"
+	pb "galaxy-node-pool/proto/pool"
+)
+
+// Node identity modes
+const (
+	// IdentityDisabled ignores node keys entirely
+	IdentityDisabled = "disabled"
+
+	// IdentityOptional binds nodes that register with a key to it, nodes
+	// without a key are accepted as before. Their IDs are not protected,
+	// anyone may re-register or bind a key to them.
+	IdentityOptional = "optional"
+
+	// IdentityRequired rejects registrations without a signed challenge (default)
+	IdentityRequired = "required"
+)
+
+// Calls covered by a NodeProof signature
+const (
+	// ProofHeartbeat is the call name signed for heartbeats
+	ProofHeartbeat = "heartbeat"
+
+	// ProofDeregister is the call name signed for deregistration
+	ProofDeregister = "deregister"
+)
+
+const (
+	// nonceSize is the length of registration challenge nonces
+	nonceSize = 32
+
+	// maxPendingChallenges bounds the challenges kept in memory
+	maxPendingChallenges = 10000
+)
+
+// challenge is an outstanding registration challenge
+type challenge struct {
+	nodeID    string
+	publicKey ed25519.PublicKey
+	expiresAt time.Time
+}
+
+// identityMode returns the configured node identity mode
+func identityMode(cfg *config.Config) string {
+	switch mode := cfg.Registry.Identity.Mode; mode {
+	case IdentityDisabled, IdentityOptional:
+		return mode
+	case "", IdentityRequired:
+		return IdentityRequired
+	default:
+		log.Printf("Invalid identity mode %q, using %s", mode, IdentityRequired)
+		return IdentityRequired
+	}
+}
+
+// identityDuration parses an identity duration setting, falling back to a default
+func identityDuration(name, value string, fallback time.Duration) time.Duration {
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		log.Printf("Invalid identity %s %q, using default of %s", name, value, fallback)
+		return fallback
+	}
+	return d
+}
+
+// newTokenSecret returns a random key for signing session tokens. Tokens do
+// not survive restarts, nodes fall back to a signed heartbeat or re-register.
+func newTokenSecret() []byte {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		log.Fatalf("Failed to generate session token secret: %v", err)
+	}
+	return secret
+}
+
+// ChallengePayload returns the bytes a node signs to answer a registration challenge
+func ChallengePayload(nodeID string, nonce []byte) []byte {
+	return []byte("galaxy-node-pool/register\n" + nodeID + "\n" + hex.EncodeToString(nonce))
+}
+
+// ProofPayload returns the bytes a node signs to prove a heartbeat or deregistration
+func ProofPayload(nodeID, call string, timestampMs int64) []byte {
+	return []byte("galaxy-node-pool/" + call + "\n" + nodeID + "\n" + strconv.FormatInt(timestampMs, 10))
+}
+
+// NewSignedProof creates a signature based proof for a call, for use by node clients
+func NewSignedProof(key ed25519.PrivateKey, nodeID, call string) *pb.NodeProof {
+	timestampMs := time.Now().UnixMilli()
+	return &pb.NodeProof{
+		TimestampMs: timestampMs,
+		Signature:   ed25519.Sign(key, ProofPayload(nodeID, call, timestampMs)),
+	}
+}
+
+// RequestChallenge handles requests for a registration nonce
+func (r *Registry) RequestChallenge(ctx context.Context, req *pb.RequestChallengeRequest) (*pb.RequestChallengeResponse, error) {
+	if r.identityMode == IdentityDisabled {
+		return nil, status.Error(codes.FailedPrecondition, "node identity is disabled on this pool")
+	}
+	if req.NodeId == "" {
+		return nil, status.Error(codes.InvalidArgument, "node_id is required")
+	}
+	if len(req.PublicKey) != ed25519.PublicKeySize {
+		return nil, status.Errorf(codes.InvalidArgument, "public_key must be a %d-byte ed25519 key", ed25519.PublicKeySize)
+	}
+
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to generate nonce: %v", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.clock()
+	r.pruneChallengesLocked(now)
+	if len(r.challenges) >= maxPendingChallenges {
+		return nil, status.Error(codes.ResourceExhausted, "too many pending challenges, retry later")
+	}
+
+	expiresAt := now.Add(r.challengeTTL)
+	r.challenges[hex.EncodeToString(nonce)] = &challenge{
+		nodeID:    req.NodeId,
+		publicKey: append(ed25519.PublicKey(nil), req.PublicKey...),
+		expiresAt: expiresAt,
+	}
+
+	return &pb.RequestChallengeResponse{Nonce: nonce, ExpiresAt: expiresAt.Unix()}, nil
+}
+
+// pruneChallengesLocked drops expired challenges (must be called with lock held)
+func (r *Registry) pruneChallengesLocked(now time.Time) {
+	for nonce, c := range r.challenges {
+		if !now.Before(c.expiresAt) {
+			delete(r.challenges, nonce)
+		}
+	}
+}
+
+// verifyRegistrationLocked checks the identity presented by a registering
+// node and returns the key to bind it to, nil for nodes without a key
+// (must be called with lock held)
+func (r *Registry) verifyRegistrationLocked(req *pb.RegisterNodeRequest) (ed25519.PublicKey, error) {
+	var pinned []byte
+	if existing, ok := r.nodes[req.NodeId]; ok {
+		pinned = existing.PublicKey
+	}
+
+	if r.identityMode == IdentityDisabled {
+		return nil, nil
+	}
+
+	if len(req.PublicKey) == 0 {
+		if r.identityMode == IdentityRequired {
+			return nil, fmt.Errorf("this pool requires a signed challenge to register")
+		}
+		if len(pinned) > 0 {
+			return nil, fmt.Errorf("node %s is registered with a key, sign a challenge to re-register", req.NodeId)
+		}
+		return nil, nil
+	}
+
+	// Challenges are single use, consume it whatever the outcome
+	nonceKey := hex.EncodeToString(req.ChallengeNonce)
+	c, ok := r.challenges[nonceKey]
+	delete(r.challenges, nonceKey)
+
+	if !ok || !r.clock().Before(c.expiresAt) {
+		return nil, fmt.Errorf("unknown or expired challenge, request a new one")
+	}
+	if c.nodeID != req.NodeId || !bytes.Equal(c.publicKey, req.PublicKey) {
+		return nil, fmt.Errorf("challenge was issued for a different node or key")
+	}
+	if !ed25519.Verify(c.publicKey, ChallengePayload(req.NodeId, req.ChallengeNonce), req.Signature) {
+		return nil, fmt.Errorf("invalid challenge signature")
+	}
+
+	// A node ID stays bound to its key until the node is removed
+	if len(pinned) > 0 && !bytes.Equal(pinned, req.PublicKey) {
+		return nil, fmt.Errorf("node %s is registered with a different key", req.NodeId)
+	}
+
+	return c.publicKey, nil
+}
+
+// verifyProofLocked checks that a call for a node carries proof of the
+// node's key. Nodes without a key need no proof. (must be called with lock held)
+func (r *Registry) verifyProofLocked(node *pb.NodeInfo, call string, proof *pb.NodeProof) error {
+	if r.identityMode == IdentityDisabled || len(node.PublicKey) == 0 {
+		return nil
+	}
+	if proof == nil {
+		return status.Errorf(codes.Unauthenticated, "node %s requires a session token or signature", node.NodeId)
+	}
+
+	if proof.SessionToken != "" {
+		if err := r.verifySessionToken(node, proof.SessionToken); err != nil {
+			return status.Error(codes.Unauthenticated, err.Error())
+		}
+		return nil
+	}
+
+	// Signed calls must be recent and newer than the last one, so a captured
+	// signature can't be replayed
+	now := r.clock()
+	signedAt := time.UnixMilli(proof.TimestampMs)
+	if signedAt.Before(now.Add(-r.clockSkew)) || signedAt.After(now.Add(r.clockSkew)) {
+		return status.Errorf(codes.Unauthenticated, "proof timestamp is outside the allowed clock skew of %s", r.clockSkew)
+	}
+	if proof.TimestampMs <= r.lastProofAt[node.NodeId] {
+		return status.Error(codes.Unauthenticated, "proof timestamp has already been used")
+	}
+	if !ed25519.Verify(node.PublicKey, ProofPayload(node.NodeId, call, proof.TimestampMs), proof.Signature) {
+		return status.Error(codes.Unauthenticated, "invalid proof signature")
+	}
+
+	r.lastProofAt[node.NodeId] = proof.TimestampMs
+	return nil
+}
+
+// issueSessionToken creates a token proving a node holds its key until expiry.
+// Tokens are nodeID.expiry.mac, the MAC covers the node's public key so
+// re-keying a node invalidates its tokens.
+func (r *Registry) issueSessionToken(node *pb.NodeInfo) (string, int64) {
+	if len(node.PublicKey) == 0 {
+		return "", 0
+	}
+
+	expiresAt := r.clock().Add(r.sessionTTL).Unix()
+	encodedID := base64.RawURLEncoding.EncodeToString([]byte(node.NodeId))
+	expiry := strconv.FormatInt(expiresAt, 10)
+	mac := r.sessionTokenMAC(encodedID, expiry, node.PublicKey)
+
+	return encodedID + "." + expiry + "." + base64.RawURLEncoding.EncodeToString(mac), expiresAt
+}
+
+// verifySessionToken checks a session token against a node
+func (r *Registry) verifySessionToken(node *pb.NodeInfo, token string) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("malformed session token")
+	}
+
+	mac, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(mac, r.sessionTokenMAC(parts[0], parts[1], node.PublicKey)) {
+		return fmt.Errorf("invalid session token")
+	}
+
+	nodeID, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || string(nodeID) != node.NodeId {
+		return fmt.Errorf("session token was issued for a different node")
+	}
+
+	expiresAt, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || !r.clock().Before(time.Unix(expiresAt, 0)) {
+		return fmt.Errorf("session token expired")
+	}
+
+	return nil
+}
+
+// sessionTokenMAC computes the MAC of a session token
+func (r *Registry) sessionTokenMAC(encodedID, expiry string, publicKey []byte) []byte {
+	mac := hmac.New(sha256.New, r.tokenSecret)
+	mac.Write([]byte(encodedID + "." + expiry + "."))
+	mac.Write(publicKey)
+	return mac.Sum(nil)
+}
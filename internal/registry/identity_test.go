@@ -0,0 +1,328 @@
+package registry
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "galaxy-node-pool/proto/pool"
+)
+
+// newIdentityTestRegistry creates a registry that requires node keys, with
+// the default 1m challenge TTL, 15m session TTL and 30s clock skew
+func newIdentityTestRegistry(t *testing.T) (*Registry, *fakeClock) {
+	t.Helper()
+
+	r, clock := newLeaseTestRegistry(t)
+	r.identityMode = IdentityRequired
+	return r, clock
+}
+
+func newTestKey(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// requestTestChallenge returns a registration challenge nonce for a node and key
+func requestTestChallenge(t *testing.T, r *Registry, nodeID string, key ed25519.PrivateKey) []byte {
+	t.Helper()
+
+	resp, err := r.RequestChallenge(context.Background(), &pb.RequestChallengeRequest{
+		NodeId:    nodeID,
+		PublicKey: key.Public().(ed25519.PublicKey),
+	})
+	if err != nil {
+		t.Fatalf("RequestChallenge(%s) failed: %v", nodeID, err)
+	}
+	return resp.Nonce
+}
+
+// signedRegistration returns a registration answering a challenge
+func signedRegistration(nodeID string, key ed25519.PrivateKey, nonce []byte) *pb.RegisterNodeRequest {
+	return &pb.RegisterNodeRequest{
+		NodeId:         nodeID,
+		Specialization: "gpu",
+		Endpoint:       "127.0.0.1:9000",
+		Org:            "org1",
+		PublicKey:      key.Public().(ed25519.PublicKey),
+		ChallengeNonce: nonce,
+		Signature:      ed25519.Sign(key, ChallengePayload(nodeID, nonce)),
+	}
+}
+
+// registerSignedTestNode registers a node with a key and returns its session token
+func registerSignedTestNode(t *testing.T, r *Registry, nodeID string, key ed25519.PrivateKey) string {
+	t.Helper()
+
+	nonce := requestTestChallenge(t, r, nodeID, key)
+	resp, err := r.RegisterNode(context.Background(), signedRegistration(nodeID, key, nonce))
+	if err != nil || !resp.Success {
+		t.Fatalf("RegisterNode(%s) = %v, %v", nodeID, resp, err)
+	}
+	return resp.SessionToken
+}
+
+func TestRegistrationChallenge(t *testing.T) {
+	tests := []struct {
+		name    string
+		prepare func(t *testing.T, r *Registry, clock *fakeClock, key ed25519.PrivateKey) *pb.RegisterNodeRequest
+		wantErr string
+	}{
+		{
+			name: "signed challenge",
+			prepare: func(t *testing.T, r *Registry, clock *fakeClock, key ed25519.PrivateKey) *pb.RegisterNodeRequest {
+				return signedRegistration("node1", key, requestTestChallenge(t, r, "node1", key))
+			},
+		},
+		{
+			name: "no key",
+			prepare: func(t *testing.T, r *Registry, clock *fakeClock, key ed25519.PrivateKey) *pb.RegisterNodeRequest {
+				return &pb.RegisterNodeRequest{NodeId: "node1", Specialization: "gpu", Endpoint: "127.0.0.1:9000", Org: "org1"}
+			},
+			wantErr: "requires a signed challenge",
+		},
+		{
+			name: "replayed nonce",
+			prepare: func(t *testing.T, r *Registry, clock *fakeClock, key ed25519.PrivateKey) *pb.RegisterNodeRequest {
+				req := signedRegistration("node1", key, requestTestChallenge(t, r, "node1", key))
+				if resp, err := r.RegisterNode(context.Background(), req); err != nil || !resp.Success {
+					t.Fatalf("RegisterNode = %v, %v", resp, err)
+				}
+				return req
+			},
+			wantErr: "unknown or expired challenge",
+		},
+		{
+			name: "unknown nonce",
+			prepare: func(t *testing.T, r *Registry, clock *fakeClock, key ed25519.PrivateKey) *pb.RegisterNodeRequest {
+				return signedRegistration("node1", key, make([]byte, nonceSize))
+			},
+			wantErr: "unknown or expired challenge",
+		},
+		{
+			name: "expired challenge",
+			prepare: func(t *testing.T, r *Registry, clock *fakeClock, key ed25519.PrivateKey) *pb.RegisterNodeRequest {
+				nonce := requestTestChallenge(t, r, "node1", key)
+				clock.Advance(time.Minute)
+				return signedRegistration("node1", key, nonce)
+			},
+			wantErr: "unknown or expired challenge",
+		},
+		{
+			name: "signed with the wrong key",
+			prepare: func(t *testing.T, r *Registry, clock *fakeClock, key ed25519.PrivateKey) *pb.RegisterNodeRequest {
+				nonce := requestTestChallenge(t, r, "node1", key)
+				req := signedRegistration("node1", key, nonce)
+				req.Signature = ed25519.Sign(newTestKey(t), ChallengePayload("node1", nonce))
+				return req
+			},
+			wantErr: "invalid challenge signature",
+		},
+		{
+			name: "challenge for another key",
+			prepare: func(t *testing.T, r *Registry, clock *fakeClock, key ed25519.PrivateKey) *pb.RegisterNodeRequest {
+				return signedRegistration("node1", newTestKey(t), requestTestChallenge(t, r, "node1", key))
+			},
+			wantErr: "different node or key",
+		},
+		{
+			name: "challenge for another node",
+			prepare: func(t *testing.T, r *Registry, clock *fakeClock, key ed25519.PrivateKey) *pb.RegisterNodeRequest {
+				return signedRegistration("node1", key, requestTestChallenge(t, r, "node2", key))
+			},
+			wantErr: "different node or key",
+		},
+		{
+			name: "node registered with another key",
+			prepare: func(t *testing.T, r *Registry, clock *fakeClock, key ed25519.PrivateKey) *pb.RegisterNodeRequest {
+				registerSignedTestNode(t, r, "node1", newTestKey(t))
+				return signedRegistration("node1", key, requestTestChallenge(t, r, "node1", key))
+			},
+			wantErr: "registered with a different key",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, clock := newIdentityTestRegistry(t)
+			req := tt.prepare(t, r, clock, newTestKey(t))
+
+			resp, err := r.RegisterNode(context.Background(), req)
+			if err != nil {
+				t.Fatalf("RegisterNode failed: %v", err)
+			}
+			if tt.wantErr == "" {
+				if !resp.Success {
+					t.Fatalf("RegisterNode rejected: %s", resp.Message)
+				}
+				if resp.SessionToken == "" {
+					t.Errorf("no session token issued")
+				}
+				return
+			}
+			if resp.Success || !strings.Contains(resp.Message, tt.wantErr) {
+				t.Errorf("RegisterNode = %v, %q, want rejection containing %q", resp.Success, resp.Message, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHeartbeatProof(t *testing.T) {
+	// signed returns a proof for the current fake time shifted by offset
+	signed := func(clock *fakeClock, key ed25519.PrivateKey, call string, offset time.Duration) *pb.NodeProof {
+		timestampMs := clock.Now().Add(offset).UnixMilli()
+		return &pb.NodeProof{
+			TimestampMs: timestampMs,
+			Signature:   ed25519.Sign(key, ProofPayload("node1", call, timestampMs)),
+		}
+	}
+
+	tests := []struct {
+		name    string
+		proof   func(t *testing.T, r *Registry, clock *fakeClock, key ed25519.PrivateKey, token string) *pb.NodeProof
+		wantErr string
+	}{
+		{
+			name: "signed heartbeat",
+			proof: func(t *testing.T, r *Registry, clock *fakeClock, key ed25519.PrivateKey, token string) *pb.NodeProof {
+				return signed(clock, key, ProofHeartbeat, 0)
+			},
+		},
+		{
+			name: "session token",
+			proof: func(t *testing.T, r *Registry, clock *fakeClock, key ed25519.PrivateKey, token string) *pb.NodeProof {
+				return &pb.NodeProof{SessionToken: token}
+			},
+		},
+		{
+			name: "no proof",
+			proof: func(t *testing.T, r *Registry, clock *fakeClock, key ed25519.PrivateKey, token string) *pb.NodeProof {
+				return nil
+			},
+			wantErr: "requires a session token or signature",
+		},
+		{
+			name: "replayed signature",
+			proof: func(t *testing.T, r *Registry, clock *fakeClock, key ed25519.PrivateKey, token string) *pb.NodeProof {
+				proof := signed(clock, key, ProofHeartbeat, 0)
+				if _, err := r.Heartbeat(context.Background(), &pb.HeartbeatRequest{NodeId: "node1", Proof: proof}); err != nil {
+					t.Fatalf("Heartbeat failed: %v", err)
+				}
+				return proof
+			},
+			wantErr: "has already been used",
+		},
+		{
+			name: "older signature",
+			proof: func(t *testing.T, r *Registry, clock *fakeClock, key ed25519.PrivateKey, token string) *pb.NodeProof {
+				if _, err := r.Heartbeat(context.Background(), &pb.HeartbeatRequest{NodeId: "node1", Proof: signed(clock, key, ProofHeartbeat, 0)}); err != nil {
+					t.Fatalf("Heartbeat failed: %v", err)
+				}
+				return signed(clock, key, ProofHeartbeat, -time.Second)
+			},
+			wantErr: "has already been used",
+		},
+		{
+			name: "signed with the wrong key",
+			proof: func(t *testing.T, r *Registry, clock *fakeClock, key ed25519.PrivateKey, token string) *pb.NodeProof {
+				return signed(clock, newTestKey(t), ProofHeartbeat, 0)
+			},
+			wantErr: "invalid proof signature",
+		},
+		{
+			name: "signature for another call",
+			proof: func(t *testing.T, r *Registry, clock *fakeClock, key ed25519.PrivateKey, token string) *pb.NodeProof {
+				return signed(clock, key, ProofDeregister, 0)
+			},
+			wantErr: "invalid proof signature",
+		},
+		{
+			name: "timestamp behind the clock skew",
+			proof: func(t *testing.T, r *Registry, clock *fakeClock, key ed25519.PrivateKey, token string) *pb.NodeProof {
+				return signed(clock, key, ProofHeartbeat, -31*time.Second)
+			},
+			wantErr: "outside the allowed clock skew",
+		},
+		{
+			name: "timestamp ahead of the clock skew",
+			proof: func(t *testing.T, r *Registry, clock *fakeClock, key ed25519.PrivateKey, token string) *pb.NodeProof {
+				return signed(clock, key, ProofHeartbeat, 31*time.Second)
+			},
+			wantErr: "outside the allowed clock skew",
+		},
+		{
+			name: "expired session token",
+			proof: func(t *testing.T, r *Registry, clock *fakeClock, key ed25519.PrivateKey, token string) *pb.NodeProof {
+				clock.Advance(15 * time.Minute)
+				return &pb.NodeProof{SessionToken: token}
+			},
+			wantErr: "session token expired",
+		},
+		{
+			name: "session token with a forged expiry",
+			proof: func(t *testing.T, r *Registry, clock *fakeClock, key ed25519.PrivateKey, token string) *pb.NodeProof {
+				parts := strings.Split(token, ".")
+				parts[1] = "9999999999"
+				return &pb.NodeProof{SessionToken: strings.Join(parts, ".")}
+			},
+			wantErr: "invalid session token",
+		},
+		{
+			name: "session token of another node",
+			proof: func(t *testing.T, r *Registry, clock *fakeClock, key ed25519.PrivateKey, token string) *pb.NodeProof {
+				return &pb.NodeProof{SessionToken: registerSignedTestNode(t, r, "node2", newTestKey(t))}
+			},
+			wantErr: "invalid session token",
+		},
+		{
+			name: "session token signed by another registry",
+			proof: func(t *testing.T, r *Registry, clock *fakeClock, key ed25519.PrivateKey, token string) *pb.NodeProof {
+				other, _ := newIdentityTestRegistry(t)
+				other.SetClock(clock.Now)
+				return &pb.NodeProof{SessionToken: registerSignedTestNode(t, other, "node1", key)}
+			},
+			wantErr: "invalid session token",
+		},
+		{
+			name: "malformed session token",
+			proof: func(t *testing.T, r *Registry, clock *fakeClock, key ed25519.PrivateKey, token string) *pb.NodeProof {
+				return &pb.NodeProof{SessionToken: "node1"}
+			},
+			wantErr: "malformed session token",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, clock := newIdentityTestRegistry(t)
+			key := newTestKey(t)
+			token := registerSignedTestNode(t, r, "node1", key)
+			proof := tt.proof(t, r, clock, key, token)
+
+			resp, err := r.Heartbeat(context.Background(), &pb.HeartbeatRequest{NodeId: "node1", Proof: proof})
+			if tt.wantErr == "" {
+				if err != nil || !resp.Alive {
+					t.Fatalf("Heartbeat = %v, %v", resp, err)
+				}
+				if resp.SessionToken == "" {
+					t.Errorf("no session token issued")
+				}
+				return
+			}
+			if status.Code(err) != codes.Unauthenticated || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Heartbeat error = %v, want Unauthenticated containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package registry
+
+import (
+	"log"
+	"time"
+
+	"galaxy-node-pool/internal/config"
+	pb "galaxy-node-pool/proto/pool"
+)
+
+// Clock returns the current time. The registry reads time only through its
+// clock so lease expiry can be tested deterministically.
+type Clock func() time.Time
+
+// SetClock replaces the clock used for heartbeats and lease expiry
+func (r *Registry) SetClock(clock Clock) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.clock = clock
+}
+
+// healthCheckInterval returns how often leases are checked for expiry
+func healthCheckInterval(cfg *config.Config) time.Duration {
+	interval, err := time.ParseDuration(cfg.Registry.HealthCheckInterval)
+	if err != nil || interval <= 0 {
+		log.Printf("Invalid health check interval %q, using default of 30s", cfg.Registry.HealthCheckInterval)
+		return 30 * time.Second
+	}
+	return interval
+}
+
+// leaseTTL returns the configured lease TTL. Without an explicit lease_ttl it
+// falls back to the window the tick-based policy used to allow, i.e.
+// health_check_interval * auto_deregister_after.
+func leaseTTL(cfg *config.Config) time.Duration {
+	if cfg.Registry.LeaseTTL != "" {
+		ttl, err := time.ParseDuration(cfg.Registry.LeaseTTL)
+		if err == nil && ttl > 0 {
+			return ttl
+		}
+		log.Printf("Invalid lease TTL %q, deriving it from the health check interval", cfg.Registry.LeaseTTL)
+	}
+
+	missed := cfg.Registry.AutoDeregisterAfter
+	if missed <= 0 {
+		missed = 3
+	}
+	return healthCheckInterval(cfg) * time.Duration(missed)
+}
+
+// suspectAfter returns how long a node may go without heartbeats before it is
+// marked suspect, defaulting to one health check interval
+func suspectAfter(cfg *config.Config) time.Duration {
+	if cfg.Registry.SuspectAfter != "" {
+		after, err := time.ParseDuration(cfg.Registry.SuspectAfter)
+		if err == nil && after > 0 {
+			return after
+		}
+		log.Printf("Invalid suspect_after %q, using the health check interval", cfg.Registry.SuspectAfter)
+	}
+	return healthCheckInterval(cfg)
+}
+
+// leaseExpiry computes when a node's lease runs out. The lease runs from the
+// node's last heartbeat, or its registration if it has not heartbeat yet.
+// Leases never start before the registry did, so nodes restored from storage
+// get a full TTL to reconnect after a restart.
+func (r *Registry) leaseExpiry(node *pb.NodeInfo) time.Time {
+	return r.leaseBase(node).Add(r.leaseTTL)
+}
+
+// suspectDeadline computes when a node without heartbeats becomes suspect
+func (r *Registry) suspectDeadline(node *pb.NodeInfo) time.Time {
+	return r.leaseBase(node).Add(r.suspectAfter)
+}
+
+// leaseBase returns the time a node's lease currently runs from
+func (r *Registry) leaseBase(node *pb.NodeInfo) time.Time {
+	lastSeen := node.LastHeartbeatAt
+	if lastSeen == 0 {
+		lastSeen = node.RegisteredAt
+	}
+
+	base := time.Unix(lastSeen, 0)
+	if base.Before(r.startedAt) {
+		base = r.startedAt
+	}
+	return base
+}
+
+// renewLeaseLocked refreshes the lease expiry published on the node
+// (must be called with lock held)
+func (r *Registry) renewLeaseLocked(node *pb.NodeInfo) {
+	node.LeaseExpiresAt = r.leaseExpiry(node).Unix()
+}
@@ -0,0 +1,183 @@
+package registry
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"galaxy-node-pool/internal/config"
+	pb "galaxy-node-pool/proto/pool"
+)
+
+// fakeClock is a manually advanced clock for lease tests
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+// newLeaseTestRegistry creates a registry with a 30s lease, nodes turning
+// suspect after 10s without heartbeats, and a fake clock
+func newLeaseTestRegistry(t *testing.T) (*Registry, *fakeClock) {
+	t.Helper()
+
+	cfg := &config.Config{}
+	cfg.Registry.MaxNodes = 10
+	cfg.Registry.HealthCheckInterval = "10s"
+	cfg.Registry.LeaseTTL = "30s"
+	cfg.Registry.SuspectAfter = "10s"
+	cfg.Registry.Identity.Mode = IdentityDisabled
+
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	r := NewRegistry(cfg, nil)
+	r.SetClock(clock.Now)
+	r.startedAt = clock.Now()
+	return r, clock
+}
+
+func registerTestNode(t *testing.T, r *Registry, nodeID string) {
+	t.Helper()
+
+	resp, err := r.RegisterNode(context.Background(), &pb.RegisterNodeRequest{
+		NodeId:         nodeID,
+		Specialization: "gpu",
+		Endpoint:       "127.0.0.1:9000",
+		Org:            "org1",
+	})
+	if err != nil || !resp.Success {
+		t.Fatalf("RegisterNode(%s) = %v, %v", nodeID, resp, err)
+	}
+}
+
+func heartbeatTestNode(t *testing.T, r *Registry, nodeID string) *pb.HeartbeatResponse {
+	t.Helper()
+
+	resp, err := r.Heartbeat(context.Background(), &pb.HeartbeatRequest{NodeId: nodeID})
+	if err != nil {
+		t.Fatalf("Heartbeat(%s) failed: %v", nodeID, err)
+	}
+	return resp
+}
+
+func TestLeaseExpiry(t *testing.T) {
+	tests := []struct {
+		name       string
+		heartbeat  bool
+		status     string
+		advance    time.Duration
+		wantStatus string
+		wantEvict  bool
+	}{
+		{name: "new node within suspect window", advance: 9 * time.Second, wantStatus: StatusRegistering},
+		{name: "new node without heartbeats", advance: 10 * time.Second, wantStatus: StatusSuspect},
+		{name: "healthy within suspect window", heartbeat: true, advance: 9 * time.Second, wantStatus: StatusHealthy},
+		{name: "healthy missing heartbeats", heartbeat: true, advance: 10 * time.Second, wantStatus: StatusSuspect},
+		{name: "suspect before lease expiry", heartbeat: true, advance: 29 * time.Second, wantStatus: StatusSuspect},
+		{name: "lease expired", heartbeat: true, advance: 30 * time.Second, wantEvict: true},
+		{name: "new node lease expired", advance: 30 * time.Second, wantEvict: true},
+		{name: "cordoned node is not suspect", status: StatusCordoned, advance: 29 * time.Second, wantStatus: StatusCordoned},
+		{name: "cordoned node lease expired", status: StatusCordoned, advance: 30 * time.Second, wantEvict: true},
+		{name: "draining node lease expired", heartbeat: true, status: StatusDraining, advance: 30 * time.Second, wantEvict: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, clock := newLeaseTestRegistry(t)
+			registerTestNode(t, r, "node1")
+			if tt.heartbeat {
+				if resp := heartbeatTestNode(t, r, "node1"); !resp.Alive {
+					t.Fatalf("Heartbeat rejected: %s", resp.Message)
+				}
+			}
+			if tt.status != "" {
+				resp, err := r.SetNodeStatus(context.Background(), &pb.SetNodeStatusRequest{NodeId: "node1", Status: tt.status})
+				if err != nil || !resp.Success {
+					t.Fatalf("SetNodeStatus(%s) = %v, %v", tt.status, resp, err)
+				}
+			}
+
+			clock.Advance(tt.advance)
+			r.checkNodeHealth()
+
+			node, ok := r.GetNodeByID("node1")
+			if tt.wantEvict {
+				if ok {
+					t.Fatalf("node was not evicted, status %s", node.Status)
+				}
+				return
+			}
+			if !ok {
+				t.Fatalf("node was evicted")
+			}
+			if node.Status != tt.wantStatus {
+				t.Errorf("status = %s, want %s", node.Status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestHeartbeatRenewsLease(t *testing.T) {
+	r, clock := newLeaseTestRegistry(t)
+	registerTestNode(t, r, "node1")
+
+	// Heartbeats every 20s keep the node past several lease TTLs
+	for i := 0; i < 5; i++ {
+		clock.Advance(20 * time.Second)
+		r.checkNodeHealth()
+
+		resp := heartbeatTestNode(t, r, "node1")
+		if !resp.Alive {
+			t.Fatalf("heartbeat %d rejected: %s", i, resp.Message)
+		}
+		if want := clock.Now().Add(30 * time.Second).Unix(); resp.LeaseExpiresAt != want {
+			t.Errorf("heartbeat %d: lease expires at %d, want %d", i, resp.LeaseExpiresAt, want)
+		}
+	}
+
+	// A suspect node that heartbeats again is healthy
+	node, _ := r.GetNodeByID("node1")
+	if node.Status != StatusHealthy {
+		t.Errorf("status = %s, want %s", node.Status, StatusHealthy)
+	}
+
+	// Once heartbeats stop, the lease runs out
+	clock.Advance(30 * time.Second)
+	r.checkNodeHealth()
+	if _, ok := r.GetNodeByID("node1"); ok {
+		t.Errorf("node was not evicted after its lease expired")
+	}
+	if resp := heartbeatTestNode(t, r, "node1"); resp.Alive {
+		t.Errorf("heartbeat of evicted node was acknowledged")
+	}
+}
+
+func TestLeaseStartsWithRegistry(t *testing.T) {
+	r, clock := newLeaseTestRegistry(t)
+
+	// Nodes restored from storage were last seen before the registry started
+	node := &pb.NodeInfo{
+		NodeId:          "node1",
+		RegisteredAt:    clock.Now().Add(-2 * time.Hour).Unix(),
+		LastHeartbeatAt: clock.Now().Add(-time.Hour).Unix(),
+	}
+	if got, want := r.leaseExpiry(node), clock.Now().Add(30*time.Second); !got.Equal(want) {
+		t.Errorf("restored node lease expires at %s, want %s", got, want)
+	}
+
+	// Otherwise the lease runs from the last heartbeat, or the registration
+	node.LastHeartbeatAt = clock.Now().Add(5 * time.Second).Unix()
+	if got, want := r.leaseExpiry(node), clock.Now().Add(35*time.Second); !got.Equal(want) {
+		t.Errorf("lease expires at %s, want %s", got, want)
+	}
+	node.LastHeartbeatAt = 0
+	node.RegisteredAt = clock.Now().Add(3 * time.Second).Unix()
+	if got, want := r.suspectDeadline(node), clock.Now().Add(13*time.Second); !got.Equal(want) {
+		t.Errorf("suspect deadline is %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,68 @@
+package registry
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+
+	pb "galaxy-node-pool/proto/pool"
+)
+
+// validateLoad checks that a heartbeat load report is within range
+func validateLoad(load *pb.NodeLoad) error {
+	if load == nil {
+		return nil
+	}
+
+	if load.ActiveTasks < 0 {
+		return fmt.Errorf("active_tasks must not be negative")
+	}
+	if load.QueueDepth < 0 {
+		return fmt.Errorf("queue_depth must not be negative")
+	}
+	if load.CpuUtilization < 0 || load.CpuUtilization > 100 {
+		return fmt.Errorf("cpu_utilization must be between 0 and 100")
+	}
+	if load.MemoryUtilization < 0 || load.MemoryUtilization > 100 {
+		return fmt.Errorf("memory_utilization must be between 0 and 100")
+	}
+
+	return nil
+}
+
+// statusFromHealthChecks derives the node status from its self-reported
+// checks; any check that is not passing marks the node as degraded
+func statusFromHealthChecks(checks []*pb.HealthCheck) string {
+	for _, check := range checks {
+		if check.Status != pb.HealthCheck_PASSING {
+			return StatusDegraded
+		}
+	}
+	return StatusHealthy
+}
+
+// loadChanged reports whether a heartbeat's load reading differs from the
+// stored one, ignoring when each was reported
+func loadChanged(stored, reported *pb.NodeLoad) bool {
+	if stored == nil || reported == nil {
+		return stored != reported
+	}
+	return stored.ActiveTasks != reported.ActiveTasks ||
+		stored.CpuUtilization != reported.CpuUtilization ||
+		stored.MemoryUtilization != reported.MemoryUtilization ||
+		stored.QueueDepth != reported.QueueDepth
+}
+
+// healthChecksChanged reports whether a heartbeat's health checks differ
+// from the stored ones
+func healthChecksChanged(stored, reported []*pb.HealthCheck) bool {
+	if len(stored) != len(reported) {
+		return true
+	}
+	for i := range stored {
+		if !proto.Equal(stored[i], reported[i]) {
+			return true
+		}
+	}
+	return false
+}
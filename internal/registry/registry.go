@@ -1,6 +1,7 @@
 package registry
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"log"
@@ -8,6 +9,11 @@ import (
 	"sync"
 	"time"
 
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"galaxy-node-pool/internal/auth"
 	"galaxy-node-pool/internal/config"
 	"galaxy-node-pool/internal/plugin"
 	pb "galaxy-node-pool/proto/pool"
@@ -16,27 +22,75 @@ import (
 // Registry implements the gRPC registry server with plugin support
 type Registry struct {
 	pb.UnimplementedRegistryServer
-	mu             sync.RWMutex
-	nodes          map[string]*pb.NodeInfo
-	pluginManager  *plugin.PluginManager
-	config         *config.Config
-	missedHeartbeats map[string]int
-	maxNodes       int
+	mu              sync.RWMutex
+	nodes           map[string]*pb.NodeInfo
+	pluginManager   *plugin.PluginManager
+	config          *config.Config
+	maxNodes        int
+	storage         plugin.StoragePlugin
+	revision        uint64
+	history         []*pb.NodeEvent
+	watchers        map[uint64]*watcher
+	loadEvents      map[string]loadEvent
+	nextWatcherID   uint64
+	leaseTTL        time.Duration
+	suspectAfter    time.Duration
+	strategies      map[string]selectionStrategy
+	hooks           []registryHook
+	pluginCalls     map[string]pluginCall
+	defaultStrategy string
+	location        *pb.Coordinates
+	identityMode    string
+	challenges      map[string]*challenge
+	lastProofAt     map[string]int64
+	tokenSecret     []byte
+	challengeTTL    time.Duration
+	sessionTTL      time.Duration
+	clockSkew       time.Duration
+	clock           Clock
+	startedAt       time.Time
 }
 
 // NewRegistry creates a new registry server with the given configuration
 func NewRegistry(cfg *config.Config, pluginMgr *plugin.PluginManager) *Registry {
 	return &Registry{
-		nodes:          make(map[string]*pb.NodeInfo),
-		pluginManager:  pluginMgr,
-		config:         cfg,
-		missedHeartbeats: make(map[string]int),
-		maxNodes:       cfg.Registry.MaxNodes,
+		nodes:           make(map[string]*pb.NodeInfo),
+		pluginManager:   pluginMgr,
+		config:          cfg,
+		maxNodes:        cfg.Registry.MaxNodes,
+		watchers:        make(map[uint64]*watcher),
+		loadEvents:      make(map[string]loadEvent),
+		leaseTTL:        leaseTTL(cfg),
+		suspectAfter:    suspectAfter(cfg),
+		strategies:      builtinStrategies(),
+		defaultStrategy: defaultStrategy(cfg),
+		location:        poolLocation(cfg),
+		identityMode:    identityMode(cfg),
+		challenges:      make(map[string]*challenge),
+		lastProofAt:     make(map[string]int64),
+		tokenSecret:     newTokenSecret(),
+		challengeTTL:    identityDuration("challenge_ttl", cfg.Registry.Identity.ChallengeTTL, time.Minute),
+		sessionTTL:      identityDuration("session_ttl", cfg.Registry.Identity.SessionTTL, 15*time.Minute),
+		clockSkew:       identityDuration("clock_skew", cfg.Registry.Identity.ClockSkew, 30*time.Second),
+		clock:           time.Now,
+		pluginCalls:     newPluginCalls(cfg),
 	}
 }
 
 // Start initializes the registry and starts background tasks
 func (r *Registry) Start(ctx context.Context) error {
+	r.mu.Lock()
+	r.startedAt = r.clock()
+	r.mu.Unlock()
+
+	// Attach storage and restore nodes persisted by a previous run
+	if err := r.initStorage(); err != nil {
+		log.Printf("Warning: Registry storage unavailable, running in-memory only: %v", err)
+	}
+	if err := r.restoreNodes(); err != nil {
+		return fmt.Errorf("failed to restore registry state: %v", err)
+	}
+
 	// Start health check goroutine
 	go r.healthCheckLoop(ctx)
 
@@ -52,27 +106,41 @@ func (r *Registry) Start(ctx context.Context) error {
 			continue
 		}
 
-		// Check if it's a registry plugin
-		if regPlugin, ok := plg.(plugin.RegistryPlugin); ok {
-			if err := regPlugin.Initialize(pluginCfg.Config); err != nil {
-				log.Printf("Warning: Failed to initialize registry plugin %s: %v", pluginCfg.Name, err)
-			}
+		// Check if it's a registry or selection plugin, plugins loaded from
+		// the plugin directory are already initialized
+		kind := ""
+		switch plg.(type) {
+		case plugin.RegistryPlugin:
+			kind = "registry"
+		case plugin.SelectionPlugin:
+			kind = "selection"
+		default:
+			continue
+		}
+		if err := r.pluginManager.InitializePlugin(pluginCfg.Name, pluginCfg.Config); err != nil {
+			log.Printf("Warning: Failed to initialize %s plugin %s: %v", kind, pluginCfg.Name, err)
 		}
 	}
 
-	log.Printf("Registry started with max nodes: %d", r.maxNodes)
+	// Order the registry plugin hooks by priority
+	hooks, err := r.loadHooks()
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.hooks = hooks
+	r.mu.Unlock()
+	if len(hooks) > 0 {
+		log.Printf("Registry plugin hooks: %s", describeHooks(hooks))
+	}
+
+	log.Printf("Registry started with max nodes: %d, lease TTL: %s, selection strategy: %s", r.maxNodes, r.leaseTTL, r.defaultStrategy)
 	return nil
 }
 
-// healthCheckLoop periodically checks node health and removes unhealthy nodes
+// healthCheckLoop periodically checks node leases and removes expired nodes
 func (r *Registry) healthCheckLoop(ctx context.Context) {
-	interval, err := time.ParseDuration(r.config.Registry.HealthCheckInterval)
-	if err != nil {
-		log.Printf("Invalid health check interval: %v, using default of 30s", err)
-		interval = 30 * time.Second
-	}
-
-	ticker := time.NewTicker(interval)
+	ticker := time.NewTicker(healthCheckInterval(r.config))
 	defer ticker.Stop()
 
 	for {
@@ -85,52 +153,78 @@ func (r *Registry) healthCheckLoop(ctx context.Context) {
 	}
 }
 
-// checkNodeHealth identifies and removes unhealthy nodes
+// checkNodeHealth marks nodes that missed heartbeats as suspect and evicts
+// nodes whose lease has expired
 func (r *Registry) checkNodeHealth() {
+	// Plugins are told about evicted nodes once the lock is released
+	var evicted []string
+	var hooks []registryHook
+	defer func() { notifyDeregistered(hooks, evicted) }()
+
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	hooks = r.hooks
+
+	now := r.clock()
+	for nodeID, node := range r.nodes {
+		expiry := r.leaseExpiry(node)
+		if !now.Before(expiry) {
+			// Lease ran out without a heartbeat, deregister the node
+			log.Printf("Deregistering unhealthy node: %s (lease expired %s ago)", nodeID, now.Sub(expiry).Round(time.Second))
+			r.removeNodeLocked(nodeID, "lease expired")
+			evicted = append(evicted, nodeID)
+			continue
+		}
+
+		// Publish load readings held back from earlier heartbeats
+		r.publishUpdateLocked(node, false, false)
+
+		// Operator statuses are kept until the lease expires
+		if isOperatorStatus(node.Status) || node.Status == StatusSuspect {
+			continue
+		}
 
-	for nodeID, missedCount := range r.missedHeartbeats {
-		if missedCount >= r.config.Registry.AutoDeregisterAfter {
-			// Node has missed too many heartbeats, deregister it
-			log.Printf("Deregistering unhealthy node: %s (missed %d heartbeats)", nodeID, missedCount)
-			
-			// Call plugins for node deregistration
-			for _, pluginCfg := range r.config.Registry.Plugins {
-				if !pluginCfg.Enabled {
-					continue
-				}
-
-				plg, err := r.pluginManager.Get(pluginCfg.Name)
-				if err != nil {
-					continue
-				}
-
-				if regPlugin, ok := plg.(plugin.RegistryPlugin); ok {
-					regPlugin.OnNodeDeregister(nodeID)
-				}
+		if !now.Before(r.suspectDeadline(node)) {
+			if err := r.transitionLocked(node, StatusSuspect, "heartbeats missed"); err != nil {
+				log.Printf("Warning: %v", err)
+				continue
 			}
+			r.persistNodeLocked(nodeID)
+		}
+	}
+}
 
-			// Remove the node
-			delete(r.nodes, nodeID)
-			delete(r.missedHeartbeats, nodeID)
-		} else {
-			// Increment missed heartbeat count
-			r.missedHeartbeats[nodeID] = missedCount + 1
+// removeNodeLocked evicts a node and removes it from memory and storage
+// (must be called with lock held). Callers notify plugins with
+// notifyDeregistered after releasing the lock.
+func (r *Registry) removeNodeLocked(nodeID, reason string) {
+	if node, ok := r.nodes[nodeID]; ok {
+		transition, err := r.recordTransitionLocked(node, StatusEvicted, reason)
+		if err != nil {
+			log.Printf("Warning: %v", err)
 		}
+		r.emitLocked(pb.NodeEvent_REMOVED, node, transition)
 	}
+	delete(r.nodes, nodeID)
+	delete(r.lastProofAt, nodeID)
+	r.deletePersistedNode(nodeID)
 }
 
 // RegisterNode handles node registration requests
 func (r *Registry) RegisterNode(ctx context.Context, req *pb.RegisterNodeRequest) (*pb.RegisterNodeResponse, error) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+	// Callers bound to an organization may only register nodes for it
+	if !mayActOnOrg(ctx, req.Org) {
+		return &pb.RegisterNodeResponse{
+			Success: false,
+			Message: fmt.Sprintf("Caller is bound to organization %s", auth.OrgFromContext(ctx)),
+		}, nil
+	}
 
-	// Check if we've reached the maximum number of nodes
-	if len(r.nodes) >= r.maxNodes {
+	// Callers presenting a node certificate may only register that node
+	if !mayActAsNode(ctx, req.NodeId, req.Org) {
 		return &pb.RegisterNodeResponse{
 			Success: false,
-			Message: fmt.Sprintf("Maximum number of nodes (%d) reached", r.maxNodes),
+			Message: "Node ID and organization do not match the client certificate",
 		}, nil
 	}
 
@@ -152,139 +246,472 @@ func (r *Registry) RegisterNode(ctx context.Context, req *pb.RegisterNodeRequest
 		}
 	}
 
-	// Call plugins for node registration
-	for _, pluginCfg := range r.config.Registry.Plugins {
-		if !pluginCfg.Enabled {
-			continue
+	// Label keys must be non-empty so selectors can address them
+	for key := range req.Labels {
+		if key == "" {
+			return &pb.RegisterNodeResponse{Success: false, Message: "Label keys must not be empty"}, nil
 		}
+	}
 
-		plg, err := r.pluginManager.Get(pluginCfg.Name)
-		if err != nil {
-			continue
-		}
+	if err := validateCoordinates(req.Coordinates); err != nil {
+		return &pb.RegisterNodeResponse{Success: false, Message: fmt.Sprintf("Invalid coordinates: %v", err)}, nil
+	}
 
-		if regPlugin, ok := plg.(plugin.RegistryPlugin); ok {
-			// Convert request to metadata map
-			metadata := map[string]interface{}{
-				"node_id": req.NodeId,
-				"specialization": req.Specialization,
-				"endpoint": req.Endpoint,
-				"org": req.Org,
-				"private_node": req.PrivateNode,
-			}
+	r.mu.Lock()
+
+	// Check if we've reached the maximum number of nodes, registered nodes
+	// may always re-register
+	if !r.hasRoomLocked(req.NodeId) {
+		r.mu.Unlock()
+		return &pb.RegisterNodeResponse{
+			Success: false,
+			Message: fmt.Sprintf("Maximum number of nodes (%d) reached", r.maxNodes),
+		}, nil
+	}
+
+	// Verify the signed challenge, nodes registered with a key stay bound to it
+	publicKey, err := r.verifyRegistrationLocked(req)
+	hooks := r.hooks
+	r.mu.Unlock()
+	if err != nil {
+		log.Printf("Rejected registration of node %s: %v", req.NodeId, err)
+		return &pb.RegisterNodeResponse{Success: false, Message: fmt.Sprintf("Identity verification failed: %v", err)}, nil
+	}
 
-			if err := regPlugin.OnNodeRegister(req.NodeId, metadata); err != nil {
-				return &pb.RegisterNodeResponse{Success: false, Message: err.Error()}, nil
+	// Call plugins for node registration in priority order without the lock,
+	// each sees the changes of the plugins before it
+	metadata := map[string]interface{}{
+		"node_id":        req.NodeId,
+		"specialization": req.Specialization,
+		"endpoint":       req.Endpoint,
+		"org":            req.Org,
+		"private_node":   req.PrivateNode,
+		"labels":         copyLabels(req.Labels),
+		"capacity":       capacityMetadata(req.Capacity),
+		"version":        req.Version,
+		"region":         req.Region,
+		"datacenter":     req.Datacenter,
+		"coordinates":    coordinatesMetadata(req.Coordinates),
+		"user_id":        auth.UserIDFromContext(ctx),
+		"key_verified":   publicKey != nil,
+	}
+	for _, hook := range hooks {
+		input := copyMetadata(metadata)
+		var changes *plugin.NodeChanges
+		ok, err := hook.run(ctx, "register", func(ctx context.Context) error {
+			var err error
+			if changes, err = hook.plugin.OnNodeRegister(ctx, req.NodeId, input); err != nil {
+				return err
 			}
+			if err := checkNodeChanges(changes); err != nil {
+				return &plugin.FailureError{Err: fmt.Errorf("invalid changes: %v", err)}
+			}
+			return nil
+		})
+		if err != nil {
+			return &pb.RegisterNodeResponse{Success: false, Message: err.Error()}, nil
+		}
+		if ok {
+			applyNodeChanges(metadata, changes)
 		}
 	}
+	labels := metadata["labels"].(map[string]string)
+	for key := range labels {
+		if key == "" {
+			return &pb.RegisterNodeResponse{Success: false, Message: "Label keys must not be empty"}, nil
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
 
-	// Create and store the node
+	// The pool may have filled up or the node been registered with another
+	// key while plugins ran
+	if !r.hasRoomLocked(req.NodeId) {
+		return &pb.RegisterNodeResponse{
+			Success: false,
+			Message: fmt.Sprintf("Maximum number of nodes (%d) reached", r.maxNodes),
+		}, nil
+	}
+	if existing, exists := r.nodes[req.NodeId]; exists && len(existing.PublicKey) > 0 && !bytes.Equal(existing.PublicKey, publicKey) {
+		return &pb.RegisterNodeResponse{
+			Success: false,
+			Message: "Node was registered with another key during registration, request a new challenge and retry",
+		}, nil
+	}
+
+	// Create and store the node, metadata types were checked by checkNodeChanges
 	node := &pb.NodeInfo{
 		NodeId:         req.NodeId,
-		Specialization: req.Specialization,
-		Endpoint:       req.Endpoint,
+		Specialization: metadata["specialization"].(string),
+		Endpoint:       metadata["endpoint"].(string),
 		Org:            req.Org,
-		PrivateNode:    req.PrivateNode,
-		Status:         "healthy",
-		RegisteredAt:   time.Now().Unix(),
+		PrivateNode:    metadata["private_node"].(bool),
+		RegisteredAt:   r.clock().Unix(),
+		Labels:         labels,
+		Capacity:       req.Capacity,
+		Version:        metadata["version"].(string),
+		Region:         metadata["region"].(string),
+		Datacenter:     metadata["datacenter"].(string),
+		Coordinates:    req.Coordinates,
+		PublicKey:      publicKey,
 	}
 
+	// Re-registering nodes keep their status and history, so a restart
+	// doesn't lift a cordon; new nodes wait for their first heartbeat
+	eventType := pb.NodeEvent_ADDED
+	if existing, exists := r.nodes[req.NodeId]; exists {
+		eventType = pb.NodeEvent_UPDATED
+		node.Status = existing.Status
+		node.StatusChangedAt = existing.StatusChangedAt
+		node.StatusHistory = existing.StatusHistory
+	} else if _, err := r.recordTransitionLocked(node, StatusRegistering, "registered"); err != nil {
+		return &pb.RegisterNodeResponse{Success: false, Message: err.Error()}, nil
+	}
+
+	r.renewLeaseLocked(node) // Start the node's lease
 	r.nodes[req.NodeId] = node
-	r.missedHeartbeats[req.NodeId] = 0 // Initialize heartbeat tracking
+	r.emitLocked(eventType, node, nil)
+	r.persistNodeLocked(req.NodeId)
+
+	log.Printf("Registered node: %s (%s) from org: %s", req.NodeId, node.Specialization, req.Org)
+	token, tokenExpiresAt := r.issueSessionToken(node)
+	return &pb.RegisterNodeResponse{
+		Success:               true,
+		Message:               "Node registered successfully",
+		LeaseTtlSeconds:       int64(r.leaseTTL / time.Second),
+		LeaseExpiresAt:        node.LeaseExpiresAt,
+		SessionToken:          token,
+		SessionTokenExpiresAt: tokenExpiresAt,
+	}, nil
+}
 
-	log.Printf("Registered node: %s (%s) from org: %s", req.NodeId, req.Specialization, req.Org)
-	return &pb.RegisterNodeResponse{Success: true, Message: "Node registered successfully"}, nil
+// hasRoomLocked reports whether a node may register without exceeding the
+// maximum number of nodes (must be called with lock held)
+func (r *Registry) hasRoomLocked(nodeID string) bool {
+	if _, exists := r.nodes[nodeID]; exists {
+		return true
+	}
+	return len(r.nodes) < r.maxNodes
 }
 
 // Heartbeat handles node heartbeat requests
 func (r *Registry) Heartbeat(ctx context.Context, req *pb.HeartbeatRequest) (*pb.HeartbeatResponse, error) {
+	if err := validateLoad(req.Load); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	r.mu.Lock()
+	node, ok := r.nodes[req.NodeId]
+	if !ok {
+		r.mu.Unlock()
+		return &pb.HeartbeatResponse{Alive: false, Message: "Node not registered"}, nil
+	}
+	if !mayActOnOrg(ctx, node.Org) {
+		r.mu.Unlock()
+		return &pb.HeartbeatResponse{Alive: false, Message: "Node belongs to another organization"}, nil
+	}
+	if !mayActAsNode(ctx, node.NodeId, node.Org) {
+		r.mu.Unlock()
+		return nil, status.Error(codes.PermissionDenied, "client certificate was issued for a different node")
+	}
+	if err := r.verifyProofLocked(node, ProofHeartbeat, req.Proof); err != nil {
+		r.mu.Unlock()
+		return nil, err
+	}
+	publicKey, hooks := node.PublicKey, r.hooks
+	r.mu.Unlock()
+
+	// Call plugins for heartbeat without the lock, a rejected heartbeat does
+	// not renew the lease
+	for _, hook := range hooks {
+		if _, err := hook.run(ctx, "heartbeat", func(ctx context.Context) error {
+			return hook.plugin.OnNodeHeartbeat(ctx, req.NodeId)
+		}); err != nil {
+			return &pb.HeartbeatResponse{Alive: false, Message: fmt.Sprintf("Heartbeat rejected: %v", err)}, nil
+		}
+	}
+
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	node, ok := r.nodes[req.NodeId]
+	// The node may have been removed, or registered again, while plugins ran;
+	// the proof only covers the key it was checked against
+	node, ok = r.nodes[req.NodeId]
 	if !ok {
 		return &pb.HeartbeatResponse{Alive: false, Message: "Node not registered"}, nil
 	}
+	if !bytes.Equal(node.PublicKey, publicKey) {
+		return &pb.HeartbeatResponse{Alive: false, Message: "Node was registered with another key during the heartbeat"}, nil
+	}
 
-	// Update node status
-	node.Status = "healthy"
-	node.LastHeartbeatAt = time.Now().Unix()
-	
-	// Reset missed heartbeat counter
-	r.missedHeartbeats[req.NodeId] = 0
+	now := r.clock().Unix()
 
-	// Call plugins for heartbeat
-	for _, pluginCfg := range r.config.Registry.Plugins {
-		if !pluginCfg.Enabled {
-			continue
+	// Store the latest load and health readings
+	healthChanged := healthChecksChanged(node.HealthChecks, req.HealthChecks)
+	loadUpdated := req.Load != nil && loadChanged(node.Load, req.Load)
+	if req.Load != nil {
+		node.Load = proto.Clone(req.Load).(*pb.NodeLoad)
+		node.Load.ReportedAt = now
+	}
+	node.HealthChecks = req.HealthChecks
+
+	node.LastHeartbeatAt = now
+	r.renewLeaseLocked(node)
+
+	// Update node status unless an operator has cordoned or drained it. A
+	// status change is published with the new readings, otherwise changed
+	// readings are published as an update of their own.
+	previousStatus := node.Status
+	if !isOperatorStatus(node.Status) {
+		reason := "heartbeat"
+		newStatus := statusFromHealthChecks(req.HealthChecks)
+		if newStatus == StatusDegraded {
+			reason = "health checks failing"
 		}
-
-		plg, err := r.pluginManager.Get(pluginCfg.Name)
-		if err != nil {
-			continue
+		if err := r.transitionLocked(node, newStatus, reason); err != nil {
+			log.Printf("Warning: %v", err)
 		}
+	}
+	if node.Status == previousStatus {
+		r.publishUpdateLocked(node, healthChanged, loadUpdated)
+	}
 
-		if regPlugin, ok := plg.(plugin.RegistryPlugin); ok {
-			regPlugin.OnNodeHeartbeat(req.NodeId)
-		}
+	r.persistNodeLocked(req.NodeId)
+
+	token, tokenExpiresAt := r.issueSessionToken(node)
+	return &pb.HeartbeatResponse{
+		Alive:                 true,
+		Message:               "Heartbeat acknowledged",
+		LeaseTtlSeconds:       int64(r.leaseTTL / time.Second),
+		LeaseExpiresAt:        node.LeaseExpiresAt,
+		SessionToken:          token,
+		SessionTokenExpiresAt: tokenExpiresAt,
+	}, nil
+}
+
+// DeregisterNode handles graceful node shutdown requests
+func (r *Registry) DeregisterNode(ctx context.Context, req *pb.DeregisterNodeRequest) (*pb.DeregisterNodeResponse, error) {
+	// Plugins are told about the removal once the lock is released
+	var removed []string
+	var hooks []registryHook
+	defer func() { notifyDeregistered(hooks, removed) }()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	node, ok := r.nodes[req.NodeId]
+	if !ok {
+		return &pb.DeregisterNodeResponse{Success: false, Message: "Node not registered"}, nil
+	}
+	if !mayActOnOrg(ctx, node.Org) {
+		return &pb.DeregisterNodeResponse{Success: false, Message: "Node belongs to another organization"}, nil
+	}
+	if !mayActAsNode(ctx, node.NodeId, node.Org) {
+		return nil, status.Error(codes.PermissionDenied, "client certificate was issued for a different node")
+	}
+	if err := r.verifyProofLocked(node, ProofDeregister, req.Proof); err != nil {
+		return nil, err
 	}
 
-	return &pb.HeartbeatResponse{Alive: true, Message: "Heartbeat acknowledged"}, nil
+	log.Printf("Deregistering node on request: %s", req.NodeId)
+	r.removeNodeLocked(req.NodeId, "deregistered by node")
+	removed, hooks = []string{req.NodeId}, r.hooks
+
+	return &pb.DeregisterNodeResponse{Success: true, Message: "Node deregistered successfully"}, nil
 }
 
-// ListNodes handles requests to list available nodes
-func (r *Registry) ListNodes(ctx context.Context, req *pb.ListNodesRequest) (*pb.ListNodesResponse, error) {
+// GetNode handles requests to look up a single node
+func (r *Registry) GetNode(ctx context.Context, req *pb.GetNodeRequest) (*pb.GetNodeResponse, error) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
+	// Nodes of other organizations are reported as not found
+	node, ok := r.nodes[req.NodeId]
+	if !ok || !mayActOnOrg(ctx, node.Org) {
+		return &pb.GetNodeResponse{Found: false}, nil
+	}
+
+	// Return a copy, the stored node keeps being updated by heartbeats
+	return &pb.GetNodeResponse{Found: true, Node: proto.Clone(node).(*pb.NodeInfo)}, nil
+}
+
+// SetNodeStatus handles operator requests to cordon, drain or restore a node
+func (r *Registry) SetNodeStatus(ctx context.Context, req *pb.SetNodeStatusRequest) (*pb.SetNodeStatusResponse, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	node, ok := r.nodes[req.NodeId]
+	if !ok {
+		return &pb.SetNodeStatusResponse{Success: false, Message: "Node not registered"}, nil
+	}
+	if !mayActOnOrg(ctx, node.Org) {
+		return &pb.SetNodeStatusResponse{Success: false, Message: "Node belongs to another organization"}, nil
+	}
+
+	target := req.Status
+	switch target {
+	case StatusCordoned, StatusDraining:
+	case StatusHealthy:
+		// Returning to service resumes the status the node's health checks report
+		target = statusFromHealthChecks(node.HealthChecks)
+	default:
+		return &pb.SetNodeStatusResponse{
+			Success: false,
+			Message: fmt.Sprintf("Status %q cannot be set by operators, use %s, %s or %s", req.Status, StatusCordoned, StatusDraining, StatusHealthy),
+		}, nil
+	}
+
+	reason := req.Reason
+	if reason == "" {
+		reason = "set by operator"
+	}
+
+	if err := r.transitionLocked(node, target, reason); err != nil {
+		return &pb.SetNodeStatusResponse{Success: false, Message: err.Error()}, nil
+	}
+	r.persistNodeLocked(req.NodeId)
+
+	return &pb.SetNodeStatusResponse{
+		Success: true,
+		Message: fmt.Sprintf("Node status is %s", node.Status),
+		Node:    proto.Clone(node).(*pb.NodeInfo),
+	}, nil
+}
+
+// ListNodes handles requests to list available nodes
+func (r *Registry) ListNodes(ctx context.Context, req *pb.ListNodesRequest) (*pb.ListNodesResponse, error) {
+	if err := validateSelector(req.LabelSelector); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := validateGeoQuery(req.Geo); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	org, err := scopedOrg(ctx, req.Org)
+	if err != nil {
+		return nil, err
+	}
+
 	// Prepare filter for plugins
 	filter := map[string]string{}
 	if req.Specialization != "" {
 		filter["specialization"] = req.Specialization
 	}
-	if req.Org != "" {
-		filter["org"] = req.Org
+	if org != "" {
+		filter["org"] = org
 	}
-
-	// Call plugins for node listing
-	for _, pluginCfg := range r.config.Registry.Plugins {
-		if !pluginCfg.Enabled {
-			continue
-		}
-
-		plg, err := r.pluginManager.Get(pluginCfg.Name)
-		if err != nil {
-			continue
-		}
-
-		if regPlugin, ok := plg.(plugin.RegistryPlugin); ok {
-			regPlugin.OnNodeList(filter)
-		}
+	if selector := selectorString(req.LabelSelector); selector != "" {
+		filter["label_selector"] = selector
+	}
+	if req.Geo != nil && req.Geo.Region != "" {
+		filter["geo_region"] = req.Geo.Region
+	}
+	if userID := auth.UserIDFromContext(ctx); userID != "" {
+		filter["user_id"] = userID
 	}
 
+	r.mu.RLock()
+
 	// Filter nodes based on request
 	var result []*pb.NodeInfo
 	for _, node := range r.nodes {
-		// Skip unhealthy nodes
-		if node.Status != "healthy" {
+		// Skip nodes that aren't healthy unless asked for all of them
+		if node.Status != StatusHealthy && !req.IncludeAllStatuses {
 			continue
 		}
 
-		// Apply specialization filter
-		if req.Specialization != "" && node.Specialization != req.Specialization {
+		// Apply specialization, organization and label filters
+		if !matchesFilter(node, req.Specialization, org, req.LabelSelector) {
 			continue
 		}
 
-		// Apply organization filter
-		if req.Org != "" && node.Org != req.Org {
-			continue
+		// Copy the node, heartbeats keep updating the stored one
+		result = append(result, proto.Clone(node).(*pb.NodeInfo))
+	}
+
+	// Measure distances from the requested origin, if any
+	origin, err := r.geoOriginLocked(req.Geo)
+	hooks, revision := r.hooks, r.revision
+	r.mu.RUnlock()
+	if err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	result = applyGeoQuery(result, req.Geo, origin)
+
+	// Call plugins for node listing in priority order without the lock, they
+	// may filter and reorder the nodes
+	for _, hook := range hooks {
+		input := make([]map[string]interface{}, 0, len(result))
+		for _, node := range result {
+			input = append(input, nodeMetadata(node))
+		}
+		hookFilter := copyLabels(filter)
+		var ids []string
+		ok, err := hook.run(ctx, "list", func(ctx context.Context) error {
+			var err error
+			ids, err = hook.plugin.OnNodeList(ctx, hookFilter, input)
+			return err
+		})
+		if err != nil {
+			code := codes.PermissionDenied
+			if err.failed {
+				code = codes.Unavailable
+			}
+			return nil, status.Error(code, err.Error())
+		}
+		if ok && ids != nil {
+			result = orderNodes(result, ids, hook.name)
 		}
+	}
+
+	return &pb.ListNodesResponse{Nodes: result, Revision: revision}, nil
+}
 
-		result = append(result, node)
+// matchesFilter reports whether a node matches the specialization,
+// organization and label filters shared by ListNodes and WatchNodes
+func matchesFilter(node *pb.NodeInfo, specialization, org string, selector *pb.LabelSelector) bool {
+	if specialization != "" && node.Specialization != specialization {
+		return false
 	}
+	if org != "" && node.Org != org {
+		return false
+	}
+	return matchesSelector(node.Labels, selector)
+}
+
+// copyLabels returns a copy of a label map so stored nodes don't alias request data
+func copyLabels(labels map[string]string) map[string]string {
+	result := make(map[string]string, len(labels))
+	for key, value := range labels {
+		result[key] = value
+	}
+	return result
+}
 
-	return &pb.ListNodesResponse{Nodes: result}, nil
+// capacityMetadata converts node capacity to the plugin metadata representation
+func capacityMetadata(capacity *pb.NodeCapacity) map[string]interface{} {
+	if capacity == nil {
+		return map[string]interface{}{}
+	}
+	return map[string]interface{}{
+		"cpu_cores":     capacity.CpuCores,
+		"memory_mb":     capacity.MemoryMb,
+		"storage_tb":    capacity.StorageTb,
+		"compute_units": capacity.ComputeUnits,
+		"gpu_model":     capacity.GpuModel,
+		"gpu_count":     capacity.GpuCount,
+	}
+}
+
+// coordinatesMetadata converts coordinates to the plugin metadata representation
+func coordinatesMetadata(coords *pb.Coordinates) map[string]interface{} {
+	if coords == nil {
+		return map[string]interface{}{}
+	}
+	return map[string]interface{}{
+		"latitude":  coords.Latitude,
+		"longitude": coords.Longitude,
+	}
 }
 
 // GetNodeCount returns the current number of registered nodes
@@ -0,0 +1,397 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync/atomic"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"galaxy-node-pool/internal/config"
+	"galaxy-node-pool/internal/plugin"
+	pb "galaxy-node-pool/proto/pool"
+)
+
+// Built-in node selection strategies
+const (
+	// StrategyRoundRobin rotates through the matching nodes
+	StrategyRoundRobin = "round-robin"
+
+	// StrategyLeastLoaded prefers nodes reporting the least work in heartbeats
+	StrategyLeastLoaded = "least-loaded"
+
+	// StrategyWeightedRandom picks nodes at random, weighted by capacity
+	StrategyWeightedRandom = "weighted-random"
+
+	// StrategyConsistentHash maps a caller-supplied key to the same nodes
+	// for as long as they stay registered
+	StrategyConsistentHash = "consistent-hash"
+
+	// StrategyNearest picks the nodes closest to the request's geo origin
+	StrategyNearest = "nearest"
+)
+
+// hashRingReplicas is the number of virtual nodes each node has on the hash ring
+const hashRingReplicas = 100
+
+// selectionStrategy orders candidate nodes and returns up to count of them.
+// Strategies are called without the registry lock held.
+type selectionStrategy interface {
+	selectNodes(ctx context.Context, candidates []*pb.NodeInfo, count int, key string) ([]*pb.NodeInfo, error)
+}
+
+// builtinStrategies returns the strategies available without plugins
+func builtinStrategies() map[string]selectionStrategy {
+	return map[string]selectionStrategy{
+		StrategyRoundRobin:     &roundRobinStrategy{},
+		StrategyLeastLoaded:    leastLoadedStrategy{},
+		StrategyWeightedRandom: weightedRandomStrategy{},
+		StrategyConsistentHash: consistentHashStrategy{},
+		StrategyNearest:        nearestStrategy{},
+	}
+}
+
+// defaultStrategy returns the configured default selection strategy
+func defaultStrategy(cfg *config.Config) string {
+	if cfg.Registry.Selection.DefaultStrategy != "" {
+		return cfg.Registry.Selection.DefaultStrategy
+	}
+	return StrategyLeastLoaded
+}
+
+// SelectNodes handles requests to pick the best nodes for a request
+func (r *Registry) SelectNodes(ctx context.Context, req *pb.SelectNodesRequest) (*pb.SelectNodesResponse, error) {
+	if err := validateSelector(req.LabelSelector); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := validateGeoQuery(req.Geo); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	org, err := scopedOrg(ctx, req.Org)
+	if err != nil {
+		return nil, err
+	}
+
+	name := req.Strategy
+	if name == "" {
+		name = r.defaultStrategy
+	}
+
+	strategy, err := r.strategy(name)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	count := int(req.Count)
+	if count <= 0 {
+		count = 1
+	}
+
+	r.mu.RLock()
+	origin, err := r.geoOriginLocked(req.Geo)
+	if err != nil {
+		r.mu.RUnlock()
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	if name == StrategyNearest && origin == nil {
+		r.mu.RUnlock()
+		return nil, status.Errorf(codes.InvalidArgument, "strategy %s requires a geo origin or region", StrategyNearest)
+	}
+
+	// Only healthy nodes are eligible. Candidates are copies, heartbeats keep
+	// updating the stored nodes, and the strategy runs without the lock.
+	var candidates []*pb.NodeInfo
+	for _, node := range r.nodes {
+		if node.Status != StatusHealthy {
+			continue
+		}
+		if !matchesFilter(node, req.Specialization, org, req.LabelSelector) {
+			continue
+		}
+		candidates = append(candidates, proto.Clone(node).(*pb.NodeInfo))
+	}
+	r.mu.RUnlock()
+
+	// Sort so strategies are deterministic; with a geo origin candidates are
+	// ordered nearest first, so ties in other strategies favour closer nodes
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].NodeId < candidates[j].NodeId
+	})
+	candidates = applyGeoQuery(candidates, req.Geo, origin)
+	if origin != nil {
+		sortByDistance(candidates)
+	}
+
+	if len(candidates) == 0 {
+		return &pb.SelectNodesResponse{Success: false, Message: "No healthy nodes match the request", Strategy: name}, nil
+	}
+
+	selected, err := strategy.selectNodes(ctx, candidates, count, req.HashKey)
+	if err != nil {
+		// Plugin failures carry their own status
+		if _, ok := status.FromError(err); ok {
+			return nil, err
+		}
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return &pb.SelectNodesResponse{
+		Success:  true,
+		Message:  fmt.Sprintf("Selected %d of %d nodes", len(selected), len(candidates)),
+		Nodes:    selected,
+		Strategy: name,
+	}, nil
+}
+
+// strategy resolves a strategy name to a built-in strategy or a selection plugin
+func (r *Registry) strategy(name string) (selectionStrategy, error) {
+	if strategy, ok := r.strategies[name]; ok {
+		return strategy, nil
+	}
+
+	if r.pluginManager == nil {
+		return nil, fmt.Errorf("unknown selection strategy %s", name)
+	}
+	plg, err := r.pluginManager.Get(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown selection strategy %s", name)
+	}
+
+	selPlugin, ok := plg.(plugin.SelectionPlugin)
+	if !ok {
+		return nil, fmt.Errorf("plugin %s is not a selection plugin", name)
+	}
+
+	return pluginStrategy{plugin: selPlugin, call: r.pluginCallFor(name)}, nil
+}
+
+// roundRobinStrategy rotates the starting node on every selection
+type roundRobinStrategy struct {
+	next atomic.Uint64
+}
+
+func (s *roundRobinStrategy) selectNodes(ctx context.Context, candidates []*pb.NodeInfo, count int, key string) ([]*pb.NodeInfo, error) {
+	start := int((s.next.Add(1) - 1) % uint64(len(candidates)))
+
+	result := make([]*pb.NodeInfo, 0, count)
+	for i := 0; i < count && i < len(candidates); i++ {
+		result = append(result, candidates[(start+i)%len(candidates)])
+	}
+	return result, nil
+}
+
+// leastLoadedStrategy ranks nodes by the load reported in their last heartbeat.
+// Nodes that never reported load rank last.
+type leastLoadedStrategy struct{}
+
+func (leastLoadedStrategy) selectNodes(ctx context.Context, candidates []*pb.NodeInfo, count int, key string) ([]*pb.NodeInfo, error) {
+	ranked := append([]*pb.NodeInfo(nil), candidates...)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		a, b := ranked[i].Load, ranked[j].Load
+		if a == nil || b == nil {
+			return a != nil && b == nil
+		}
+
+		// Outstanding work first, utilisation breaks ties
+		workA, workB := a.ActiveTasks+a.QueueDepth, b.ActiveTasks+b.QueueDepth
+		if workA != workB {
+			return workA < workB
+		}
+		return math.Max(a.CpuUtilization, a.MemoryUtilization) < math.Max(b.CpuUtilization, b.MemoryUtilization)
+	})
+
+	return firstN(ranked, count), nil
+}
+
+// weightedRandomStrategy samples nodes without replacement, weighted by
+// compute units or CPU cores from their reported capacity
+type weightedRandomStrategy struct{}
+
+func (weightedRandomStrategy) selectNodes(ctx context.Context, candidates []*pb.NodeInfo, count int, key string) ([]*pb.NodeInfo, error) {
+	// Efraimidis-Spirakis: ordering by u^(1/w) is a weighted sample
+	type scored struct {
+		node  *pb.NodeInfo
+		score float64
+	}
+
+	ranked := make([]scored, len(candidates))
+	for i, node := range candidates {
+		ranked[i] = scored{node: node, score: math.Pow(rand.Float64(), 1/nodeWeight(node))}
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		return ranked[i].score > ranked[j].score
+	})
+
+	result := make([]*pb.NodeInfo, 0, count)
+	for i := 0; i < count && i < len(ranked); i++ {
+		result = append(result, ranked[i].node)
+	}
+	return result, nil
+}
+
+// nodeWeight returns a node's selection weight, defaulting to 1 when it
+// reported no capacity
+func nodeWeight(node *pb.NodeInfo) float64 {
+	if node.Capacity != nil {
+		if node.Capacity.ComputeUnits > 0 {
+			return float64(node.Capacity.ComputeUnits)
+		}
+		if node.Capacity.CpuCores > 0 {
+			return float64(node.Capacity.CpuCores)
+		}
+	}
+	return 1
+}
+
+// consistentHashStrategy places nodes on a hash ring and walks it clockwise
+// from the key, so a key keeps mapping to the same nodes as others join or leave
+type consistentHashStrategy struct{}
+
+func (consistentHashStrategy) selectNodes(ctx context.Context, candidates []*pb.NodeInfo, count int, key string) ([]*pb.NodeInfo, error) {
+	if key == "" {
+		return nil, fmt.Errorf("strategy %s requires a hash_key", StrategyConsistentHash)
+	}
+
+	type point struct {
+		hash uint64
+		node *pb.NodeInfo
+	}
+
+	ring := make([]point, 0, len(candidates)*hashRingReplicas)
+	for _, node := range candidates {
+		for i := 0; i < hashRingReplicas; i++ {
+			ring = append(ring, point{hash: hashKey(node.NodeId + "#" + strconv.Itoa(i)), node: node})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool {
+		return ring[i].hash < ring[j].hash
+	})
+
+	target := hashKey(key)
+	start := sort.Search(len(ring), func(i int) bool {
+		return ring[i].hash >= target
+	})
+
+	result := make([]*pb.NodeInfo, 0, count)
+	seen := make(map[string]bool)
+	for i := 0; i < len(ring) && len(result) < count; i++ {
+		node := ring[(start+i)%len(ring)].node
+		if seen[node.NodeId] {
+			continue
+		}
+		seen[node.NodeId] = true
+		result = append(result, node)
+	}
+	return result, nil
+}
+
+// hashKey hashes a string onto the hash ring
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64()
+}
+
+// nearestStrategy picks the first candidates, which SelectNodes orders by
+// distance from the request's geo origin
+type nearestStrategy struct{}
+
+func (nearestStrategy) selectNodes(ctx context.Context, candidates []*pb.NodeInfo, count int, key string) ([]*pb.NodeInfo, error) {
+	return firstN(candidates, count), nil
+}
+
+// pluginStrategy adapts a SelectionPlugin to a selection strategy. Calls are
+// bounded by the plugin's timeout; failures reject the selection, or with
+// the open failure policy fall back to the least-loaded strategy.
+type pluginStrategy struct {
+	plugin plugin.SelectionPlugin
+	call   pluginCall
+}
+
+func (s pluginStrategy) selectNodes(ctx context.Context, candidates []*pb.NodeInfo, count int, key string) ([]*pb.NodeInfo, error) {
+	byID := make(map[string]*pb.NodeInfo, len(candidates))
+	metadata := make([]map[string]interface{}, 0, len(candidates))
+	for _, node := range candidates {
+		byID[node.NodeId] = node
+		metadata = append(metadata, nodeMetadata(node))
+	}
+
+	var ids []string
+	ok, err := s.call.run(ctx, "select", func(ctx context.Context) error {
+		var err error
+		ids, err = s.plugin.SelectNodes(metadata, count, key)
+		return err
+	})
+	if err != nil {
+		if err.failed {
+			return nil, status.Error(codes.Unavailable, err.Error())
+		}
+		return nil, fmt.Errorf("selection plugin %s rejected the request: %v", s.plugin.Name(), err)
+	}
+	if !ok {
+		return leastLoadedStrategy{}.selectNodes(ctx, candidates, count, key)
+	}
+
+	// Drop IDs the plugin made up or repeated, and anything beyond count
+	result := make([]*pb.NodeInfo, 0, count)
+	for _, id := range ids {
+		node, ok := byID[id]
+		if !ok {
+			log.Printf("Warning: Selection plugin %s returned unknown node %s", s.plugin.Name(), id)
+			continue
+		}
+		delete(byID, id)
+		result = append(result, node)
+	}
+	return firstN(result, count), nil
+}
+
+// nodeMetadata converts a node to the plugin metadata representation
+func nodeMetadata(node *pb.NodeInfo) map[string]interface{} {
+	metadata := map[string]interface{}{
+		"node_id":        node.NodeId,
+		"specialization": node.Specialization,
+		"endpoint":       node.Endpoint,
+		"org":            node.Org,
+		"private_node":   node.PrivateNode,
+		"labels":         copyLabels(node.Labels),
+		"capacity":       capacityMetadata(node.Capacity),
+		"version":        node.Version,
+		"region":         node.Region,
+		"datacenter":     node.Datacenter,
+		"coordinates":    coordinatesMetadata(node.Coordinates),
+		"status":         node.Status,
+	}
+
+	if node.DistanceKm != nil {
+		metadata["distance_km"] = *node.DistanceKm
+	}
+
+	if node.Load != nil {
+		metadata["load"] = map[string]interface{}{
+			"active_tasks":       node.Load.ActiveTasks,
+			"cpu_utilization":    node.Load.CpuUtilization,
+			"memory_utilization": node.Load.MemoryUtilization,
+			"queue_depth":        node.Load.QueueDepth,
+			"reported_at":        node.Load.ReportedAt,
+		}
+	}
+
+	return metadata
+}
+
+// firstN returns at most the first n nodes
+func firstN(nodes []*pb.NodeInfo, n int) []*pb.NodeInfo {
+	if len(nodes) > n {
+		return nodes[:n]
+	}
+	return nodes
+}
@@ -0,0 +1,128 @@
+package registry
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	pb "galaxy-node-pool/proto/pool"
+)
+
+// validateSelector checks that a label selector is well formed
+func validateSelector(sel *pb.LabelSelector) error {
+	if sel == nil {
+		return nil
+	}
+
+	for key := range sel.MatchLabels {
+		if key == "" {
+			return fmt.Errorf("label selector contains an empty key")
+		}
+	}
+
+	for _, req := range sel.MatchExpressions {
+		if req.Key == "" {
+			return fmt.Errorf("label selector expression has an empty key")
+		}
+
+		switch req.Operator {
+		case pb.LabelSelectorRequirement_IN, pb.LabelSelectorRequirement_NOT_IN:
+			if len(req.Values) == 0 {
+				return fmt.Errorf("label selector operator %s on %s requires values", req.Operator, req.Key)
+			}
+		case pb.LabelSelectorRequirement_EXISTS, pb.LabelSelectorRequirement_DOES_NOT_EXIST:
+			if len(req.Values) > 0 {
+				return fmt.Errorf("label selector operator %s on %s does not take values", req.Operator, req.Key)
+			}
+		default:
+			return fmt.Errorf("label selector expression on %s has unsupported operator %s", req.Key, req.Operator)
+		}
+	}
+
+	return nil
+}
+
+// matchesSelector reports whether labels satisfy every requirement of a selector.
+// A nil selector matches everything.
+func matchesSelector(labels map[string]string, sel *pb.LabelSelector) bool {
+	if sel == nil {
+		return true
+	}
+
+	for key, value := range sel.MatchLabels {
+		if actual, ok := labels[key]; !ok || actual != value {
+			return false
+		}
+	}
+
+	for _, req := range sel.MatchExpressions {
+		actual, ok := labels[req.Key]
+		switch req.Operator {
+		case pb.LabelSelectorRequirement_IN:
+			if !ok || !containsString(req.Values, actual) {
+				return false
+			}
+		case pb.LabelSelectorRequirement_NOT_IN:
+			// As in Kubernetes, nodes without the label satisfy NOT_IN
+			if ok && containsString(req.Values, actual) {
+				return false
+			}
+		case pb.LabelSelectorRequirement_EXISTS:
+			if !ok {
+				return false
+			}
+		case pb.LabelSelectorRequirement_DOES_NOT_EXIST:
+			if ok {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// selectorString renders a selector in Kubernetes string syntax,
+// e.g. "env=prod,tier in (a,b),gpu,!spot"
+func selectorString(sel *pb.LabelSelector) string {
+	if sel == nil {
+		return ""
+	}
+
+	var parts []string
+
+	keys := make([]string, 0, len(sel.MatchLabels))
+	for key := range sel.MatchLabels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		parts = append(parts, key+"="+sel.MatchLabels[key])
+	}
+
+	for _, req := range sel.MatchExpressions {
+		switch req.Operator {
+		case pb.LabelSelectorRequirement_IN:
+			parts = append(parts, fmt.Sprintf("%s in (%s)", req.Key, strings.Join(req.Values, ",")))
+		case pb.LabelSelectorRequirement_NOT_IN:
+			parts = append(parts, fmt.Sprintf("%s notin (%s)", req.Key, strings.Join(req.Values, ",")))
+		case pb.LabelSelectorRequirement_EXISTS:
+			parts = append(parts, req.Key)
+		case pb.LabelSelectorRequirement_DOES_NOT_EXIST:
+			parts = append(parts, "!"+req.Key)
+		}
+	}
+
+	return strings.Join(parts, ",")
+}
+
+// containsString reports whether a slice contains a value
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+package registry
+
+import (
+	"fmt"
+	"log"
+
+	pb "galaxy-node-pool/proto/pool"
+)
+
+// Node status values reported in NodeInfo.Status
+const (
+	// StatusRegistering marks a node that registered but has not heartbeat yet
+	StatusRegistering = "registering"
+
+	// StatusHealthy marks a node that heartbeats and reports no failing checks
+	StatusHealthy = "healthy"
+
+	// StatusDegraded marks a node that heartbeats but reports failing health checks
+	StatusDegraded = "degraded"
+
+	// StatusSuspect marks a node that has missed heartbeats but whose lease is still valid
+	StatusSuspect = "suspect"
+
+	// StatusCordoned marks a node an operator has excluded from new work
+	StatusCordoned = "cordoned"
+
+	// StatusDraining marks a node an operator is taking out of service
+	StatusDraining = "draining"
+
+	// StatusEvicted marks a node that has been removed from the registry
+	StatusEvicted = "evicted"
+)
+
+// statusHistorySize is the number of transitions kept on each node
+const statusHistorySize = 16
+
+// validTransitions lists the statuses each status may move to
+var validTransitions = map[string][]string{
+	StatusRegistering: {StatusHealthy, StatusDegraded, StatusSuspect, StatusCordoned, StatusDraining, StatusEvicted},
+	StatusHealthy:     {StatusDegraded, StatusSuspect, StatusCordoned, StatusDraining, StatusEvicted},
+	StatusDegraded:    {StatusHealthy, StatusSuspect, StatusCordoned, StatusDraining, StatusEvicted},
+	StatusSuspect:     {StatusHealthy, StatusDegraded, StatusCordoned, StatusDraining, StatusEvicted},
+	StatusCordoned:    {StatusHealthy, StatusDegraded, StatusDraining, StatusEvicted},
+	StatusDraining:    {StatusHealthy, StatusDegraded, StatusCordoned, StatusEvicted},
+}
+
+// canTransition reports whether a node may move from one status to another
+func canTransition(from, to string) bool {
+	for _, allowed := range validTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// isOperatorStatus reports whether a status is set by operators rather than
+// derived from heartbeats
+func isOperatorStatus(status string) bool {
+	return status == StatusCordoned || status == StatusDraining
+}
+
+// recordTransitionLocked moves a node to a new status and records the
+// transition on the node without publishing it (must be called with lock held)
+func (r *Registry) recordTransitionLocked(node *pb.NodeInfo, to, reason string) (*pb.StatusTransition, error) {
+	from := node.Status
+	if from != "" && !canTransition(from, to) {
+		return nil, fmt.Errorf("invalid status transition for node %s: %s -> %s", node.NodeId, from, to)
+	}
+
+	transition := &pb.StatusTransition{
+		From:   from,
+		To:     to,
+		At:     r.clock().Unix(),
+		Reason: reason,
+	}
+
+	node.Status = to
+	node.StatusChangedAt = transition.At
+	node.StatusHistory = append(node.StatusHistory, transition)
+	if len(node.StatusHistory) > statusHistorySize {
+		node.StatusHistory = node.StatusHistory[len(node.StatusHistory)-statusHistorySize:]
+	}
+
+	return transition, nil
+}
+
+// transitionLocked moves a node to a new status and publishes the change to
+// watchers. Moving to the current status is a no-op.
+// (must be called with lock held)
+func (r *Registry) transitionLocked(node *pb.NodeInfo, to, reason string) error {
+	if node.Status == to {
+		return nil
+	}
+
+	transition, err := r.recordTransitionLocked(node, to, reason)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Node %s status changed: %s -> %s (%s)", node.NodeId, transition.From, transition.To, reason)
+	r.emitLocked(pb.NodeEvent_UPDATED, node, transition)
+	return nil
+}
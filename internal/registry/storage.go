@@ -0,0 +1,172 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protojson"
+
+	"galaxy-node-pool/internal/plugin"
+	pb "galaxy-node-pool/proto/pool"
+)
+
+const (
+	// nodeKeyPrefix is the storage key prefix under which node records are kept
+	nodeKeyPrefix = "registry/nodes/"
+
+	// revisionKey stores the last event revision so watchers can't resume
+	// against reused revisions after a restart
+	revisionKey = "registry/revision"
+)
+
+// nodeRecord is the persisted form of a registered node
+type nodeRecord struct {
+	Node json.RawMessage `json:"node"`
+}
+
+// initStorage attaches the configured storage plugin, if any
+func (r *Registry) initStorage() error {
+	storageCfg := r.config.Registry.Storage
+	if storageCfg.Plugin == "" {
+		log.Printf("No registry storage configured, node state will not survive restarts")
+		return nil
+	}
+
+	plg, err := r.pluginManager.Get(storageCfg.Plugin)
+	if err != nil {
+		return fmt.Errorf("storage plugin %s not found: %v", storageCfg.Plugin, err)
+	}
+
+	storagePlugin, ok := plg.(plugin.StoragePlugin)
+	if !ok {
+		return fmt.Errorf("plugin %s is not a storage plugin", storageCfg.Plugin)
+	}
+
+	if err := r.pluginManager.InitializePlugin(storageCfg.Plugin, storageCfg.Config); err != nil {
+		return fmt.Errorf("failed to initialize storage plugin %s: %v", storageCfg.Plugin, err)
+	}
+
+	r.storage = storagePlugin
+	return nil
+}
+
+// restoreNodes rehydrates the in-memory node maps from storage
+func (r *Registry) restoreNodes() error {
+	if r.storage == nil {
+		return nil
+	}
+
+	keys, err := r.storage.List(nodeKeyPrefix)
+	if err != nil {
+		return fmt.Errorf("failed to list persisted nodes: %v", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if value, err := r.storage.Retrieve(revisionKey); err == nil {
+		if revision, err := strconv.ParseUint(string(valueBytes(value)), 10, 64); err == nil {
+			r.revision = revision
+		}
+	}
+
+	for _, key := range keys {
+		value, err := r.storage.Retrieve(key)
+		if err != nil {
+			log.Printf("Warning: Failed to load persisted node %s: %v", key, err)
+			continue
+		}
+
+		data := valueBytes(value)
+		if data == nil {
+			log.Printf("Warning: Unexpected value type %T for persisted node %s", value, key)
+			continue
+		}
+
+		var record nodeRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			log.Printf("Warning: Failed to decode persisted node %s: %v", key, err)
+			continue
+		}
+
+		node := &pb.NodeInfo{}
+		if err := protojson.Unmarshal(record.Node, node); err != nil {
+			log.Printf("Warning: Failed to decode persisted node %s: %v", key, err)
+			continue
+		}
+
+		nodeID := strings.TrimPrefix(key, nodeKeyPrefix)
+		r.renewLeaseLocked(node)
+		r.nodes[nodeID] = node
+	}
+
+	log.Printf("Restored %d nodes from storage", len(r.nodes))
+	return nil
+}
+
+// persistNodeLocked writes the current state of a node through to storage
+// (must be called with lock held)
+func (r *Registry) persistNodeLocked(nodeID string) {
+	if r.storage == nil {
+		return
+	}
+
+	node, ok := r.nodes[nodeID]
+	if !ok {
+		return
+	}
+
+	nodeData, err := protojson.Marshal(node)
+	if err != nil {
+		log.Printf("Warning: Failed to encode node %s for storage: %v", nodeID, err)
+		return
+	}
+
+	data, err := json.Marshal(nodeRecord{Node: nodeData})
+	if err != nil {
+		log.Printf("Warning: Failed to encode node %s for storage: %v", nodeID, err)
+		return
+	}
+
+	if err := r.storage.Store(nodeKeyPrefix+nodeID, data); err != nil {
+		log.Printf("Warning: Failed to persist node %s: %v", nodeID, err)
+	}
+}
+
+// persistRevisionLocked writes the current event revision through to storage
+// (must be called with lock held)
+func (r *Registry) persistRevisionLocked() {
+	if r.storage == nil {
+		return
+	}
+
+	if err := r.storage.Store(revisionKey, strconv.FormatUint(r.revision, 10)); err != nil {
+		log.Printf("Warning: Failed to persist registry revision: %v", err)
+	}
+}
+
+// deletePersistedNode removes a node record from storage
+func (r *Registry) deletePersistedNode(nodeID string) {
+	if r.storage == nil {
+		return
+	}
+
+	if err := r.storage.Delete(nodeKeyPrefix + nodeID); err != nil {
+		log.Printf("Warning: Failed to delete persisted node %s: %v", nodeID, err)
+	}
+}
+
+// valueBytes converts a value returned by a storage plugin to bytes
+func valueBytes(value interface{}) []byte {
+	switch v := value.(type) {
+	case []byte:
+		return v
+	case string:
+		return []byte(v)
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,257 @@
+package registry
+
+import (
+	"log"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	pb "galaxy-node-pool/proto/pool"
+)
+
+const (
+	// eventHistorySize is the number of recent events kept for resuming watchers
+	eventHistorySize = 1024
+
+	// watchBufferSize is the number of undelivered events a watcher may queue
+	// before it is disconnected and has to resume
+	watchBufferSize = 256
+
+	// loadEventInterval is the minimum time between UPDATED events caused by
+	// a node's load readings
+	loadEventInterval = 5 * time.Second
+)
+
+// loadEvent tracks when a node's last event was published and whether a
+// newer load reading is waiting for the next one
+type loadEvent struct {
+	publishedAt time.Time
+	pending     bool
+}
+
+// watcher is a single WatchNodes subscription
+type watcher struct {
+	id             uint64
+	specialization string
+	org            string
+	selector       *pb.LabelSelector
+	events         chan *pb.NodeEvent
+
+	// sent tracks the nodes the caller was sent and has not seen removed,
+	// false for nodes known to be outside the filters. Resumed watchers
+	// don't know what the caller received before the resume revision.
+	// Only used by the stream goroutine.
+	sent    map[string]bool
+	resumed bool
+}
+
+// matches reports whether a node passes the watcher's filters
+func (w *watcher) matches(node *pb.NodeInfo) bool {
+	return matchesFilter(node, w.specialization, w.org, w.selector)
+}
+
+// filter returns the event to send the caller for a node event, nil to skip
+// it. Nodes that start matching the filters are sent as ADDED and nodes that
+// stop matching them as REMOVED, so the caller's view stays consistent.
+func (w *watcher) filter(ev *pb.NodeEvent) *pb.NodeEvent {
+	nodeID := ev.Node.NodeId
+	sent, known := w.sent[nodeID]
+	if !known && w.resumed {
+		// The caller may hold the node from before it resumed
+		sent = true
+	}
+
+	switch {
+	case ev.Type == pb.NodeEvent_REMOVED:
+		delete(w.sent, nodeID)
+		if !sent {
+			return nil
+		}
+		return ev
+	case w.matches(ev.Node):
+		w.sent[nodeID] = true
+		if !sent && ev.Type == pb.NodeEvent_UPDATED {
+			return withEventType(ev, pb.NodeEvent_ADDED)
+		}
+		return ev
+	default:
+		w.sent[nodeID] = false
+		if !sent {
+			return nil
+		}
+		return withEventType(ev, pb.NodeEvent_REMOVED)
+	}
+}
+
+// withEventType returns a copy of a shared event with another type
+func withEventType(ev *pb.NodeEvent, eventType pb.NodeEvent_Type) *pb.NodeEvent {
+	result := proto.Clone(ev).(*pb.NodeEvent)
+	result.Type = eventType
+	return result
+}
+
+// WatchNodes streams node membership changes to the caller
+func (r *Registry) WatchNodes(req *pb.WatchNodesRequest, stream pb.Registry_WatchNodesServer) error {
+	org, err := scopedOrg(stream.Context(), req.Org)
+	if err != nil {
+		return err
+	}
+
+	w, backlog, err := r.addWatcher(req, org)
+	if err != nil {
+		return err
+	}
+	defer r.removeWatcher(w.id)
+
+	// Send the snapshot or replayed history first
+	for _, ev := range backlog {
+		if ev = w.filter(ev); ev == nil {
+			continue
+		}
+		if err := stream.Send(ev); err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case ev, ok := <-w.events:
+			if !ok {
+				return status.Errorf(codes.Aborted, "watcher fell behind, resume from the last received revision")
+			}
+			if ev = w.filter(ev); ev == nil {
+				continue
+			}
+			if err := stream.Send(ev); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// addWatcher registers a watcher and returns the events it has to receive
+// before live events: a snapshot of all nodes, or the history since the
+// requested resume revision. The org overrides the requested one for callers
+// bound to an organization.
+func (r *Registry) addWatcher(req *pb.WatchNodesRequest, org string) (*watcher, []*pb.NodeEvent, error) {
+	if err := validateSelector(req.LabelSelector); err != nil {
+		return nil, nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var backlog []*pb.NodeEvent
+	if req.ResumeRevision == 0 {
+		for _, node := range r.nodes {
+			backlog = append(backlog, &pb.NodeEvent{
+				Type:     pb.NodeEvent_ADDED,
+				Node:     proto.Clone(node).(*pb.NodeInfo),
+				Revision: r.revision,
+			})
+		}
+	} else {
+		if req.ResumeRevision > r.revision {
+			return nil, nil, status.Errorf(codes.OutOfRange, "revision %d is newer than current revision %d", req.ResumeRevision, r.revision)
+		}
+
+		// The oldest retained event must directly follow the resume revision,
+		// otherwise events in between have been compacted away
+		if req.ResumeRevision < r.revision && (len(r.history) == 0 || r.history[0].Revision > req.ResumeRevision+1) {
+			return nil, nil, status.Errorf(codes.OutOfRange, "revision %d has been compacted, list nodes and watch again", req.ResumeRevision)
+		}
+
+		for _, ev := range r.history {
+			if ev.Revision > req.ResumeRevision {
+				backlog = append(backlog, ev)
+			}
+		}
+	}
+
+	r.nextWatcherID++
+	w := &watcher{
+		id:             r.nextWatcherID,
+		specialization: req.Specialization,
+		org:            org,
+		selector:       req.LabelSelector,
+		events:         make(chan *pb.NodeEvent, watchBufferSize),
+		sent:           make(map[string]bool),
+		resumed:        req.ResumeRevision != 0,
+	}
+	r.watchers[w.id] = w
+
+	return w, backlog, nil
+}
+
+// removeWatcher unregisters a watcher
+func (r *Registry) removeWatcher(id uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if w, ok := r.watchers[id]; ok {
+		delete(r.watchers, id)
+		close(w.events)
+	}
+}
+
+// emitLocked records a node event and fans it out to watchers. The
+// transition is set when the event was caused by a status change.
+// (must be called with lock held)
+func (r *Registry) emitLocked(eventType pb.NodeEvent_Type, node *pb.NodeInfo, transition *pb.StatusTransition) {
+	// Every event carries the node's latest load, so held back load
+	// readings are published with it
+	if eventType == pb.NodeEvent_REMOVED {
+		delete(r.loadEvents, node.NodeId)
+	} else {
+		r.loadEvents[node.NodeId] = loadEvent{publishedAt: r.clock()}
+	}
+
+	r.revision++
+	ev := &pb.NodeEvent{
+		Type:       eventType,
+		Node:       proto.Clone(node).(*pb.NodeInfo),
+		Revision:   r.revision,
+		Transition: transition,
+	}
+
+	r.persistRevisionLocked()
+
+	r.history = append(r.history, ev)
+	if len(r.history) > eventHistorySize {
+		r.history = r.history[len(r.history)-eventHistorySize:]
+	}
+
+	for id, w := range r.watchers {
+		select {
+		case w.events <- ev:
+		default:
+			// Slow consumer, disconnect it so it resumes from its last revision
+			log.Printf("Watcher %d fell behind at revision %d, disconnecting", id, ev.Revision)
+			delete(r.watchers, id)
+			close(w.events)
+		}
+	}
+}
+
+// publishUpdateLocked emits an UPDATED event for a node whose heartbeat
+// changed its health checks or load. Health check changes are published
+// immediately; load changes at most once per node every loadEventInterval,
+// readings in between are held back and published once the interval has
+// passed, by the next heartbeat or health check.
+// (must be called with lock held)
+func (r *Registry) publishUpdateLocked(node *pb.NodeInfo, healthChanged, loadChanged bool) {
+	last := r.loadEvents[node.NodeId]
+	pending := last.pending || loadChanged
+	if healthChanged || (pending && !r.clock().Before(last.publishedAt.Add(loadEventInterval))) {
+		r.emitLocked(pb.NodeEvent_UPDATED, node, nil)
+		return
+	}
+	if pending {
+		last.pending = true
+		r.loadEvents[node.NodeId] = last
+	}
+}
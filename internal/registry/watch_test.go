@@ -0,0 +1,257 @@
+package registry
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "galaxy-node-pool/proto/pool"
+)
+
+// drainEvents returns the events queued for a watcher
+func drainEvents(w *watcher) []*pb.NodeEvent {
+	var events []*pb.NodeEvent
+	for {
+		select {
+		case ev := <-w.events:
+			events = append(events, ev)
+		default:
+			return events
+		}
+	}
+}
+
+func TestWatchResume(t *testing.T) {
+	tests := []struct {
+		name     string
+		events   int
+		resume   func(current uint64) uint64
+		wantCode codes.Code
+		wantLen  int
+	}{
+		{name: "snapshot", events: 3, resume: func(uint64) uint64 { return 0 }, wantLen: 1},
+		{name: "up to date", events: 3, resume: func(current uint64) uint64 { return current }, wantLen: 0},
+		{name: "replays missed events", events: 3, resume: func(current uint64) uint64 { return current - 2 }, wantLen: 2},
+		{name: "oldest retained event", events: eventHistorySize + 10, resume: func(current uint64) uint64 { return current - eventHistorySize }, wantLen: eventHistorySize},
+		{name: "compacted revision", events: eventHistorySize + 10, resume: func(current uint64) uint64 { return current - eventHistorySize - 1 }, wantCode: codes.OutOfRange},
+		{name: "future revision", events: 3, resume: func(current uint64) uint64 { return current + 1 }, wantCode: codes.OutOfRange},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _ := newLeaseTestRegistry(t)
+			registerTestNode(t, r, "node1")
+
+			r.mu.Lock()
+			for i := 1; i < tt.events; i++ {
+				r.emitLocked(pb.NodeEvent_UPDATED, r.nodes["node1"], nil)
+			}
+			current := r.revision
+			r.mu.Unlock()
+
+			w, backlog, err := r.addWatcher(&pb.WatchNodesRequest{ResumeRevision: tt.resume(current)}, "")
+			if tt.wantCode != codes.OK {
+				if status.Code(err) != tt.wantCode {
+					t.Fatalf("addWatcher error = %v, want %s", err, tt.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("addWatcher failed: %v", err)
+			}
+			defer r.removeWatcher(w.id)
+
+			if len(backlog) != tt.wantLen {
+				t.Fatalf("backlog has %d events, want %d", len(backlog), tt.wantLen)
+			}
+			for i, ev := range backlog {
+				if ev.Revision > current {
+					t.Errorf("event %d has revision %d, newer than %d", i, ev.Revision, current)
+				}
+				if i > 0 && ev.Revision != backlog[i-1].Revision+1 {
+					t.Errorf("event %d has revision %d, want %d", i, ev.Revision, backlog[i-1].Revision+1)
+				}
+			}
+		})
+	}
+}
+
+func TestWatcherFilter(t *testing.T) {
+	matching := &pb.NodeInfo{NodeId: "node1", Specialization: "gpu", Labels: map[string]string{"zone": "a"}}
+	outside := &pb.NodeInfo{NodeId: "node1", Specialization: "gpu", Labels: map[string]string{"zone": "b"}}
+
+	type step struct {
+		eventType pb.NodeEvent_Type
+		node      *pb.NodeInfo
+		want      pb.NodeEvent_Type
+		skip      bool
+	}
+	tests := []struct {
+		name    string
+		resumed bool
+		steps   []step
+	}{
+		{
+			name: "node inside the filter",
+			steps: []step{
+				{eventType: pb.NodeEvent_ADDED, node: matching, want: pb.NodeEvent_ADDED},
+				{eventType: pb.NodeEvent_UPDATED, node: matching, want: pb.NodeEvent_UPDATED},
+				{eventType: pb.NodeEvent_REMOVED, node: matching, want: pb.NodeEvent_REMOVED},
+			},
+		},
+		{
+			name: "node outside the filter",
+			steps: []step{
+				{eventType: pb.NodeEvent_ADDED, node: outside, skip: true},
+				{eventType: pb.NodeEvent_UPDATED, node: outside, skip: true},
+				{eventType: pb.NodeEvent_REMOVED, node: outside, skip: true},
+			},
+		},
+		{
+			name: "node enters and leaves the filter",
+			steps: []step{
+				{eventType: pb.NodeEvent_ADDED, node: outside, skip: true},
+				{eventType: pb.NodeEvent_UPDATED, node: matching, want: pb.NodeEvent_ADDED},
+				{eventType: pb.NodeEvent_UPDATED, node: matching, want: pb.NodeEvent_UPDATED},
+				{eventType: pb.NodeEvent_UPDATED, node: outside, want: pb.NodeEvent_REMOVED},
+				{eventType: pb.NodeEvent_UPDATED, node: outside, skip: true},
+				{eventType: pb.NodeEvent_REMOVED, node: outside, skip: true},
+			},
+		},
+		{
+			name: "node registered again after leaving",
+			steps: []step{
+				{eventType: pb.NodeEvent_ADDED, node: matching, want: pb.NodeEvent_ADDED},
+				{eventType: pb.NodeEvent_REMOVED, node: matching, want: pb.NodeEvent_REMOVED},
+				{eventType: pb.NodeEvent_ADDED, node: matching, want: pb.NodeEvent_ADDED},
+			},
+		},
+		{
+			name:    "resumed watcher removes nodes it may hold",
+			resumed: true,
+			steps: []step{
+				{eventType: pb.NodeEvent_UPDATED, node: outside, want: pb.NodeEvent_REMOVED},
+				{eventType: pb.NodeEvent_UPDATED, node: matching, want: pb.NodeEvent_ADDED},
+			},
+		},
+		{
+			name:    "resumed watcher passes removals through",
+			resumed: true,
+			steps: []step{
+				{eventType: pb.NodeEvent_REMOVED, node: outside, want: pb.NodeEvent_REMOVED},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &watcher{
+				specialization: "gpu",
+				selector:       &pb.LabelSelector{MatchLabels: map[string]string{"zone": "a"}},
+				sent:           make(map[string]bool),
+				resumed:        tt.resumed,
+			}
+			for i, s := range tt.steps {
+				ev := &pb.NodeEvent{Type: s.eventType, Node: s.node, Revision: uint64(i + 1)}
+				got := w.filter(ev)
+				if s.skip {
+					if got != nil {
+						t.Errorf("step %d: sent %s, want it skipped", i, got.Type)
+					}
+					continue
+				}
+				if got == nil {
+					t.Errorf("step %d: skipped, want %s", i, s.want)
+					continue
+				}
+				if got.Type != s.want {
+					t.Errorf("step %d: sent %s, want %s", i, got.Type, s.want)
+				}
+				if got.Revision != ev.Revision {
+					t.Errorf("step %d: revision %d, want %d", i, got.Revision, ev.Revision)
+				}
+				if ev.Type != s.eventType {
+					t.Errorf("step %d: shared event changed to %s", i, ev.Type)
+				}
+			}
+		})
+	}
+}
+
+func TestHeartbeatPublishesUpdates(t *testing.T) {
+	r, clock := newLeaseTestRegistry(t)
+	registerTestNode(t, r, "node1")
+	heartbeatTestNode(t, r, "node1")
+
+	w, _, err := r.addWatcher(&pb.WatchNodesRequest{}, "")
+	if err != nil {
+		t.Fatalf("addWatcher failed: %v", err)
+	}
+	defer r.removeWatcher(w.id)
+
+	heartbeat := func(load int32, checks ...pb.HealthCheck_Status) {
+		t.Helper()
+		req := &pb.HeartbeatRequest{NodeId: "node1", Load: &pb.NodeLoad{ActiveTasks: load}}
+		for _, check := range checks {
+			req.HealthChecks = append(req.HealthChecks, &pb.HealthCheck{Name: "disk", Status: check})
+		}
+		resp, err := r.Heartbeat(context.Background(), req)
+		if err != nil || !resp.Alive {
+			t.Fatalf("Heartbeat = %v, %v", resp, err)
+		}
+	}
+	expect := func(step string, wantLoad int32, wantStatus string) {
+		t.Helper()
+		events := drainEvents(w)
+		if len(events) != 1 {
+			t.Fatalf("%s: got %d events, want 1", step, len(events))
+		}
+		ev := events[0]
+		if ev.Type != pb.NodeEvent_UPDATED || ev.Node.Load.GetActiveTasks() != wantLoad || ev.Node.Status != wantStatus {
+			t.Errorf("%s: got %s with load %d and status %s, want UPDATED with load %d and status %s",
+				step, ev.Type, ev.Node.Load.GetActiveTasks(), ev.Node.Status, wantLoad, wantStatus)
+		}
+	}
+	expectNone := func(step string) {
+		t.Helper()
+		if events := drainEvents(w); len(events) != 0 {
+			t.Fatalf("%s: got %d events, want none", step, len(events))
+		}
+	}
+
+	clock.Advance(loadEventInterval)
+	heartbeat(1, pb.HealthCheck_PASSING)
+	expect("load and health checks reported", 1, StatusHealthy)
+
+	heartbeat(1, pb.HealthCheck_PASSING)
+	expectNone("unchanged readings")
+
+	// Load changes within the interval are held back and coalesced
+	heartbeat(2, pb.HealthCheck_PASSING)
+	heartbeat(3, pb.HealthCheck_PASSING)
+	expectNone("load changed within the interval")
+
+	// The health check loop publishes the latest reading once it has passed
+	clock.Advance(loadEventInterval)
+	r.checkNodeHealth()
+	expect("held back load", 3, StatusHealthy)
+	r.checkNodeHealth()
+	expectNone("nothing held back")
+
+	// Health check changes are published at once
+	clock.Advance(time.Second)
+	heartbeat(3, pb.HealthCheck_PASSING, pb.HealthCheck_WARNING)
+	expect("status changed", 3, StatusDegraded)
+	heartbeat(4, pb.HealthCheck_FAILING, pb.HealthCheck_WARNING)
+	expect("health checks changed", 4, StatusDegraded)
+
+	// The next heartbeat after the interval publishes held back readings
+	heartbeat(5, pb.HealthCheck_FAILING, pb.HealthCheck_WARNING)
+	expectNone("load changed within the interval")
+	clock.Advance(loadEventInterval)
+	heartbeat(5, pb.HealthCheck_FAILING, pb.HealthCheck_WARNING)
+	expect("held back load", 5, StatusDegraded)
+}
@@ -151,7 +151,9 @@ func (s *StellarClient) DistributeStakerRewards(stakerAccounts []string, rewardP
 	return nil
 }
 
-// VerifyNodeIdentity verifies a node's identity using Stellar signatures
+// VerifyNodeIdentity verifies a node's identity using Stellar signatures.
+// It has no nonce or replay protection; registry node identity uses the
+// challenge-response exchange in internal/registry instead.
 func (s *StellarClient) VerifyNodeIdentity(nodeID, nodeAccount, signature, challenge string) (bool, error) {
 	// Get the node's public key
 	kp, err := keypair.Parse(nodeAccount)
@@ -0,0 +1,183 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"galaxy-node-pool/internal/plugin"
+)
+
+// DefaultFileStoragePath is used when no path is configured for the file storage plugin
+const DefaultFileStoragePath = "./data/registry"
+
+// FileStoragePlugin implements the StoragePlugin interface
+// on top of a local directory, storing one file per key
+type FileStoragePlugin struct {
+	dir         string
+	initialized bool
+	mu          sync.RWMutex
+}
+
+// NewFileStoragePlugin creates a new file storage plugin instance
+func NewFileStoragePlugin() *FileStoragePlugin {
+	return &FileStoragePlugin{
+		initialized: false,
+	}
+}
+
+// Name returns the plugin name
+func (p *FileStoragePlugin) Name() string {
+	return "file-storage"
+}
+
+// Initialize sets up the plugin with its configuration
+func (p *FileStoragePlugin) Initialize(config map[string]interface{}) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	dir := DefaultFileStoragePath
+	if path, ok := config["path"].(string); ok && path != "" {
+		dir = path
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create storage directory: %v", err)
+	}
+
+	p.dir = dir
+	p.initialized = true
+
+	log.Printf("File storage plugin initialized with directory: %s", dir)
+	return nil
+}
+
+// Shutdown gracefully stops the plugin
+func (p *FileStoragePlugin) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.initialized = false
+	return nil
+}
+
+// Store persists a key-value pair. Byte slices and strings are written as-is,
+// any other value is encoded as JSON.
+func (p *FileStoragePlugin) Store(key string, value interface{}) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.initialized {
+		return fmt.Errorf("file storage plugin not initialized")
+	}
+
+	var data []byte
+	switch v := value.(type) {
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("failed to encode value for key %s: %v", key, err)
+		}
+		data = encoded
+	}
+
+	// Write to a temporary file first so a crash never leaves a partial record
+	path := p.pathForKey(key)
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write key %s: %v", key, err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write key %s: %v", key, err)
+	}
+
+	return nil
+}
+
+// Retrieve gets a value by key as raw bytes
+func (p *FileStoragePlugin) Retrieve(key string) (interface{}, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if !p.initialized {
+		return nil, fmt.Errorf("file storage plugin not initialized")
+	}
+
+	data, err := os.ReadFile(p.pathForKey(key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("key %s not found", key)
+		}
+		return nil, fmt.Errorf("failed to read key %s: %v", key, err)
+	}
+
+	return data, nil
+}
+
+// Delete removes a key-value pair
+func (p *FileStoragePlugin) Delete(key string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.initialized {
+		return fmt.Errorf("file storage plugin not initialized")
+	}
+
+	if err := os.Remove(p.pathForKey(key)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete key %s: %v", key, err)
+	}
+
+	return nil
+}
+
+// List returns all keys with optional prefix
+func (p *FileStoragePlugin) List(prefix string) ([]string, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if !p.initialized {
+		return nil, fmt.Errorf("file storage plugin not initialized")
+	}
+
+	entries, err := os.ReadDir(p.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read storage directory: %v", err)
+	}
+
+	keys := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasSuffix(entry.Name(), ".tmp") {
+			continue
+		}
+
+		key, err := url.PathUnescape(entry.Name())
+		if err != nil {
+			continue
+		}
+
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys, nil
+}
+
+// pathForKey maps a key to a file inside the storage directory
+func (p *FileStoragePlugin) pathForKey(key string) string {
+	return filepath.Join(p.dir, url.PathEscape(key))
+}
+
+// Ensure FileStoragePlugin implements the StoragePlugin interface
+var _ plugin.StoragePlugin = (*FileStoragePlugin)(nil)
@@ -0,0 +1,754 @@
+// Galaxy Node Pool - Testnet Simulation Cluster
+// AI-ID: CP-GAL-NODEPOOL-001
+package testnet
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"galaxy-node-pool/internal/plugin"
+	"galaxy-node-pool/internal/registry"
+	pb "galaxy-node-pool/proto/pool"
+)
+
+// ClusterOptions configures a simulation cluster
+type ClusterOptions struct {
+	Pools int
+	Nodes int
+
+	// BasePort is the port of the first registry, the others listen on the
+	// following ports. Zero picks free loopback ports.
+	BasePort int
+
+	// Duration is how long the simulation runs, zero runs until cancelled
+	Duration time.Duration
+
+	// Registry health check settings applied to every pool
+	HeartbeatInterval   time.Duration
+	HealthCheckInterval time.Duration
+	LeaseTTL            time.Duration
+	SuspectAfter        time.Duration
+
+	// FailureRate is the chance of a node failing at each heartbeat. Failed
+	// nodes stop heartbeating for MinDowntime to MaxDowntime, then rejoin a
+	// random pool.
+	FailureRate float64
+	MinDowntime time.Duration
+	MaxDowntime time.Duration
+
+	// ReportInterval is how often progress is printed, zero disables it
+	ReportInterval time.Duration
+
+	// Seed makes node behaviour reproducible, zero picks a random seed
+	Seed int64
+
+	// LogFile receives the registry logs, they are discarded when empty
+	LogFile string
+}
+
+// DefaultClusterOptions returns options for a short simulation with fast leases
+func DefaultClusterOptions() ClusterOptions {
+	return ClusterOptions{
+		Pools:               3,
+		Nodes:               50,
+		Duration:            time.Minute,
+		HeartbeatInterval:   time.Second,
+		HealthCheckInterval: time.Second,
+		LeaseTTL:            5 * time.Second,
+		SuspectAfter:        2 * time.Second,
+		FailureRate:         0.01,
+		MinDowntime:         5 * time.Second,
+		MaxDowntime:         15 * time.Second,
+		ReportInterval:      10 * time.Second,
+	}
+}
+
+// ClusterReport summarizes a simulation run
+type ClusterReport struct {
+	Pools    int
+	Nodes    int
+	Duration time.Duration
+
+	LeaseTTL            time.Duration
+	HealthCheckInterval time.Duration
+
+	// Convergence is how long each pool took to list all nodes initially
+	// assigned to it, Converged is false for pools that never did
+	Convergence []PoolConvergence
+
+	Registrations      int
+	Rejoins            int
+	RegistrationErrors int
+	Heartbeats         int
+	HeartbeatErrors    int
+
+	// Propagation is the delay between registering and the registry
+	// publishing the node to watchers
+	Propagation []time.Duration
+
+	Failures int
+
+	// Evictions is the delay between the last heartbeat of a failed node and
+	// its pool evicting it
+	Evictions []time.Duration
+
+	// RejoinedBeforeEviction counts failed nodes that rejoined their pool
+	// before the lease ran out
+	RejoinedBeforeEviction int
+
+	// UnexpectedEvictions counts nodes evicted while they were heartbeating
+	UnexpectedEvictions int
+
+	// PendingEvictions counts failed nodes still listed when the run ended
+	PendingEvictions int
+
+	// Listed and Missing compare the final registry contents with the nodes
+	// the simulation knows to be up
+	Listed  int
+	Missing int
+}
+
+// PoolConvergence is the initial convergence of one pool
+type PoolConvergence struct {
+	Pool      string
+	Address   string
+	Nodes     int
+	Converged bool
+	After     time.Duration
+}
+
+// cluster is a running simulation
+type cluster struct {
+	opts  ClusterOptions
+	start time.Time
+	pools []*simPool
+
+	mu     sync.Mutex
+	report ClusterReport
+
+	// joins holds registrations waiting to be published, by node ID
+	joins map[string]joinAttempt
+
+	// failures holds failed nodes waiting to be evicted, by node ID
+	failures map[string]failure
+
+	// up maps the nodes that are heartbeating to their pool
+	up map[string]*simPool
+}
+
+// simPool is a registry server of the cluster
+type simPool struct {
+	name     string
+	address  string
+	registry *registry.Registry
+	server   *grpc.Server
+	conn     *grpc.ClientConn
+	client   pb.RegistryClient
+
+	// assigned is the number of nodes that initially join the pool
+	assigned  int
+	members   map[string]bool
+	converged time.Duration
+	revision  uint64
+}
+
+// joinAttempt is a registration waiting to be published
+type joinAttempt struct {
+	pool *simPool
+	at   time.Time
+}
+
+// failure is a failed node waiting to be evicted
+type failure struct {
+	pool *simPool
+
+	// lastSeen is the last heartbeat of the node, or its registration
+	lastSeen time.Time
+}
+
+// RunCluster runs a simulation of several registries on loopback ports with
+// simulated nodes that register, heartbeat with synthetic load, fail and
+// rejoin. It prints progress while it runs and a report at the end.
+func (m *Manager) RunCluster(ctx context.Context, opts ClusterOptions) (*ClusterReport, error) {
+	if opts.Pools < 1 || opts.Nodes < 1 {
+		return nil, fmt.Errorf("a cluster needs at least one pool and one node")
+	}
+	if opts.HeartbeatInterval <= 0 || opts.HealthCheckInterval <= 0 || opts.LeaseTTL <= 0 {
+		return nil, fmt.Errorf("heartbeat interval, health check interval and lease TTL must be positive")
+	}
+	if opts.HeartbeatInterval >= opts.LeaseTTL {
+		log.Printf("Warning: Heartbeat interval %s is not shorter than the lease TTL %s, healthy nodes will be evicted", opts.HeartbeatInterval, opts.LeaseTTL)
+	}
+	if opts.MaxDowntime < opts.MinDowntime {
+		opts.MaxDowntime = opts.MinDowntime
+	}
+	if opts.Seed == 0 {
+		opts.Seed = time.Now().UnixNano()
+	}
+
+	// The registries log every registration and eviction, keep them out of the report
+	var logOutput io.Writer = io.Discard
+	if opts.LogFile != "" {
+		if err := os.MkdirAll(filepath.Dir(opts.LogFile), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create log directory: %v", err)
+		}
+		logFile, err := os.OpenFile(opts.LogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open log file: %v", err)
+		}
+		defer logFile.Close()
+		logOutput = logFile
+	}
+	log.SetOutput(logOutput)
+	defer log.SetOutput(os.Stderr)
+
+	if opts.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Duration)
+		defer cancel()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	c := &cluster{
+		opts:     opts,
+		joins:    make(map[string]joinAttempt),
+		failures: make(map[string]failure),
+		up:       make(map[string]*simPool),
+	}
+	c.report.Pools = opts.Pools
+	c.report.Nodes = opts.Nodes
+	c.report.LeaseTTL = opts.LeaseTTL
+	c.report.HealthCheckInterval = opts.HealthCheckInterval
+
+	if err := c.startPools(ctx); err != nil {
+		c.stopPools()
+		return nil, err
+	}
+	defer c.stopPools()
+
+	for _, pool := range c.pools {
+		fmt.Printf("Pool %s listening on %s\n", pool.name, pool.address)
+	}
+	fmt.Printf("Simulating %d nodes, lease TTL %s, heartbeat every %s, failure rate %.1f%% per heartbeat (seed %d)\n",
+		opts.Nodes, opts.LeaseTTL, opts.HeartbeatInterval, opts.FailureRate*100, opts.Seed)
+
+	var watchers sync.WaitGroup
+	for _, pool := range c.pools {
+		watchers.Add(1)
+		go func(pool *simPool) {
+			defer watchers.Done()
+			c.watch(ctx, pool)
+		}(pool)
+	}
+
+	c.start = time.Now()
+	var nodes sync.WaitGroup
+	for i := 0; i < opts.Nodes; i++ {
+		nodes.Add(1)
+		go func(i int) {
+			defer nodes.Done()
+			c.runNode(ctx, i)
+		}(i)
+	}
+
+	if opts.ReportInterval > 0 {
+		go c.reportProgress(ctx)
+	}
+
+	<-ctx.Done()
+	nodes.Wait()
+	c.report.Duration = time.Since(c.start)
+
+	// Compare what the registries list with the nodes known to be up
+	c.finalCheck()
+	cancel()
+	watchers.Wait()
+
+	report := c.report
+	for _, pool := range c.pools {
+		report.Convergence = append(report.Convergence, PoolConvergence{
+			Pool:      pool.name,
+			Address:   pool.address,
+			Nodes:     pool.assigned,
+			Converged: pool.converged > 0,
+			After:     pool.converged,
+		})
+	}
+	printClusterReport(&report)
+	return &report, nil
+}
+
+// startPools starts a registry with a gRPC server for each pool
+func (c *cluster) startPools(ctx context.Context) error {
+	for i := 0; i < c.opts.Pools; i++ {
+		address := "127.0.0.1:0"
+		if c.opts.BasePort > 0 {
+			address = fmt.Sprintf("127.0.0.1:%d", c.opts.BasePort+i)
+		}
+		listener, err := registry.Listen(address)
+		if err != nil {
+			return fmt.Errorf("failed to listen on %s: %v", address, err)
+		}
+
+		pool := &simPool{
+			name:     fmt.Sprintf("sim-%d", i+1),
+			address:  listener.Addr().String(),
+			assigned: c.opts.Nodes / c.opts.Pools,
+			members:  make(map[string]bool),
+		}
+		if i < c.opts.Nodes%c.opts.Pools {
+			pool.assigned++
+		}
+
+		// Nodes run without keys and state is kept in memory only
+		cfg := NewConfig(pool.name, "", "", pool.address, "")
+		cfg.Registry.MaxNodes = c.opts.Nodes
+		cfg.Registry.HealthCheckInterval = c.opts.HealthCheckInterval.String()
+		cfg.Registry.LeaseTTL = c.opts.LeaseTTL.String()
+		if c.opts.SuspectAfter > 0 {
+			cfg.Registry.SuspectAfter = c.opts.SuspectAfter.String()
+		}
+		cfg.Registry.Identity.Mode = registry.IdentityDisabled
+		cfg.Registry.Storage.Plugin = ""
+		cfg.Registry.Storage.Config = nil
+
+		pool.registry = registry.NewRegistry(cfg, plugin.NewPluginManager())
+		if err := pool.registry.Start(ctx); err != nil {
+			listener.Close()
+			return fmt.Errorf("failed to start registry %s: %v", pool.name, err)
+		}
+		pool.server = grpc.NewServer()
+		pb.RegisterRegistryServer(pool.server, pool.registry)
+		go func(server *grpc.Server, listener net.Listener) {
+			if err := server.Serve(listener); err != nil {
+				log.Printf("Warning: Registry server stopped: %v", err)
+			}
+		}(pool.server, listener)
+		// Serving pools are stopped by stopPools, even if connecting fails
+		c.pools = append(c.pools, pool)
+
+		pool.conn, err = grpc.NewClient(pool.address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			return fmt.Errorf("failed to connect to %s: %v", pool.address, err)
+		}
+		pool.client = pb.NewRegistryClient(pool.conn)
+	}
+	return nil
+}
+
+// stopPools stops the registry servers
+func (c *cluster) stopPools() {
+	for _, pool := range c.pools {
+		if pool.conn != nil {
+			pool.conn.Close()
+		}
+		if pool.server != nil {
+			pool.server.Stop()
+		}
+	}
+}
+
+// watch follows the membership events of a pool, resuming after disconnects
+func (c *cluster) watch(ctx context.Context, pool *simPool) {
+	for ctx.Err() == nil {
+		c.mu.Lock()
+		revision := pool.revision
+		c.mu.Unlock()
+
+		stream, err := pool.client.WatchNodes(ctx, &pb.WatchNodesRequest{ResumeRevision: revision})
+		if err == nil {
+			for {
+				ev, err := stream.Recv()
+				if err != nil {
+					break
+				}
+				c.observe(pool, ev)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// observe records a membership event of a pool
+func (c *cluster) observe(pool *simPool, ev *pb.NodeEvent) {
+	now := time.Now()
+	nodeID := ev.Node.GetNodeId()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if ev.Revision > pool.revision {
+		pool.revision = ev.Revision
+	}
+
+	switch ev.Type {
+	case pb.NodeEvent_ADDED:
+		pool.members[nodeID] = true
+		if join, ok := c.joins[nodeID]; ok && join.pool == pool {
+			c.report.Propagation = append(c.report.Propagation, now.Sub(join.at))
+			delete(c.joins, nodeID)
+		}
+		if pool.converged == 0 && len(pool.members) >= pool.assigned {
+			pool.converged = now.Sub(c.start)
+		}
+
+	case pb.NodeEvent_REMOVED:
+		delete(pool.members, nodeID)
+		if failed, ok := c.failures[nodeID]; ok && failed.pool == pool {
+			c.report.Evictions = append(c.report.Evictions, now.Sub(failed.lastSeen))
+			delete(c.failures, nodeID)
+		} else if c.up[nodeID] == pool {
+			c.report.UnexpectedEvictions++
+		}
+	}
+}
+
+// runNode simulates one node until the cluster stops
+func (c *cluster) runNode(ctx context.Context, index int) {
+	rng := rand.New(rand.NewSource(c.opts.Seed + int64(index)))
+	node := newSimNode(index, rng)
+	pool := c.pools[index%len(c.pools)]
+	rejoin := false
+
+	for ctx.Err() == nil {
+		if !c.register(ctx, node, pool, rejoin) {
+			if !sleep(ctx, c.opts.HeartbeatInterval) {
+				return
+			}
+			continue
+		}
+
+		if !c.heartbeat(ctx, node, pool, rng) {
+			// Evicted while up, register with the same pool again
+			rejoin = true
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		// The node failed, it stays silent and then joins a random pool
+		downtime := c.opts.MinDowntime
+		if spread := c.opts.MaxDowntime - c.opts.MinDowntime; spread > 0 {
+			downtime += time.Duration(rng.Int63n(int64(spread)))
+		}
+		if !sleep(ctx, downtime) {
+			return
+		}
+		pool = c.pools[rng.Intn(len(c.pools))]
+		rejoin = true
+	}
+}
+
+// register registers a node with a pool
+func (c *cluster) register(ctx context.Context, node *simNode, pool *simPool, rejoin bool) bool {
+	c.mu.Lock()
+	if failed, ok := c.failures[node.id]; ok && failed.pool == pool {
+		// The lease outlived the downtime, the registry never saw the failure
+		c.report.RejoinedBeforeEviction++
+		delete(c.failures, node.id)
+	}
+	c.joins[node.id] = joinAttempt{pool: pool, at: time.Now()}
+	c.mu.Unlock()
+
+	resp, err := pool.client.RegisterNode(ctx, node.registration())
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil || !resp.Success {
+		if ctx.Err() == nil {
+			c.report.RegistrationErrors++
+		}
+		delete(c.joins, node.id)
+		return false
+	}
+	c.report.Registrations++
+	if pool.members[node.id] {
+		// Re-registering a listed node updates it, there is nothing to publish
+		delete(c.joins, node.id)
+	}
+	if rejoin {
+		c.report.Rejoins++
+	}
+	c.up[node.id] = pool
+	return true
+}
+
+// heartbeat sends heartbeats until the node fails or the cluster stops,
+// returning false when the pool no longer knows the node
+func (c *cluster) heartbeat(ctx context.Context, node *simNode, pool *simPool, rng *rand.Rand) bool {
+	ticker := time.NewTicker(c.opts.HeartbeatInterval)
+	defer ticker.Stop()
+
+	lastSeen := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return true
+		case <-ticker.C:
+		}
+
+		if rng.Float64() < c.opts.FailureRate {
+			c.mu.Lock()
+			c.report.Failures++
+			c.failures[node.id] = failure{pool: pool, lastSeen: lastSeen}
+			delete(c.joins, node.id)
+			delete(c.up, node.id)
+			c.mu.Unlock()
+			return true
+		}
+
+		load, checks := node.nextLoad()
+		resp, err := pool.client.Heartbeat(ctx, &pb.HeartbeatRequest{
+			NodeId:       node.id,
+			Load:         load,
+			HealthChecks: checks,
+		})
+
+		c.mu.Lock()
+		if err != nil {
+			if ctx.Err() == nil {
+				c.report.HeartbeatErrors++
+			}
+			c.mu.Unlock()
+			continue
+		}
+		c.report.Heartbeats++
+		if !resp.Alive {
+			delete(c.up, node.id)
+			c.mu.Unlock()
+			return false
+		}
+		c.mu.Unlock()
+		lastSeen = time.Now()
+	}
+}
+
+// reportProgress prints the membership of each pool at the report interval
+func (c *cluster) reportProgress(ctx context.Context) {
+	ticker := time.NewTicker(c.opts.ReportInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		line := fmt.Sprintf("[%6s]", time.Since(c.start).Round(time.Second))
+		for _, pool := range c.pools {
+			probeCtx, cancel := context.WithTimeout(ctx, probeTimeout)
+			resp, err := pool.client.ListNodes(probeCtx, &pb.ListNodesRequest{IncludeAllStatuses: true})
+			cancel()
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				line += fmt.Sprintf(" %s: unreachable", pool.name)
+				continue
+			}
+			counts := make(map[string]int)
+			for _, node := range resp.Nodes {
+				counts[node.Status]++
+			}
+			line += fmt.Sprintf(" %s: %d%s", pool.name, len(resp.Nodes), formatStatuses(counts))
+		}
+
+		c.mu.Lock()
+		line += fmt.Sprintf(" | failures %d, evictions %d", c.report.Failures, len(c.report.Evictions))
+		c.mu.Unlock()
+		fmt.Println(line)
+	}
+}
+
+// finalCheck compares the nodes listed by the registries with the nodes the
+// simulation knows to be up
+func (c *cluster) finalCheck() {
+	listed := make(map[*simPool]map[string]bool)
+	for _, pool := range c.pools {
+		ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+		resp, err := pool.client.ListNodes(ctx, &pb.ListNodesRequest{IncludeAllStatuses: true})
+		cancel()
+		if err != nil {
+			continue
+		}
+		listed[pool] = make(map[string]bool)
+		for _, node := range resp.Nodes {
+			listed[pool][node.NodeId] = true
+		}
+		c.report.Listed += len(resp.Nodes)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for nodeID, pool := range c.up {
+		if !listed[pool][nodeID] {
+			c.report.Missing++
+		}
+	}
+	for nodeID, failed := range c.failures {
+		if listed[failed.pool][nodeID] {
+			c.report.PendingEvictions++
+		}
+	}
+}
+
+// printClusterReport prints the summary of a simulation run
+func printClusterReport(report *ClusterReport) {
+	fmt.Printf("\nCluster of %d pools and %d nodes ran for %s\n", report.Pools, report.Nodes, report.Duration.Round(time.Second))
+
+	fmt.Println("Convergence:")
+	slowest := time.Duration(0)
+	converged := true
+	for _, pool := range report.Convergence {
+		if !pool.Converged {
+			converged = false
+			fmt.Printf("  %-8s %3d nodes, did not converge\n", pool.Pool, pool.Nodes)
+			continue
+		}
+		if pool.After > slowest {
+			slowest = pool.After
+		}
+		fmt.Printf("  %-8s %3d nodes in %s\n", pool.Pool, pool.Nodes, pool.After.Round(time.Millisecond))
+	}
+	if converged {
+		fmt.Printf("  all pools converged in %s\n", slowest.Round(time.Millisecond))
+	}
+
+	fmt.Printf("Registrations: %d (%d rejoins, %d failed), published to watchers %s\n",
+		report.Registrations, report.Rejoins, report.RegistrationErrors, summarize(report.Propagation))
+	fmt.Printf("Heartbeats:    %d (%d failed)\n", report.Heartbeats, report.HeartbeatErrors)
+	fmt.Printf("Failures:      %d simulated\n", report.Failures)
+	fmt.Printf("Evictions:     %d, %s after the last heartbeat (lease TTL %s, health check every %s)\n",
+		len(report.Evictions), summarize(report.Evictions), report.LeaseTTL, report.HealthCheckInterval)
+	fmt.Printf("  %d failed nodes rejoined their pool before eviction\n", report.RejoinedBeforeEviction)
+	fmt.Printf("  %d failed nodes still listed at the end\n", report.PendingEvictions)
+	fmt.Printf("  %d nodes evicted while heartbeating\n", report.UnexpectedEvictions)
+	fmt.Printf("Final state:   %d nodes listed, %d running nodes missing\n", report.Listed, report.Missing)
+}
+
+// summarize formats the average, 95th percentile and maximum of durations
+func summarize(durations []time.Duration) string {
+	if len(durations) == 0 {
+		return "n/a"
+	}
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var total time.Duration
+	for _, d := range sorted {
+		total += d
+	}
+	avg := total / time.Duration(len(sorted))
+	p95 := sorted[(len(sorted)*95+99)/100-1]
+	return fmt.Sprintf("avg %s, p95 %s, max %s", round(avg), round(p95), round(sorted[len(sorted)-1]))
+}
+
+// round rounds a duration for display
+func round(d time.Duration) time.Duration {
+	if d < time.Second {
+		return d.Round(100 * time.Microsecond)
+	}
+	return d.Round(10 * time.Millisecond)
+}
+
+// sleep waits for a duration, returning false if the context ends first
+func sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// simNode is a simulated node with a synthetic workload
+type simNode struct {
+	id       string
+	capacity *pb.NodeCapacity
+	region   string
+	tasks    int32
+	rng      *rand.Rand
+}
+
+// simRegions are assigned to simulated nodes in turn
+var simRegions = []string{"ap-southeast", "eu-west", "us-east"}
+
+// newSimNode creates a simulated node with a random capacity
+func newSimNode(index int, rng *rand.Rand) *simNode {
+	cores := []float64{2, 4, 8, 16}[rng.Intn(4)]
+	return &simNode{
+		id: fmt.Sprintf("sim-node-%03d", index+1),
+		capacity: &pb.NodeCapacity{
+			CpuCores:     cores,
+			MemoryMb:     int64(cores) * 2048,
+			StorageTb:    1,
+			ComputeUnits: int64(cores) * 10,
+		},
+		region: simRegions[index%len(simRegions)],
+		rng:    rng,
+	}
+}
+
+// registration returns the registration request of the node
+func (n *simNode) registration() *pb.RegisterNodeRequest {
+	return &pb.RegisterNodeRequest{
+		NodeId:         n.id,
+		Specialization: "developer",
+		Endpoint:       "sim://" + n.id,
+		Org:            "simorg",
+		Labels:         map[string]string{"simulated": "true"},
+		Capacity:       n.capacity,
+		Version:        "sim",
+		Region:         n.region,
+	}
+}
+
+// nextLoad advances the synthetic workload by one heartbeat. Nodes running
+// near their capacity report a warning and are marked degraded.
+func (n *simNode) nextLoad() (*pb.NodeLoad, []*pb.HealthCheck) {
+	max := int32(n.capacity.ComputeUnits)
+	n.tasks += int32(n.rng.Intn(7)) - 3
+	if n.tasks < 0 {
+		n.tasks = 0
+	}
+	if n.tasks > max {
+		n.tasks = max
+	}
+
+	cpu := float64(n.tasks)/float64(max)*100 + n.rng.Float64()*5
+	if cpu > 100 {
+		cpu = 100
+	}
+	load := &pb.NodeLoad{
+		ActiveTasks:       n.tasks,
+		CpuUtilization:    cpu,
+		MemoryUtilization: 20 + cpu*0.6,
+		QueueDepth:        int32(n.rng.Intn(3)),
+	}
+
+	check := &pb.HealthCheck{Name: "workload", Status: pb.HealthCheck_PASSING}
+	if cpu > 95 {
+		check.Status = pb.HealthCheck_WARNING
+		check.Message = "running at capacity"
+	}
+	return load, []*pb.HealthCheck{check}
+}
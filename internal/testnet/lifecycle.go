@@ -0,0 +1,397 @@
+// Galaxy Node Pool - Testnet Pool Lifecycle
+// AI-ID: CP-GAL-NODEPOOL-001
+package testnet
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"galaxy-node-pool/internal/config"
+)
+
+const (
+	// pidFile holds the PID of the supervisor of a running pool
+	pidFile = "pool.pid"
+
+	// stateFile holds the state of the supervised pool-server process
+	stateFile = "state.json"
+
+	// defaultLogFile is used when the pool config sets no log file
+	defaultLogFile = "pool.log"
+
+	// startTimeout bounds how long Start waits for the server to listen
+	startTimeout = 15 * time.Second
+
+	// stopTimeout bounds how long a graceful shutdown may take before the
+	// server is killed
+	stopTimeout = 30 * time.Second
+
+	// minUptime is how long the server must run for an exit to not count as a crash loop
+	minUptime = 10 * time.Second
+
+	// maxCrashes is how many crashes in a row stop the supervisor
+	maxCrashes = 5
+
+	// maxRestartDelay caps the delay between restarts
+	maxRestartDelay = 30 * time.Second
+)
+
+// processState describes the supervised pool-server process
+type processState struct {
+	SupervisorPID int       `json:"supervisor_pid"`
+	ServerPID     int       `json:"server_pid"`
+	Address       string    `json:"address"`
+	StartedAt     time.Time `json:"started_at"`
+	Restarts      int       `json:"restarts"`
+}
+
+// Start launches the pool-server of a testnet pool under a background
+// supervisor that restarts it when it crashes
+func (m *Manager) Start(poolName string) error {
+	if poolName == "" {
+		poolName = "test"
+	}
+	configDir := filepath.Join(m.ConfigDir, poolName)
+
+	cfg, err := m.loadConfig(poolName)
+	if err != nil {
+		return err
+	}
+	if cfg.Server.Address == "" {
+		return fmt.Errorf("%s has no server address, run testnet init again to regenerate it", filepath.Join(configDir, "config.yaml"))
+	}
+	if pid, running := m.supervisorPID(poolName); running {
+		return fmt.Errorf("testnet pool %s is already running (pid %d)", poolName, pid)
+	}
+
+	serverBinary, err := m.serverBinary()
+	if err != nil {
+		return err
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find executable: %v", err)
+	}
+
+	logPath := m.logPath(poolName, cfg)
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %v", err)
+	}
+	defer logFile.Close()
+
+	// Detach the supervisor into its own session so it outlives this command
+	os.Remove(filepath.Join(configDir, stateFile))
+	cmd := exec.Command(executable, "testnet", "supervise", poolName, "--server-binary", serverBinary)
+	cmd.Dir = configDir
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start supervisor: %v", err)
+	}
+	supervisorPID := cmd.Process.Pid
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	// Wait for the server to accept connections
+	addr := localAddr(cfg.Server.Address)
+	deadline := time.Now().Add(startTimeout)
+	for {
+		select {
+		case <-exited:
+			return fmt.Errorf("testnet pool %s failed to start, see %s", poolName, logPath)
+		default:
+		}
+
+		conn, err := net.DialTimeout("tcp", addr, time.Second)
+		if err == nil {
+			conn.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			// Don't leave a supervisor restarting a server nobody waits for
+			stopSupervisor(supervisorPID, exited)
+			return fmt.Errorf("testnet pool %s is not listening on %s after %s and was stopped, see %s", poolName, addr, startTimeout, logPath)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+
+	fmt.Printf("Testnet pool %s started on %s (pid %d)\n", poolName, addr, supervisorPID)
+	fmt.Printf("Logs: %s\n", logPath)
+	return nil
+}
+
+// Stop stops a running testnet pool, giving the server time to shut down gracefully
+func (m *Manager) Stop(poolName string) error {
+	if poolName == "" {
+		poolName = "test"
+	}
+	configDir := filepath.Join(m.ConfigDir, poolName)
+	if _, err := os.Stat(configDir); os.IsNotExist(err) {
+		return fmt.Errorf("testnet environment not found: %s", configDir)
+	}
+
+	pid, running := m.supervisorPID(poolName)
+	if !running {
+		os.Remove(filepath.Join(configDir, pidFile))
+		os.Remove(filepath.Join(configDir, stateFile))
+		fmt.Printf("Testnet pool %s is not running\n", poolName)
+		return nil
+	}
+
+	if err := syscall.Kill(pid, syscall.SIGTERM); err != nil {
+		return fmt.Errorf("failed to stop testnet pool %s: %v", poolName, err)
+	}
+
+	// The supervisor kills the server after stopTimeout, allow it to report back
+	deadline := time.Now().Add(stopTimeout + 5*time.Second)
+	for processAlive(pid) {
+		if time.Now().After(deadline) {
+			killSupervisor(pid)
+			return fmt.Errorf("testnet pool %s did not stop in time and was killed", poolName)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+
+	fmt.Printf("Testnet pool %s stopped\n", poolName)
+	return nil
+}
+
+// Supervise runs the pool-server of a testnet pool in the foreground,
+// restarting it when it exits unexpectedly, until SIGTERM or SIGINT. The
+// signal is passed on to the server for a graceful shutdown.
+func (m *Manager) Supervise(poolName string) error {
+	if poolName == "" {
+		poolName = "test"
+	}
+	configDir := filepath.Join(m.ConfigDir, poolName)
+
+	cfg, err := m.loadConfig(poolName)
+	if err != nil {
+		return err
+	}
+	serverBinary, err := m.serverBinary()
+	if err != nil {
+		return err
+	}
+
+	logFile, err := os.OpenFile(m.logPath(poolName, cfg), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %v", err)
+	}
+	defer logFile.Close()
+	logger := log.New(logFile, "supervisor: ", log.LstdFlags)
+
+	pidPath := filepath.Join(configDir, pidFile)
+	statePath := filepath.Join(configDir, stateFile)
+	if err := os.WriteFile(pidPath, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write pidfile: %v", err)
+	}
+	defer os.Remove(pidPath)
+	defer os.Remove(statePath)
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(sigCh)
+
+	state := processState{
+		SupervisorPID: os.Getpid(),
+		Address:       localAddr(cfg.Server.Address),
+	}
+	crashes := 0
+	for {
+		cmd := exec.Command(serverBinary, "-config", filepath.Join(configDir, "config.yaml"))
+		cmd.Dir = configDir
+		cmd.Stdout = logFile
+		cmd.Stderr = logFile
+		if err := cmd.Start(); err != nil {
+			logger.Printf("Failed to start %s: %v", serverBinary, err)
+			return fmt.Errorf("failed to start pool-server: %v", err)
+		}
+
+		state.ServerPID = cmd.Process.Pid
+		state.StartedAt = time.Now()
+		if err := writeState(statePath, state); err != nil {
+			logger.Printf("Warning: Failed to write state: %v", err)
+		}
+		logger.Printf("Started pool-server (pid %d) on %s", state.ServerPID, cfg.Server.Address)
+
+		exited := make(chan error, 1)
+		go func() { exited <- cmd.Wait() }()
+
+		select {
+		case sig := <-sigCh:
+			logger.Printf("Received %v, stopping pool-server", sig)
+			cmd.Process.Signal(syscall.SIGTERM)
+			select {
+			case <-exited:
+				logger.Printf("Pool-server stopped")
+			case <-time.After(stopTimeout):
+				logger.Printf("Pool-server did not stop within %s, killing it", stopTimeout)
+				cmd.Process.Kill()
+				<-exited
+			}
+			return nil
+
+		case err := <-exited:
+			uptime := time.Since(state.StartedAt)
+			if uptime >= minUptime {
+				crashes = 0
+			}
+			crashes++
+			if crashes >= maxCrashes {
+				logger.Printf("Pool-server exited (%v) %d times in a row, giving up", err, crashes)
+				return fmt.Errorf("pool-server keeps exiting: %v", err)
+			}
+
+			delay := time.Second << uint(crashes-1)
+			if delay > maxRestartDelay {
+				delay = maxRestartDelay
+			}
+			logger.Printf("Pool-server exited after %s (%v), restarting in %s", uptime.Round(time.Second), err, delay)
+
+			select {
+			case sig := <-sigCh:
+				logger.Printf("Received %v while waiting to restart, stopping", sig)
+				return nil
+			case <-time.After(delay):
+			}
+			state.Restarts++
+		}
+	}
+}
+
+// serverBinary returns the pool-server executable to run
+func (m *Manager) serverBinary() (string, error) {
+	if m.ServerBinary != "" {
+		if _, err := os.Stat(m.ServerBinary); err != nil {
+			return "", fmt.Errorf("pool-server binary not found: %v", err)
+		}
+		return filepath.Abs(m.ServerBinary)
+	}
+
+	if executable, err := os.Executable(); err == nil {
+		candidate := filepath.Join(filepath.Dir(executable), "pool-server")
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			return candidate, nil
+		}
+	}
+	path, err := exec.LookPath("pool-server")
+	if err != nil {
+		return "", fmt.Errorf("pool-server binary not found next to this executable or on PATH, set it with --server-binary")
+	}
+	return filepath.Abs(path)
+}
+
+// logPath returns the log file of a testnet pool
+func (m *Manager) logPath(poolName string, cfg *config.Config) string {
+	logFile := cfg.Logging.File
+	if logFile == "" {
+		logFile = defaultLogFile
+	}
+	if filepath.IsAbs(logFile) {
+		return logFile
+	}
+	return filepath.Join(m.ConfigDir, poolName, logFile)
+}
+
+// supervisorPID returns the PID in the pidfile of a pool and whether it is
+// running. A stale pidfile whose PID now belongs to another process is not
+// running, so that process is never signalled.
+func (m *Manager) supervisorPID(poolName string) (int, bool) {
+	data, err := os.ReadFile(filepath.Join(m.ConfigDir, poolName, pidFile))
+	if err != nil {
+		return 0, false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return 0, false
+	}
+	if !processAlive(pid) {
+		return pid, false
+	}
+	return pid, m.isSupervisor(pid, poolName)
+}
+
+// isSupervisor reports whether a live process is the supervisor of a pool,
+// from its command line, or from the pool's state file where /proc is not
+// available
+func (m *Manager) isSupervisor(pid int, poolName string) bool {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil {
+		state, err := m.readState(poolName)
+		return err == nil && state.SupervisorPID == pid
+	}
+
+	args := strings.Split(strings.TrimRight(string(data), "\x00"), "\x00")
+	for i := 0; i+2 < len(args); i++ {
+		if args[i] == "testnet" && args[i+1] == "supervise" && args[i+2] == poolName {
+			return true
+		}
+	}
+	return false
+}
+
+// stopSupervisor stops a supervisor started by this process, passing SIGTERM
+// on to the server and killing both when they don't exit in time. exited
+// receives once the supervisor has been waited for.
+func stopSupervisor(pid int, exited <-chan error) {
+	syscall.Kill(pid, syscall.SIGTERM)
+	select {
+	case <-exited:
+	case <-time.After(stopTimeout + 5*time.Second):
+		killSupervisor(pid)
+		<-exited
+	}
+}
+
+// killSupervisor kills a supervisor and the server it runs. The supervisor
+// leads its own session, so its process group holds both.
+func killSupervisor(pid int) {
+	if err := syscall.Kill(-pid, syscall.SIGKILL); err != nil {
+		syscall.Kill(pid, syscall.SIGKILL)
+	}
+}
+
+// readState reads the state of the supervised server of a pool
+func (m *Manager) readState(poolName string) (*processState, error) {
+	data, err := os.ReadFile(filepath.Join(m.ConfigDir, poolName, stateFile))
+	if err != nil {
+		return nil, err
+	}
+	var state processState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse state: %v", err)
+	}
+	return &state, nil
+}
+
+// writeState replaces the state file through a rename
+func writeState(path string, state processState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// processAlive reports whether a process exists
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
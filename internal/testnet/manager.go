@@ -4,31 +4,40 @@ package testnet
 
 import (
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
 	"strings"
-	"text/template"
 	
 	"galaxy-node-pool/internal/cert"
+	"galaxy-node-pool/internal/config"
+	"galaxy-node-pool/internal/nginx"
 )
 
 // Manager handles testnet operations
 type Manager struct {
-	BaseDir     string
-	ConfigDir   string
-	TemplateDir string
+	BaseDir   string
+	ConfigDir string
+
+	// ServerBinary is the pool-server executable, looked up next to the
+	// running executable and then on PATH when empty
+	ServerBinary string
 }
 
-// Config represents testnet configuration
-type Config struct {
-	PoolName   string
-	OrgID      string
-	ListenAddr string
-	GrpcAddr   string
-	WebAddr    string
-	ApiPort    string
-	WsPort     string
-	WebPort    string
+// NewConfig creates the pool-server configuration of a testnet pool. Relative
+// paths in it resolve against the pool's config directory, where the pool runs.
+func NewConfig(poolName, orgID, listenAddr, grpcAddr, webAddr string) *config.Config {
+	cfg := config.Default()
+	cfg.Pool.Name = poolName
+	cfg.Pool.OrgID = orgID
+	cfg.Pool.Network = "testnet"
+	cfg.Pool.APIAddress = listenAddr
+	cfg.Pool.WebAddress = webAddr
+	cfg.Server.Address = grpcAddr
+	cfg.Registry.Storage.Config = map[string]interface{}{"path": "./data/registry"}
+	cfg.Logging.Level = "debug"
+	cfg.Logging.File = poolName + ".log"
+	return cfg
 }
 
 // NewManager creates a new testnet manager
@@ -42,9 +51,8 @@ func NewManager(baseDir string) *Manager {
 	}
 	
 	return &Manager{
-		BaseDir:     baseDir,
-		ConfigDir:   filepath.Join(baseDir, ".galaxy", "testnet"),
-		TemplateDir: filepath.Join(baseDir, "galaxy-node-pool", "templates"),
+		BaseDir:   baseDir,
+		ConfigDir: filepath.Join(baseDir, ".galaxy", "testnet"),
 	}
 }
 
@@ -58,22 +66,6 @@ func (m *Manager) Initialize(poolName, orgID, listenAddr, grpcAddr, webAddr stri
 		orgID = "testorg"
 	}
 	
-	// Extract ports from addresses
-	apiPort := extractPort(listenAddr)
-	wsPort := extractPort(grpcAddr)
-	webPort := extractPort(webAddr)
-	
-	config := Config{
-		PoolName:   poolName,
-		OrgID:      orgID,
-		ListenAddr: listenAddr,
-		GrpcAddr:   grpcAddr,
-		WebAddr:    webAddr,
-		ApiPort:    apiPort,
-		WsPort:     wsPort,
-		WebPort:    webPort,
-	}
-	
 	// Create config directory
 	configDir := filepath.Join(m.ConfigDir, poolName)
 	if err := os.MkdirAll(configDir, 0755); err != nil {
@@ -82,7 +74,8 @@ func (m *Manager) Initialize(poolName, orgID, listenAddr, grpcAddr, webAddr stri
 	
 	// Create config file
 	configPath := filepath.Join(configDir, "config.yaml")
-	if err := m.createConfigFile(configPath, config); err != nil {
+	header := "Galaxy Node Pool Testnet Configuration\nGenerated by galaxy-pool testnet init, read by pool-server on testnet start"
+	if err := config.WriteFile(configPath, NewConfig(poolName, orgID, listenAddr, grpcAddr, webAddr), header); err != nil {
 		return fmt.Errorf("failed to create config file: %v", err)
 	}
 	
@@ -90,8 +83,10 @@ func (m *Manager) Initialize(poolName, orgID, listenAddr, grpcAddr, webAddr stri
 	return nil
 }
 
-// GenerateNginxConfig generates Nginx configuration for testnet
-func (m *Manager) GenerateNginxConfig(poolName, orgID string) error {
+// GenerateNginxConfig generates Nginx configuration for testnet, proxying to
+// the addresses in the pool's config file. Operator edits outside the managed
+// section of an existing file are kept.
+func (m *Manager) GenerateNginxConfig(poolName, orgID string, opts nginx.WriteOptions) error {
 	if poolName == "" {
 		poolName = "test"
 	}
@@ -106,18 +101,10 @@ func (m *Manager) GenerateNginxConfig(poolName, orgID string) error {
 	// Create Nginx config file
 	nginxPath := filepath.Join(configDir, "nginx.conf")
 	
-	// Get template path
-	templateFile := filepath.Join(m.TemplateDir, "nginx-testnet.conf.template")
-	
-	// Check if template exists
-	if _, err := os.Stat(templateFile); os.IsNotExist(err) {
-		return fmt.Errorf("template file not found: %s", templateFile)
-	}
-	
-	// Read template file
-	templateData, err := os.ReadFile(templateFile)
+	// Read the pool addresses
+	poolConfig, err := m.loadConfig(poolName)
 	if err != nil {
-		return fmt.Errorf("failed to read template file: %v", err)
+		return err
 	}
 	
 	// Get certificate paths
@@ -126,30 +113,37 @@ func (m *Manager) GenerateNginxConfig(poolName, orgID string) error {
 	certPath := filepath.Join(certDir, domain+".crt")
 	keyPath := filepath.Join(certDir, domain+".key")
 	
-	// Check if certificates exist, otherwise use default snakeoil certs
+	// Use the default snakeoil certs until a certificate is generated
 	if _, err := os.Stat(certPath); os.IsNotExist(err) {
-		certPath = "/etc/ssl/certs/ssl-cert-snakeoil.pem"
-	}
-	if _, err := os.Stat(keyPath); os.IsNotExist(err) {
-		keyPath = "/etc/ssl/private/ssl-cert-snakeoil.key"
-	}
-	
-	// Replace variables in template
-	nginxConfig := string(templateData)
-	nginxConfig = strings.ReplaceAll(nginxConfig, "${pool_env}", poolName)
-	nginxConfig = strings.ReplaceAll(nginxConfig, "${org_id}", orgID)
-	nginxConfig = strings.ReplaceAll(nginxConfig, "${api_port}", "8080")
-	nginxConfig = strings.ReplaceAll(nginxConfig, "${ws_port}", "9090")
-	nginxConfig = strings.ReplaceAll(nginxConfig, "${web_port}", "3000")
-	nginxConfig = strings.ReplaceAll(nginxConfig, "${ssl_cert}", certPath)
-	nginxConfig = strings.ReplaceAll(nginxConfig, "${ssl_key}", keyPath)
-	
-	// Write the configuration file
-	if err := os.WriteFile(nginxPath, []byte(nginxConfig), 0644); err != nil {
+		certPath, keyPath = "", ""
+	} else if _, err := os.Stat(keyPath); os.IsNotExist(err) {
+		certPath, keyPath = "", ""
+	}
+	
+	config := nginx.NewPoolConfig(nginx.PoolOptions{
+		ServerName:        strings.TrimPrefix(domain, "*."),
+		SSLCertificate:    certPath,
+		SSLCertificateKey: keyPath,
+		IPv6:              true,
+		HSTS:              true,
+		APIAddr:           localAddr(poolConfig.Pool.APIAddress),
+		GRPCAddr:          localAddr(poolConfig.Server.Address),
+		WebAddr:           localAddr(poolConfig.Pool.WebAddress),
+	})
+	
+	result, err := nginx.WriteConfig(nginxPath, config, opts)
+	if err != nil {
 		return fmt.Errorf("failed to write Nginx config: %v", err)
 	}
 	
-	fmt.Printf("Nginx configuration generated at %s\n", nginxPath)
+	switch {
+	case !result.Changed:
+		fmt.Printf("Nginx configuration at %s is up to date\n", nginxPath)
+	case opts.DryRun:
+		fmt.Print(result.Diff)
+	default:
+		fmt.Printf("Nginx configuration generated at %s\n", nginxPath)
+	}
 	return nil
 }
 
@@ -188,132 +182,39 @@ func (m *Manager) GenerateSSLCertificate(poolName, orgID string, production bool
 		}
 		
 		// Generate certificate using Let's Encrypt
-		return manager.GenerateWithLetsEncrypt(domain, config.Email, true, config.DNSProvider)
+		return manager.GenerateWithLetsEncrypt(domain, config, true)
 	}
 	
 	// Generate self-signed certificate for testnet
 	return manager.GenerateSelfSigned(domain)
 }
 
-// Start starts the testnet pool
-func (m *Manager) Start(poolName string) error {
-	if poolName == "" {
-		poolName = "test"
+// loadConfig reads the configuration of a testnet pool
+func (m *Manager) loadConfig(poolName string) (*config.Config, error) {
+	configPath := filepath.Join(m.ConfigDir, poolName, "config.yaml")
+	if _, err := os.Stat(configPath); os.IsNotExist(err) {
+		return nil, fmt.Errorf("testnet environment not found: %s", filepath.Dir(configPath))
 	}
 	
-	// Get config directory
-	configDir := filepath.Join(m.ConfigDir, poolName)
-	
-	// Check if config directory exists
-	if _, err := os.Stat(configDir); os.IsNotExist(err) {
-		return fmt.Errorf("testnet environment not found: %s", configDir)
-	}
-	
-	// TODO: Implement actual pool starting logic
-	
-	fmt.Printf("Testnet pool %s started\n", poolName)
-	return nil
-}
-
-// Stop stops the testnet pool
-func (m *Manager) Stop(poolName string) error {
-	if poolName == "" {
-		poolName = "test"
-	}
-	
-	// Get config directory
-	configDir := filepath.Join(m.ConfigDir, poolName)
-	
-	// Check if config directory exists
-	if _, err := os.Stat(configDir); os.IsNotExist(err) {
-		return fmt.Errorf("testnet environment not found: %s", configDir)
-	}
-	
-	// TODO: Implement actual pool stopping logic
-	
-	fmt.Printf("Testnet pool %s stopped\n", poolName)
-	return nil
-}
-
-// Status checks the status of the testnet pool
-func (m *Manager) Status(poolName string) error {
-	if poolName == "" {
-		poolName = "test"
-	}
-	
-	// Get config directory
-	configDir := filepath.Join(m.ConfigDir, poolName)
-	
-	// Check if config directory exists
-	if _, err := os.Stat(configDir); os.IsNotExist(err) {
-		return fmt.Errorf("testnet environment not found: %s", configDir)
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("%v\nrun testnet init %s again to regenerate the configuration", err, poolName)
 	}
-	
-	// TODO: Implement actual status checking logic
-	
-	fmt.Printf("Testnet pool %s is running\n", poolName)
-	return nil
+	return cfg, nil
 }
 
-// createConfigFile creates a testnet configuration file
-func (m *Manager) createConfigFile(path string, config Config) error {
-	// Define the configuration template
-	tmpl := `# Galaxy Node Pool Testnet Configuration
-# Generated by galaxy-pool testnet init
-
-# Pool Configuration
-pool:
-  name: {{ .PoolName }}
-  org_id: {{ .OrgID }}
-  listen_addr: {{ .ListenAddr }}
-  grpc_addr: {{ .GrpcAddr }}
-  web_addr: {{ .WebAddr }}
-
-# Network Configuration
-network:
-  type: testnet
-  api_port: {{ .ApiPort }}
-  ws_port: {{ .WsPort }}
-  web_port: {{ .WebPort }}
-
-# Security Configuration
-security:
-  tls_enabled: true
-  auth_required: true
-  rate_limit: 100
-
-# Logging Configuration
-logging:
-  level: debug
-  file: {{ .PoolName }}.log
-`
-	
-	// Parse the template
-	t, err := template.New("config").Parse(tmpl)
-	if err != nil {
-		return fmt.Errorf("failed to parse template: %v", err)
+// localAddr turns a listen address into an address to connect to, empty
+// when no address is set
+func localAddr(addr string) string {
+	if addr == "" {
+		return ""
 	}
-	
-	// Create the file
-	file, err := os.Create(path)
+	host, port, err := net.SplitHostPort(addr)
 	if err != nil {
-		return fmt.Errorf("failed to create file: %v", err)
-	}
-	defer file.Close()
-	
-	// Execute the template
-	if err := t.Execute(file, config); err != nil {
-		return fmt.Errorf("failed to execute template: %v", err)
+		return addr
 	}
-	
-	return nil
-}
-
-// extractPort extracts the port from an address string
-func extractPort(addr string) string {
-	parts := strings.Split(addr, ":")
-	if len(parts) < 2 {
-		return "8080" // Default port
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
 	}
-	return parts[1]
+	return net.JoinHostPort(host, port)
 }
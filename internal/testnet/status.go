@@ -0,0 +1,225 @@
+// Galaxy Node Pool - Testnet Pool Status
+// AI-ID: CP-GAL-NODEPOOL-001
+package testnet
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"galaxy-node-pool/internal/cert"
+	pb "galaxy-node-pool/proto/pool"
+)
+
+// probeTimeout bounds each probe of a running pool
+const probeTimeout = 5 * time.Second
+
+// PoolStatus describes a testnet pool as observed by Status
+type PoolStatus struct {
+	PoolName      string
+	Running       bool
+	SupervisorPID int
+	ServerPID     int
+	Restarts      int
+	Uptime        time.Duration
+	Address       string
+	LogFile       string
+
+	// Reachable is set when the registry answered a ListNodes probe
+	Reachable    bool
+	ProbeError   string
+	NodeCount    int
+	NodeStatuses map[string]int
+
+	// Certificate is the certificate served by the pool, or the testnet
+	// domain certificate when pool-server runs without TLS
+	Certificate *CertificateStatus
+}
+
+// CertificateStatus describes the validity of a certificate
+type CertificateStatus struct {
+	Source     string
+	Subject    string
+	SelfSigned bool
+	NotAfter   time.Time
+}
+
+// Status reports whether a testnet pool is running, probing its gRPC
+// endpoint for the registered nodes
+func (m *Manager) Status(poolName string) error {
+	status, err := m.PoolStatus(poolName)
+	if err != nil {
+		return err
+	}
+
+	if !status.Running {
+		fmt.Printf("Testnet pool %s is stopped\n", poolName)
+	} else {
+		fmt.Printf("Testnet pool %s is running\n", poolName)
+		fmt.Printf("  Supervisor PID: %d\n", status.SupervisorPID)
+		fmt.Printf("  Server PID:     %d (restarts: %d)\n", status.ServerPID, status.Restarts)
+		fmt.Printf("  Uptime:         %s\n", status.Uptime.Round(time.Second))
+		fmt.Printf("  Address:        %s\n", status.Address)
+		if status.Reachable {
+			fmt.Printf("  Nodes:          %d%s\n", status.NodeCount, formatStatuses(status.NodeStatuses))
+		} else {
+			fmt.Printf("  Nodes:          unknown, registry unreachable: %s\n", status.ProbeError)
+		}
+	}
+
+	if c := status.Certificate; c != nil {
+		remaining := time.Until(c.NotAfter)
+		validity := fmt.Sprintf("valid until %s, %d days left", c.NotAfter.Format(time.RFC3339), int(remaining.Hours()/24))
+		if remaining <= 0 {
+			validity = fmt.Sprintf("EXPIRED on %s", c.NotAfter.Format(time.RFC3339))
+		}
+		kind := ""
+		if c.SelfSigned {
+			kind = ", self-signed"
+		}
+		fmt.Printf("  Certificate:    %s (%s%s)\n", c.Subject, validity, kind)
+		fmt.Printf("                  from %s\n", c.Source)
+	}
+	fmt.Printf("  Log:            %s\n", status.LogFile)
+
+	return nil
+}
+
+// PoolStatus observes a testnet pool
+func (m *Manager) PoolStatus(poolName string) (*PoolStatus, error) {
+	if poolName == "" {
+		poolName = "test"
+	}
+
+	cfg, err := m.loadConfig(poolName)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &PoolStatus{
+		PoolName: poolName,
+		Address:  localAddr(cfg.Server.Address),
+		LogFile:  m.logPath(poolName, cfg),
+	}
+
+	status.SupervisorPID, status.Running = m.supervisorPID(poolName)
+	if status.Running {
+		if state, err := m.readState(poolName); err == nil {
+			status.ServerPID = state.ServerPID
+			status.Restarts = state.Restarts
+			status.Uptime = time.Since(state.StartedAt)
+			if state.Address != "" {
+				status.Address = state.Address
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+		defer cancel()
+		if err := probeRegistry(ctx, status, cfg.Server.TLS.Enabled); err != nil {
+			status.ProbeError = err.Error()
+		}
+	}
+
+	if status.Certificate == nil {
+		status.Certificate = m.domainCertificate(poolName, cfg.Pool.OrgID)
+	}
+	return status, nil
+}
+
+// probeRegistry lists the nodes of a running pool, recording the certificate
+// it serves when TLS is enabled
+func probeRegistry(ctx context.Context, status *PoolStatus, useTLS bool) error {
+	creds := insecure.NewCredentials()
+	if useTLS {
+		// The probe only reads the served certificate, it does not trust it
+		creds = credentials.NewTLS(&tls.Config{
+			InsecureSkipVerify: true,
+			VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+				if len(rawCerts) == 0 {
+					return nil
+				}
+				leaf, err := x509.ParseCertificate(rawCerts[0])
+				if err != nil {
+					return err
+				}
+				status.Certificate = &CertificateStatus{
+					Source:     "served by " + status.Address,
+					Subject:    leaf.Subject.CommonName,
+					SelfSigned: leaf.Issuer.String() == leaf.Subject.String(),
+					NotAfter:   leaf.NotAfter,
+				}
+				return nil
+			},
+		})
+	}
+
+	conn, err := grpc.NewClient(status.Address, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	resp, err := pb.NewRegistryClient(conn).ListNodes(ctx, &pb.ListNodesRequest{IncludeAllStatuses: true})
+	if err != nil {
+		return err
+	}
+
+	status.Reachable = true
+	status.NodeCount = len(resp.Nodes)
+	status.NodeStatuses = make(map[string]int)
+	for _, node := range resp.Nodes {
+		status.NodeStatuses[node.Status]++
+	}
+	return nil
+}
+
+// domainCertificate returns the status of the testnet domain certificate, if one was generated
+func (m *Manager) domainCertificate(poolName, orgID string) *CertificateStatus {
+	if orgID == "" {
+		orgID = "testorg"
+	}
+	certDir := filepath.Join(m.BaseDir, ".galaxy", "certs")
+	domain := fmt.Sprintf("*.%s.pool.galaxy.net.%s.asia.hybridconnect.cloud", poolName, orgID)
+	if _, err := os.Stat(filepath.Join(certDir, domain+".crt")); err != nil {
+		return nil
+	}
+
+	certStatus, err := cert.NewManager(certDir, "").Status(domain)
+	if err != nil {
+		return nil
+	}
+	return &CertificateStatus{
+		Source:     certStatus.CertPath,
+		Subject:    domain,
+		SelfSigned: certStatus.SelfSigned,
+		NotAfter:   certStatus.NotAfter,
+	}
+}
+
+// formatStatuses formats node counts by status, as " (2 healthy, 1 degraded)"
+func formatStatuses(counts map[string]int) string {
+	if len(counts) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%d %s", counts[name], name))
+	}
+	return " (" + strings.Join(parts, ", ") + ")"
+}